@@ -0,0 +1,259 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/logger"
+	"easyCacheMirror/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	// SessionCookie 保存登录会话令牌的 Cookie 名称
+	SessionCookie = "ecm_session"
+	// SessionTTL 登录会话的有效期
+	SessionTTL = 7 * 24 * time.Hour
+	// TokenPrefix API 令牌的前缀，便于识别
+	TokenPrefix = "ecm_"
+
+	// contextUserKey 在请求上下文中保存当前用户的键
+	contextUserKey = "auth_user"
+)
+
+// ErrInvalidToken 令牌不存在或已过期
+var ErrInvalidToken = errors.New("令牌无效或已过期")
+
+// HashPassword 使用 bcrypt 生成密码摘要
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("生成密码摘要失败: %v", err)
+	}
+	return string(hash), nil
+}
+
+// CheckPassword 检查密码是否正确
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// NewToken 生成随机令牌
+func NewToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("生成令牌失败: %v", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// HashToken 计算令牌的摘要，数据库中只保存摘要
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateSession 为用户创建登录会话，返回会话令牌
+func CreateSession(user *models.User) (string, time.Time, error) {
+	token, err := NewToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	session := models.Session{
+		UserID:    user.ID,
+		TokenHash: HashToken(token),
+		ExpiresAt: time.Now().Add(SessionTTL),
+	}
+	if err := database.DB.Create(&session).Error; err != nil {
+		return "", time.Time{}, fmt.Errorf("创建会话失败: %v", err)
+	}
+
+	// 顺便清理已过期的会话
+	database.DB.Where("expires_at < ?", time.Now()).Delete(&models.Session{})
+
+	return token, session.ExpiresAt, nil
+}
+
+// DeleteSession 删除登录会话
+func DeleteSession(token string) error {
+	return database.DB.Where("token_hash = ?", HashToken(token)).Delete(&models.Session{}).Error
+}
+
+// Authenticate 根据会话令牌或 API 令牌查找用户
+func Authenticate(token string) (*models.User, error) {
+	if token == "" {
+		return nil, ErrInvalidToken
+	}
+	hash := HashToken(token)
+	now := time.Now()
+
+	var userID uint
+	var session models.Session
+	err := database.DB.Where("token_hash = ? AND expires_at > ?", hash, now).First(&session).Error
+	switch {
+	case err == nil:
+		userID = session.UserID
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		var apiToken models.APIToken
+		if err := database.DB.Where("token_hash = ?", hash).First(&apiToken).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrInvalidToken
+			}
+			return nil, fmt.Errorf("查询令牌失败: %v", err)
+		}
+		if apiToken.ExpiresAt != nil && apiToken.ExpiresAt.Before(now) {
+			return nil, ErrInvalidToken
+		}
+		database.DB.Model(&apiToken).Update("last_used_at", now)
+		userID = apiToken.UserID
+	default:
+		return nil, fmt.Errorf("查询会话失败: %v", err)
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, fmt.Errorf("查询用户失败: %v", err)
+	}
+	return &user, nil
+}
+
+// credentialTTL 用户名密码校验结果的缓存时间，避免包管理器的每个请求都做一次 bcrypt
+const credentialTTL = 5 * time.Minute
+
+// cachedCredential 校验通过的用户名密码，同时记录校验时的密码摘要
+// 命中缓存时仍然读取用户，密码被修改(包括其他实例修改)或用户被删除后缓存立即失效
+type cachedCredential struct {
+	userID       uint
+	passwordHash string
+	expiresAt    time.Time
+}
+
+var (
+	credentialMu    sync.Mutex
+	credentialCache = make(map[string]cachedCredential)
+)
+
+// AuthenticateBasic 校验 HTTP Basic 认证的用户名和密码
+// 密码可以是用户的登录密码，也可以是 API 令牌，使用令牌时忽略用户名
+func AuthenticateBasic(username, password string) (*models.User, error) {
+	if strings.HasPrefix(password, TokenPrefix) {
+		return Authenticate(password)
+	}
+	if username == "" || password == "" {
+		return nil, ErrInvalidToken
+	}
+
+	key := HashToken(username + "\x00" + password)
+	credentialMu.Lock()
+	cached, ok := credentialCache[key]
+	credentialMu.Unlock()
+
+	var user models.User
+	if ok && cached.expiresAt.After(time.Now()) {
+		if err := database.DB.First(&user, cached.userID).Error; err == nil && user.PasswordHash == cached.passwordHash {
+			return &user, nil
+		}
+		credentialMu.Lock()
+		delete(credentialCache, key)
+		credentialMu.Unlock()
+		user = models.User{}
+	}
+
+	if err := database.DB.Where("username = ?", username).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, fmt.Errorf("查询用户失败: %v", err)
+	}
+	if !CheckPassword(user.PasswordHash, password) {
+		return nil, ErrInvalidToken
+	}
+
+	credentialMu.Lock()
+	credentialCache[key] = cachedCredential{
+		userID:       user.ID,
+		passwordHash: user.PasswordHash,
+		expiresAt:    time.Now().Add(credentialTTL),
+	}
+	credentialMu.Unlock()
+	return &user, nil
+}
+
+// ResetCredentialCache 清空用户名密码的校验缓存，修改密码或删除用户后调用
+func ResetCredentialCache() {
+	credentialMu.Lock()
+	credentialCache = make(map[string]cachedCredential)
+	credentialMu.Unlock()
+}
+
+// SetCurrentUser 将当前用户保存到请求上下文
+func SetCurrentUser(c *gin.Context, user *models.User) {
+	c.Set(contextUserKey, user)
+}
+
+// CurrentUser 返回请求上下文中的当前用户，未登录时返回 nil
+func CurrentUser(c *gin.Context) *models.User {
+	if value, ok := c.Get(contextUserKey); ok {
+		if user, ok := value.(*models.User); ok {
+			return user
+		}
+	}
+	return nil
+}
+
+// EnsureDefaultAdmin 没有任何用户时创建默认管理员
+// 密码取环境变量 ADMIN_PASSWORD，未设置时随机生成并打印到控制台
+func EnsureDefaultAdmin() error {
+	var count int64
+	if err := database.DB.Model(&models.User{}).Count(&count).Error; err != nil {
+		return fmt.Errorf("查询用户失败: %v", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	password := os.Getenv("ADMIN_PASSWORD")
+	generated := password == ""
+	if generated {
+		token, err := NewToken()
+		if err != nil {
+			return err
+		}
+		password = token[:16]
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	admin := models.User{
+		Username:     "admin",
+		PasswordHash: hash,
+		Role:         models.RoleAdmin,
+	}
+	if err := database.DB.Create(&admin).Error; err != nil {
+		return fmt.Errorf("创建默认管理员失败: %v", err)
+	}
+
+	logger.GetLogger().Info("已创建默认管理员", zap.String("username", admin.Username))
+	if generated {
+		fmt.Printf("已创建默认管理员 admin，初始密码: %s ，请登录后尽快修改\n", password)
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/models"
+)
+
+// setupDB 使用测试数据库并清空用户名密码的校验缓存
+func setupDB(t *testing.T) {
+	t.Helper()
+	database.UseTestDB(t)
+	ResetCredentialCache()
+	t.Cleanup(ResetCredentialCache)
+}
+
+// createUser 创建测试用户
+func createUser(t *testing.T, username, password, role string) *models.User {
+	t.Helper()
+	hash, err := HashPassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{Username: username, PasswordHash: hash, Role: role}
+	if err := database.DB.Create(user).Error; err != nil {
+		t.Fatalf("创建用户失败: %v", err)
+	}
+	return user
+}
+
+func TestPasswordHash(t *testing.T) {
+	hash, err := HashPassword("s3cret-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash == "s3cret-password" {
+		t.Fatal("密码摘要不应与明文相同")
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		want     bool
+	}{
+		{"正确密码", "s3cret-password", true},
+		{"错误密码", "s3cret-passw0rd", false},
+		{"空密码", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CheckPassword(hash, tt.password); got != tt.want {
+				t.Errorf("CheckPassword(%q) = %v, want %v", tt.password, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	setupDB(t)
+	user := createUser(t, "alice", "alice-password", models.RoleViewer)
+
+	sessionToken, _, err := CreateSession(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expiredSession := "expired-session"
+	database.DB.Create(&models.Session{
+		UserID:    user.ID,
+		TokenHash: HashToken(expiredSession),
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	// CreateSession 会清理已过期的会话，创建后再修改过期时间
+	database.DB.Model(&models.Session{}).
+		Where("token_hash = ?", HashToken(expiredSession)).
+		Update("expires_at", time.Now().Add(-time.Hour))
+
+	past := time.Now().Add(-time.Hour)
+	apiToken := TokenPrefix + "valid"
+	expiredToken := TokenPrefix + "expired"
+	database.DB.Create(&models.APIToken{UserID: user.ID, Name: "ci", TokenHash: HashToken(apiToken)})
+	database.DB.Create(&models.APIToken{UserID: user.ID, Name: "old", TokenHash: HashToken(expiredToken), ExpiresAt: &past})
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"登录会话", sessionToken, nil},
+		{"API 令牌", apiToken, nil},
+		{"过期会话", expiredSession, ErrInvalidToken},
+		{"过期令牌", expiredToken, ErrInvalidToken},
+		{"未知令牌", "unknown", ErrInvalidToken},
+		{"空令牌", "", ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Authenticate(tt.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Authenticate() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && got.ID != user.ID {
+				t.Errorf("Authenticate() user = %d, want %d", got.ID, user.ID)
+			}
+		})
+	}
+
+	if err := DeleteSession(sessionToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Authenticate(sessionToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("删除后的会话仍然有效: %v", err)
+	}
+}
+
+func TestAuthenticateBasic(t *testing.T) {
+	setupDB(t)
+	user := createUser(t, "bob", "bob-password", models.RoleWriter)
+	apiToken := TokenPrefix + "basic"
+	database.DB.Create(&models.APIToken{UserID: user.ID, Name: "deploy", TokenHash: HashToken(apiToken)})
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		wantErr  error
+	}{
+		{"用户名密码", "bob", "bob-password", nil},
+		{"再次校验使用缓存", "bob", "bob-password", nil},
+		{"令牌忽略用户名", "anyone", apiToken, nil},
+		{"错误密码", "bob", "wrong", ErrInvalidToken},
+		{"未知用户", "carol", "bob-password", ErrInvalidToken},
+		{"空用户名", "", "bob-password", ErrInvalidToken},
+		{"空密码", "bob", "", ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := AuthenticateBasic(tt.username, tt.password)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("AuthenticateBasic() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && got.ID != user.ID {
+				t.Errorf("AuthenticateBasic() user = %d, want %d", got.ID, user.ID)
+			}
+		})
+	}
+
+	// 不清空缓存也能发现密码已被修改(例如由其他实例修改)
+	hash, _ := HashPassword("new-password")
+	database.DB.Model(user).Update("password_hash", hash)
+	if _, err := AuthenticateBasic("bob", "bob-password"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("修改密码后旧密码仍然有效: %v", err)
+	}
+	if _, err := AuthenticateBasic("bob", "new-password"); err != nil {
+		t.Errorf("修改密码后新密码无效: %v", err)
+	}
+
+	// 删除用户后缓存的校验结果失效
+	database.DB.Delete(user)
+	if _, err := AuthenticateBasic("bob", "new-password"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("删除用户后密码仍然有效: %v", err)
+	}
+}
+
+func TestLoginThrottle(t *testing.T) {
+	throttleMu.Lock()
+	loginFailures = make(map[string]*loginFailure)
+	throttleMu.Unlock()
+
+	user, ip, otherIP := LoginUserKey("alice"), LoginIPKey("192.0.2.1"), LoginIPKey("192.0.2.2")
+	tests := []struct {
+		name        string
+		do          func()
+		keys        []string
+		wantBlocked bool
+	}{
+		{"没有失败记录", func() {}, []string{user, ip}, false},
+		{"失败次数未达到上限", func() {
+			for i := 0; i < maxLoginFailures-1; i++ {
+				RecordLoginFailure(user, ip)
+			}
+		}, []string{user, ip}, false},
+		{"达到上限后限制用户名", func() { RecordLoginFailure(user, ip) }, []string{user, otherIP}, true},
+		{"达到上限后限制地址", func() {}, []string{LoginUserKey("bob"), ip}, true},
+		{"其他用户名和地址不受影响", func() {}, []string{LoginUserKey("bob"), otherIP}, false},
+		{"清除用户名的记录", func() { ResetLoginFailures(user) }, []string{user, otherIP}, false},
+		{"地址的记录仍然有效", func() {}, []string{user, ip}, true},
+	}
+	for _, tt := range tests {
+		tt.do()
+		if blocked := LoginBlocked(tt.keys...) > 0; blocked != tt.wantBlocked {
+			t.Errorf("%s: LoginBlocked() = %v, want %v", tt.name, blocked, tt.wantBlocked)
+		}
+	}
+}
+
+func TestRoles(t *testing.T) {
+	tests := []struct {
+		role      string
+		wantAdmin bool
+		wantWrite bool
+	}{
+		{models.RoleAdmin, true, true},
+		{models.RoleWriter, false, true},
+		{models.RoleViewer, false, false},
+		{"", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.role, func(t *testing.T) {
+			user := &models.User{Role: tt.role}
+			if got := user.IsAdmin(); got != tt.wantAdmin {
+				t.Errorf("IsAdmin() = %v, want %v", got, tt.wantAdmin)
+			}
+			if got := user.CanWrite(); got != tt.wantWrite {
+				t.Errorf("CanWrite() = %v, want %v", got, tt.wantWrite)
+			}
+		})
+	}
+}
+
+func TestEnsureDefaultAdmin(t *testing.T) {
+	setupDB(t)
+	t.Setenv("ADMIN_PASSWORD", "initial-password")
+
+	for i := 0; i < 2; i++ {
+		if err := EnsureDefaultAdmin(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var users []models.User
+	database.DB.Find(&users)
+	if len(users) != 1 {
+		t.Fatalf("用户数 = %d, want 1", len(users))
+	}
+	if users[0].Role != models.RoleAdmin || !CheckPassword(users[0].PasswordHash, "initial-password") {
+		t.Errorf("默认管理员不正确: %+v", users[0])
+	}
+}
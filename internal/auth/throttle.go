@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// maxLoginFailures 连续失败多少次后暂时拒绝登录
+	maxLoginFailures = 5
+	// loginLockout 达到失败次数后拒绝登录的时间，也是失败次数的统计窗口
+	loginLockout = 15 * time.Minute
+	// maxThrottleEntries 记录数超过该值时清理已过期的记录
+	maxThrottleEntries = 10000
+)
+
+// ErrTooManyAttempts 登录失败次数过多，需要等待后再试
+var ErrTooManyAttempts = errors.New("登录失败次数过多，请稍后再试")
+
+// loginFailure 一个用户名或客户端地址的登录失败记录
+type loginFailure struct {
+	count       int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+var (
+	throttleMu    sync.Mutex
+	loginFailures = make(map[string]*loginFailure)
+)
+
+// LoginUserKey 按用户名统计登录失败的键
+func LoginUserKey(username string) string {
+	return "user:" + username
+}
+
+// LoginIPKey 按客户端地址统计登录失败的键
+func LoginIPKey(clientIP string) string {
+	return "ip:" + clientIP
+}
+
+// LoginBlocked 返回需要等待多久才能再次尝试登录，没有限制时返回 0
+// 按用户名和客户端地址分别统计，任意一个达到失败次数都会限制
+func LoginBlocked(keys ...string) time.Duration {
+	throttleMu.Lock()
+	defer throttleMu.Unlock()
+
+	now := time.Now()
+	var wait time.Duration
+	for _, key := range keys {
+		if failure, ok := loginFailures[key]; ok && failure.lockedUntil.After(now) {
+			if d := failure.lockedUntil.Sub(now); d > wait {
+				wait = d
+			}
+		}
+	}
+	return wait
+}
+
+// RecordLoginFailure 记录一次登录失败，连续失败达到次数后在 loginLockout 内拒绝登录
+func RecordLoginFailure(keys ...string) {
+	throttleMu.Lock()
+	defer throttleMu.Unlock()
+
+	now := time.Now()
+	if len(loginFailures) > maxThrottleEntries {
+		for key, failure := range loginFailures {
+			if now.Sub(failure.lastFailure) > loginLockout && !failure.lockedUntil.After(now) {
+				delete(loginFailures, key)
+			}
+		}
+	}
+
+	for _, key := range keys {
+		failure, ok := loginFailures[key]
+		if !ok || now.Sub(failure.lastFailure) > loginLockout {
+			failure = &loginFailure{}
+			loginFailures[key] = failure
+		}
+		failure.count++
+		failure.lastFailure = now
+		if failure.count >= maxLoginFailures {
+			failure.count = 0
+			failure.lockedUntil = now.Add(loginLockout)
+		}
+	}
+}
+
+// ResetLoginFailures 登录成功后清除失败记录
+func ResetLoginFailures(keys ...string) {
+	throttleMu.Lock()
+	defer throttleMu.Unlock()
+	for _, key := range keys {
+		delete(loginFailures, key)
+	}
+}
@@ -60,12 +60,32 @@ func (mc *MirrorCache) Get(path string) *models.Mirror {
 	return nil
 }
 
+// GetByID 根据ID获取镜像
+func (mc *MirrorCache) GetByID(id uint) *models.Mirror {
+	mc.mutex.RLock()
+	defer mc.mutex.RUnlock()
+
+	return mc.mirrors[id]
+}
+
 func (mc *MirrorCache) Remove(mirror *models.Mirror) {
 	mc.mutex.Lock()
 	defer mc.mutex.Unlock()
 	delete(mc.mirrors, mirror.ID)
 }
 
+// Replace 用数据库中的全部镜像替换缓存，已删除的镜像同时移除
+func (mc *MirrorCache) Replace(mirrors []*models.Mirror) {
+	replaced := make(map[uint]*models.Mirror, len(mirrors))
+	for _, mirror := range mirrors {
+		replaced[mirror.ID] = mirror
+	}
+
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+	mc.mirrors = replaced
+}
+
 func (mc *MirrorCache) Clear() {
 	mc.mutex.Lock()
 	defer mc.mutex.Unlock()
@@ -5,11 +5,14 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"easyCacheMirror/internal/models"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 var DB *gorm.DB
@@ -28,40 +31,194 @@ func InitDB() error {
 	}
 
 	// 自动迁移数据库结构
-	err = db.AutoMigrate(
-		&models.Mirror{},
-		&models.MavenFile{},
-		&models.NPMFile{},
-	)
+	err = db.AutoMigrate(allModels...)
 	if err != nil {
 		log.Fatal("数据库迁移失败:", err)
 	}
 
+	// 从各类型的文件表生成统一的缓存记录，只在升级后第一次启动时执行
+	if err := migrateCacheEntries(db); err != nil {
+		log.Fatal("迁移缓存记录失败:", err)
+	}
+
 	DB = db
+	if err := registerInstance(); err != nil {
+		log.Println("登记实例失败:", err)
+	}
 	fmt.Println("数据库初始化成功")
 
 	return nil
 }
 
-// GetMirrorUsedSpace 计算镜像已用空间
-func GetMirrorUsedSpace(mirrorID uint) (int64, error) {
-	var totalSize int64
-	err := DB.Model(&models.NPMFile{}).
-		Where("mirror_id = ?", mirrorID).
-		Select("COALESCE(SUM(file_size), 0)").
-		Scan(&totalSize).Error
-	if err != nil {
-		return 0, err
+// allModels 需要自动迁移的所有数据表
+var allModels = []interface{}{
+	&models.Mirror{},
+	&models.MirrorUpstream{},
+	&models.MavenFile{},
+	&models.NPMFile{},
+	&models.PyPIFile{},
+	&models.GoModuleFile{},
+	&models.DockerFile{},
+	&models.CargoFile{},
+	&models.CondaFile{},
+	&models.RFile{},
+	&models.RubyGemsFile{},
+	&models.RawFile{},
+	&models.User{},
+	&models.Session{},
+	&models.APIToken{},
+	&models.CleanupRun{},
+	&models.CacheEntry{},
+	&models.Blob{},
+	&models.Lease{},
+}
+
+// cacheFileModels 各类型的文件表，均包含 mirror_id 和 save_path 字段
+var cacheFileModels = []interface{}{
+	&models.NPMFile{},
+	&models.MavenFile{},
+	&models.PyPIFile{},
+	&models.GoModuleFile{},
+	&models.DockerFile{},
+	&models.CargoFile{},
+	&models.CondaFile{},
+	&models.RFile{},
+	&models.RubyGemsFile{},
+	&models.RawFile{},
+}
+
+// migrateCacheEntries 缓存记录为空时，根据各类型文件表中的记录生成
+func migrateCacheEntries(db *gorm.DB) error {
+	var count int64
+	if err := db.Model(&models.CacheEntry{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	var mirrors []models.Mirror
+	if err := db.Find(&mirrors).Error; err != nil {
+		return err
+	}
+	blobPaths := make(map[uint]string, len(mirrors))
+	for _, mirror := range mirrors {
+		blobPaths[mirror.ID] = mirror.BlobPath
+	}
+
+	type legacyFile struct {
+		MirrorID     uint
+		SavePath     string
+		DownloadedAt time.Time
+		LastUsedTime time.Time
+		IsHosted     bool
+	}
+
+	var migrated int
+	for _, model := range cacheFileModels {
+		columns := []string{"mirror_id", "save_path", "downloaded_at", "last_used_time"}
+		if db.Migrator().HasColumn(model, "is_hosted") {
+			columns = append(columns, "is_hosted")
+		}
+
+		// 同一文件可能有多条记录(例如 Docker 标签指向的清单)，只保留第一条
+		var files []legacyFile
+		if err := db.Model(model).Select(columns).Scan(&files).Error; err != nil {
+			return err
+		}
+
+		for _, file := range files {
+			blobPath, ok := blobPaths[file.MirrorID]
+			if !ok || file.SavePath == "" {
+				continue
+			}
+			rel, err := filepath.Rel(blobPath, file.SavePath)
+			if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+				continue
+			}
+			// 文件表中的大小不一定准确(例如 NPM 元数据按格式化前的内容记录)，以实际文件为准
+			info, err := os.Stat(file.SavePath)
+			if err != nil || info.IsDir() {
+				continue
+			}
+			entry := models.CacheEntry{
+				MirrorID:     file.MirrorID,
+				Key:          filepath.ToSlash(rel),
+				Size:         info.Size(),
+				Hosted:       file.IsHosted,
+				StoredAt:     file.DownloadedAt,
+				LastUsedTime: file.LastUsedTime,
+			}
+			result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&entry)
+			if result.Error != nil {
+				return result.Error
+			}
+			migrated += int(result.RowsAffected)
+		}
 	}
 
-	// 计算 Maven 文件大小
-	var mavenSize int64
-	err = DB.Model(&models.MavenFile{}).
-		Where("mirror_id = ?", mirrorID).
-		Select("COALESCE(SUM(file_size), 0)").
-		Scan(&mavenSize).Error
+	if migrated > 0 {
+		fmt.Printf("已从文件表生成 %d 条缓存记录\n", migrated)
+	}
+	return nil
+}
 
-	totalSize += mavenSize
+// MirrorUsage 镜像的使用空间
+type MirrorUsage struct {
+	Logical  int64 // 所有缓存文件的大小之和，容量限制和清理水位按该值计算
+	Physical int64 // 镜像引用的对象实际占用的空间，多个镜像共用的对象按各自的引用数分摊
+}
 
-	return totalSize, err
+// GetMirrorUsedSpace 计算镜像已用空间，location 为镜像使用的内容寻址存储的位置
+// 对象的大小按引用数分摊到引用它的缓存记录上，共用同一存储的所有镜像的实际占用之和等于对象的总大小；
+// 还没有对象记录的文件(例如迁移没有完成)按文件大小计算
+func GetMirrorUsedSpace(mirrorID uint, location string) (MirrorUsage, error) {
+	var usage MirrorUsage
+	err := DB.Raw(`SELECT
+		(SELECT COALESCE(SUM(size), 0) FROM cache_entries WHERE mirror_id = ?) AS logical,
+		(SELECT CAST(ROUND(COALESCE(SUM(
+			COALESCE(CAST(b.size AS REAL) * r.refs / MAX(b.ref_count, r.refs), r.size)
+		), 0)) AS INTEGER) FROM (
+			SELECT sha256, COUNT(*) AS refs, MAX(size) AS size FROM cache_entries
+			WHERE mirror_id = ? AND sha256 <> '' GROUP BY sha256
+		) r LEFT JOIN blobs b ON b.location = ? AND b.sha256 = r.sha256) AS physical`,
+		mirrorID, mirrorID, location).
+		Scan(&usage).Error
+	return usage, err
+}
+
+// DeleteFileRecords 删除各类型文件表中指定文件的记录，prefix 为 true 时删除目录下所有文件的记录
+func DeleteFileRecords(mirrorID uint, savePath string, prefix bool) error {
+	for _, model := range cacheFileModels {
+		query := DB.Where("mirror_id = ? AND save_path = ?", mirrorID, savePath)
+		if prefix {
+			pattern := strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").
+				Replace(savePath+string(filepath.Separator)) + "%"
+			query = DB.Where("mirror_id = ? AND save_path LIKE ? ESCAPE '\\'", mirrorID, pattern)
+		}
+		if err := query.Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteMirrorFileRecords 删除镜像在各类型文件表中的所有记录，tx 为删除镜像的事务
+func DeleteMirrorFileRecords(tx *gorm.DB, mirrorID uint) error {
+	for _, model := range cacheFileModels {
+		if err := tx.Where("mirror_id = ?", mirrorID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteFileRecordsByPaths 删除各类型文件表中一批文件的记录
+func DeleteFileRecordsByPaths(mirrorID uint, savePaths []string) error {
+	for _, model := range cacheFileModels {
+		if err := DB.Where("mirror_id = ? AND save_path IN ?", mirrorID, savePaths).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,155 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"easyCacheMirror/internal/models"
+)
+
+// leasePollInterval 等待租约时重试的间隔
+const leasePollInterval = 100 * time.Millisecond
+
+// instanceLeaseTTL 实例登记的有效时间，实例退出后在该时间内过期
+const instanceLeaseTTL = time.Minute
+
+// instancePrefix 实例登记的租约名称前缀
+const instancePrefix = "instance:"
+
+// ErrLeaseTimeout 等待租约超时，其他持有者一直没有释放
+var ErrLeaseTimeout = errors.New("等待租约超时")
+
+// instanceLease 当前实例的登记，实例运行期间一直持有
+var instanceLease *Lease
+
+// instanceID 当前实例的标识，记录在租约的持有者中便于排查
+var instanceID = func() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}()
+
+// Lease 当前实例持有的租约
+// 多个实例共用数据库时，用于代替进程内的锁保证同一时间只有一个持有者
+type Lease struct {
+	name   string
+	holder string
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// TryAcquireLease 尝试获取租约，租约被其他持有者占用且未过期时返回 nil
+// 获取成功后在后台每隔 ttl/3 续期，直到调用 Release；实例崩溃后租约在 ttl 后过期
+func TryAcquireLease(name string, ttl time.Duration) (*Lease, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, fmt.Errorf("生成租约持有者失败: %v", err)
+	}
+	holder := instanceID + "-" + hex.EncodeToString(buf)
+
+	// 记录不存在时插入，已过期时接管，否则不修改任何记录
+	now := time.Now().UTC()
+	result := DB.Exec(`INSERT INTO leases (name, holder, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET holder = excluded.holder, expires_at = excluded.expires_at
+		WHERE leases.expires_at < ?`,
+		name, holder, now.Add(ttl), now)
+	if result.Error != nil {
+		return nil, fmt.Errorf("获取租约失败: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	lease := &Lease{
+		name:   name,
+		holder: holder,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go lease.keepAlive(ttl)
+	return lease, nil
+}
+
+// AcquireLease 等待并获取租约，超过 timeout 仍未获取时返回 ErrLeaseTimeout
+func AcquireLease(name string, ttl, timeout time.Duration) (*Lease, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		lease, err := TryAcquireLease(name, ttl)
+		if err != nil || lease != nil {
+			return lease, err
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%w: %s", ErrLeaseTimeout, name)
+		}
+		time.Sleep(leasePollInterval)
+	}
+}
+
+// keepAlive 定期续期，直到租约被释放
+func (l *Lease) keepAlive(ttl time.Duration) {
+	defer close(l.done)
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			result := DB.Model(&models.Lease{}).
+				Where("name = ? AND holder = ?", l.name, l.holder).
+				Update("expires_at", time.Now().UTC().Add(ttl))
+			if result.Error != nil {
+				log.Printf("续期租约 %s 失败: %v", l.name, result.Error)
+			} else if result.RowsAffected == 0 {
+				log.Printf("租约 %s 已过期并被其他持有者获取", l.name)
+			}
+		}
+	}
+}
+
+// Release 停止续期并释放租约
+func (l *Lease) Release() {
+	close(l.stop)
+	<-l.done
+	if err := DB.Where("name = ? AND holder = ?", l.name, l.holder).Delete(&models.Lease{}).Error; err != nil {
+		// 释放失败时租约在过期后自动失效
+		log.Printf("释放租约 %s 失败: %v", l.name, err)
+	}
+}
+
+// registerInstance 登记当前实例，用于判断是否有其他实例共用数据库
+func registerInstance() error {
+	// 顺便清理崩溃的实例留下的过期租约
+	if err := DB.Where("expires_at < ?", time.Now().UTC()).Delete(&models.Lease{}).Error; err != nil {
+		return fmt.Errorf("清理过期租约失败: %v", err)
+	}
+
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Errorf("生成实例标识失败: %v", err)
+	}
+	lease, err := TryAcquireLease(instancePrefix+instanceID+"-"+hex.EncodeToString(buf), instanceLeaseTTL)
+	if err != nil {
+		return err
+	}
+	instanceLease = lease
+	return nil
+}
+
+// OtherInstancesRunning 判断是否有其他正在运行的实例共用数据库
+func OtherInstancesRunning() (bool, error) {
+	query := DB.Model(&models.Lease{}).
+		Where("name LIKE ? AND expires_at > ?", instancePrefix+"%", time.Now().UTC())
+	if instanceLease != nil {
+		query = query.Where("name <> ?", instanceLease.name)
+	}
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return false, fmt.Errorf("查询运行中的实例失败: %v", err)
+	}
+	return count > 0, nil
+}
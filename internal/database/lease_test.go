@@ -0,0 +1,122 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"easyCacheMirror/internal/models"
+)
+
+func TestLease(t *testing.T) {
+	UseTestDB(t)
+
+	first, err := TryAcquireLease("test", time.Minute)
+	if err != nil || first == nil {
+		t.Fatalf("TryAcquireLease() = %v, %v", first, err)
+	}
+
+	// 已过期的租约可以被接管
+	DB.Create(&models.Lease{Name: "expired", Holder: "crashed", ExpiresAt: time.Now().UTC().Add(-time.Second)})
+
+	tests := []struct {
+		name    string
+		lease   string
+		wantGot bool
+	}{
+		{"被占用的租约", "test", false},
+		{"其他名称的租约", "other", true},
+		{"已过期的租约", "expired", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lease, err := TryAcquireLease(tt.lease, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := lease != nil; got != tt.wantGot {
+				t.Errorf("TryAcquireLease(%q) 获取 = %v, want %v", tt.lease, got, tt.wantGot)
+			}
+			if lease != nil {
+				lease.Release()
+			}
+		})
+	}
+
+	if _, err := AcquireLease("test", time.Minute, 3*leasePollInterval); !errors.Is(err, ErrLeaseTimeout) {
+		t.Errorf("AcquireLease() error = %v, want %v", err, ErrLeaseTimeout)
+	}
+
+	// 释放后等待中的请求获取租约
+	acquired := make(chan error, 1)
+	go func() {
+		lease, err := AcquireLease("test", time.Minute, time.Minute)
+		if err == nil {
+			lease.Release()
+		}
+		acquired <- err
+	}()
+	time.Sleep(2 * leasePollInterval)
+	first.Release()
+	if err := <-acquired; err != nil {
+		t.Errorf("释放后 AcquireLease() error = %v", err)
+	}
+}
+
+func TestLeaseKeepAlive(t *testing.T) {
+	UseTestDB(t)
+
+	const ttl = 300 * time.Millisecond
+	lease, err := TryAcquireLease("renewed", ttl)
+	if err != nil || lease == nil {
+		t.Fatalf("TryAcquireLease() = %v, %v", lease, err)
+	}
+	defer lease.Release()
+
+	// 超过 ttl 后仍在续期，其他持有者不能获取
+	time.Sleep(3 * ttl)
+	other, err := TryAcquireLease("renewed", ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other != nil {
+		other.Release()
+		t.Error("续期中的租约被其他持有者获取")
+	}
+}
+
+func TestOtherInstancesRunning(t *testing.T) {
+	UseTestDB(t)
+	previous := instanceLease
+	t.Cleanup(func() { instanceLease = previous })
+
+	if err := registerInstance(); err != nil {
+		t.Fatal(err)
+	}
+	defer instanceLease.Release()
+
+	tests := []struct {
+		name    string
+		other   *models.Lease
+		running bool
+	}{
+		{"只有当前实例", nil, false},
+		{"其他实例已崩溃", &models.Lease{Name: instancePrefix + "crashed", ExpiresAt: time.Now().UTC().Add(-time.Second)}, false},
+		{"其他实例正在运行", &models.Lease{Name: instancePrefix + "other", ExpiresAt: time.Now().UTC().Add(time.Minute)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.other != nil {
+				DB.Create(tt.other)
+				defer DB.Delete(tt.other)
+			}
+			running, err := OtherInstancesRunning()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if running != tt.running {
+				t.Errorf("OtherInstancesRunning() = %v, want %v", running, tt.running)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"easyCacheMirror/internal/auth"
+	"easyCacheMirror/internal/logger"
+	"easyCacheMirror/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// splitLines 按换行、逗号或空白拆分配置项
+func splitLines(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == '\n' || r == '\r' || r == ',' || r == ' ' || r == '\t'
+	})
+}
+
+// parseCIDR 解析地址段，单个 IP 视为只包含该地址的地址段
+func parseCIDR(value string) (*net.IPNet, error) {
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("无效的地址: %s", value)
+		}
+		bits := 128
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits = 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, ipNet, err := net.ParseCIDR(value)
+	if err != nil {
+		return nil, fmt.Errorf("无效的地址段: %s", value)
+	}
+	return ipNet, nil
+}
+
+// validateMirrorAccess 检查并规范化镜像的访问控制配置
+func validateMirrorAccess(mirror *models.Mirror) error {
+	cidrs := splitLines(mirror.AllowedCIDRs)
+	for i, value := range cidrs {
+		ipNet, err := parseCIDR(value)
+		if err != nil {
+			return err
+		}
+		cidrs[i] = ipNet.String()
+	}
+	mirror.AllowedCIDRs = strings.Join(cidrs, "\n")
+
+	users := splitLines(mirror.AllowedUsers)
+	for _, username := range users {
+		if !usernamePattern.MatchString(username) {
+			return fmt.Errorf("无效的用户名: %s", username)
+		}
+	}
+	if len(users) > 0 && !mirror.RequireAuth {
+		return errors.New("限制访问用户时必须开启客户端认证")
+	}
+	mirror.AllowedUsers = strings.Join(users, "\n")
+	return nil
+}
+
+// clientAllowed 判断客户端地址是否在镜像的白名单中
+func clientAllowed(mirror *models.Mirror, clientIP string) bool {
+	cidrs := splitLines(mirror.AllowedCIDRs)
+	if len(cidrs) == 0 {
+		return true
+	}
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return false
+	}
+	for _, value := range cidrs {
+		ipNet, err := parseCIDR(value)
+		if err == nil && ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientUser 从请求中解析包管理器客户端的身份
+// 支持 Bearer 令牌(npm 的 _authToken)、HTTP Basic(Maven settings.xml、pip 等)以及管理界面的登录会话
+func clientUser(ctx *gin.Context) (*models.User, error) {
+	header := ctx.GetHeader("Authorization")
+	switch {
+	case strings.HasPrefix(header, "Bearer "):
+		return auth.Authenticate(strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")))
+	case strings.HasPrefix(header, "Basic "):
+		username, password, ok := ctx.Request.BasicAuth()
+		if !ok {
+			return nil, auth.ErrInvalidToken
+		}
+		if strings.HasPrefix(password, auth.TokenPrefix) {
+			return auth.AuthenticateBasic(username, password)
+		}
+		// 使用登录密码时与管理界面的登录共用失败次数限制
+		userKey, ipKey := auth.LoginUserKey(username), auth.LoginIPKey(ctx.ClientIP())
+		if auth.LoginBlocked(userKey, ipKey) > 0 {
+			return nil, auth.ErrTooManyAttempts
+		}
+		user, err := auth.AuthenticateBasic(username, password)
+		switch {
+		case err == nil:
+			auth.ResetLoginFailures(userKey)
+		case errors.Is(err, auth.ErrInvalidToken):
+			auth.RecordLoginFailure(userKey, ipKey)
+		}
+		return user, err
+	}
+	if cookie, err := ctx.Cookie(auth.SessionCookie); err == nil {
+		return auth.Authenticate(cookie)
+	}
+	return nil, auth.ErrInvalidToken
+}
+
+// checkMirrorAccess 检查客户端是否可以访问镜像，不允许时写入响应并返回 false
+func checkMirrorAccess(ctx *gin.Context, mirror *models.Mirror) bool {
+	log := logger.GetLogger()
+
+	if !clientAllowed(mirror, ctx.ClientIP()) {
+		log.Warn("客户端地址不在镜像白名单中",
+			zap.String("mirror", mirror.Name),
+			zap.String("client_ip", ctx.ClientIP()),
+		)
+		ctx.String(http.StatusForbidden, "当前地址不允许访问该镜像")
+		return false
+	}
+
+	// 未开启客户端认证时也解析身份，托管仓库根据当前用户判断发布权限
+	user, err := clientUser(ctx)
+	if errors.Is(err, auth.ErrTooManyAttempts) {
+		ctx.String(http.StatusTooManyRequests, err.Error())
+		return false
+	}
+	if err != nil && !errors.Is(err, auth.ErrInvalidToken) {
+		log.Error("校验客户端身份失败", zap.Error(err))
+	}
+	if user != nil {
+		auth.SetCurrentUser(ctx, user)
+	}
+	if !mirror.RequireAuth {
+		return true
+	}
+
+	if user == nil {
+		ctx.Header("WWW-Authenticate", fmt.Sprintf(`Basic realm=%q`, mirror.Name))
+		ctx.String(http.StatusUnauthorized, "访问该镜像需要认证")
+		return false
+	}
+
+	if !userAllowed(mirror, user) {
+		log.Warn("用户无权访问镜像",
+			zap.String("mirror", mirror.Name),
+			zap.String("username", user.Username),
+		)
+		ctx.String(http.StatusForbidden, "当前用户无权访问该镜像")
+		return false
+	}
+	return true
+}
+
+// userAllowed 判断用户是否在镜像允许访问的用户中，没有限制用户时所有已认证的用户都可以访问
+func userAllowed(mirror *models.Mirror, user *models.User) bool {
+	users := splitLines(mirror.AllowedUsers)
+	if len(users) == 0 {
+		return true
+	}
+	for _, username := range users {
+		if username == user.Username {
+			return true
+		}
+	}
+	return false
+}
+
+// canReadMirror 判断已通过 checkMirrorAccess 的请求能否读取另一个镜像，不写入响应
+// 用于组合镜像检查每个成员自己的访问控制
+func canReadMirror(ctx *gin.Context, mirror *models.Mirror) bool {
+	if !clientAllowed(mirror, ctx.ClientIP()) {
+		return false
+	}
+	if !mirror.RequireAuth {
+		return true
+	}
+	user := auth.CurrentUser(ctx)
+	return user != nil && userAllowed(mirror, user)
+}
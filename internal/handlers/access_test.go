@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"easyCacheMirror/internal/auth"
+	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCanReadMirror(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	alice := &models.User{Username: "alice"}
+	bob := &models.User{Username: "bob"}
+
+	tests := []struct {
+		name     string
+		mirror   models.Mirror
+		clientIP string
+		user     *models.User
+		want     bool
+	}{
+		{"不限制访问", models.Mirror{}, "203.0.113.1", nil, true},
+		{"地址在白名单中", models.Mirror{AllowedCIDRs: "10.0.0.0/8"}, "10.1.2.3", nil, true},
+		{"地址不在白名单中", models.Mirror{AllowedCIDRs: "10.0.0.0/8"}, "203.0.113.1", alice, false},
+		{"需要认证但没有身份", models.Mirror{RequireAuth: true}, "10.1.2.3", nil, false},
+		{"需要认证且已认证", models.Mirror{RequireAuth: true}, "10.1.2.3", bob, true},
+		{"允许的用户", models.Mirror{RequireAuth: true, AllowedUsers: "alice"}, "10.1.2.3", alice, true},
+		{"不允许的用户", models.Mirror{RequireAuth: true, AllowedUsers: "alice"}, "10.1.2.3", bob, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/maven/lib.jar", nil)
+			c.Request.RemoteAddr = tt.clientIP + ":12345"
+			if tt.user != nil {
+				auth.SetCurrentUser(c, tt.user)
+			}
+			if got := canReadMirror(c, &tt.mirror); got != tt.want {
+				t.Errorf("canReadMirror() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckMirrorAccess(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupMirrorDB(t)
+	auth.ResetCredentialCache()
+	t.Cleanup(auth.ResetCredentialCache)
+
+	users := make(map[string]*models.User)
+	for _, username := range []string{"alice", "bob"} {
+		hash, err := auth.HashPassword(username + "-password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		users[username] = &models.User{Username: username, PasswordHash: hash, Role: models.RoleViewer}
+		if err := database.DB.Create(users[username]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	secret, err := auth.NewToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := auth.TokenPrefix + secret
+	if err := database.DB.Create(&models.APIToken{UserID: users["alice"].ID, TokenHash: auth.HashToken(token)}).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { auth.ResetLoginFailures(auth.LoginUserKey("alice"), auth.LoginIPKey("10.1.2.3")) })
+
+	basic := func(username, password string) string {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth(username, password)
+		return req.Header.Get("Authorization")
+	}
+
+	tests := []struct {
+		name          string
+		mirror        models.Mirror
+		clientIP      string
+		authorization string
+		// wantStatus 为 0 时表示允许访问
+		wantStatus int
+		wantUser   string
+	}{
+		{"不限制访问", models.Mirror{}, "203.0.113.1", "", 0, ""},
+		{"未开启认证时也解析身份", models.Mirror{}, "203.0.113.1", basic("alice", "alice-password"), 0, "alice"},
+		{"地址不在白名单中", models.Mirror{AllowedCIDRs: "10.0.0.0/8"}, "203.0.113.1", "", http.StatusForbidden, ""},
+		{"地址在白名单中", models.Mirror{AllowedCIDRs: "10.0.0.0/8\n192.168.1.10"}, "192.168.1.10", "", 0, ""},
+		{"需要认证但没有凭据", models.Mirror{RequireAuth: true}, "10.1.2.3", "", http.StatusUnauthorized, ""},
+		{"Bearer 令牌", models.Mirror{RequireAuth: true}, "10.1.2.3", "Bearer " + token, 0, "alice"},
+		{"无效的 Bearer 令牌", models.Mirror{RequireAuth: true}, "10.1.2.3", "Bearer ecm_invalid", http.StatusUnauthorized, ""},
+		{"Basic 登录密码", models.Mirror{RequireAuth: true}, "10.1.2.3", basic("bob", "bob-password"), 0, "bob"},
+		{"Basic 使用令牌作为密码", models.Mirror{RequireAuth: true}, "10.1.2.3", basic("anyone", token), 0, "alice"},
+		{"Basic 密码错误", models.Mirror{RequireAuth: true}, "10.1.2.3", basic("alice", "wrong"), http.StatusUnauthorized, ""},
+		{"允许的用户", models.Mirror{RequireAuth: true, AllowedUsers: "alice"}, "10.1.2.3", "Bearer " + token, 0, "alice"},
+		{"不允许的用户", models.Mirror{RequireAuth: true, AllowedUsers: "alice"}, "10.1.2.3", basic("bob", "bob-password"), http.StatusForbidden, "bob"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/maven/lib.jar", nil)
+			c.Request.RemoteAddr = tt.clientIP + ":12345"
+			if tt.authorization != "" {
+				c.Request.Header.Set("Authorization", tt.authorization)
+			}
+
+			allowed := checkMirrorAccess(c, &tt.mirror)
+			if allowed != (tt.wantStatus == 0) {
+				t.Fatalf("checkMirrorAccess() = %v, want status %d", allowed, tt.wantStatus)
+			}
+			if !allowed && w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 响应缺少 WWW-Authenticate")
+			}
+			username := ""
+			if user := auth.CurrentUser(c); user != nil {
+				username = user.Username
+			}
+			if username != tt.wantUser {
+				t.Errorf("当前用户 = %q, want %q", username, tt.wantUser)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"easyCacheMirror/internal/auth"
+	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/logger"
+	"easyCacheMirror/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Login 用户名密码登录，成功后写入会话 Cookie
+func Login(c *gin.Context) {
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据"})
+		return
+	}
+
+	// 同一用户名或同一地址连续失败后暂时拒绝登录，避免暴力猜测密码
+	userKey, ipKey := auth.LoginUserKey(req.Username), auth.LoginIPKey(c.ClientIP())
+	if wait := auth.LoginBlocked(userKey, ipKey); wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": auth.ErrTooManyAttempts.Error()})
+		return
+	}
+
+	var user models.User
+	if err := database.DB.Where("username = ?", req.Username).First(&user).Error; err != nil ||
+		!auth.CheckPassword(user.PasswordHash, req.Password) {
+		logger.GetLogger().Warn("登录失败",
+			zap.String("username", req.Username),
+			zap.String("client_ip", c.ClientIP()),
+		)
+		auth.RecordLoginFailure(userKey, ipKey)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户名或密码错误"})
+		return
+	}
+	auth.ResetLoginFailures(userKey)
+
+	token, expiresAt, err := auth.CreateSession(&user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建会话失败"})
+		return
+	}
+	database.DB.Model(&user).Update("last_login_at", time.Now())
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(auth.SessionCookie, token, int(time.Until(expiresAt).Seconds()), "/", "", c.Request.TLS != nil, true)
+	c.JSON(http.StatusOK, gin.H{"user": user, "expiresAt": expiresAt})
+}
+
+// Logout 退出登录
+func Logout(c *gin.Context) {
+	if token, err := c.Cookie(auth.SessionCookie); err == nil {
+		if err := auth.DeleteSession(token); err != nil {
+			logger.GetLogger().Error("删除会话失败", zap.Error(err))
+		}
+	}
+	c.SetCookie(auth.SessionCookie, "", -1, "/", "", c.Request.TLS != nil, true)
+	c.JSON(http.StatusOK, gin.H{"message": "已退出登录"})
+}
+
+// GetCurrentUser 返回当前登录的用户
+func GetCurrentUser(c *gin.Context) {
+	c.JSON(http.StatusOK, auth.CurrentUser(c))
+}
+
+// ChangePassword 修改当前用户的密码
+func ChangePassword(c *gin.Context) {
+	var req struct {
+		OldPassword string `json:"oldPassword"`
+		NewPassword string `json:"newPassword"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据"})
+		return
+	}
+
+	user := auth.CurrentUser(c)
+	if !auth.CheckPassword(user.PasswordHash, req.OldPassword) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "原密码错误"})
+		return
+	}
+	if err := validatePassword(req.NewPassword); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hash, err := auth.HashPassword(req.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "修改密码失败"})
+		return
+	}
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Update("password_hash", hash).Error; err != nil {
+			return err
+		}
+		// 修改密码后让其他登录会话失效，只保留当前会话
+		query := tx.Where("user_id = ?", user.ID)
+		if token := currentSessionToken(c); token != "" {
+			query = query.Where("token_hash <> ?", auth.HashToken(token))
+		}
+		return query.Delete(&models.Session{}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "修改密码失败"})
+		return
+	}
+	auth.ResetCredentialCache()
+
+	c.JSON(http.StatusOK, gin.H{"message": "密码已修改"})
+}
+
+// currentSessionToken 返回当前请求使用的会话令牌，与 AuthMiddleware 的取值顺序一致
+func currentSessionToken(c *gin.Context) string {
+	if header := c.GetHeader("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	}
+	if cookie, err := c.Cookie(auth.SessionCookie); err == nil {
+		return cookie
+	}
+	return ""
+}
+
+// ListTokens 列出当前用户的 API 令牌
+func ListTokens(c *gin.Context) {
+	var tokens []models.APIToken
+	if err := database.DB.Where("user_id = ?", auth.CurrentUser(c).ID).
+		Order("id desc").Find(&tokens).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取令牌列表失败"})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// CreateToken 为当前用户创建 API 令牌，令牌明文只在创建时返回一次
+func CreateToken(c *gin.Context) {
+	var req struct {
+		Name      string `json:"name"`
+		ExpiresIn int    `json:"expiresIn"` // 有效天数，0 表示永不过期
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请输入令牌名称"})
+		return
+	}
+
+	token, err := auth.NewToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成令牌失败"})
+		return
+	}
+	token = auth.TokenPrefix + token
+
+	apiToken := models.APIToken{
+		UserID:    auth.CurrentUser(c).ID,
+		Name:      strings.TrimSpace(req.Name),
+		TokenHash: auth.HashToken(token),
+		Prefix:    token[:len(auth.TokenPrefix)+6],
+	}
+	if req.ExpiresIn > 0 {
+		expiresAt := time.Now().AddDate(0, 0, req.ExpiresIn)
+		apiToken.ExpiresAt = &expiresAt
+	}
+	if err := database.DB.Create(&apiToken).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建令牌失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"token": token, "apiToken": apiToken})
+}
+
+// DeleteToken 删除当前用户的 API 令牌
+func DeleteToken(c *gin.Context) {
+	result := database.DB.Where("id = ? AND user_id = ?", c.Param("id"), auth.CurrentUser(c).ID).
+		Delete(&models.APIToken{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除令牌失败"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "令牌不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "令牌已删除"})
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"easyCacheMirror/internal/auth"
+	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/middleware"
+	"easyCacheMirror/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setupAuthDB 使用测试数据库并创建一个测试用户
+func setupAuthDB(t *testing.T, role string) *models.User {
+	t.Helper()
+	db := database.UseTestDB(t)
+
+	hash, err := auth.HashPassword("old-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{Username: "alice", PasswordHash: hash, Role: role}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// newAuthRouter 按正式路由的方式注册登录相关的接口
+func newAuthRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/api/auth/login", Login)
+	authorized := r.Group("/api", middleware.AuthMiddleware())
+	authorized.PUT("/auth/password", ChangePassword)
+	authorized.GET("/users", middleware.RequireAdmin(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	return r
+}
+
+func TestLoginDoesNotReturnToken(t *testing.T) {
+	setupAuthDB(t, models.RoleViewer)
+	r := newAuthRouter()
+
+	tests := []struct {
+		name       string
+		password   string
+		wantStatus int
+	}{
+		{"正确密码", "old-password", http.StatusOK},
+		{"错误密码", "wrong", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			body := `{"username":"alice","password":"` + tt.password + `"}`
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(body)))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var resp map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := resp["token"]; ok {
+				t.Error("登录响应中不应包含会话令牌")
+			}
+			if !strings.Contains(w.Header().Get("Set-Cookie"), auth.SessionCookie+"=") {
+				t.Error("登录后没有写入会话 Cookie")
+			}
+		})
+	}
+}
+
+func TestLoginThrottle(t *testing.T) {
+	setupAuthDB(t, models.RoleViewer)
+	r := newAuthRouter()
+	keys := []string{auth.LoginUserKey("alice"), auth.LoginIPKey("192.0.2.1")}
+	auth.ResetLoginFailures(keys...)
+	t.Cleanup(func() { auth.ResetLoginFailures(keys...) })
+
+	login := func(password string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		body := `{"username":"alice","password":"` + password + `"}`
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(body)))
+		return w
+	}
+	for i := 0; i < 5; i++ {
+		if w := login("wrong"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("第 %d 次失败 status = %d, want %d", i+1, w.Code, http.StatusUnauthorized)
+		}
+	}
+
+	// 达到失败次数后正确密码也被拒绝
+	w := login("old-password")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("没有返回 Retry-After")
+	}
+}
+
+func TestChangePasswordRevokesOtherSessions(t *testing.T) {
+	user := setupAuthDB(t, models.RoleViewer)
+	r := newAuthRouter()
+
+	current, _, err := auth.CreateSession(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := auth.CreateSession(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiToken := auth.TokenPrefix + "kept"
+	database.DB.Create(&models.APIToken{UserID: user.ID, Name: "ci", TokenHash: auth.HashToken(apiToken)})
+
+	req := httptest.NewRequest(http.MethodPut, "/api/auth/password",
+		strings.NewReader(`{"oldPassword":"old-password","newPassword":"new-password"}`))
+	req.Header.Set("Authorization", "Bearer "+current)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"当前会话", current, true},
+		{"其他会话", other, false},
+		{"API 令牌", apiToken, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := auth.Authenticate(tt.token)
+			if valid := err == nil; valid != tt.valid {
+				t.Errorf("Authenticate() valid = %v, want %v (err = %v)", valid, tt.valid, err)
+			}
+		})
+	}
+
+	var updated models.User
+	database.DB.First(&updated, user.ID)
+	if !auth.CheckPassword(updated.PasswordHash, "new-password") {
+		t.Error("密码没有修改")
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	tests := []struct {
+		role       string
+		wantStatus int
+	}{
+		{models.RoleAdmin, http.StatusOK},
+		{models.RoleWriter, http.StatusForbidden},
+		{models.RoleViewer, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.role, func(t *testing.T) {
+			user := setupAuthDB(t, tt.role)
+			token, _, err := auth.CreateSession(user)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			newAuthRouter().ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+
+	t.Run("未登录", func(t *testing.T) {
+		setupAuthDB(t, models.RoleViewer)
+		w := httptest.NewRecorder()
+		newAuthRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/users", nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+		}
+	})
+}
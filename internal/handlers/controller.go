@@ -5,7 +5,9 @@ import (
 	"easyCacheMirror/internal/database"
 	"easyCacheMirror/internal/logger"
 	"easyCacheMirror/internal/models"
+	"easyCacheMirror/internal/proxy"
 	"easyCacheMirror/internal/registry"
+	"errors"
 	"net/http"
 	"strings"
 
@@ -27,6 +29,7 @@ func NewController() *Controller {
 		log.Error("无法获取Registry")
 		return nil
 	}
+	registry.OnReadAccess(canReadMirror)
 
 	return &Controller{
 		mirrorCache: cache.GetMirrorCache(),
@@ -76,6 +79,15 @@ func (c *Controller) HandleRequest(ctx *gin.Context) {
 		zap.Int("match_length", longestMatch),
 	)
 
+	// 检查客户端地址和凭据
+	if !checkMirrorAccess(ctx, matchedMirror) {
+		return
+	}
+
+	// 客户端的凭据只用于访问本服务，不能转发给上游，上游凭据由代理层按镜像配置添加
+	ctx.Request.Header.Del("Authorization")
+	ctx.Request.Header.Del("Cookie")
+
 	// 获取对应的处理器
 	handler := registry.GetRegistry().GetHandler(matchedMirror.Type)
 	if handler == nil {
@@ -85,12 +97,26 @@ func (c *Controller) HandleRequest(ctx *gin.Context) {
 
 	// 处理请求
 	if err := handler.Handle(ctx, matchedMirror, relativePath); err != nil {
+		// 离线模式下缓存未命中时处理器无法访问上游，按文件不存在处理，其他错误仍然返回 500
+		if errors.Is(err, proxy.ErrOffline) {
+			log.Info("离线模式下缓存未命中",
+				zap.String("path", path),
+				zap.String("mirror", matchedMirror.Name),
+			)
+			if !ctx.Writer.Written() {
+				ctx.String(http.StatusNotFound, "离线模式下缓存中没有该文件")
+			}
+			return
+		}
 		log.Error("处理请求失败",
 			zap.Error(err),
 			zap.String("path", path),
 			zap.String("mirror_type", matchedMirror.Type),
 		)
-		ctx.String(http.StatusInternalServerError, "处理请求失败")
+		// 响应已经开始发送时不能再写入错误信息
+		if !ctx.Writer.Written() {
+			ctx.String(http.StatusInternalServerError, "处理请求失败")
+		}
 		return
 	}
 
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"easyCacheMirror/internal/cache"
+	"easyCacheMirror/internal/models"
+	"easyCacheMirror/internal/proxy"
+	"easyCacheMirror/internal/registry"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errorHandler 总是返回指定错误的处理器
+type errorHandler struct {
+	err error
+}
+
+func (h *errorHandler) SupportedType() string { return "ErrorTest" }
+
+func (h *errorHandler) Handle(c *gin.Context, mirror *models.Mirror, path string) error {
+	return h.err
+}
+
+func (h *errorHandler) CleanupCache(mirror *models.Mirror) error { return nil }
+
+func TestHandleRequestErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := &errorHandler{}
+	registry.GetRegistry().Register(handler)
+
+	mirror := &models.Mirror{ID: 1000, Name: "error-test", Type: handler.SupportedType(), AccessURL: "error-test"}
+	cache.GetMirrorCache().Set(mirror)
+	t.Cleanup(func() {
+		cache.GetMirrorCache().Remove(mirror)
+		proxy.SetOffline(false)
+	})
+
+	controller := NewController()
+	tests := []struct {
+		name       string
+		offline    bool
+		err        error
+		wantStatus int
+	}{
+		{"离线模式下缓存未命中", true, fmt.Errorf("代理请求失败: %w", proxy.ErrOffline), http.StatusNotFound},
+		{"离线模式下的其他错误", true, errors.New("读取缓存文件失败"), http.StatusInternalServerError},
+		{"在线时的错误", false, errors.New("读取缓存文件失败"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy.SetOffline(tt.offline)
+			handler.err = tt.err
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/error-test/file", nil)
+			controller.HandleRequest(c)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
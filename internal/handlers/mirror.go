@@ -4,42 +4,25 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"os"
-	"path/filepath"
+	"net/textproto"
+	"regexp"
 	"strings"
 
 	"easyCacheMirror/internal/cache"
 	"easyCacheMirror/internal/database"
 	"easyCacheMirror/internal/models"
-	"easyCacheMirror/internal/registry"
+	"easyCacheMirror/internal/proxy"
+	"easyCacheMirror/internal/scheduler"
+	"easyCacheMirror/internal/storage"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
-// 创建镜像目录
-func createMirrorDirectory(path string) error {
-	// 检查目录是否已存在
-	if _, err := os.Stat(path); err == nil {
-		// 目录已存在，直接返回
-		return nil
-	} else if !os.IsNotExist(err) {
-		// 如果是其他错误（比如权限问题），返回错误
-		return err
-	}
-	// 目录不存在，创建新目录
-	return os.MkdirAll(path, 0755)
-}
-
-// 删除镜像目录
-func deleteMirrorDirectory(path string) error {
-	return os.RemoveAll(path)
-}
-
 // 获取镜像列表
 func ListMirrors(c *gin.Context) {
 	var mirrors []models.Mirror
-	result := database.DB.Find(&mirrors)
+	result := database.DB.Preload("Upstreams").Find(&mirrors)
 	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "获取镜像列表失败",
@@ -50,13 +33,14 @@ func ListMirrors(c *gin.Context) {
 	// 创建响应结构
 	type MirrorResponse struct {
 		models.Mirror
-		UsedSpace int64 `json:"usedSpace"`
+		UsedSpace     int64 `json:"usedSpace"`
+		PhysicalSpace int64 `json:"physicalSpace"` // 与其他镜像共用的内容按引用数分摊
 	}
 
 	var response []MirrorResponse
 	for _, mirror := range mirrors {
 		// 获取已用空间
-		usedSpace, err := database.GetMirrorUsedSpace(mirror.ID)
+		usage, err := storage.UsedSpace(&mirror)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": "计算镜像使用空间失败",
@@ -65,8 +49,9 @@ func ListMirrors(c *gin.Context) {
 		}
 
 		response = append(response, MirrorResponse{
-			Mirror:    mirror,
-			UsedSpace: usedSpace,
+			Mirror:        mirror,
+			UsedSpace:     usage.Logical,
+			PhysicalSpace: usage.Physical,
 		})
 	}
 
@@ -81,37 +66,57 @@ func CreateMirror(c *gin.Context) {
 		return
 	}
 
-	// 检查是否已存在相同类型的镜像
-	var existingMirror models.Mirror
-	result := database.DB.Where("type = ?", mirror.Type).First(&existingMirror)
-	if result.Error == nil {
-		c.JSON(http.StatusConflict, gin.H{
-			"error": fmt.Sprintf("已存在 %s 类型的镜像: %s", mirror.Type, existingMirror.Name),
-		})
+	// 检查访问地址是否已被其他镜像使用
+	if status, err := checkAccessURL(&mirror, 0); err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
 		return
-	} else if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "检查镜像类型失败",
-		})
+	}
+
+	// 检查组合镜像的成员
+	if err := validateMirrorKind(&mirror); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// 如果是 NPM 类型且没有指定上游地址，使用默认地址
-	if mirror.Type == "NPM" && mirror.UpstreamURL == "" {
-		mirror.UpstreamURL = models.DefaultNPMRegistry
+	// 检查客户端访问控制配置
+	if err := validateMirrorAccess(&mirror); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	// 创建Blob存储目录
-	if err := createMirrorDirectory(mirror.BlobPath); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "创建存储目录失败",
-		})
+	// 检查上游认证配置
+	if err := validateUpstreamAuth(&mirror, nil); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 检查清理计划
+	if err := validateCleanupSchedule(&mirror); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	// 检查淘汰策略
+	if err := validateEviction(&mirror); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 检查存储后端配置
+	if err := validateStorage(&mirror, nil); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 如果是 NPM 类型且没有指定上游地址，使用默认地址
+	if mirror.Type == "NPM" && mirror.UpstreamURL == "" && !mirror.IsHosted() {
+		mirror.UpstreamURL = models.DefaultNPMRegistry
+	}
+
+	// 备用上游随镜像一起创建
+	normalizeUpstreams(&mirror)
+
 	if err := database.DB.Create(&mirror).Error; err != nil {
-		// 如果数据库创建失败，删除已创建的目录
-		os.RemoveAll(mirror.BlobPath)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "创建镜像失败",
 		})
@@ -143,21 +148,47 @@ func UpdateMirror(c *gin.Context) {
 		return
 	}
 
-	// 如果类型发生变化，检查新类型是否已存在
-	if mirror.Type != oldMirror.Type {
-		var existingMirror models.Mirror
-		result := database.DB.Where("type = ? AND id != ?", mirror.Type, oldMirror.ID).First(&existingMirror)
-		if result.Error == nil {
-			c.JSON(http.StatusConflict, gin.H{
-				"error": fmt.Sprintf("已存在 %s 类型的镜像: %s", mirror.Type, existingMirror.Name),
-			})
-			return
-		} else if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "检查镜像类型失败",
-			})
-			return
-		}
+	// 检查访问地址是否已被其他镜像使用
+	if status, err := checkAccessURL(&mirror, oldMirror.ID); err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 检查组合镜像的成员
+	mirror.ID = oldMirror.ID
+	if err := validateMirrorKind(&mirror); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 检查客户端访问控制配置
+	if err := validateMirrorAccess(&mirror); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 检查上游认证配置，未填写密码或令牌时沿用原来的
+	if err := validateUpstreamAuth(&mirror, &oldMirror); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 检查清理计划
+	if err := validateCleanupSchedule(&mirror); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 检查淘汰策略
+	if err := validateEviction(&mirror); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 检查存储后端配置，未填写密钥时沿用原来的
+	if err := validateStorage(&mirror, &oldMirror); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
 	// 检查名称是否被其他镜像使用
@@ -172,28 +203,20 @@ func UpdateMirror(c *gin.Context) {
 		}
 	}
 
-	// 如果Blob路径发生变化
-	if oldMirror.BlobPath != mirror.BlobPath {
-		// 创建新目录
-		if err := createMirrorDirectory(mirror.BlobPath); err != nil {
+	// 存储位置发生变化时清空原来的缓存，validateStorage 已确认没有本地发布的文件
+	if !sameStorage(&oldMirror, &mirror) {
+		if err := storage.ForMirror(&oldMirror).Clear(); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "创建新存储目录失败",
+				"error": "清空原存储失败: " + err.Error(),
 			})
 			return
 		}
-
-		// 移动文件到新目录
-		if err := moveDirectory(oldMirror.BlobPath, mirror.BlobPath); err != nil {
-			// 如果移动失败，删除新创建的目录
-			os.RemoveAll(mirror.BlobPath)
+		if err := database.DeleteFileRecords(oldMirror.ID, oldMirror.BlobPath, true); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "移动存储目录失败",
+				"error": "删除文件记录失败",
 			})
 			return
 		}
-
-		// 删除旧目录
-		os.RemoveAll(oldMirror.BlobPath)
 	}
 
 	// 保持原有的统计数据不变
@@ -204,8 +227,24 @@ func UpdateMirror(c *gin.Context) {
 	mirror.RequestCount = oldMirror.RequestCount // 保留请求次数
 	mirror.HitCount = oldMirror.HitCount         // 保留命中次数
 
-	// 更新镜像
-	if err := database.DB.Save(&mirror).Error; err != nil {
+	// 更新镜像，备用上游整体替换
+	normalizeUpstreams(&mirror)
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Upstreams").Save(&mirror).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("mirror_id = ?", mirror.ID).Delete(&models.MirrorUpstream{}).Error; err != nil {
+			return err
+		}
+		for i := range mirror.Upstreams {
+			mirror.Upstreams[i].MirrorID = mirror.ID
+		}
+		if len(mirror.Upstreams) > 0 {
+			return tx.Create(&mirror.Upstreams).Error
+		}
+		return nil
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "更新镜像失败",
 		})
@@ -228,15 +267,45 @@ func DeleteMirror(c *gin.Context) {
 		return
 	}
 
-	// 删除Blob存储目录
-	if err := deleteMirrorDirectory(mirror.BlobPath); err != nil {
+	// 仍被组合镜像引用的成员不能删除，否则组合镜像会一直引用不存在的成员
+	groups, err := referencingGroups(mirror.ID)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "删除存储目录失败",
+			"error": "检查组合镜像失败",
+		})
+		return
+	}
+	if len(groups) > 0 {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": fmt.Sprintf("镜像仍是组合镜像 %s 的成员，请先从组合镜像中移除", strings.Join(groups, "、")),
 		})
 		return
 	}
 
-	if err := database.DB.Delete(&mirror).Error; err != nil {
+	// 删除镜像的所有缓存文件，其他镜像仍在引用的内容会保留
+	if err := storage.ForMirror(&mirror).Clear(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "删除缓存文件失败",
+		})
+		return
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("mirror_id = ?", mirror.ID).Delete(&models.MirrorUpstream{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("mirror_id = ?", mirror.ID).Delete(&models.CleanupRun{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("mirror_id = ?", mirror.ID).Delete(&models.CacheEntry{}).Error; err != nil {
+			return err
+		}
+		if err := database.DeleteMirrorFileRecords(tx, mirror.ID); err != nil {
+			return err
+		}
+		return tx.Delete(&mirror).Error
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "删除镜像失败",
 		})
@@ -251,37 +320,269 @@ func DeleteMirror(c *gin.Context) {
 	})
 }
 
-// 移动目录内容
-func moveDirectory(src, dst string) error {
+// referencingGroups 返回成员中包含指定镜像的组合镜像名称
+func referencingGroups(id uint) ([]string, error) {
+	var groups []models.Mirror
+	if err := database.DB.Where("kind = ?", models.MirrorKindGroup).Find(&groups).Error; err != nil {
+		return nil, err
+	}
 
-	entries, err := os.ReadDir(src)
-	if err != nil {
-		return err
+	var names []string
+	for _, group := range groups {
+		for _, member := range group.Members {
+			if member == id {
+				names = append(names, group.Name)
+				break
+			}
+		}
 	}
+	return names, nil
+}
 
-	for _, entry := range entries {
-		srcPath := filepath.Join(src, entry.Name())
-		dstPath := filepath.Join(dst, entry.Name())
+// checkAccessURL 检查访问地址是否与其他镜像重复，excludeID 为正在更新的镜像
+func checkAccessURL(mirror *models.Mirror, excludeID uint) (int, error) {
+	var mirrors []models.Mirror
+	if err := database.DB.Where("id != ?", excludeID).Find(&mirrors).Error; err != nil {
+		return http.StatusInternalServerError, errors.New("检查访问地址失败")
+	}
 
-		if entry.IsDir() {
-			// 如果是目录，递归移动
-			if err := os.MkdirAll(dstPath, 0755); err != nil {
-				return err
-			}
-			if err := moveDirectory(srcPath, dstPath); err != nil {
-				return err
-			}
-		} else {
-			// 如果是文件，直接移动
-			if err := os.Rename(srcPath, dstPath); err != nil {
-				return err
+	accessURL := strings.Trim(mirror.AccessURL, "/")
+	for _, existing := range mirrors {
+		if strings.Trim(existing.AccessURL, "/") == accessURL {
+			return http.StatusConflict, fmt.Errorf("访问地址已被镜像 %s 使用", existing.Name)
+		}
+	}
+	return http.StatusOK, nil
+}
+
+// hostedMirrorTypes 支持托管镜像的类型
+var hostedMirrorTypes = map[string]bool{
+	"NPM":   true,
+	"Maven": true,
+	"PyPI":  true,
+}
+
+// validateMirrorKind 检查镜像种类，组合镜像的成员必须是同类型的代理或托管镜像
+func validateMirrorKind(mirror *models.Mirror) error {
+	if mirror.Kind == "" {
+		mirror.Kind = models.MirrorKindProxy
+	}
+
+	switch mirror.Kind {
+	case models.MirrorKindProxy:
+		mirror.Members = nil
+		return nil
+	case models.MirrorKindHosted:
+		if !hostedMirrorTypes[mirror.Type] {
+			return fmt.Errorf("%s 类型不支持托管镜像", mirror.Type)
+		}
+		mirror.Members = nil
+		mirror.HostedScopes = ""
+		return nil
+	case models.MirrorKindGroup:
+	default:
+		return fmt.Errorf("不支持的镜像种类: %s", mirror.Kind)
+	}
+
+	if mirror.Type != "Maven" {
+		return fmt.Errorf("%s 类型不支持组合镜像", mirror.Type)
+	}
+	if len(mirror.Members) == 0 {
+		return errors.New("组合镜像至少需要一个成员")
+	}
+
+	for _, id := range mirror.Members {
+		if id == mirror.ID {
+			return errors.New("组合镜像不能包含自身")
+		}
+		var member models.Mirror
+		if err := database.DB.First(&member, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("成员镜像不存在: %d", id)
 			}
+			return errors.New("检查成员镜像失败")
+		}
+		if member.Type != mirror.Type || member.IsGroup() {
+			return fmt.Errorf("成员 %s 必须是 %s 类型的代理或托管镜像", member.Name, mirror.Type)
+		}
+	}
+	return nil
+}
+
+// validateUpstreamAuth 检查上游认证配置，oldMirror 不为空时表示修改镜像
+func validateUpstreamAuth(mirror *models.Mirror, oldMirror *models.Mirror) error {
+	if mirror.IsGroup() || mirror.IsHosted() {
+		mirror.UpstreamAuthType = models.UpstreamAuthNone
+	}
+
+	switch mirror.UpstreamAuthType {
+	case models.UpstreamAuthNone:
+		mirror.UpstreamUsername = ""
+		mirror.UpstreamHeaderName = ""
+		mirror.UpstreamSecret = ""
+		return nil
+	case models.UpstreamAuthBasic:
+		if mirror.UpstreamUsername == "" {
+			return errors.New("请填写上游用户名")
+		}
+		mirror.UpstreamHeaderName = ""
+	case models.UpstreamAuthBearer:
+		mirror.UpstreamUsername = ""
+		mirror.UpstreamHeaderName = ""
+	case models.UpstreamAuthHeader:
+		mirror.UpstreamHeaderName = textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(mirror.UpstreamHeaderName))
+		if !headerNamePattern.MatchString(mirror.UpstreamHeaderName) {
+			return errors.New("请填写有效的认证请求头名称")
 		}
+		mirror.UpstreamUsername = ""
+	default:
+		return fmt.Errorf("不支持的上游认证方式: %s", mirror.UpstreamAuthType)
 	}
 
+	// 接口不返回已保存的密码，修改时留空表示不修改；
+	// 主上游换到其他主机或更换认证方式时不沿用原来的凭据，避免发送给新的主机
+	if mirror.UpstreamSecret == "" && oldMirror != nil &&
+		oldMirror.UpstreamAuthType == mirror.UpstreamAuthType &&
+		proxy.SameHost(oldMirror.UpstreamURL, mirror.UpstreamURL) {
+		mirror.UpstreamSecret = oldMirror.UpstreamSecret
+	}
+	if mirror.UpstreamSecret == "" {
+		if oldMirror != nil && oldMirror.UpstreamSecret != "" {
+			return errors.New("更换上游地址或认证方式后需要重新填写上游密码或令牌")
+		}
+		return errors.New("请填写上游密码或令牌")
+	}
 	return nil
 }
 
+// headerNamePattern 合法的 HTTP 请求头名称
+var headerNamePattern = regexp.MustCompile("^[A-Za-z0-9!#$%&'*+.^_`|~-]+$")
+
+// validateEviction 检查镜像的淘汰策略和清理水位
+func validateEviction(mirror *models.Mirror) error {
+	mirror.EvictionPolicy = strings.ToLower(strings.TrimSpace(mirror.EvictionPolicy))
+	if mirror.EvictionPolicy == "" {
+		mirror.EvictionPolicy = models.EvictionLRU
+	}
+	if !storage.ValidEvictionPolicy(mirror.EvictionPolicy) {
+		return fmt.Errorf("不支持的淘汰策略: %s", mirror.EvictionPolicy)
+	}
+	if mirror.EvictionPolicy == models.EvictionTTL && mirror.EvictionTTL <= 0 {
+		return fmt.Errorf("ttl 策略需要设置文件的保留时间")
+	}
+	if mirror.EvictionTTL < 0 {
+		return fmt.Errorf("文件的保留时间不能小于 0")
+	}
+
+	high, low := mirror.HighWatermark, mirror.LowWatermark
+	if high == 0 {
+		high = models.DefaultHighWatermark
+	}
+	if low == 0 {
+		low = models.DefaultLowWatermark
+	}
+	if high < 1 || high > 100 || low < 1 || low > 100 {
+		return fmt.Errorf("清理水位需要在 1 到 100 之间")
+	}
+	if low >= high {
+		return fmt.Errorf("低水位需要小于高水位")
+	}
+	return nil
+}
+
+// validateStorage 检查存储后端配置，oldMirror 不为空时表示修改镜像
+// 存储位置发生变化时原来的缓存会被清空，有本地发布的文件时不允许修改
+func validateStorage(mirror *models.Mirror, oldMirror *models.Mirror) error {
+	mirror.StorageType = strings.ToLower(strings.TrimSpace(mirror.StorageType))
+	switch mirror.StorageType {
+	case "", models.StorageLocal:
+		mirror.StorageType = models.StorageLocal
+		mirror.S3Endpoint = ""
+		mirror.S3Region = ""
+		mirror.S3Bucket = ""
+		mirror.S3Prefix = ""
+		mirror.S3AccessKey = ""
+		mirror.S3SecretKey = ""
+		mirror.S3VirtualHost = false
+		mirror.S3Redirect = false
+	case models.StorageS3:
+		mirror.S3Endpoint = strings.TrimRight(strings.TrimSpace(mirror.S3Endpoint), "/")
+		mirror.S3Bucket = strings.TrimSpace(mirror.S3Bucket)
+		mirror.S3Prefix = strings.Trim(strings.TrimSpace(mirror.S3Prefix), "/")
+		if mirror.S3Endpoint == "" || mirror.S3Bucket == "" {
+			return errors.New("请填写对象存储地址和 bucket")
+		}
+		if mirror.S3AccessKey == "" {
+			return errors.New("请填写对象存储的 Access Key")
+		}
+		// 接口不返回已保存的密钥，修改时留空表示不修改
+		if mirror.S3SecretKey == "" && oldMirror != nil {
+			mirror.S3SecretKey = oldMirror.S3SecretKey
+		}
+		if mirror.S3SecretKey == "" {
+			return errors.New("请填写对象存储的 Secret Key")
+		}
+
+		store, err := storage.NewS3Store(storage.S3ConfigFor(mirror))
+		if err != nil {
+			return err
+		}
+		if err := store.Check(); err != nil {
+			return fmt.Errorf("无法访问对象存储: %v", err)
+		}
+	default:
+		return fmt.Errorf("不支持的存储后端: %s", mirror.StorageType)
+	}
+
+	if oldMirror != nil && !sameStorage(oldMirror, mirror) {
+		var hosted int64
+		if err := database.DB.Model(&models.CacheEntry{}).
+			Where("mirror_id = ? AND hosted = ?", oldMirror.ID, true).
+			Count(&hosted).Error; err != nil {
+			return fmt.Errorf("检查本地发布的文件失败: %v", err)
+		}
+		if hosted > 0 {
+			return errors.New("镜像中有本地发布的文件，不能修改存储位置")
+		}
+	}
+	return nil
+}
+
+// sameStorage 判断两个镜像配置的缓存是否保存在同一位置，本地存储的镜像都使用同一个目录
+func sameStorage(a, b *models.Mirror) bool {
+	if a.UsesS3() != b.UsesS3() {
+		return false
+	}
+	if !a.UsesS3() {
+		return true
+	}
+	return a.S3Endpoint == b.S3Endpoint && a.S3Bucket == b.S3Bucket && a.S3Prefix == b.S3Prefix
+}
+
+// validateCleanupSchedule 检查镜像的清理计划
+func validateCleanupSchedule(mirror *models.Mirror) error {
+	mirror.CleanupSchedule = strings.TrimSpace(mirror.CleanupSchedule)
+	if _, err := scheduler.ParseSchedule(mirror.CleanupSchedule); err != nil {
+		return fmt.Errorf("清理计划无效: %v", err)
+	}
+	return nil
+}
+
+// normalizeUpstreams 清理备用上游列表，去掉空地址并重置主键，保存时按列表重新创建
+func normalizeUpstreams(mirror *models.Mirror) {
+	upstreams := make([]models.MirrorUpstream, 0, len(mirror.Upstreams))
+	for _, upstream := range mirror.Upstreams {
+		upstream.URL = strings.TrimSpace(upstream.URL)
+		if upstream.URL == "" {
+			continue
+		}
+		upstream.ID = 0
+		upstream.MirrorID = mirror.ID
+		upstreams = append(upstreams, upstream)
+	}
+	mirror.Upstreams = upstreams
+}
+
 // CleanupMirrorCache 清理指定镜像的缓存
 func CleanupMirrorCache(c *gin.Context) {
 	// 获取镜像ID
@@ -296,17 +597,13 @@ func CleanupMirrorCache(c *gin.Context) {
 		return
 	}
 
-	// 获取对应类型的处理器
-	handler := registry.GetRegistry().GetHandler(mirror.Type)
-	if handler == nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "不支持的镜像类型",
-		})
+	// 执行缓存清理，与定时清理共用同一流程并记录清理结果
+	run, err := scheduler.Run(mirror.ID, models.CleanupTriggerManual)
+	if errors.Is(err, scheduler.ErrCleanupRunning) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
 		return
 	}
-
-	// 执行缓存清理
-	if err := handler.CleanupCache(c, &mirror); err != nil {
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": fmt.Sprintf("清理缓存失败: %v", err),
 		})
@@ -315,9 +612,26 @@ func CleanupMirrorCache(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "缓存清理完成",
+		"run":     run,
 	})
 }
 
+// ListCleanupRuns 获取镜像最近的缓存清理记录
+func ListCleanupRuns(c *gin.Context) {
+	var mirror models.Mirror
+	if err := database.DB.First(&mirror, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "镜像不存在"})
+		return
+	}
+
+	var runs []models.CleanupRun
+	if err := database.DB.Where("mirror_id = ?", mirror.ID).Order("id desc").Find(&runs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取清理记录失败"})
+		return
+	}
+	c.JSON(http.StatusOK, runs)
+}
+
 // GetSimpleMirrors 获取简化的镜像列表
 func GetSimpleMirrors(c *gin.Context) {
 	var mirrors []models.Mirror
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/models"
+	"easyCacheMirror/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setupMirrorDB 使用测试数据库和临时的内容寻址存储
+func setupMirrorDB(t *testing.T) {
+	t.Helper()
+	database.UseTestDB(t)
+
+	previous := storage.CASDir
+	storage.CASDir = filepath.Join(t.TempDir(), "cas")
+	t.Cleanup(func() { storage.CASDir = previous })
+}
+
+// countFileRecords 返回镜像在各类型文件表中的记录数量
+func countFileRecords(t *testing.T, mirrorID uint) int64 {
+	t.Helper()
+	var total int64
+	for _, model := range []interface{}{&models.NPMFile{}, &models.MavenFile{}, &models.RawFile{}} {
+		var count int64
+		if err := database.DB.Model(model).Where("mirror_id = ?", mirrorID).Count(&count).Error; err != nil {
+			t.Fatal(err)
+		}
+		total += count
+	}
+	return total
+}
+
+func TestDeleteMirror(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupMirrorDB(t)
+
+	mirrors := make(map[string]*models.Mirror)
+	for _, name := range []string{"deleted", "kept", "member"} {
+		mirror := &models.Mirror{Name: name, Type: "Maven", BlobPath: filepath.Join(t.TempDir(), name)}
+		if err := database.DB.Create(mirror).Error; err != nil {
+			t.Fatal(err)
+		}
+		mirrors[name] = mirror
+
+		records := []interface{}{
+			&models.NPMFile{MirrorID: mirror.ID, SavePath: filepath.Join(mirror.BlobPath, "a.tgz")},
+			&models.MavenFile{MirrorID: mirror.ID, SavePath: filepath.Join(mirror.BlobPath, "a.jar")},
+			&models.RawFile{MirrorID: mirror.ID, SavePath: filepath.Join(mirror.BlobPath, "a.bin")},
+		}
+		for _, record := range records {
+			if err := database.DB.Create(record).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	group := &models.Mirror{Name: "group", Type: "Maven", Kind: models.MirrorKindGroup, Members: []uint{mirrors["member"].ID}}
+	if err := database.DB.Create(group).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.DELETE("/api/mirrors/:id", DeleteMirror)
+
+	tests := []struct {
+		name        string
+		mirror      string
+		wantStatus  int
+		wantRecords map[string]int64
+	}{
+		{"删除镜像及其文件记录", "deleted", http.StatusOK, map[string]int64{"deleted": 0, "kept": 3, "member": 3}},
+		{"组合镜像的成员不能删除", "member", http.StatusConflict, map[string]int64{"kept": 3, "member": 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api/mirrors/%d", mirrors[tt.mirror].ID), nil)
+			r.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body = %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			for name, want := range tt.wantRecords {
+				if got := countFileRecords(t, mirrors[name].ID); got != want {
+					t.Errorf("%s 的文件记录数量 = %d, want %d", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateUpstreamAuthKeepsSecret(t *testing.T) {
+	old := &models.Mirror{
+		UpstreamURL:      "https://repo.example.com/maven",
+		UpstreamAuthType: models.UpstreamAuthBasic,
+		UpstreamUsername: "deploy",
+		UpstreamSecret:   "old-secret",
+	}
+
+	tests := []struct {
+		name       string
+		mirror     models.Mirror
+		old        *models.Mirror
+		wantSecret models.EncryptedString
+		wantErr    bool
+	}{
+		{
+			name:       "同一主机留空沿用原来的凭据",
+			mirror:     models.Mirror{UpstreamURL: "https://repo.example.com/other", UpstreamAuthType: models.UpstreamAuthBasic, UpstreamUsername: "deploy"},
+			old:        old,
+			wantSecret: "old-secret",
+		},
+		{
+			name:    "更换主机后留空",
+			mirror:  models.Mirror{UpstreamURL: "https://evil.example.net/maven", UpstreamAuthType: models.UpstreamAuthBasic, UpstreamUsername: "deploy"},
+			old:     old,
+			wantErr: true,
+		},
+		{
+			name:    "更换端口后留空",
+			mirror:  models.Mirror{UpstreamURL: "https://repo.example.com:8443/maven", UpstreamAuthType: models.UpstreamAuthBasic, UpstreamUsername: "deploy"},
+			old:     old,
+			wantErr: true,
+		},
+		{
+			name:       "更换主机并填写新的凭据",
+			mirror:     models.Mirror{UpstreamURL: "https://other.example.net/maven", UpstreamAuthType: models.UpstreamAuthBasic, UpstreamUsername: "deploy", UpstreamSecret: "new-secret"},
+			old:        old,
+			wantSecret: "new-secret",
+		},
+		{
+			name:    "更换认证方式后留空",
+			mirror:  models.Mirror{UpstreamURL: "https://repo.example.com/maven", UpstreamAuthType: models.UpstreamAuthBearer},
+			old:     old,
+			wantErr: true,
+		},
+		{
+			name:    "创建时留空",
+			mirror:  models.Mirror{UpstreamURL: "https://repo.example.com/maven", UpstreamAuthType: models.UpstreamAuthBasic, UpstreamUsername: "deploy"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateUpstreamAuth(&tt.mirror, tt.old)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateUpstreamAuth() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && tt.mirror.UpstreamSecret != tt.wantSecret {
+				t.Errorf("UpstreamSecret = %q, want %q", tt.mirror.UpstreamSecret, tt.wantSecret)
+			}
+		})
+	}
+}
@@ -2,13 +2,17 @@ package handlers
 
 import (
 	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/logger"
 	"easyCacheMirror/internal/models"
+	"easyCacheMirror/internal/storage"
 	"net/http"
-	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
 )
 
 type FileNode struct {
@@ -33,6 +37,8 @@ func (h *Handler) HandleStorage(c *gin.Context) {
 	switch c.Request.Method {
 	case http.MethodGet:
 		h.getStorageTree(c)
+	case http.MethodDelete:
+		h.deleteStorageItem(c)
 	default:
 		c.JSON(http.StatusMethodNotAllowed, gin.H{
 			"error": "Method not allowed",
@@ -40,7 +46,7 @@ func (h *Handler) HandleStorage(c *gin.Context) {
 	}
 }
 
-// getStorageTree 获取存储树结构
+// getStorageTree 获取存储树结构，按缓存记录生成，与镜像的已用空间一致
 func (h *Handler) getStorageTree(c *gin.Context) {
 	var mirrors []models.Mirror
 	if err := database.DB.Find(&mirrors).Error; err != nil {
@@ -50,94 +56,154 @@ func (h *Handler) getStorageTree(c *gin.Context) {
 		return
 	}
 
-	var rootNodes []FileNode
+	rootNodes := make([]FileNode, 0, len(mirrors))
 	for _, mirror := range mirrors {
-		node, err := buildFileTree(mirror.BlobPath, mirror.Name)
-		if err != nil {
-			continue
+		var entries []models.CacheEntry
+		if err := database.DB.Where("mirror_id = ?", mirror.ID).
+			Order("object_key").
+			Find(&entries).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "获取缓存记录失败",
+			})
+			return
 		}
-		rootNodes = append(rootNodes, node)
+		rootNodes = append(rootNodes, buildFileTree(mirror.BlobPath, mirror.Name, entries))
 	}
 
 	c.JSON(http.StatusOK, rootNodes)
 }
 
 // 构建文件树
-func buildFileTree(root, name string) (FileNode, error) {
-	info, err := os.Stat(root)
-	if err != nil {
-		return FileNode{}, err
+func buildFileTree(root, name string, entries []models.CacheEntry) FileNode {
+	seen := make(map[string]bool)
+	subdirs := make(map[string][]string)
+	files := make(map[string][]FileNode)
+
+	// addDir 记录目录，并逐级记录上级目录
+	var addDir func(key string)
+	addDir = func(key string) {
+		if key == "" || seen[key] {
+			return
+		}
+		seen[key] = true
+		parent := parentKey(key)
+		subdirs[parent] = append(subdirs[parent], key)
+		addDir(parent)
 	}
 
-	node := FileNode{
-		Key:         root,
-		Name:        name,
-		Path:        root,
-		Size:        info.Size(),
-		ModTime:     info.ModTime(),
-		IsDirectory: info.IsDir(),
+	for _, entry := range entries {
+		dir := parentKey(entry.Key)
+		addDir(dir)
+		childPath := filepath.Join(root, filepath.FromSlash(entry.Key))
+		files[dir] = append(files[dir], FileNode{
+			Key:     childPath,
+			Name:    entry.Key[strings.LastIndex(entry.Key, "/")+1:],
+			Path:    childPath,
+			Size:    entry.Size,
+			ModTime: entry.StoredAt,
+		})
 	}
 
-	if !info.IsDir() {
-		return node, nil
+	// build 生成目录节点，统计大小、文件数和子目录数，目录排在文件之前
+	var build func(key, path, name string) FileNode
+	build = func(key, path, name string) FileNode {
+		node := FileNode{
+			Key:         path,
+			Name:        name,
+			Path:        path,
+			IsDirectory: true,
+		}
+
+		dirKeys := subdirs[key]
+		sort.Strings(dirKeys)
+		for _, dirKey := range dirKeys {
+			child := build(dirKey, filepath.Join(root, filepath.FromSlash(dirKey)), dirKey[strings.LastIndex(dirKey, "/")+1:])
+			node.DirCount += child.DirCount + 1
+			node.FileCount += child.FileCount
+			node.Size += child.Size
+			if child.ModTime.After(node.ModTime) {
+				node.ModTime = child.ModTime
+			}
+			node.Children = append(node.Children, child)
+		}
+		for _, file := range files[key] {
+			node.FileCount++
+			node.Size += file.Size
+			if file.ModTime.After(node.ModTime) {
+				node.ModTime = file.ModTime
+			}
+			node.Children = append(node.Children, file)
+		}
+		return node
 	}
 
-	// 统计目录信息
-	var fileCount, dirCount int
-	var totalSize int64
+	return build("", root, name)
+}
 
-	entries, err := os.ReadDir(root)
-	if err != nil {
-		return node, nil
+// parentKey 返回 key 所在目录的 key，顶层返回空字符串
+func parentKey(key string) string {
+	if i := strings.LastIndex(key, "/"); i >= 0 {
+		return key[:i]
 	}
+	return ""
+}
 
-	for _, entry := range entries {
-		childPath := filepath.Join(root, entry.Name())
-		childInfo, err := entry.Info()
-		if err != nil {
+// deleteStorageItem 删除存储树中的文件或目录，同时删除缓存记录和各类型的文件记录
+func (h *Handler) deleteStorageItem(c *gin.Context) {
+	log := logger.GetLogger()
+
+	target := filepath.Clean(c.Query("path"))
+	if c.Query("path") == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少路径参数"})
+		return
+	}
+
+	var mirrors []models.Mirror
+	if err := database.DB.Find(&mirrors).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取镜像列表失败"})
+		return
+	}
+
+	for i := range mirrors {
+		mirror := &mirrors[i]
+		rel, err := filepath.Rel(filepath.Clean(mirror.BlobPath), target)
+		if err != nil || strings.HasPrefix(rel, "..") {
 			continue
 		}
-
-		childNode := FileNode{
-			Key:         childPath,
-			Name:        entry.Name(),
-			Path:        childPath,
-			Size:        childInfo.Size(),
-			ModTime:     childInfo.ModTime(),
-			IsDirectory: entry.IsDir(),
+		if rel == "." {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "不能删除镜像的存储目录，请使用清理缓存"})
+			return
 		}
+		key := filepath.ToSlash(rel)
 
-		if entry.IsDir() {
-			dirCount++
-			// 递归处理子目录
-			if subNode, err := buildFileTree(childPath, entry.Name()); err == nil {
-				childNode = subNode
-				fileCount += subNode.FileCount
-				dirCount += subNode.DirCount
-				totalSize += subNode.Size
-			}
+		store := storage.ForMirror(mirror)
+		var count int64
+		database.DB.Model(&models.CacheEntry{}).
+			Where("mirror_id = ? AND object_key = ?", mirror.ID, key).
+			Count(&count)
+
+		isDir := count == 0
+		if isDir {
+			err = store.DeletePrefix(key)
 		} else {
-			fileCount++
-			totalSize += childInfo.Size()
+			err = store.Delete(key)
+		}
+		if err != nil {
+			log.Error("删除存储项失败", zap.Error(err), zap.String("path", target))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := database.DeleteFileRecords(mirror.ID, target, isDir); err != nil {
+			log.Error("删除文件记录失败", zap.Error(err), zap.String("path", target))
 		}
 
-		node.Children = append(node.Children, childNode)
+		log.Info("已删除存储项",
+			zap.String("mirror", mirror.Name),
+			zap.String("key", key),
+		)
+		c.JSON(http.StatusOK, gin.H{"message": "删除成功"})
+		return
 	}
 
-	node.Size = totalSize
-	node.FileCount = fileCount
-	node.DirCount = dirCount
-
-	return node, nil
-}
-
-// GetMirrorUsedSpace 计算镜像已用空间
-func GetMirrorUsedSpace(mirrorID uint) (int64, error) {
-	var totalSize int64
-	err := database.DB.Model(&models.NPMFile{}).
-		Where("mirror_id = ?", mirrorID).
-		Select("COALESCE(SUM(file_size), 0)").
-		Scan(&totalSize).Error
-
-	return totalSize, err
+	c.JSON(http.StatusNotFound, gin.H{"error": "路径不属于任何镜像"})
 }
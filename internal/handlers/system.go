@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"net/http"
+
+	"easyCacheMirror/internal/auth"
+	"easyCacheMirror/internal/logger"
+	"easyCacheMirror/internal/proxy"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// offlineRequest 切换离线模式的请求
+type offlineRequest struct {
+	Offline bool `json:"offline"`
+}
+
+// GetOfflineMode 获取离线模式状态
+func GetOfflineMode(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"offline": proxy.IsOffline()})
+}
+
+// SetOfflineMode 开启或关闭离线模式，开启后所有镜像只使用缓存，不访问上游
+// 只在当前进程内生效，重启后恢复为 OFFLINE_MODE 环境变量的设置
+func SetOfflineMode(c *gin.Context) {
+	var req offlineRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据"})
+		return
+	}
+
+	proxy.SetOffline(req.Offline)
+	logger.GetLogger().Info("切换离线模式",
+		zap.Bool("offline", req.Offline),
+		zap.String("operator", auth.CurrentUser(c).Username),
+	)
+	c.JSON(http.StatusOK, gin.H{"offline": req.Offline})
+}
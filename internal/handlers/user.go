@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+
+	"easyCacheMirror/internal/auth"
+	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// usernamePattern 用户名只能包含字母、数字、点、下划线和连字符
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{2,32}$`)
+
+// userRequest 创建和修改用户的请求，修改时密码为空表示不修改
+type userRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+// validatePassword 检查密码强度
+func validatePassword(password string) error {
+	if len(password) < 8 {
+		return errors.New("密码至少需要 8 位")
+	}
+	return nil
+}
+
+// validateRole 检查用户角色
+func validateRole(role string) error {
+	if role != models.RoleAdmin && role != models.RoleWriter && role != models.RoleViewer {
+		return errors.New("不支持的用户角色: " + role)
+	}
+	return nil
+}
+
+// isLastAdmin 判断用户是否为唯一的管理员
+func isLastAdmin(user *models.User) bool {
+	if !user.IsAdmin() {
+		return false
+	}
+	var count int64
+	database.DB.Model(&models.User{}).Where("role = ?", models.RoleAdmin).Count(&count)
+	return count <= 1
+}
+
+// ListUsers 获取用户列表
+func ListUsers(c *gin.Context) {
+	var users []models.User
+	if err := database.DB.Order("id asc").Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取用户列表失败"})
+		return
+	}
+	c.JSON(http.StatusOK, users)
+}
+
+// CreateUser 创建用户
+func CreateUser(c *gin.Context) {
+	var req userRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据"})
+		return
+	}
+	if !usernamePattern.MatchString(req.Username) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "用户名只能包含字母、数字、点、下划线和连字符，长度 2-32"})
+		return
+	}
+	if req.Role == "" {
+		req.Role = models.RoleViewer
+	}
+	if err := validateRole(req.Role); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validatePassword(req.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var count int64
+	database.DB.Model(&models.User{}).Where("username = ?", req.Username).Count(&count)
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "用户名已存在"})
+		return
+	}
+
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建用户失败"})
+		return
+	}
+	user := models.User{
+		Username:     req.Username,
+		PasswordHash: hash,
+		Role:         req.Role,
+	}
+	if err := database.DB.Create(&user).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建用户失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// UpdateUser 修改用户角色或重置密码
+func UpdateUser(c *gin.Context) {
+	var user models.User
+	if err := database.DB.First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+		return
+	}
+
+	var req userRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据"})
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if req.Role != "" && req.Role != user.Role {
+		if err := validateRole(req.Role); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if isLastAdmin(&user) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "至少需要保留一个管理员"})
+			return
+		}
+		updates["role"] = req.Role
+	}
+	if req.Password != "" {
+		if err := validatePassword(req.Password); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		hash, err := auth.HashPassword(req.Password)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "修改用户失败"})
+			return
+		}
+		updates["password_hash"] = hash
+	}
+
+	if len(updates) > 0 {
+		err := database.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&user).Updates(updates).Error; err != nil {
+				return err
+			}
+			// 重置密码或修改角色后，让已有的登录会话失效
+			return tx.Where("user_id = ?", user.ID).Delete(&models.Session{}).Error
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "修改用户失败"})
+			return
+		}
+		auth.ResetCredentialCache()
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// DeleteUser 删除用户及其会话和令牌
+func DeleteUser(c *gin.Context) {
+	var user models.User
+	if err := database.DB.First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+		return
+	}
+	if user.ID == auth.CurrentUser(c).ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不能删除当前登录的用户"})
+		return
+	}
+	if isLastAdmin(&user) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "至少需要保留一个管理员"})
+		return
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.Session{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.APIToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&user).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除用户失败"})
+		return
+	}
+	auth.ResetCredentialCache()
+
+	c.JSON(http.StatusOK, gin.H{"message": "用户已删除"})
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"easyCacheMirror/internal/auth"
+	"easyCacheMirror/internal/logger"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// AuthMiddleware 校验登录会话或 API 令牌
+// 令牌可以放在 Authorization: Bearer 请求头中，浏览器使用登录时写入的 Cookie
+func AuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := ""
+		if header := c.GetHeader("Authorization"); strings.HasPrefix(header, "Bearer ") {
+			token = strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+		} else if cookie, err := c.Cookie(auth.SessionCookie); err == nil {
+			token = cookie
+		}
+
+		user, err := auth.Authenticate(token)
+		if err != nil {
+			if !errors.Is(err, auth.ErrInvalidToken) {
+				logger.GetLogger().Error("校验登录状态失败", zap.Error(err))
+			}
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "未登录或登录已过期"})
+			return
+		}
+
+		auth.SetCurrentUser(c, user)
+		c.Next()
+	}
+}
+
+// RequireAdmin 只允许管理员访问，需要放在 AuthMiddleware 之后
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := auth.CurrentUser(c)
+		if user == nil || !user.IsAdmin() {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "需要管理员权限"})
+			return
+		}
+		c.Next()
+	}
+}
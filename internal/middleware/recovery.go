@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Recovery 与 gin.Recovery 相同，恢复处理请求时的 panic 并返回 500，
+// 但 http.ErrAbortHandler 继续抛给 net/http 断开连接：响应已经发出部分内容后出错时，
+// 只有断开连接才能让客户端知道响应不完整
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecovery(func(c *gin.Context, err any) {
+		if err == http.ErrAbortHandler {
+			panic(err)
+		}
+		c.AbortWithStatus(http.StatusInternalServerError)
+	})
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRecovery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		panicWith  any
+		wantStatus int
+		wantAbort  bool
+	}{
+		{"普通 panic 返回 500", errors.New("boom"), http.StatusInternalServerError, false},
+		{"中断响应交给 net/http", http.ErrAbortHandler, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.Use(Recovery())
+			r.GET("/", func(c *gin.Context) { panic(tt.panicWith) })
+
+			w := httptest.NewRecorder()
+			aborted := func() (aborted bool) {
+				defer func() {
+					if err := recover(); err != nil {
+						aborted = err == http.ErrAbortHandler
+					}
+				}()
+				r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+				return false
+			}()
+			if aborted != tt.wantAbort {
+				t.Fatalf("aborted = %v, want %v", aborted, tt.wantAbort)
+			}
+			if !tt.wantAbort && w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
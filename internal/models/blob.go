@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// Blob 内容寻址存储中的一个对象，内容相同的文件只保存一份
+// RefCount 为引用该对象的缓存记录数量，降为 0 时删除对象
+type Blob struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	Location  string    `json:"location" gorm:"column:location;uniqueIndex:idx_blob_digest;comment:对象所在的存储(local 或对象存储的地址和 bucket)"`
+	Sha256    string    `json:"sha256" gorm:"column:sha256;uniqueIndex:idx_blob_digest"`
+	Size      int64     `json:"size" gorm:"column:size;comment:对象大小(字节)"`
+	RefCount  int64     `json:"refCount" gorm:"column:ref_count;comment:引用该对象的缓存记录数量"`
+	CreatedAt time.Time `json:"createdAt"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// CacheEntry 镜像存储中的一个文件，所有类型的镜像共用
+// 各类型自己的文件表只记录包管理器相关的信息，容量统计、清理和存储浏览都基于该表
+type CacheEntry struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	MirrorID     uint      `json:"mirrorId" gorm:"column:mirror_id;uniqueIndex:idx_cache_entry_key"`
+	Key          string    `json:"key" gorm:"column:object_key;uniqueIndex:idx_cache_entry_key;comment:镜像存储目录内的相对路径"`
+	Size         int64     `json:"size" gorm:"column:size;comment:文件大小(字节)"`
+	Sha256       string    `json:"sha256" gorm:"column:sha256;index;comment:文件内容的 sha256，对应内容寻址存储中的对象"`
+	Hosted       bool      `json:"hosted" gorm:"column:hosted;default:false;comment:本地发布的文件，不会被清理"`
+	ContentType  string    `json:"contentType" gorm:"column:content_type;comment:上游返回的内容类型"`
+	StoredAt     time.Time `json:"storedAt" gorm:"column:stored_at;comment:写入存储的时间"`
+	LastUsedTime time.Time `json:"lastUsedTime" gorm:"column:last_used_time;index"`
+	Hits         int64     `json:"hits" gorm:"column:hits;default:0;comment:从缓存读取的次数"`
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+)
+
+// CargoFileType 定义文件类型
+type CargoFileType string
+
+const (
+	CargoFileTypeConfig CargoFileType = "CONFIG" // 索引的 config.json
+	CargoFileTypeIndex  CargoFileType = "INDEX"  // sparse 索引文件
+	CargoFileTypeCrate  CargoFileType = "CRATE"  // .crate 包文件
+)
+
+// CargoFile 记录Cargo文件下载信息
+type CargoFile struct {
+	ID           uint   `gorm:"primarykey"`
+	MirrorID     uint   `gorm:"column:mirror_id;index"`
+	CrateName    string `gorm:"index"` // 包名，例如: "serde"
+	Version      string // 版本号，例如: "1.0.200"
+	RelativePath string `gorm:"index"` // 相对路径，例如: "index/se/rd/serde"
+	FileType     CargoFileType
+	FileSize     int64     // 文件大小（字节）
+	SavePath     string    // 本地保存路径
+	Checksum     string    // sha256 校验值
+	ETag         string    // 上游返回的 ETag，用于重新验证
+	LastModified string    // 上游返回的 Last-Modified，用于重新验证
+	DownloadedAt time.Time `gorm:"column:downloaded_at;comment:从上游获取的时间"`
+	LastUsedTime time.Time `gorm:"column:last_used_time"`
+}
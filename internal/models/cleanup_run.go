@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// 缓存清理的触发方式
+const (
+	CleanupTriggerSchedule  = "schedule"  // 按镜像的清理计划定时执行
+	CleanupTriggerThreshold = "threshold" // 写入缓存后使用率超过阈值
+	CleanupTriggerManual    = "manual"    // 管理员手动执行
+)
+
+// CleanupRun 一次缓存清理的记录
+type CleanupRun struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	MirrorID   uint      `json:"mirrorId" gorm:"column:mirror_id;index"`
+	Trigger    string    `json:"trigger" gorm:"column:trigger_type;comment:触发方式(schedule/threshold/manual)"`
+	StartedAt  time.Time `json:"startedAt" gorm:"column:started_at"`
+	FinishedAt time.Time `json:"finishedAt" gorm:"column:finished_at"`
+	UsedBefore int64     `json:"usedBefore" gorm:"column:used_before;comment:清理前的使用空间(字节)"`
+	UsedAfter  int64     `json:"usedAfter" gorm:"column:used_after;comment:清理后的使用空间(字节)"`
+	Reclaimed  int64     `json:"reclaimed" gorm:"column:reclaimed;comment:释放的空间(字节)"`
+	Error      string    `json:"error" gorm:"column:error"`
+}
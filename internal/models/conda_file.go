@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+)
+
+// CondaFileType 定义文件类型
+type CondaFileType string
+
+const (
+	CondaFileTypeRepodata CondaFileType = "REPODATA" // repodata.json 及其压缩版本
+	CondaFileTypePackage  CondaFileType = "PACKAGE"  // .tar.bz2 和 .conda 包文件
+)
+
+// CondaFile 记录Conda文件下载信息
+type CondaFile struct {
+	ID           uint   `gorm:"primarykey"`
+	MirrorID     uint   `gorm:"column:mirror_id;index"`
+	Channel      string // 频道路径，例如: "pkgs/main"
+	Subdir       string // 平台目录，例如: "linux-64"
+	FileName     string // 文件名，例如: "numpy-1.26.4-py311h08b1b3b_0.conda"
+	RelativePath string `gorm:"index"` // 相对路径，例如: "pkgs/main/linux-64/repodata.json"
+	FileType     CondaFileType
+	FileSize     int64     // 文件大小（字节）
+	SavePath     string    // 本地保存路径
+	ContentType  string    // HTTP Content-Type
+	Sha256       string    // sha256 校验值
+	ETag         string    // 上游返回的 ETag，用于重新验证
+	LastModified string    // 上游返回的 Last-Modified，用于重新验证
+	DownloadedAt time.Time `gorm:"column:downloaded_at;comment:从上游获取的时间"`
+	LastUsedTime time.Time `gorm:"column:last_used_time"`
+}
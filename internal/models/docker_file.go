@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+)
+
+// DockerFileType 定义文件类型
+type DockerFileType string
+
+const (
+	DockerFileTypeManifest DockerFileType = "MANIFEST" // 镜像清单
+	DockerFileTypeBlob     DockerFileType = "BLOB"     // 镜像层和配置
+)
+
+// DockerFile 记录Docker镜像文件下载信息
+// 文件按 digest 存储；通过标签缓存的清单只是指向 digest 文件的引用记录，FileSize 为 0
+type DockerFile struct {
+	ID           uint   `gorm:"primarykey"`
+	MirrorID     uint   `gorm:"column:mirror_id;index"`
+	Repository   string `gorm:"index"` // 仓库名，例如: "library/alpine"
+	Reference    string `gorm:"index"` // 标签或 digest，例如: "3.20"
+	Digest       string `gorm:"index"` // 内容 digest，例如: "sha256:..."
+	FileType     DockerFileType
+	MediaType    string    // 清单的 Content-Type
+	FileSize     int64     // 文件大小（字节）
+	SavePath     string    // 本地保存路径
+	DownloadedAt time.Time `gorm:"column:downloaded_at;comment:从上游获取的时间"`
+	LastUsedTime time.Time `gorm:"column:last_used_time"`
+}
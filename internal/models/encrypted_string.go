@@ -0,0 +1,45 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+
+	"easyCacheMirror/internal/secret"
+)
+
+// EncryptedString 加密保存到数据库的字符串，例如上游的密码和令牌
+// 序列化为 JSON 时总是输出空字符串，避免通过接口泄露
+type EncryptedString string
+
+// Value 写入数据库前加密
+func (s EncryptedString) Value() (driver.Value, error) {
+	return secret.Encrypt(string(s))
+}
+
+// Scan 从数据库读取后解密
+func (s *EncryptedString) Scan(value interface{}) error {
+	var raw string
+	switch v := value.(type) {
+	case nil:
+		*s = ""
+		return nil
+	case string:
+		raw = v
+	case []byte:
+		raw = string(v)
+	default:
+		return fmt.Errorf("无法解析加密字段: %T", value)
+	}
+
+	plaintext, err := secret.Decrypt(raw)
+	if err != nil {
+		return err
+	}
+	*s = EncryptedString(plaintext)
+	return nil
+}
+
+// MarshalJSON 不输出明文
+func (s EncryptedString) MarshalJSON() ([]byte, error) {
+	return []byte(`""`), nil
+}
@@ -0,0 +1,145 @@
+package models
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestMain 在临时目录中运行，加密密钥生成在临时目录的 data/secret.key
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "models-test")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestEncryptedStringRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		value EncryptedString
+	}{
+		{"空字符串", ""},
+		{"密码", "p@ssw0rd"},
+		{"令牌", "ghp_0123456789abcdef"},
+		{"中文", "密码"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stored, err := tt.value.Value()
+			if err != nil {
+				t.Fatalf("Value() error = %v", err)
+			}
+			if tt.value != "" && strings.Contains(stored.(string), string(tt.value)) {
+				t.Errorf("数据库中保存了明文: %v", stored)
+			}
+
+			var scanned EncryptedString
+			if err := scanned.Scan(stored); err != nil {
+				t.Fatalf("Scan() error = %v", err)
+			}
+			if scanned != tt.value {
+				t.Errorf("Scan() = %q, want %q", scanned, tt.value)
+			}
+		})
+	}
+}
+
+func TestEncryptedStringScan(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    EncryptedString
+		wantErr bool
+	}{
+		{"NULL", nil, "", false},
+		{"加密前保存的明文", "legacy-secret", "legacy-secret", false},
+		{"字节切片", []byte("legacy-bytes"), "legacy-bytes", false},
+		{"损坏的密文", "enc:v1:not-base64!", "", true},
+		{"不支持的类型", 42, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s EncryptedString
+			err := s.Scan(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Scan() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && s != tt.want {
+				t.Errorf("Scan() = %q, want %q", s, tt.want)
+			}
+		})
+	}
+}
+
+func TestMirrorJSONHidesSecrets(t *testing.T) {
+	mirror := Mirror{
+		Name:             "private",
+		UpstreamAuthType: UpstreamAuthBasic,
+		UpstreamUsername: "deploy",
+		UpstreamSecret:   "upstream-password",
+		S3AccessKey:      "access-key",
+		S3SecretKey:      "s3-secret-key",
+	}
+	data, err := json.Marshal(mirror)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, secret := range []string{"upstream-password", "s3-secret-key"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("JSON 中包含密钥 %q: %s", secret, data)
+		}
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		field string
+		want  interface{}
+	}{
+		{"upstreamSecret", ""},
+		{"s3SecretKey", ""},
+		{"upstreamUsername", "deploy"},
+		{"s3AccessKey", "access-key"},
+	}
+	for _, tt := range tests {
+		if got := decoded[tt.field]; got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.field, got, tt.want)
+		}
+	}
+}
+
+func TestUpstreamAuthHeader(t *testing.T) {
+	tests := []struct {
+		name      string
+		mirror    Mirror
+		wantName  string
+		wantValue string
+	}{
+		{"未配置", Mirror{}, "", ""},
+		{"Basic", Mirror{UpstreamAuthType: UpstreamAuthBasic, UpstreamUsername: "user", UpstreamSecret: "pass"},
+			"Authorization", "Basic dXNlcjpwYXNz"},
+		{"Bearer", Mirror{UpstreamAuthType: UpstreamAuthBearer, UpstreamSecret: "token"},
+			"Authorization", "Bearer token"},
+		{"自定义请求头", Mirror{UpstreamAuthType: UpstreamAuthHeader, UpstreamHeaderName: "Private-Token", UpstreamSecret: "token"},
+			"Private-Token", "token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, value := tt.mirror.UpstreamAuthHeader()
+			if name != tt.wantName || value != tt.wantValue {
+				t.Errorf("UpstreamAuthHeader() = %q, %q, want %q, %q", name, value, tt.wantName, tt.wantValue)
+			}
+		})
+	}
+}
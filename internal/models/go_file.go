@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+)
+
+// GoFileType 定义文件类型
+type GoFileType string
+
+const (
+	GoFileTypeList   GoFileType = "LIST"   // @v/list 版本列表
+	GoFileTypeLatest GoFileType = "LATEST" // @latest 最新版本
+	GoFileTypeQuery  GoFileType = "QUERY"  // 非规范版本(分支名等)的 .info 查询
+	GoFileTypeInfo   GoFileType = "INFO"   // @v/{version}.info
+	GoFileTypeMod    GoFileType = "MOD"    // @v/{version}.mod
+	GoFileTypeZip    GoFileType = "ZIP"    // @v/{version}.zip
+)
+
+// GoModuleFile 记录Go模块文件下载信息
+type GoModuleFile struct {
+	ID           uint   `gorm:"primarykey"`
+	MirrorID     uint   `gorm:"column:mirror_id;index"`
+	ModulePath   string `gorm:"index"` // 模块路径(转义后)，例如: "github.com/gin-gonic/gin"
+	Version      string // 版本号，例如: "v1.10.0"
+	RelativePath string `gorm:"index"` // 相对路径，例如: "github.com/gin-gonic/gin/@v/v1.10.0.zip"
+	FileType     GoFileType
+	FileSize     int64     // 文件大小（字节）
+	SavePath     string    // 本地保存路径
+	ContentType  string    // HTTP Content-Type
+	DownloadedAt time.Time `gorm:"column:downloaded_at;comment:从上游获取的时间"`
+	LastUsedTime time.Time `gorm:"column:last_used_time"`
+}
+
+// IsImmutable 版本化的 .info/.mod/.zip 文件按 GOPROXY 协议不可变
+func (f *GoModuleFile) IsImmutable() bool {
+	switch f.FileType {
+	case GoFileTypeInfo, GoFileTypeMod, GoFileTypeZip:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// Lease 多个实例共用数据库时的互斥租约
+// 持有者需要在 ExpiresAt 之前续期，实例退出或崩溃后租约过期，其他实例可以重新获取
+type Lease struct {
+	Name      string    `json:"name" gorm:"column:name;primaryKey;comment:租约名称，例如 fetch:1:path"`
+	Holder    string    `json:"holder" gorm:"column:holder;comment:持有者，由实例标识和随机值组成"`
+	ExpiresAt time.Time `json:"expiresAt" gorm:"column:expires_at;comment:过期时间(UTC)"`
+}
@@ -23,6 +23,7 @@ type MavenFile struct {
 	ContentType     string    // HTTP Content-Type
 	ContentEncoding string    // 新增：记录压缩编码方式
 	IsSnapshot      bool      // 是否为SNAPSHOT版本
+	IsHosted        bool      `gorm:"column:is_hosted;default:false"` // 是否为本地部署的文件，本地部署的文件不会被清理
 	DownloadedAt    time.Time `gorm:"column:downloaded_at"`
 	LastUsedTime    time.Time `gorm:"column:last_used_time"`
 }
@@ -1,11 +1,52 @@
 package models
 
 import (
+	"encoding/base64"
+	"sort"
 	"time"
 )
 
 const (
-	DefaultNPMRegistry = "https://registry.npmjs.org"
+	DefaultNPMRegistry    = "https://registry.npmjs.org"
+	DefaultPyPIIndex      = "https://pypi.org"
+	DefaultPyPIFilesHost  = "https://files.pythonhosted.org"
+	DefaultDockerRegistry = "https://registry-1.docker.io"
+	DefaultCargoIndex     = "https://index.crates.io"
+)
+
+// 上游认证方式
+const (
+	UpstreamAuthNone   = ""       // 不需要认证
+	UpstreamAuthBasic  = "basic"  // HTTP Basic 用户名密码
+	UpstreamAuthBearer = "bearer" // Bearer 令牌，例如 npm 的 _authToken、GitHub Packages 的令牌
+	UpstreamAuthHeader = "header" // 自定义请求头，例如 GitLab 的 Private-Token
+)
+
+// 缓存淘汰策略
+const (
+	EvictionLRU     = "lru"     // 优先删除最久未使用的文件
+	EvictionLFU     = "lfu"     // 优先删除命中次数最少的文件
+	EvictionLargest = "largest" // 优先删除最大的文件
+	EvictionTTL     = "ttl"     // 只删除缓存时间超过 EvictionTTL 的文件，不考虑使用空间
+)
+
+// 默认的清理水位(最大容量的百分比)
+const (
+	DefaultHighWatermark = 95
+	DefaultLowWatermark  = 80
+)
+
+// 缓存文件的存储后端
+const (
+	StorageLocal = "local" // 保存在本地的 BlobPath 目录
+	StorageS3    = "s3"    // 保存在 S3 兼容的对象存储，例如 MinIO
+)
+
+// 镜像种类
+const (
+	MirrorKindProxy  = "proxy"  // 代理并缓存上游
+	MirrorKindGroup  = "group"  // 组合多个成员镜像，按顺序解析请求
+	MirrorKindHosted = "hosted" // 本地托管，接受发布的包，不访问上游
 )
 
 type Mirror struct {
@@ -26,4 +67,127 @@ type Mirror struct {
 	ServiceURL   string    `json:"serviceUrl" gorm:"column:service_url"`
 	HitCount     int64     `json:"hit_count" gorm:"default:0"`     // 缓存命中次数
 	RequestCount int64     `json:"request_count" gorm:"default:0"` // 总请求次数
+
+	// 定时清理缓存的 cron 表达式，为空时每天凌晨 3 点清理
+	CleanupSchedule string `json:"cleanupSchedule" gorm:"column:cleanup_schedule;comment:缓存清理计划(cron 表达式)"`
+
+	// 缓存淘汰策略，使用空间达到高水位时按策略删除文件直到低于低水位，水位为 0 时使用默认值
+	EvictionPolicy string `json:"evictionPolicy" gorm:"column:eviction_policy;default:lru;comment:缓存淘汰策略(lru/lfu/largest/ttl)"`
+	HighWatermark  int    `json:"highWatermark" gorm:"column:high_watermark;comment:开始清理的使用率(百分比)"`
+	LowWatermark   int    `json:"lowWatermark" gorm:"column:low_watermark;comment:清理后的目标使用率(百分比)"`
+	EvictionTTL    int    `json:"evictionTtl" gorm:"column:eviction_ttl;comment:ttl 策略下文件的保留时间(分钟)"`
+
+	// Raw 类型镜像的缓存规则
+	ImmutablePatterns string `json:"immutablePatterns" gorm:"column:immutable_patterns;comment:不可变文件的路径模式(每行一个)"`
+	ChecksumFile      string `json:"checksumFile" gorm:"column:checksum_file;comment:同目录下的校验文件名"`
+
+	// 备用上游，主上游失败时按优先级依次尝试
+	Upstreams     []MirrorUpstream `json:"upstreams" gorm:"foreignKey:MirrorID"`
+	FallbackOn404 bool             `json:"fallbackOn404" gorm:"column:fallback_on_404;comment:上游返回404时是否尝试下一个上游"`
+
+	// 所有上游都失败时，缓存过期后仍可以继续使用的时间，0 表示不使用过期的缓存
+	StaleIfError int `json:"staleIfError" gorm:"column:stale_if_error;comment:上游失败时使用过期缓存的时间(分钟)"`
+
+	// 组合镜像的配置
+	Kind    string `json:"kind" gorm:"column:kind;default:proxy;comment:镜像种类(proxy/group)"`
+	Members []uint `json:"members" gorm:"column:members;serializer:json;comment:组合镜像的成员镜像ID(按解析顺序)"`
+
+	// 代理镜像中由本地托管的作用域(每行一个，例如 @ourco)，这些作用域下的包只从本地提供
+	HostedScopes string `json:"hostedScopes" gorm:"column:hosted_scopes"`
+
+	// 客户端访问控制，都为空时任何能访问服务的客户端都可以使用该镜像
+	RequireAuth  bool   `json:"requireAuth" gorm:"column:require_auth;comment:是否要求客户端提供令牌或用户名密码"`
+	AllowedUsers string `json:"allowedUsers" gorm:"column:allowed_users;comment:允许访问的用户名(每行一个)，为空表示所有用户"`
+	AllowedCIDRs string `json:"allowedCidrs" gorm:"column:allowed_cidrs;comment:允许访问的客户端地址段(每行一个)，为空表示不限制"`
+
+	// 访问主上游使用的凭据，密码和令牌加密保存
+	UpstreamAuthType   string          `json:"upstreamAuthType" gorm:"column:upstream_auth_type;comment:上游认证方式(basic/bearer/header)"`
+	UpstreamUsername   string          `json:"upstreamUsername" gorm:"column:upstream_username"`
+	UpstreamHeaderName string          `json:"upstreamHeaderName" gorm:"column:upstream_header_name;comment:自定义认证请求头的名称"`
+	UpstreamSecret     EncryptedString `json:"upstreamSecret" gorm:"column:upstream_secret;comment:上游密码或令牌(加密)"`
+
+	// 缓存文件的存储后端，为 s3 时文件保存在对象存储中，BlobPath 只用于生成文件记录的路径
+	StorageType   string          `json:"storageType" gorm:"column:storage_type;default:local;comment:存储后端(local/s3)"`
+	S3Endpoint    string          `json:"s3Endpoint" gorm:"column:s3_endpoint;comment:对象存储地址"`
+	S3Region      string          `json:"s3Region" gorm:"column:s3_region"`
+	S3Bucket      string          `json:"s3Bucket" gorm:"column:s3_bucket"`
+	S3Prefix      string          `json:"s3Prefix" gorm:"column:s3_prefix;comment:对象 key 的前缀"`
+	S3AccessKey   string          `json:"s3AccessKey" gorm:"column:s3_access_key"`
+	S3SecretKey   EncryptedString `json:"s3SecretKey" gorm:"column:s3_secret_key;comment:对象存储密钥(加密)"`
+	S3VirtualHost bool            `json:"s3VirtualHost" gorm:"column:s3_virtual_host;comment:是否使用 bucket 子域名形式的地址"`
+	S3Redirect    bool            `json:"s3Redirect" gorm:"column:s3_redirect;comment:缓存命中时是否重定向到对象存储的临时地址"`
+}
+
+// IsGroup 判断是否为组合镜像
+func (m *Mirror) IsGroup() bool {
+	return m.Kind == MirrorKindGroup
+}
+
+// IsHosted 判断是否为本地托管镜像
+func (m *Mirror) IsHosted() bool {
+	return m.Kind == MirrorKindHosted
+}
+
+// UsesS3 判断缓存文件是否保存在对象存储中
+func (m *Mirror) UsesS3() bool {
+	return m.StorageType == StorageS3
+}
+
+// Watermarks 返回开始清理和清理后的目标使用空间(字节)
+func (m *Mirror) Watermarks() (high, low int64) {
+	highPercent, lowPercent := m.HighWatermark, m.LowWatermark
+	if highPercent <= 0 {
+		highPercent = DefaultHighWatermark
+	}
+	if lowPercent <= 0 {
+		lowPercent = DefaultLowWatermark
+	}
+	return m.MaxSize * int64(highPercent) / 100, m.MaxSize * int64(lowPercent) / 100
+}
+
+// UpstreamAuthHeader 返回访问上游时需要添加的认证请求头，未配置认证时返回空
+func (m *Mirror) UpstreamAuthHeader() (string, string) {
+	secret := string(m.UpstreamSecret)
+	switch m.UpstreamAuthType {
+	case UpstreamAuthBasic:
+		credentials := base64.StdEncoding.EncodeToString([]byte(m.UpstreamUsername + ":" + secret))
+		return "Authorization", "Basic " + credentials
+	case UpstreamAuthBearer:
+		return "Authorization", "Bearer " + secret
+	case UpstreamAuthHeader:
+		return m.UpstreamHeaderName, secret
+	}
+	return "", ""
+}
+
+// MirrorUpstream 镜像的备用上游
+type MirrorUpstream struct {
+	ID       uint   `json:"id" gorm:"primaryKey"`
+	MirrorID uint   `json:"mirrorId" gorm:"column:mirror_id;index"`
+	URL      string `json:"url" gorm:"column:url"`
+	UseProxy bool   `json:"useProxy" gorm:"column:use_proxy"`
+	ProxyURL string `json:"proxyUrl" gorm:"column:proxy_url"`
+	Priority int    `json:"priority" gorm:"column:priority;comment:优先级(数值越小越优先)"`
+}
+
+// UpstreamList 返回按尝试顺序排列的上游列表，主上游总是排在第一位
+func (m *Mirror) UpstreamList() []MirrorUpstream {
+	upstreams := make([]MirrorUpstream, 0, len(m.Upstreams)+1)
+	upstreams = append(upstreams, MirrorUpstream{
+		MirrorID: m.ID,
+		URL:      m.UpstreamURL,
+		UseProxy: m.UseProxy,
+		ProxyURL: m.ProxyURL,
+	})
+
+	fallbacks := append([]MirrorUpstream(nil), m.Upstreams...)
+	sort.SliceStable(fallbacks, func(i, j int) bool {
+		return fallbacks[i].Priority < fallbacks[j].Priority
+	})
+	for _, upstream := range fallbacks {
+		if upstream.URL != "" {
+			upstreams = append(upstreams, upstream)
+		}
+	}
+	return upstreams
 }
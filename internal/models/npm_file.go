@@ -24,6 +24,9 @@ type NPMFile struct {
 	SavePath     string    // 本地保存路径
 	Integrity    string    // integrity 校验值，例如: sha512-xxx
 	Shasum       string    // shasum 校验值，例如: xxx
+	ETag         string    // 上游返回的 ETag，用于重新验证元数据
+	LastModified string    // 上游返回的 Last-Modified，用于重新验证元数据
+	IsHosted     bool      `gorm:"column:is_hosted;default:false"` // 是否为本地发布的包，本地发布的包不会被清理
 	DownloadedAt time.Time `gorm:"column:downloaded_at;comment:从上游获取的时间"`
 	LastUsedTime time.Time `gorm:"column:last_used_time"`
 }
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+)
+
+// PyPIFileType 定义文件类型
+type PyPIFileType string
+
+const (
+	PyPIFileTypeIndex   PyPIFileType = "INDEX"   // simple 索引页面
+	PyPIFileTypePackage PyPIFileType = "PACKAGE" // 分发包(wheel、sdist等)
+)
+
+// PyPIFile 记录PyPI文件下载信息
+type PyPIFile struct {
+	ID             uint   `gorm:"primarykey"`
+	MirrorID       uint   `gorm:"column:mirror_id;index"`
+	RelativePath   string `gorm:"index"` // 相对路径，例如: "simple/numpy.json" 或 "packages/.../numpy-1.26.4.tar.gz"
+	PackageName    string // 包名，例如: "numpy"
+	FileName       string // 文件名
+	FileType       PyPIFileType
+	FileSize       int64     // 文件大小（字节）
+	SavePath       string    // 本地保存路径
+	ContentType    string    // HTTP Content-Type
+	Sha256         string    // sha256 校验值
+	Version        string    // 版本号，仅本地上传的包记录
+	RequiresPython string    // 上传时提供的 Requires-Python
+	IsHosted       bool      `gorm:"column:is_hosted;default:false"` // 是否为本地上传的包，本地上传的包不会被清理
+	DownloadedAt   time.Time `gorm:"column:downloaded_at;comment:从上游获取的时间"`
+	LastUsedTime   time.Time `gorm:"column:last_used_time"`
+}
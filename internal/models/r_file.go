@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+)
+
+// RFileType 定义文件类型
+type RFileType string
+
+const (
+	RFileTypeIndex   RFileType = "INDEX"   // PACKAGES、PACKAGES.gz、PACKAGES.rds 索引文件
+	RFileTypePackage RFileType = "PACKAGE" // 源码包和二进制包
+)
+
+// RFile 记录CRAN文件下载信息
+type RFile struct {
+	ID           uint   `gorm:"primarykey"`
+	MirrorID     uint   `gorm:"column:mirror_id;index"`
+	PackageName  string // 包名，例如: "ggplot2"，索引文件为空
+	Version      string // 版本号，例如: "3.5.1"，索引文件为空
+	RelativePath string `gorm:"index"` // 请求的相对路径，例如: "src/contrib/ggplot2_3.5.1.tar.gz"
+	UpstreamPath string // 实际拉取的上游路径，从 Archive 获取时与请求路径不同
+	FileType     RFileType
+	FileSize     int64     // 文件大小（字节）
+	SavePath     string    // 本地保存路径
+	ContentType  string    // HTTP Content-Type
+	ETag         string    // 上游返回的 ETag，用于重新验证
+	LastModified string    // 上游返回的 Last-Modified，用于重新验证
+	DownloadedAt time.Time `gorm:"column:downloaded_at;comment:从上游获取的时间"`
+	LastUsedTime time.Time `gorm:"column:last_used_time"`
+}
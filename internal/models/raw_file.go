@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+)
+
+// RawFileType 定义文件类型
+type RawFileType string
+
+const (
+	RawFileTypeFile  RawFileType = "FILE"  // 普通文件
+	RawFileTypeIndex RawFileType = "INDEX" // 目录页面，保存为目录下的 __index__
+)
+
+// RawFile 记录通用文件下载信息
+type RawFile struct {
+	ID           uint   `gorm:"primarykey"`
+	MirrorID     uint   `gorm:"column:mirror_id;index"`
+	RelativePath string `gorm:"index"` // 相对路径，例如: "v20.11.0/node-v20.11.0-linux-x64.tar.xz"
+	FileType     RawFileType
+	Immutable    bool      // 是否匹配镜像的不可变路径模式，不可变文件缓存后永久有效
+	FileSize     int64     // 文件大小（字节）
+	SavePath     string    // 本地保存路径
+	ContentType  string    // HTTP Content-Type
+	Sha256       string    // sha256 校验值
+	ETag         string    // 上游返回的 ETag，用于重新验证
+	LastModified string    // 上游返回的 Last-Modified，用于重新验证
+	DownloadedAt time.Time `gorm:"column:downloaded_at;comment:从上游获取的时间"`
+	LastUsedTime time.Time `gorm:"column:last_used_time"`
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+)
+
+// RubyGemsFileType 定义文件类型
+type RubyGemsFileType string
+
+const (
+	RubyGemsFileTypeCompactIndex RubyGemsFileType = "COMPACT_INDEX" // versions、names、info/<gem> 兼容索引
+	RubyGemsFileTypeSpecs        RubyGemsFileType = "SPECS"         // specs.4.8.gz 等完整索引
+	RubyGemsFileTypeGemspec      RubyGemsFileType = "GEMSPEC"       // quick/Marshal.4.8/*.gemspec.rz
+	RubyGemsFileTypeGem          RubyGemsFileType = "GEM"           // .gem 包文件
+)
+
+// RubyGemsFile 记录RubyGems文件下载信息
+type RubyGemsFile struct {
+	ID           uint   `gorm:"primarykey"`
+	MirrorID     uint   `gorm:"column:mirror_id;index"`
+	GemName      string // gem 名称，例如: "rails"，versions/names/specs 为空
+	Version      string // 版本号（可能带平台），例如: "7.1.3" 或 "1.16.0-x86_64-linux"
+	RelativePath string `gorm:"index"` // 相对路径，例如: "info/rails"
+	FileType     RubyGemsFileType
+	FileSize     int64     // 文件大小（字节）
+	SavePath     string    // 本地保存路径
+	ContentType  string    // HTTP Content-Type
+	Sha256       string    // sha256 校验值
+	MD5          string    // md5 校验值，兼容索引以此作为 ETag
+	ETag         string    // 上游返回的 ETag，用于重新验证
+	LastModified string    // 上游返回的 Last-Modified，用于重新验证
+	DownloadedAt time.Time `gorm:"column:downloaded_at;comment:从上游获取的时间"`
+	LastUsedTime time.Time `gorm:"column:last_used_time"`
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+)
+
+// 用户角色
+const (
+	RoleAdmin  = "admin"  // 管理员，可以修改镜像、清理缓存和管理用户
+	RoleWriter = "writer" // 发布用户，可以向托管仓库发布包，管理界面中与只读用户相同
+	RoleViewer = "viewer" // 只读用户，只能查看镜像和缓存使用情况
+)
+
+// User 管理界面和 API 的用户
+type User struct {
+	ID           uint      `json:"id" gorm:"primarykey"`
+	Username     string    `json:"username" gorm:"uniqueIndex;not null"`
+	PasswordHash string    `json:"-" gorm:"column:password_hash"`
+	Role         string    `json:"role" gorm:"default:viewer"`
+	LastLoginAt  time.Time `json:"lastLoginAt" gorm:"column:last_login_at"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+// IsAdmin 判断是否为管理员
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+// CanWrite 判断是否可以向托管仓库发布或删除包
+func (u *User) CanWrite() bool {
+	return u.Role == RoleAdmin || u.Role == RoleWriter
+}
+
+// Session 登录会话，令牌只保存 sha256 摘要
+type Session struct {
+	ID        uint      `gorm:"primarykey"`
+	UserID    uint      `gorm:"column:user_id;index"`
+	TokenHash string    `gorm:"column:token_hash;uniqueIndex"`
+	ExpiresAt time.Time `gorm:"column:expires_at"`
+	CreatedAt time.Time
+}
+
+// APIToken 用于脚本访问 API 的长期令牌，权限与所属用户相同
+type APIToken struct {
+	ID         uint       `json:"id" gorm:"primarykey"`
+	UserID     uint       `json:"userId" gorm:"column:user_id;index"`
+	Name       string     `json:"name"`
+	TokenHash  string     `json:"-" gorm:"column:token_hash;uniqueIndex"`
+	Prefix     string     `json:"prefix"` // 令牌的前几位，用于在界面上区分
+	ExpiresAt  *time.Time `json:"expiresAt" gorm:"column:expires_at"`
+	LastUsedAt *time.Time `json:"lastUsedAt" gorm:"column:last_used_at"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
@@ -1,10 +1,14 @@
 package proxy
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"easyCacheMirror/internal/logger"
 	"easyCacheMirror/internal/models"
@@ -12,36 +16,154 @@ import (
 	"go.uber.org/zap"
 )
 
+// upstreamResponseTimeout 等待上游响应头的超时时间，超时后尝试下一个上游
+const upstreamResponseTimeout = 30 * time.Second
+
+// ErrOffline 离线模式下不访问上游，只能使用缓存
+var ErrOffline = errors.New("离线模式，不访问上游")
+
+// offline 是否处于离线模式
+var offline atomic.Bool
+
+// SetOffline 开启或关闭离线模式
+func SetOffline(enabled bool) {
+	offline.Store(enabled)
+}
+
+// IsOffline 判断是否处于离线模式
+func IsOffline() bool {
+	return offline.Load()
+}
+
 // Proxy 处理上游请求的代理
 type Proxy struct {
 	defaultClient *http.Client
+	proxyClients  sync.Map // 代理地址 -> *http.Client
 }
 
 // NewProxy 创建新的代理实例
 func NewProxy() *Proxy {
 	return &Proxy{
-		defaultClient: &http.Client{},
+		defaultClient: &http.Client{Transport: newTransport(nil)},
+	}
+}
+
+// newTransport 创建带响应超时的传输层，proxyURL 为 nil 时使用环境变量中的代理设置
+func newTransport(proxyURL *url.URL) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxyURL != nil {
+		transport.Proxy = http.ProxyURL(proxyURL)
 	}
+	transport.ResponseHeaderTimeout = upstreamResponseTimeout
+	return transport
 }
 
 // ProxyRequest 代理请求到上游服务器并返回响应
 func (p *Proxy) ProxyRequest(mirror *models.Mirror, path string, headers http.Header) (*http.Response, error) {
+	return p.ProxyRequestWithMethod(mirror, http.MethodGet, path, headers)
+}
+
+// ProxyRequestWithMethod 使用指定的方法代理请求到上游服务器
+// 按顺序尝试镜像的所有上游，连接失败、超时或返回 5xx 时尝试下一个，
+// 镜像开启 FallbackOn404 时 404 也会尝试下一个，最后一个上游的结果原样返回
+func (p *Proxy) ProxyRequestWithMethod(mirror *models.Mirror, method, path string, headers http.Header) (*http.Response, error) {
+	return p.ProxyRequestWithHeaders(mirror, method, path, func(string) http.Header {
+		return headers
+	})
+}
+
+// ProxyRequestWithHeaders 与 ProxyRequestWithMethod 相同，但请求头由 headersFor 按每个上游的完整地址分别生成
+// 用于只能发给特定上游的请求头，例如 Docker 仓库为某个上游签发的 Bearer 令牌
+func (p *Proxy) ProxyRequestWithHeaders(mirror *models.Mirror, method, path string, headersFor func(upstreamURL string) http.Header) (*http.Response, error) {
 	log := logger.GetLogger()
 
-	// 构建上游URL
-	upstreamURL := fmt.Sprintf("%s/%s",
-		strings.TrimRight(mirror.UpstreamURL, "/"),
-		path,
-	)
+	upstreams := mirror.UpstreamList()
+	for i, upstream := range upstreams {
+		// 构建上游URL
+		upstreamURL := fmt.Sprintf("%s/%s",
+			strings.TrimRight(upstream.URL, "/"),
+			path,
+		)
+
+		headers := withUpstreamAuth(mirror, upstreamURL, headersFor(upstreamURL))
+		resp, err := p.send(upstream.UseProxy, upstream.ProxyURL, method, upstreamURL, headers)
+		if i == len(upstreams)-1 || errors.Is(err, ErrOffline) {
+			return resp, err
+		}
+
+		if err != nil {
+			log.Warn("上游请求失败，尝试下一个上游",
+				zap.Error(err),
+				zap.String("upstream", upstream.URL),
+			)
+			continue
+		}
+		if resp.StatusCode >= http.StatusInternalServerError ||
+			(resp.StatusCode == http.StatusNotFound && mirror.FallbackOn404) {
+			log.Warn("上游返回错误状态，尝试下一个上游",
+				zap.Int("status", resp.StatusCode),
+				zap.String("upstream", upstream.URL),
+			)
+			resp.Body.Close()
+			continue
+		}
+		return resp, nil
+	}
+
+	return nil, fmt.Errorf("镜像没有可用的上游")
+}
+
+// Do 按镜像的代理配置向指定地址发送请求
+// 用于访问上游之外的地址，例如 Docker 仓库的鉴权服务
+func (p *Proxy) Do(mirror *models.Mirror, method, rawURL string, headers http.Header) (*http.Response, error) {
+	return p.send(mirror.UseProxy, mirror.ProxyURL, method, rawURL, withUpstreamAuth(mirror, rawURL, headers))
+}
+
+// withUpstreamAuth 为发往主上游所在主机的请求添加镜像配置的凭据
+// 备用上游和其他主机不会收到凭据，已经带有认证头的请求(例如 Docker 的 Bearer 令牌)保持不变
+func withUpstreamAuth(mirror *models.Mirror, rawURL string, headers http.Header) http.Header {
+	name, value := mirror.UpstreamAuthHeader()
+	if name == "" || headers.Get(name) != "" || !SameHost(mirror.UpstreamURL, rawURL) {
+		return headers
+	}
+	headers = headers.Clone()
+	if headers == nil {
+		headers = http.Header{}
+	}
+	headers.Set(name, value)
+	return headers
+}
+
+// SameHost 判断两个地址是否指向同一主机和端口
+func SameHost(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return ua.Scheme == ub.Scheme && strings.EqualFold(ua.Host, ub.Host)
+}
+
+// send 使用指定的代理配置发送请求
+func (p *Proxy) send(useProxy bool, proxyURL, method, rawURL string, headers http.Header) (*http.Response, error) {
+	log := logger.GetLogger()
+
+	if IsOffline() {
+		log.Debug("离线模式，跳过上游请求", zap.String("upstream_url", rawURL))
+		return nil, ErrOffline
+	}
 
 	log.Debug("代理请求",
-		zap.String("upstream_url", upstreamURL),
-		zap.String("method", "GET"),
-		zap.Any("headers", headers),
+		zap.String("upstream_url", rawURL),
+		zap.String("method", method),
+		zap.Any("headers", redactHeaders(headers)),
 	)
 
 	// 创建请求
-	req, err := http.NewRequest("GET", upstreamURL, nil)
+	req, err := http.NewRequest(method, rawURL, nil)
 	if err != nil {
 		log.Error("创建请求失败", zap.Error(err))
 		return nil, fmt.Errorf("创建上游请求失败: %v", err)
@@ -61,13 +183,13 @@ func (p *Proxy) ProxyRequest(mirror *models.Mirror, path string, headers http.He
 
 	// 根据配置选择客户端
 	var client *http.Client
-	if mirror.UseProxy {
-		client, err = p.getProxyClient(mirror.ProxyURL)
+	if useProxy {
+		client, err = p.getProxyClient(proxyURL)
 		if err != nil {
 			log.Error("创建代理客户端失败", zap.Error(err))
 			return nil, fmt.Errorf("创建代理客户端失败: %v", err)
 		}
-		log.Info("使用代理", zap.String("proxy_url", mirror.ProxyURL))
+		log.Info("使用代理", zap.String("proxy_url", proxyURL))
 	} else {
 		client = p.defaultClient
 	}
@@ -82,23 +204,36 @@ func (p *Proxy) ProxyRequest(mirror *models.Mirror, path string, headers http.He
 	return resp, nil
 }
 
+// redactHeaders 隐藏日志中的认证信息
+func redactHeaders(headers http.Header) http.Header {
+	redacted := headers.Clone()
+	for key := range redacted {
+		lower := strings.ToLower(key)
+		if lower == "cookie" || strings.Contains(lower, "auth") || strings.Contains(lower, "token") ||
+			strings.Contains(lower, "key") || strings.Contains(lower, "secret") {
+			redacted.Set(key, "***")
+		}
+	}
+	return redacted
+}
+
 // getProxyClient 获取配置了代理的HTTP客户端
 func (p *Proxy) getProxyClient(proxyURL string) (*http.Client, error) {
+	if client, ok := p.proxyClients.Load(proxyURL); ok {
+		return client.(*http.Client), nil
+	}
+
 	// 解析代理URL
 	proxy, err := url.Parse(proxyURL)
 	if err != nil {
 		return nil, fmt.Errorf("解析代理URL失败: %v", err)
 	}
 
-	// 创建带有代理的传输层
-	transport := &http.Transport{
-		Proxy: http.ProxyURL(proxy),
-	}
-
-	// 创建新的客户端
+	// 创建带有代理的客户端，按代理地址复用连接
 	client := &http.Client{
-		Transport: transport,
+		Transport: newTransport(proxy),
 	}
+	actual, _ := p.proxyClients.LoadOrStore(proxyURL, client)
 
-	return client, nil
+	return actual.(*http.Client), nil
 }
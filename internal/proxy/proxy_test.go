@@ -0,0 +1,211 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"easyCacheMirror/internal/models"
+)
+
+// recordingServer 记录收到的 Authorization 请求头，按 status 返回
+type recordingServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	auth   []string
+	status int
+}
+
+func newRecordingServer(t *testing.T, status int) *recordingServer {
+	t.Helper()
+	s := &recordingServer{status: status}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.auth = append(s.auth, r.Header.Get("Authorization"))
+		s.mu.Unlock()
+		w.WriteHeader(s.status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// received 返回收到的 Authorization 请求头
+func (s *recordingServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.auth...)
+}
+
+func TestSameHost(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"https://registry.example.com", "https://registry.example.com/v2/library/alpine", true},
+		{"https://Registry.Example.com", "https://registry.example.com/", true},
+		{"https://registry.example.com", "http://registry.example.com", false},
+		{"https://registry.example.com", "https://registry.example.com:8443", false},
+		{"https://registry.example.com", "https://auth.example.com/token", false},
+		{"https://registry.example.com", "://bad", false},
+	}
+	for _, tt := range tests {
+		if got := SameHost(tt.a, tt.b); got != tt.want {
+			t.Errorf("SameHost(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestUpstreamAuthOnlySentToPrimary(t *testing.T) {
+	const wantAuth = "Bearer primary-token"
+
+	tests := []struct {
+		name          string
+		primaryStatus int
+		wantPrimary   []string
+		wantFallback  []string
+	}{
+		{"主上游可用", http.StatusOK, []string{wantAuth}, nil},
+		{"主上游失败后访问备用上游", http.StatusBadGateway, []string{wantAuth}, []string{""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := newRecordingServer(t, tt.primaryStatus)
+			fallback := newRecordingServer(t, http.StatusOK)
+			mirror := &models.Mirror{
+				UpstreamURL:      primary.URL,
+				UpstreamAuthType: models.UpstreamAuthBearer,
+				UpstreamSecret:   "primary-token",
+				Upstreams:        []models.MirrorUpstream{{URL: fallback.URL}},
+			}
+
+			resp, err := NewProxy().ProxyRequest(mirror, "package.json", http.Header{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if got := primary.received(); !equalStrings(got, tt.wantPrimary) {
+				t.Errorf("主上游收到 %q, want %q", got, tt.wantPrimary)
+			}
+			if got := fallback.received(); !equalStrings(got, tt.wantFallback) {
+				t.Errorf("备用上游收到 %q, want %q", got, tt.wantFallback)
+			}
+		})
+	}
+}
+
+func TestDoOnlyAddsAuthForPrimaryHost(t *testing.T) {
+	primary := newRecordingServer(t, http.StatusOK)
+	other := newRecordingServer(t, http.StatusOK)
+	mirror := &models.Mirror{
+		UpstreamURL:      primary.URL,
+		UpstreamAuthType: models.UpstreamAuthBasic,
+		UpstreamUsername: "user",
+		UpstreamSecret:   "pass",
+	}
+
+	tests := []struct {
+		name    string
+		server  *recordingServer
+		headers http.Header
+		want    string
+	}{
+		{"主上游", primary, http.Header{}, "Basic dXNlcjpwYXNz"},
+		{"其他主机", other, http.Header{}, ""},
+		{"已有认证头", primary, http.Header{"Authorization": {"Bearer issued"}}, "Bearer issued"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(tt.server.received())
+			resp, err := NewProxy().Do(mirror, http.MethodGet, tt.server.URL+"/token", tt.headers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			got := tt.server.received()[before:]
+			if len(got) != 1 || got[0] != tt.want {
+				t.Errorf("收到 %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxyRequestFallback(t *testing.T) {
+	// 关闭后的服务器用于模拟连接失败
+	down := newRecordingServer(t, http.StatusOK)
+	down.Close()
+
+	tests := []struct {
+		name          string
+		primary       int // 0 表示主上游连接失败
+		fallbacks     []int
+		priorities    []int
+		fallbackOn404 bool
+		offline       bool
+		wantStatus    int
+		wantErr       error
+		// wantRequests 为主上游和各备用上游收到的请求数量
+		wantRequests []int
+	}{
+		{"主上游可用", http.StatusOK, []int{http.StatusOK}, nil, false, false, http.StatusOK, nil, []int{1, 0}},
+		{"主上游返回 5xx", http.StatusBadGateway, []int{http.StatusOK}, nil, false, false, http.StatusOK, nil, []int{1, 1}},
+		{"主上游连接失败", 0, []int{http.StatusOK}, nil, false, false, http.StatusOK, nil, []int{0, 1}},
+		{"404 默认不尝试备用上游", http.StatusNotFound, []int{http.StatusOK}, nil, false, false, http.StatusNotFound, nil, []int{1, 0}},
+		{"开启 FallbackOn404 后尝试备用上游", http.StatusNotFound, []int{http.StatusOK}, nil, true, false, http.StatusOK, nil, []int{1, 1}},
+		{"所有上游都失败时返回最后一个上游的结果", http.StatusBadGateway, []int{http.StatusServiceUnavailable, http.StatusGatewayTimeout}, nil, false, false, http.StatusGatewayTimeout, nil, []int{1, 1, 1}},
+		{"备用上游按优先级排序", http.StatusBadGateway, []int{http.StatusOK, http.StatusOK}, []int{2, 1}, false, false, http.StatusOK, nil, []int{1, 0, 1}},
+		{"离线模式不访问上游", http.StatusOK, []int{http.StatusOK}, nil, false, true, 0, ErrOffline, []int{0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetOffline(tt.offline)
+			t.Cleanup(func() { SetOffline(false) })
+
+			servers := []*recordingServer{down}
+			if tt.primary != 0 {
+				servers[0] = newRecordingServer(t, tt.primary)
+			}
+			mirror := &models.Mirror{UpstreamURL: servers[0].URL, FallbackOn404: tt.fallbackOn404}
+			for i, status := range tt.fallbacks {
+				server := newRecordingServer(t, status)
+				servers = append(servers, server)
+				upstream := models.MirrorUpstream{URL: server.URL}
+				if tt.priorities != nil {
+					upstream.Priority = tt.priorities[i]
+				}
+				mirror.Upstreams = append(mirror.Upstreams, upstream)
+			}
+
+			resp, err := NewProxy().ProxyRequest(mirror, "package.json", http.Header{})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil {
+				resp.Body.Close()
+				if resp.StatusCode != tt.wantStatus {
+					t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+				}
+			}
+			for i, server := range servers {
+				if got := len(server.received()); got != tt.wantRequests[i] {
+					t.Errorf("上游 %d 收到 %d 次请求, want %d", i, got, tt.wantRequests[i])
+				}
+			}
+		})
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -1,16 +1,27 @@
 package registry
 
 import (
+	"bufio"
+	"encoding/json"
 	"fmt"
-	"io"
+	"net/http"
+	"path/filepath"
 	"strings"
+	"time"
 
+	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/logger"
 	"easyCacheMirror/internal/models"
 	"easyCacheMirror/internal/proxy"
 
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
+// cargoDownloadMarkers config.json 中 dl 地址支持的占位符
+var cargoDownloadMarkers = []string{"{crate}", "{version}", "{prefix}", "{lowerprefix}", "{sha256-checksum}"}
+
 type CargoHandler struct {
 	BaseHandler
 	proxy *proxy.Proxy
@@ -40,7 +51,12 @@ func (h *CargoHandler) SupportedType() string {
 	return "Cargo"
 }
 
+// Handle 处理 sparse 协议的 Cargo 请求
+// 客户端配置 registry = "sparse+{ServiceURL}{AccessURL}/index/"，
+// 索引文件位于 index/ 下，包文件通过改写后的 dl 地址 dl/{crate}/{version}/download 下载
 func (h *CargoHandler) Handle(c *gin.Context, mirror *models.Mirror, path string) error {
+	log := logger.GetLogger()
+
 	// 添加更详细的初始化检查
 	if h == nil {
 		return fmt.Errorf("handler 未初始化")
@@ -57,40 +73,460 @@ func (h *CargoHandler) Handle(c *gin.Context, mirror *models.Mirror, path string
 	}
 
 	// 检查请求类型
-	requestType := h.getRequestType(path)
+	requestType := h.getRequestType("/" + path)
 
-	// 打印请求信息
-	fmt.Printf("[INFO] 处理Cargo请求: %s (类型: %s)\n", path, requestType)
+	log.Debug("处理Cargo请求",
+		zap.String("path", path),
+		zap.String("type", requestType),
+	)
 
-	// 直接转发请求到上游
+	// 更新总请求计数
+	if err := updateMirrorCounts(mirror, false); err != nil {
+		log.Error("更新请求计数失败", zap.Error(err))
+	}
+
+	switch requestType {
+	case "config", "index":
+		if strings.HasPrefix(path, "index/") {
+			return h.handleIndex(c, mirror, path)
+		}
+	case "download":
+		return h.handleDownload(c, mirror, path)
+	case "api", "dependencies", "versions", "summary":
+		return h.proxyAPI(c, mirror, path)
+	}
+
+	// 其他请求直接转发到上游
 	resp, err := h.proxy.ProxyRequest(mirror, path, c.Request.Header)
 	if err != nil {
-		fmt.Printf("[ERROR] 代理请求失败: %v\n", err)
-		return fmt.Errorf("代理请求失败: %v", err)
+		log.Error("代理请求失败", zap.Error(err), zap.String("path", path))
+		return fmt.Errorf("代理请求失败: %w", err)
 	}
 	defer resp.Body.Close()
 
-	// 复制响应头和状态码
-	for key, values := range resp.Header {
-		for _, value := range values {
-			c.Header(key, value)
+	return copyResponse(c, resp)
+}
+
+// handleIndex 处理索引文件请求，索引在缓存时间内有效，过期后使用 ETag 向上游重新验证
+func (h *CargoHandler) handleIndex(c *gin.Context, mirror *models.Mirror, path string) error {
+	file, err := h.findFile(mirror, path)
+	if err != nil {
+		return err
+	}
+
+	if file == nil || isCacheExpired(file.DownloadedAt, mirror.CacheTime) {
+		updated, resp, err := h.refreshIndex(mirror, path, file)
+		switch {
+		case file != nil && upstreamFailed(resp, err) && canServeStale(mirror, file.DownloadedAt):
+			// 上游不可用时继续使用过期的索引
+			logger.GetLogger().Warn("上游不可用，使用过期的索引",
+				zap.Error(err),
+				zap.String("path", path),
+			)
+			if resp != nil {
+				resp.Body.Close()
+			}
+		case err != nil:
+			return err
+		case resp != nil:
+			// 上游返回 404 等状态，直接转发给客户端
+			defer resp.Body.Close()
+			return copyResponse(c, resp)
+		default:
+			file = updated
+		}
+	} else if err := updateMirrorCounts(mirror, true); err != nil {
+		logger.GetLogger().Error("更新缓存命中计数失败", zap.Error(err))
+	}
+
+	if err := database.DB.Model(file).Update("last_used_time", time.Now()).Error; err != nil {
+		logger.GetLogger().Error("更新文件使用时间失败", zap.Error(err))
+	}
+
+	if file.FileType == models.CargoFileTypeConfig {
+		return h.serveConfig(c, mirror, file)
+	}
+
+	// 使用上游的校验值，客户端携带的 ETag 或修改时间与缓存一致时返回 304
+	if file.ETag != "" {
+		c.Header("ETag", file.ETag)
+	}
+	if file.LastModified != "" {
+		c.Header("Last-Modified", file.LastModified)
+	}
+
+	return serveLocalFile(c, mirror, file.SavePath, "text/plain; charset=utf-8")
+}
+
+// refreshIndex 从上游拉取或重新验证索引文件，返回最新的缓存记录
+// 上游返回 200/304 以外的状态时，返回上游响应由调用方转发
+func (h *CargoHandler) refreshIndex(mirror *models.Mirror, path string, file *models.CargoFile) (*models.CargoFile, *http.Response, error) {
+	log := logger.GetLogger()
+
+	headers := http.Header{}
+	if file != nil {
+		if file.ETag != "" {
+			headers.Set("If-None-Match", file.ETag)
+		}
+		if file.LastModified != "" {
+			headers.Set("If-Modified-Since", file.LastModified)
+		}
+	}
+
+	resp, err := h.proxy.ProxyRequest(mirror, strings.TrimPrefix(path, "index/"), headers)
+	if err != nil {
+		log.Error("代理请求失败", zap.Error(err), zap.String("path", path))
+		return nil, nil, fmt.Errorf("代理请求失败: %w", err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		resp.Body.Close()
+		if file == nil {
+			return nil, nil, fmt.Errorf("上游返回 304 但本地没有缓存: %s", path)
+		}
+		log.Debug("索引未变化，继续使用缓存", zap.String("path", path))
+		file.DownloadedAt = time.Now()
+		if err := database.DB.Model(file).Update("downloaded_at", file.DownloadedAt).Error; err != nil {
+			log.Error("更新文件记录失败", zap.Error(err))
+		}
+		return file, nil, nil
+	case http.StatusOK:
+		defer resp.Body.Close()
+	default:
+		return nil, resp, nil
+	}
+
+	savePath := filepath.Join(mirror.BlobPath, path)
+	size, sum, err := saveToFile(mirror, savePath, resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	if file == nil {
+		fileType := models.CargoFileTypeIndex
+		if path == "index/config.json" {
+			fileType = models.CargoFileTypeConfig
 		}
+		file = &models.CargoFile{
+			MirrorID:     mirror.ID,
+			CrateName:    filepath.Base(path),
+			RelativePath: path,
+			FileType:     fileType,
+			LastUsedTime: now,
+		}
+	}
+	file.FileSize = size
+	file.SavePath = savePath
+	file.Checksum = sum
+	file.ETag = resp.Header.Get("ETag")
+	file.LastModified = resp.Header.Get("Last-Modified")
+	file.DownloadedAt = now
+
+	if err := database.DB.Save(file).Error; err != nil {
+		log.Error("保存文件记录失败", zap.Error(err))
 	}
-	c.Status(resp.StatusCode)
+	return file, nil, nil
+}
 
-	// 复制响应体
-	written, err := io.Copy(c.Writer, resp.Body)
+// serveConfig 返回改写后的 config.json，让下载和 API 请求都经过镜像
+func (h *CargoHandler) serveConfig(c *gin.Context, mirror *models.Mirror, file *models.CargoFile) error {
+	config, err := h.readConfig(mirror, file)
 	if err != nil {
-		fmt.Printf("[ERROR] 复制响应失败: %v\n", err)
-		return fmt.Errorf("复制响应失败: %v", err)
+		return err
 	}
 
-	fmt.Printf("[INFO] 完成请求: %s (状态码: %d, 大小: %d bytes)\n",
-		path, resp.StatusCode, written)
+	baseURL := mirrorBaseURL(mirror)
+	config["dl"] = baseURL + "/dl/{crate}/{version}/download"
+	config["api"] = baseURL
 
+	c.JSON(http.StatusOK, config)
 	return nil
 }
 
+// upstreamConfig 获取上游原始的 config.json
+func (h *CargoHandler) upstreamConfig(mirror *models.Mirror) (map[string]interface{}, error) {
+	file, err := h.findFile(mirror, "index/config.json")
+	if err != nil {
+		return nil, err
+	}
+
+	if file == nil || isCacheExpired(file.DownloadedAt, mirror.CacheTime) {
+		updated, resp, err := h.refreshIndex(mirror, "index/config.json", file)
+		if err != nil {
+			return nil, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("获取上游 config.json 失败，状态码: %d", resp.StatusCode)
+		}
+		file = updated
+	}
+
+	return h.readConfig(mirror, file)
+}
+
+func (h *CargoHandler) readConfig(mirror *models.Mirror, file *models.CargoFile) (map[string]interface{}, error) {
+	data, err := readCachedFile(mirror, file.SavePath)
+	if err != nil {
+		return nil, fmt.Errorf("读取缓存文件失败: %v", err)
+	}
+
+	var config map[string]interface{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("解析 config.json 失败: %v", err)
+	}
+	return config, nil
+}
+
+// handleDownload 处理包文件下载，包文件缓存后永久有效，保存前校验 sha256
+func (h *CargoHandler) handleDownload(c *gin.Context, mirror *models.Mirror, path string) error {
+	log := logger.GetLogger()
+
+	// 路径格式: dl/{crate}/{version}/download
+	parts := strings.Split(path, "/")
+	if len(parts) != 4 || parts[3] != "download" {
+		return fmt.Errorf("无效的下载路径: %s", path)
+	}
+	crateName, version := parts[1], parts[2]
+
+	var crateFile models.CargoFile
+	result := database.DB.Where(&models.CargoFile{
+		MirrorID:  mirror.ID,
+		CrateName: crateName,
+		Version:   version,
+		FileType:  models.CargoFileTypeCrate,
+	}).First(&crateFile)
+	if result.Error == nil {
+		if cachedFileExists(mirror, crateFile.SavePath) {
+			return h.serveCachedFile(c, mirror, &crateFile)
+		}
+		log.Warn("缓存文件不存在，重新拉取", zap.String("path", crateFile.SavePath))
+		database.DB.Delete(&crateFile)
+	} else if result.Error != gorm.ErrRecordNotFound {
+		return fmt.Errorf("查询缓存文件失败: %v", result.Error)
+	}
+
+	checksum, err := h.crateChecksum(mirror, crateName, version)
+	if err != nil {
+		log.Warn("无法获取包的校验值，跳过缓存", zap.Error(err), zap.String("crate", crateName))
+	}
+
+	config, err := h.upstreamConfig(mirror)
+	if err != nil {
+		return err
+	}
+	dl, _ := config["dl"].(string)
+	if dl == "" {
+		return fmt.Errorf("上游 config.json 中没有 dl 地址")
+	}
+
+	fetch := func() (*http.Response, error) {
+		return h.proxy.Do(mirror, http.MethodGet, h.downloadURL(dl, crateName, version, checksum), cacheHeaders(c.Request.Header))
+	}
+
+	// 无法获取校验值的包不进入缓存，直接转发
+	if checksum == "" {
+		resp, err := fetch()
+		if err != nil {
+			return fmt.Errorf("代理请求失败: %w", err)
+		}
+		defer resp.Body.Close()
+		return copyResponse(c, resp)
+	}
+
+	// 边下载边返回给客户端，同一文件的并发请求共用一次拉取，写入完成后校验 sha256
+	c.Header("Content-Type", "application/x-tar")
+	savePath := filepath.Join(mirror.BlobPath, "crates", crateName, crateName+"-"+version+".crate")
+	return streamDownload(c, mirror, savePath, fetch, verifySha256(checksum),
+		func(header http.Header, size int64, sum string) error {
+			crateFile = models.CargoFile{
+				MirrorID:     mirror.ID,
+				CrateName:    crateName,
+				Version:      version,
+				RelativePath: path,
+				FileType:     models.CargoFileTypeCrate,
+				FileSize:     size,
+				SavePath:     savePath,
+				Checksum:     sum,
+				DownloadedAt: time.Now(),
+				LastUsedTime: time.Now(),
+			}
+			if err := database.DB.Create(&crateFile).Error; err != nil {
+				return fmt.Errorf("保存文件记录失败: %v", err)
+			}
+			return nil
+		},
+	)
+}
+
+// crateChecksum 从索引文件中查找指定版本的 sha256
+func (h *CargoHandler) crateChecksum(mirror *models.Mirror, crateName, version string) (string, error) {
+	indexPath := "index/" + cargoIndexPath(crateName)
+
+	file, err := h.findFile(mirror, indexPath)
+	if err != nil {
+		return "", err
+	}
+	if file != nil {
+		if checksum := h.findChecksum(mirror, file, version); checksum != "" {
+			return checksum, nil
+		}
+	}
+
+	// 本地索引中没有该版本，重新验证索引后再查找
+	updated, resp, err := h.refreshIndex(mirror, indexPath, file)
+	if err != nil {
+		return "", err
+	}
+	if resp != nil {
+		resp.Body.Close()
+		return "", fmt.Errorf("获取索引失败，状态码: %d", resp.StatusCode)
+	}
+
+	if checksum := h.findChecksum(mirror, updated, version); checksum != "" {
+		return checksum, nil
+	}
+	return "", fmt.Errorf("索引中没有版本 %s", version)
+}
+
+// findChecksum 解析索引文件，每行是一个版本的 JSON 描述
+func (h *CargoHandler) findChecksum(mirror *models.Mirror, file *models.CargoFile, version string) string {
+	f, err := openCachedFile(mirror, file.SavePath)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry struct {
+			Vers  string `json:"vers"`
+			Cksum string `json:"cksum"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if entry.Vers == version {
+			return entry.Cksum
+		}
+	}
+	return ""
+}
+
+// downloadURL 按上游 dl 配置生成下载地址
+func (h *CargoHandler) downloadURL(dl, crateName, version, checksum string) string {
+	hasMarker := false
+	for _, marker := range cargoDownloadMarkers {
+		if strings.Contains(dl, marker) {
+			hasMarker = true
+			break
+		}
+	}
+	if !hasMarker {
+		return strings.TrimRight(dl, "/") + "/" + crateName + "/" + version + "/download"
+	}
+
+	prefix := cargoIndexPrefix(crateName)
+	return strings.NewReplacer(
+		"{crate}", crateName,
+		"{version}", version,
+		"{prefix}", prefix,
+		"{lowerprefix}", strings.ToLower(prefix),
+		"{sha256-checksum}", checksum,
+	).Replace(dl)
+}
+
+// proxyAPI 将 Web API 请求转发到上游 config.json 中的 api 地址
+func (h *CargoHandler) proxyAPI(c *gin.Context, mirror *models.Mirror, path string) error {
+	config, err := h.upstreamConfig(mirror)
+	if err != nil {
+		return err
+	}
+	api, _ := config["api"].(string)
+	if api == "" {
+		c.String(http.StatusNotFound, "上游未提供 API")
+		return nil
+	}
+
+	if c.Request.URL.RawQuery != "" {
+		path += "?" + c.Request.URL.RawQuery
+	}
+
+	resp, err := h.proxy.ProxyRequest(withUpstream(mirror, api), path, c.Request.Header)
+	if err != nil {
+		return fmt.Errorf("代理请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return copyResponse(c, resp)
+}
+
+// findFile 查找缓存记录，记录存在但文件丢失时删除记录
+func (h *CargoHandler) findFile(mirror *models.Mirror, path string) (*models.CargoFile, error) {
+	var file models.CargoFile
+	result := database.DB.Where(&models.CargoFile{
+		MirrorID:     mirror.ID,
+		RelativePath: path,
+	}).First(&file)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("查询缓存文件失败: %v", result.Error)
+	}
+
+	if !cachedFileExists(mirror, file.SavePath) {
+		logger.GetLogger().Warn("缓存文件不存在，重新拉取", zap.String("path", file.SavePath))
+		database.DB.Delete(&file)
+		return nil, nil
+	}
+	return &file, nil
+}
+
+// serveCachedFile 从缓存提供包文件
+func (h *CargoHandler) serveCachedFile(c *gin.Context, mirror *models.Mirror, file *models.CargoFile) error {
+	log := logger.GetLogger()
+
+	log.Debug("命中缓存",
+		zap.String("crate", file.CrateName),
+		zap.String("version", file.Version),
+		zap.String("mirror", mirror.Name),
+	)
+
+	if err := database.DB.Model(file).Update("last_used_time", time.Now()).Error; err != nil {
+		log.Error("更新文件使用时间失败", zap.Error(err))
+	}
+
+	if err := updateMirrorCounts(mirror, true); err != nil {
+		log.Error("更新缓存命中计数失败", zap.Error(err))
+	}
+
+	return serveLocalFile(c, mirror, file.SavePath, "application/x-tar")
+}
+
+// cargoIndexPath 按 sparse 索引的规则计算包对应的索引文件路径
+func cargoIndexPath(crateName string) string {
+	name := strings.ToLower(crateName)
+	return cargoIndexPrefix(name) + "/" + name
+}
+
+// cargoIndexPrefix 计算 dl 地址中 {prefix} 占位符的值，保留包名的大小写
+func cargoIndexPrefix(crateName string) string {
+	switch len(crateName) {
+	case 1:
+		return "1"
+	case 2:
+		return "2"
+	case 3:
+		return "3/" + crateName[:1]
+	default:
+		return crateName[:2] + "/" + crateName[2:4]
+	}
+}
+
 // getRequestType 判断Cargo请求的类型
 func (h *CargoHandler) getRequestType(path string) string {
 	switch {
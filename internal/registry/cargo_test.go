@@ -0,0 +1,87 @@
+package registry
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCargoCaching(t *testing.T) {
+	mirror := setupRegistryStore(t)
+	const crate = "crate content"
+	upstream := newStaticUpstream(t, map[string]string{
+		"se/rd/serde":                    `{"name":"serde","vers":"1.0.0","cksum":"` + strings.TrimPrefix(sha256Digest(crate), "sha256:") + `"}`,
+		"crates/serde/serde-1.0.0.crate": crate,
+	})
+	upstream.set("config.json", `{"dl":"`+upstream.server.URL+`/crates/{crate}/{crate}-{version}.crate","api":"https://crates.io"}`)
+	mirror.UpstreamURL = upstream.server.URL
+	mirror.ServiceURL = "http://mirror.local"
+	mirror.AccessURL = "cargo"
+	mirror.CacheTime = 10
+	h := NewCargoHandler()
+
+	steps := []struct {
+		name         string
+		path         string
+		wantBody     string
+		wantRequests int32
+	}{
+		{"改写 config.json 中的下载地址", "index/config.json", `"dl":"http://mirror.local/cargo/dl/{crate}/{version}/download"`, 1},
+		{"第一次获取索引", "index/se/rd/serde", `"vers":"1.0.0"`, 1},
+		{"缓存时间内使用缓存的索引", "index/se/rd/serde", `"vers":"1.0.0"`, 0},
+		{"按上游的 dl 地址下载并校验", "dl/serde/1.0.0/download", crate, 1},
+		{"再次下载使用缓存", "dl/serde/1.0.0/download", crate, 0},
+	}
+	for _, st := range steps {
+		before := upstream.requests.Load()
+		w := serveHandler(h, mirror, st.path, nil)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), st.wantBody) {
+			t.Fatalf("%s: status = %d, body = %q, want %q", st.name, w.Code, w.Body.String(), st.wantBody)
+		}
+		if got := upstream.requests.Load() - before; got != st.wantRequests {
+			t.Errorf("%s: 上游收到 %d 次请求, want %d", st.name, got, st.wantRequests)
+		}
+	}
+}
+
+func TestCargoDownloadURL(t *testing.T) {
+	h := NewCargoHandler()
+	tests := []struct {
+		name  string
+		dl    string
+		crate string
+		want  string
+	}{
+		{"没有占位符", "https://crates.io/api/v1/crates", "serde", "https://crates.io/api/v1/crates/serde/1.0.0/download"},
+		{"crate 和 version", "https://dl.example.com/{crate}/{crate}-{version}.crate", "serde", "https://dl.example.com/serde/serde-1.0.0.crate"},
+		{"四个字符以上的 prefix", "https://dl.example.com/{prefix}/{crate}", "Serde", "https://dl.example.com/Se/rd/Serde"},
+		{"lowerprefix", "https://dl.example.com/{lowerprefix}/{crate}", "Serde", "https://dl.example.com/se/rd/Serde"},
+		{"三个字符的 prefix", "https://dl.example.com/{prefix}/{crate}", "syn", "https://dl.example.com/3/s/syn"},
+		{"一个字符的 prefix", "https://dl.example.com/{prefix}/{crate}", "a", "https://dl.example.com/1/a"},
+		{"校验值", "https://dl.example.com/{sha256-checksum}", "serde", "https://dl.example.com/abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := h.downloadURL(tt.dl, tt.crate, "1.0.0", "abc"); got != tt.want {
+				t.Errorf("downloadURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCargoIndexPath(t *testing.T) {
+	tests := []struct {
+		crate string
+		want  string
+	}{
+		{"a", "1/a"},
+		{"ab", "2/ab"},
+		{"Syn", "3/s/syn"},
+		{"Serde", "se/rd/serde"},
+	}
+	for _, tt := range tests {
+		if got := cargoIndexPath(tt.crate); got != tt.want {
+			t.Errorf("cargoIndexPath(%q) = %q, want %q", tt.crate, got, tt.want)
+		}
+	}
+}
@@ -1,19 +1,45 @@
 package registry
 
 import (
+	"compress/bzip2"
+	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
+	"path"
+	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
+	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/logger"
 	"easyCacheMirror/internal/models"
 	"easyCacheMirror/internal/proxy"
+	"easyCacheMirror/internal/storage"
 
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
+// condaMaxChecksumIndexes 内存中最多保留的 repodata 校验值索引数量
+const condaMaxChecksumIndexes = 8
+
+// condaRepodataSources 查找包校验值时依次尝试的 repodata 文件
+var condaRepodataSources = []string{"repodata.json", "current_repodata.json", "repodata.json.bz2"}
+
+// condaChecksumIndex 从 repodata 中解析出的 文件名 -> sha256 索引
+type condaChecksumIndex struct {
+	modTime time.Time
+	sums    map[string]string
+}
+
 type CondaHandler struct {
 	BaseHandler
-	proxy *proxy.Proxy
+	proxy   *proxy.Proxy
+	indexes map[string]*condaChecksumIndex
+	mu      sync.Mutex
 }
 
 func NewCondaHandler() *CondaHandler {
@@ -23,7 +49,8 @@ func NewCondaHandler() *CondaHandler {
 	}
 
 	handler := &CondaHandler{
-		proxy: p,
+		proxy:   p,
+		indexes: make(map[string]*condaChecksumIndex),
 	}
 
 	// 验证初始化
@@ -41,6 +68,8 @@ func (h *CondaHandler) SupportedType() string {
 }
 
 func (h *CondaHandler) Handle(c *gin.Context, mirror *models.Mirror, path string) error {
+	log := logger.GetLogger()
+
 	// 添加更详细的初始化检查
 	if h == nil {
 		return fmt.Errorf("handler 未初始化")
@@ -59,36 +88,351 @@ func (h *CondaHandler) Handle(c *gin.Context, mirror *models.Mirror, path string
 	// 检查请求类型
 	requestType := h.getRequestType(path)
 
-	// 打印请求信息
-	fmt.Printf("[INFO] 处理Conda请求: %s (类型: %s)\n", path, requestType)
+	log.Debug("处理Conda请求",
+		zap.String("path", path),
+		zap.String("type", requestType),
+	)
+
+	// 更新总请求计数
+	if err := updateMirrorCounts(mirror, false); err != nil {
+		log.Error("更新请求计数失败", zap.Error(err))
+	}
 
-	// 直接转发请求到上游
+	switch requestType {
+	case "repodata", "repodata-bz2", "repodata-zst":
+		return h.handleRepodata(c, mirror, path)
+	case "package-bz2", "package-conda":
+		return h.handlePackage(c, mirror, path)
+	}
+
+	// 其他请求直接转发到上游
 	resp, err := h.proxy.ProxyRequest(mirror, path, c.Request.Header)
 	if err != nil {
-		fmt.Printf("[ERROR] 代理请求失败: %v\n", err)
-		return fmt.Errorf("代理请求失败: %v", err)
+		log.Error("代理请求失败", zap.Error(err), zap.String("path", path))
+		return fmt.Errorf("代理请求失败: %w", err)
 	}
 	defer resp.Body.Close()
 
-	// 复制响应头和状态码
-	for key, values := range resp.Header {
-		for _, value := range values {
-			c.Header(key, value)
+	return copyResponse(c, resp)
+}
+
+// handleRepodata 处理 repodata 请求，在缓存时间内有效，过期后向上游重新验证
+// 上游不可用时在 StaleIfError 时间内继续使用过期的缓存
+func (h *CondaHandler) handleRepodata(c *gin.Context, mirror *models.Mirror, path string) error {
+	file, err := h.findFile(mirror, path)
+	if err != nil {
+		return err
+	}
+
+	if file == nil || isCacheExpired(file.DownloadedAt, mirror.CacheTime) {
+		updated, resp, err := h.refreshRepodata(mirror, path, file)
+		if file != nil && upstreamFailed(resp, err) && canServeStale(mirror, file.DownloadedAt) {
+			logger.GetLogger().Warn("上游不可用，使用过期的缓存",
+				zap.Error(err),
+				zap.String("path", path),
+			)
+			if resp != nil {
+				resp.Body.Close()
+			}
+			return h.serveCachedFile(c, mirror, file)
+		}
+		if err != nil {
+			return err
+		}
+		if resp != nil {
+			defer resp.Body.Close()
+			return copyResponse(c, resp)
+		}
+		return serveLocalFile(c, mirror, updated.SavePath, updated.ContentType)
+	}
+
+	return h.serveCachedFile(c, mirror, file)
+}
+
+// refreshRepodata 从上游拉取或重新验证 repodata，返回最新的缓存记录
+// 上游返回 200/304 以外的状态时，返回上游响应由调用方转发
+func (h *CondaHandler) refreshRepodata(mirror *models.Mirror, path string, file *models.CondaFile) (*models.CondaFile, *http.Response, error) {
+	log := logger.GetLogger()
+
+	headers := http.Header{}
+	if file != nil {
+		if file.ETag != "" {
+			headers.Set("If-None-Match", file.ETag)
+		}
+		if file.LastModified != "" {
+			headers.Set("If-Modified-Since", file.LastModified)
+		}
+	}
+
+	resp, err := h.proxy.ProxyRequest(mirror, path, headers)
+	if err != nil {
+		log.Error("代理请求失败", zap.Error(err), zap.String("path", path))
+		return nil, nil, fmt.Errorf("代理请求失败: %w", err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		resp.Body.Close()
+		if file == nil {
+			return nil, nil, fmt.Errorf("上游返回 304 但本地没有缓存: %s", path)
+		}
+		log.Debug("repodata 未变化，继续使用缓存", zap.String("path", path))
+		file.DownloadedAt = time.Now()
+		if err := database.DB.Model(file).Update("downloaded_at", file.DownloadedAt).Error; err != nil {
+			log.Error("更新文件记录失败", zap.Error(err))
+		}
+		return file, nil, nil
+	case http.StatusOK:
+		defer resp.Body.Close()
+	default:
+		return nil, resp, nil
+	}
+
+	savePath := filepath.Join(mirror.BlobPath, path)
+	size, sum, err := saveToFile(mirror, savePath, resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	if file == nil {
+		channel, subdir := h.splitChannel(path)
+		file = &models.CondaFile{
+			MirrorID:     mirror.ID,
+			Channel:      channel,
+			Subdir:       subdir,
+			FileName:     filepath.Base(path),
+			RelativePath: path,
+			FileType:     models.CondaFileTypeRepodata,
+			LastUsedTime: now,
+		}
+	}
+	file.FileSize = size
+	file.SavePath = savePath
+	file.ContentType = resp.Header.Get("Content-Type")
+	file.Sha256 = sum
+	file.ETag = resp.Header.Get("ETag")
+	file.LastModified = resp.Header.Get("Last-Modified")
+	file.DownloadedAt = now
+
+	if err := database.DB.Save(file).Error; err != nil {
+		log.Error("保存文件记录失败", zap.Error(err))
+	}
+	return file, nil, nil
+}
+
+// handlePackage 处理包文件请求，包文件按平台目录永久缓存，保存前校验 repodata 中的 sha256
+func (h *CondaHandler) handlePackage(c *gin.Context, mirror *models.Mirror, path string) error {
+	log := logger.GetLogger()
+
+	file, err := h.findFile(mirror, path)
+	if err != nil {
+		return err
+	}
+	if file != nil {
+		return h.serveCachedFile(c, mirror, file)
+	}
+
+	expected := h.packageChecksum(mirror, path)
+
+	// 无法获取校验值的包不进入缓存，直接转发
+	if expected == "" {
+		log.Warn("repodata 中没有包的 sha256，跳过缓存", zap.String("path", path))
+		resp, err := h.proxy.ProxyRequest(mirror, path, cacheHeaders(c.Request.Header))
+		if err != nil {
+			log.Error("代理请求失败", zap.Error(err), zap.String("path", path))
+			return fmt.Errorf("代理请求失败: %w", err)
 		}
+		defer resp.Body.Close()
+		return copyResponse(c, resp)
 	}
-	c.Status(resp.StatusCode)
 
-	// 复制响应体
-	written, err := io.Copy(c.Writer, resp.Body)
+	// 边下载边返回给客户端，同一文件的并发请求共用一次拉取，写入完成后校验 sha256
+	savePath := filepath.Join(mirror.BlobPath, path)
+	return streamDownload(c, mirror, savePath,
+		func() (*http.Response, error) {
+			return h.proxy.ProxyRequest(mirror, path, cacheHeaders(c.Request.Header))
+		},
+		verifySha256(expected),
+		func(header http.Header, size int64, sum string) error {
+			channel, subdir := h.splitChannel(path)
+			file := &models.CondaFile{
+				MirrorID:     mirror.ID,
+				Channel:      channel,
+				Subdir:       subdir,
+				FileName:     filepath.Base(path),
+				RelativePath: path,
+				FileType:     models.CondaFileTypePackage,
+				FileSize:     size,
+				SavePath:     savePath,
+				ContentType:  header.Get("Content-Type"),
+				Sha256:       sum,
+				DownloadedAt: time.Now(),
+				LastUsedTime: time.Now(),
+			}
+			if err := database.DB.Create(file).Error; err != nil {
+				return fmt.Errorf("保存文件记录失败: %v", err)
+			}
+
+			log.Debug("包文件已缓存",
+				zap.String("path", path),
+				zap.Int64("size", size),
+			)
+			return nil
+		},
+	)
+}
+
+// packageChecksum 从同一平台目录的 repodata 中查找包的 sha256
+func (h *CondaHandler) packageChecksum(mirror *models.Mirror, packagePath string) string {
+	log := logger.GetLogger()
+	dir := path.Dir(packagePath)
+	fileName := path.Base(packagePath)
+
+	for _, source := range condaRepodataSources {
+		file, err := h.findFile(mirror, dir+"/"+source)
+		if err != nil || file == nil {
+			continue
+		}
+		if sum := h.lookupChecksum(mirror, file, fileName); sum != "" {
+			return sum
+		}
+	}
+
+	// 本地没有可用的 repodata，或其中没有该包，拉取最新的 repodata.json
+	repodataPath := dir + "/repodata.json"
+	file, err := h.findFile(mirror, repodataPath)
+	if err != nil {
+		return ""
+	}
+	updated, resp, err := h.refreshRepodata(mirror, repodataPath, file)
 	if err != nil {
-		fmt.Printf("[ERROR] 复制响应失败: %v\n", err)
-		return fmt.Errorf("复制响应失败: %v", err)
+		log.Warn("拉取 repodata 失败", zap.Error(err), zap.String("path", repodataPath))
+		return ""
 	}
+	if resp != nil {
+		resp.Body.Close()
+		return ""
+	}
+	return h.lookupChecksum(mirror, updated, fileName)
+}
 
-	fmt.Printf("[INFO] 完成请求: %s (状态码: %d, 大小: %d bytes)\n",
-		path, resp.StatusCode, written)
+// lookupChecksum 在 repodata 中查找文件的 sha256，解析结果按文件修改时间缓存在内存中
+func (h *CondaHandler) lookupChecksum(mirror *models.Mirror, file *models.CondaFile, fileName string) string {
+	info, err := storage.ForMirror(mirror).Stat(blobKey(mirror, file.SavePath))
+	if err != nil {
+		return ""
+	}
+
+	h.mu.Lock()
+	index, ok := h.indexes[file.SavePath]
+	h.mu.Unlock()
+
+	if !ok || !index.modTime.Equal(info.ModTime) {
+		sums, err := h.parseRepodata(mirror, file.SavePath)
+		if err != nil {
+			logger.GetLogger().Warn("解析 repodata 失败",
+				zap.Error(err),
+				zap.String("path", file.SavePath),
+			)
+			return ""
+		}
+		index = &condaChecksumIndex{modTime: info.ModTime, sums: sums}
+
+		h.mu.Lock()
+		if len(h.indexes) >= condaMaxChecksumIndexes {
+			for key := range h.indexes {
+				delete(h.indexes, key)
+				break
+			}
+		}
+		h.indexes[file.SavePath] = index
+		h.mu.Unlock()
+	}
+
+	return index.sums[fileName]
+}
+
+// parseRepodata 解析 repodata 中 packages 和 packages.conda 的 sha256
+func (h *CondaHandler) parseRepodata(mirror *models.Mirror, savePath string) (map[string]string, error) {
+	f, err := openCachedFile(mirror, savePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var reader io.Reader = f
+	if strings.HasSuffix(savePath, ".bz2") {
+		reader = bzip2.NewReader(f)
+	}
+
+	type packageInfo struct {
+		Sha256 string `json:"sha256"`
+	}
+	var repodata struct {
+		Packages      map[string]packageInfo `json:"packages"`
+		PackagesConda map[string]packageInfo `json:"packages.conda"`
+	}
+	if err := json.NewDecoder(reader).Decode(&repodata); err != nil {
+		return nil, err
+	}
+
+	sums := make(map[string]string, len(repodata.Packages)+len(repodata.PackagesConda))
+	for name, pkg := range repodata.Packages {
+		sums[name] = pkg.Sha256
+	}
+	for name, pkg := range repodata.PackagesConda {
+		sums[name] = pkg.Sha256
+	}
+	return sums, nil
+}
+
+// findFile 查找缓存记录，记录存在但文件丢失时删除记录
+func (h *CondaHandler) findFile(mirror *models.Mirror, path string) (*models.CondaFile, error) {
+	var file models.CondaFile
+	result := database.DB.Where(&models.CondaFile{
+		MirrorID:     mirror.ID,
+		RelativePath: path,
+	}).First(&file)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("查询缓存文件失败: %v", result.Error)
+	}
+
+	if !cachedFileExists(mirror, file.SavePath) {
+		logger.GetLogger().Warn("缓存文件不存在，重新拉取", zap.String("path", file.SavePath))
+		database.DB.Delete(&file)
+		return nil, nil
+	}
+	return &file, nil
+}
+
+// serveCachedFile 从缓存提供文件
+func (h *CondaHandler) serveCachedFile(c *gin.Context, mirror *models.Mirror, file *models.CondaFile) error {
+	log := logger.GetLogger()
+
+	log.Debug("命中缓存",
+		zap.String("path", file.RelativePath),
+		zap.String("mirror", mirror.Name),
+	)
+
+	if err := database.DB.Model(file).Update("last_used_time", time.Now()).Error; err != nil {
+		log.Error("更新文件使用时间失败", zap.Error(err))
+	}
+
+	if err := updateMirrorCounts(mirror, true); err != nil {
+		log.Error("更新缓存命中计数失败", zap.Error(err))
+	}
+
+	return serveLocalFile(c, mirror, file.SavePath, file.ContentType)
+}
 
-	return nil
+// splitChannel 从路径中拆分出频道和平台目录
+func (h *CondaHandler) splitChannel(filePath string) (channel, subdir string) {
+	dir := path.Dir(filePath)
+	return path.Dir(dir), path.Base(dir)
 }
 
 // getRequestType 判断Conda请求的类型
@@ -98,6 +442,8 @@ func (h *CondaHandler) getRequestType(path string) string {
 		return "repodata"
 	case strings.HasSuffix(path, "repodata.json.bz2"):
 		return "repodata-bz2"
+	case strings.HasSuffix(path, "repodata.json.zst"):
+		return "repodata-zst"
 	case strings.HasSuffix(path, ".tar.bz2"):
 		return "package-bz2"
 	case strings.HasSuffix(path, ".conda"):
@@ -0,0 +1,63 @@
+package registry
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/models"
+)
+
+func TestCondaCaching(t *testing.T) {
+	mirror := setupRegistryStore(t)
+	const pkg = "package content"
+	upstream := newStaticUpstream(t, map[string]string{
+		"conda-forge/linux-64/repodata.json": `{"packages":{` +
+			`"demo-1.0-0.tar.bz2":{"sha256":"` + strings.TrimPrefix(sha256Digest(pkg), "sha256:") + `"},` +
+			`"bad-1.0-0.tar.bz2":{"sha256":"` + strings.TrimPrefix(sha256Digest("other"), "sha256:") + `"}` +
+			`},"packages.conda":{}}`,
+		"conda-forge/linux-64/demo-1.0-0.tar.bz2":    pkg,
+		"conda-forge/linux-64/bad-1.0-0.tar.bz2":     "tampered",
+		"conda-forge/linux-64/unknown-1.0-0.tar.bz2": "unknown",
+	})
+	mirror.UpstreamURL = upstream.server.URL
+	mirror.CacheTime = 10
+	h := NewCondaHandler()
+
+	steps := []struct {
+		name         string
+		path         string
+		wantBody     string
+		wantAborted  bool
+		wantRequests int32
+	}{
+		{"第一次获取 repodata", "conda-forge/linux-64/repodata.json", `"demo-1.0-0.tar.bz2"`, false, 1},
+		{"缓存时间内使用缓存的 repodata", "conda-forge/linux-64/repodata.json", `"demo-1.0-0.tar.bz2"`, false, 0},
+		{"按 repodata 中的 sha256 校验后缓存", "conda-forge/linux-64/demo-1.0-0.tar.bz2", pkg, false, 1},
+		{"再次下载使用缓存", "conda-forge/linux-64/demo-1.0-0.tar.bz2", pkg, false, 0},
+		{"校验失败时中断响应", "conda-forge/linux-64/bad-1.0-0.tar.bz2", "", true, 1},
+		{"校验失败的包不缓存", "conda-forge/linux-64/bad-1.0-0.tar.bz2", "", true, 1},
+		{"repodata 中没有的包直接转发", "conda-forge/linux-64/unknown-1.0-0.tar.bz2", "unknown", false, 2},
+		{"repodata 中没有的包不缓存", "conda-forge/linux-64/unknown-1.0-0.tar.bz2", "unknown", false, 2},
+	}
+	for _, st := range steps {
+		before := upstream.requests.Load()
+		w, aborted := serveAbortable(h, mirror, st.path)
+		if aborted != st.wantAborted {
+			t.Fatalf("%s: aborted = %v, want %v", st.name, aborted, st.wantAborted)
+		}
+		if !aborted && (w.Code != http.StatusOK || !strings.Contains(w.Body.String(), st.wantBody)) {
+			t.Fatalf("%s: status = %d, body = %q, want %q", st.name, w.Code, w.Body.String(), st.wantBody)
+		}
+		if got := upstream.requests.Load() - before; got != st.wantRequests {
+			t.Errorf("%s: 上游收到 %d 次请求, want %d", st.name, got, st.wantRequests)
+		}
+	}
+
+	var count int64
+	database.DB.Model(&models.CondaFile{}).Where("mirror_id = ? AND file_type = ?", mirror.ID, models.CondaFileTypePackage).Count(&count)
+	if count != 1 {
+		t.Errorf("包文件记录数量 = %d, want 1", count)
+	}
+}
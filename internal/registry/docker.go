@@ -1,19 +1,48 @@
 package registry
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
+	"time"
 
+	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/logger"
 	"easyCacheMirror/internal/models"
 	"easyCacheMirror/internal/proxy"
 
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
+var (
+	// dockerDigestPattern 只缓存 sha256 digest，同时避免路径穿越
+	dockerDigestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+	// dockerChallengeParam 解析 WWW-Authenticate 中的参数
+	dockerChallengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+)
+
+// dockerToken 缓存的上游访问令牌
+type dockerToken struct {
+	value     string
+	expiresAt time.Time
+}
+
 type DockerHandler struct {
 	BaseHandler
-	proxy *proxy.Proxy
+	proxy  *proxy.Proxy
+	tokens map[string]dockerToken
+	mu     sync.Mutex
 }
 
 func NewDockerHandler() *DockerHandler {
@@ -23,7 +52,8 @@ func NewDockerHandler() *DockerHandler {
 	}
 
 	handler := &DockerHandler{
-		proxy: p,
+		proxy:  p,
+		tokens: make(map[string]dockerToken),
 	}
 
 	// 验证初始化
@@ -41,6 +71,8 @@ func (h *DockerHandler) SupportedType() string {
 }
 
 func (h *DockerHandler) Handle(c *gin.Context, mirror *models.Mirror, path string) error {
+	log := logger.GetLogger()
+
 	// 添加更详细的初始化检查
 	if h == nil {
 		return fmt.Errorf("handler 未初始化")
@@ -50,72 +82,576 @@ func (h *DockerHandler) Handle(c *gin.Context, mirror *models.Mirror, path strin
 		return fmt.Errorf("proxy 未初始化 (handler: %v)", h)
 	}
 
-	// 移除路径开头的斜杠并确保正确的路径格式
-	path = strings.TrimPrefix(path, "/")
-	if path == "" {
-		return fmt.Errorf("无效的路径")
-	}
+	// docker 客户端总是请求 /v2/ 开头的地址，镜像的访问地址一般配置为 /v2
+	path = strings.Trim(path, "/")
+	apiPath, isV2 := h.apiPath(path)
 
 	// 检查请求类型
-	requestType := h.getRequestType(path, c.Request.Method)
+	requestType := "other"
+	if isV2 {
+		requestType = h.getRequestType(apiPath, c.Request.Method)
+	}
+
+	log.Debug("处理Docker请求",
+		zap.String("path", path),
+		zap.String("type", requestType),
+		zap.String("method", c.Request.Method),
+	)
 
-	// 打印请求信息
-	fmt.Printf("[INFO] 处理Docker请求: %s (类型: %s, 方法: %s)\n",
-		path, requestType, c.Request.Method)
+	// 更新总请求计数
+	if err := updateMirrorCounts(mirror, false); err != nil {
+		log.Error("更新请求计数失败", zap.Error(err))
+	}
+
+	if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		h.writeError(c, http.StatusMethodNotAllowed, "UNSUPPORTED", "镜像仓库为只读，不支持推送")
+		return nil
+	}
 
-	// 直接转发请求到上游
-	resp, err := h.proxy.ProxyRequest(mirror, path, c.Request.Header)
+	switch requestType {
+	case "ping":
+		c.Header("Docker-Distribution-API-Version", "registry/2.0")
+		c.JSON(http.StatusOK, gin.H{})
+		return nil
+	case "manifest", "manifest-check":
+		repository, reference := h.splitPath(apiPath, "/manifests/")
+		return h.handleManifest(c, mirror, h.normalizeRepository(mirror, repository), reference)
+	case "blob", "blob-check":
+		repository, digest := h.splitPath(apiPath, "/blobs/")
+		return h.handleBlob(c, mirror, h.normalizeRepository(mirror, repository), digest)
+	case "other":
+		resp, err := h.proxy.ProxyRequestWithMethod(mirror, c.Request.Method, path, c.Request.Header)
+		if err != nil {
+			return fmt.Errorf("代理请求失败: %w", err)
+		}
+		defer resp.Body.Close()
+		return copyResponse(c, resp)
+	}
+
+	// 标签列表、目录等其他接口直接转发到上游
+	scope := ""
+	if requestType == "tags-list" {
+		repository := h.normalizeRepository(mirror, strings.TrimSuffix(apiPath, "/tags/list"))
+		apiPath = repository + "/tags/list"
+		scope = h.pullScope(repository)
+	} else if requestType == "catalog" {
+		scope = "registry:catalog:*"
+	}
+	if c.Request.URL.RawQuery != "" {
+		apiPath += "?" + c.Request.URL.RawQuery
+	}
+
+	resp, err := h.fetchUpstream(mirror, c.Request.Method, apiPath, c.Request.Header, scope)
 	if err != nil {
-		fmt.Printf("[ERROR] 代理请求失败: %v\n", err)
-		return fmt.Errorf("代理请求失败: %v", err)
+		return fmt.Errorf("代理请求失败: %w", err)
 	}
 	defer resp.Body.Close()
 
-	// 复制响应头和状态码
-	for key, values := range resp.Header {
-		for _, value := range values {
-			c.Header(key, value)
+	return copyResponse(c, resp)
+}
+
+// handleManifest 处理清单请求
+// 按 digest 引用的清单永久有效；按标签引用的清单遵循缓存时间
+func (h *DockerHandler) handleManifest(c *gin.Context, mirror *models.Mirror, repository, reference string) error {
+	log := logger.GetLogger()
+
+	scope := h.pullScope(repository)
+	upstreamPath := repository + "/manifests/" + reference
+	isDigest := dockerDigestPattern.MatchString(reference)
+
+	var tagFile models.DockerFile
+	if isDigest {
+		if file, ok := h.findByDigest(mirror, reference, models.DockerFileTypeManifest); ok {
+			return h.serveCachedFile(c, mirror, file)
 		}
+	} else {
+		result := database.DB.Where(&models.DockerFile{
+			MirrorID:   mirror.ID,
+			Repository: repository,
+			Reference:  reference,
+			FileType:   models.DockerFileTypeManifest,
+		}).First(&tagFile)
+		if result.Error == nil {
+			if file, ok := h.findByDigest(mirror, tagFile.Digest, models.DockerFileTypeManifest); ok {
+				if !isCacheExpired(tagFile.DownloadedAt, mirror.CacheTime) {
+					h.touch(&tagFile)
+					return h.serveCachedFile(c, mirror, file)
+				}
+
+				// 标签已过期，先用 HEAD 请求确认 digest 是否变化（HEAD 请求不计入 Docker Hub 的拉取次数）
+				digest, unavailable, err := h.upstreamDigest(mirror, upstreamPath, c.Request.Header, scope)
+				if unavailable && canServeStale(mirror, tagFile.DownloadedAt) {
+					log.Warn("上游不可用，使用过期的标签",
+						zap.Error(err),
+						zap.String("repository", repository),
+						zap.String("tag", reference),
+					)
+					return h.serveCachedFile(c, mirror, file)
+				}
+				if err == nil && digest == tagFile.Digest {
+					log.Debug("标签未变化，继续使用缓存",
+						zap.String("repository", repository),
+						zap.String("tag", reference),
+					)
+					database.DB.Model(&tagFile).Updates(map[string]interface{}{
+						"downloaded_at":  time.Now(),
+						"last_used_time": time.Now(),
+					})
+					return h.serveCachedFile(c, mirror, file)
+				}
+			}
+		} else if result.Error != gorm.ErrRecordNotFound {
+			return fmt.Errorf("查询缓存文件失败: %v", result.Error)
+		}
+	}
+
+	// 未命中的 HEAD 请求直接转发，不缓存
+	if c.Request.Method == http.MethodHead {
+		resp, err := h.fetchUpstream(mirror, http.MethodHead, upstreamPath, c.Request.Header, scope)
+		if err != nil {
+			return fmt.Errorf("代理请求失败: %w", err)
+		}
+		defer resp.Body.Close()
+		return copyResponse(c, resp)
+	}
+
+	resp, err := h.fetchUpstream(mirror, http.MethodGet, upstreamPath, cacheHeaders(c.Request.Header), scope)
+	if err != nil {
+		return fmt.Errorf("代理请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return copyResponse(c, resp)
 	}
-	c.Status(resp.StatusCode)
 
-	// 复制响应体
-	written, err := io.Copy(c.Writer, resp.Body)
+	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		fmt.Printf("[ERROR] 复制响应失败: %v\n", err)
-		return fmt.Errorf("复制响应失败: %v", err)
+		return fmt.Errorf("读取响应体失败: %v", err)
 	}
 
-	fmt.Printf("[INFO] 完成请求: %s (状态码: %d, 大小: %d bytes)\n",
-		path, resp.StatusCode, written)
+	hash := sha256.Sum256(bodyBytes)
+	digest := "sha256:" + hex.EncodeToString(hash[:])
+	if isDigest && digest != reference {
+		return fmt.Errorf("清单 digest 校验失败: 期望 %s, 实际 %s", reference, digest)
+	}
+
+	mediaType := resp.Header.Get("Content-Type")
+	file, err := h.saveManifest(mirror, repository, digest, mediaType, bodyBytes)
+	if err != nil {
+		log.Error("保存清单失败", zap.Error(err), zap.String("digest", digest))
+	} else if !isDigest {
+		// 更新标签到 digest 的引用记录
+		now := time.Now()
+		if tagFile.ID == 0 {
+			tagFile = models.DockerFile{
+				MirrorID:   mirror.ID,
+				Repository: repository,
+				Reference:  reference,
+				FileType:   models.DockerFileTypeManifest,
+			}
+		}
+		tagFile.Digest = digest
+		tagFile.MediaType = mediaType
+		tagFile.SavePath = file.SavePath
+		tagFile.DownloadedAt = now
+		tagFile.LastUsedTime = now
+		if err := database.DB.Save(&tagFile).Error; err != nil {
+			log.Error("保存标签记录失败", zap.Error(err))
+		}
+	}
 
+	c.Header("Docker-Content-Digest", digest)
+	c.Header("Content-Type", mediaType)
+	c.Header("Content-Length", fmt.Sprintf("%d", len(bodyBytes)))
+	c.Status(http.StatusOK)
+	if _, err := c.Writer.Write(bodyBytes); err != nil {
+		return fmt.Errorf("写入响应失败: %v", err)
+	}
 	return nil
 }
 
-// getRequestType 判断Docker请求的类型
+// saveManifest 按 digest 保存清单
+func (h *DockerHandler) saveManifest(mirror *models.Mirror, repository, digest, mediaType string, bodyBytes []byte) (*models.DockerFile, error) {
+	if file, ok := h.findByDigest(mirror, digest, models.DockerFileTypeManifest); ok {
+		return file, nil
+	}
+
+	savePath := h.contentPath(mirror, "manifests", digest)
+	if _, _, err := saveToFile(mirror, savePath, bytes.NewReader(bodyBytes)); err != nil {
+		return nil, fmt.Errorf("保存文件失败: %v", err)
+	}
+
+	now := time.Now()
+	file := &models.DockerFile{
+		MirrorID:     mirror.ID,
+		Repository:   repository,
+		Reference:    digest,
+		Digest:       digest,
+		FileType:     models.DockerFileTypeManifest,
+		MediaType:    mediaType,
+		FileSize:     int64(len(bodyBytes)),
+		SavePath:     savePath,
+		DownloadedAt: now,
+		LastUsedTime: now,
+	}
+	if err := database.DB.Create(file).Error; err != nil {
+		return nil, fmt.Errorf("保存文件记录失败: %v", err)
+	}
+	return file, nil
+}
+
+// handleBlob 处理镜像层请求，按 digest 内容寻址存储
+func (h *DockerHandler) handleBlob(c *gin.Context, mirror *models.Mirror, repository, digest string) error {
+	log := logger.GetLogger()
+
+	scope := h.pullScope(repository)
+	upstreamPath := repository + "/blobs/" + digest
+
+	if !dockerDigestPattern.MatchString(digest) {
+		// 非 sha256 的 digest 不缓存
+		resp, err := h.fetchUpstream(mirror, c.Request.Method, upstreamPath, c.Request.Header, scope)
+		if err != nil {
+			return fmt.Errorf("代理请求失败: %w", err)
+		}
+		defer resp.Body.Close()
+		return copyResponse(c, resp)
+	}
+
+	if file, ok := h.findByDigest(mirror, digest, models.DockerFileTypeBlob); ok {
+		return h.serveCachedFile(c, mirror, file)
+	}
+
+	if c.Request.Method == http.MethodHead {
+		resp, err := h.fetchUpstream(mirror, http.MethodHead, upstreamPath, c.Request.Header, scope)
+		if err != nil {
+			return fmt.Errorf("代理请求失败: %w", err)
+		}
+		defer resp.Body.Close()
+		return copyResponse(c, resp)
+	}
+
+	// 边下载边返回给客户端，同一镜像层的并发请求共用一次拉取，写入完成后校验 digest
+	// Docker Hub 的镜像层会重定向到 CDN，CDN 的响应中没有 Docker-Content-Digest，需要自己设置
+	c.Header("Docker-Content-Digest", digest)
+	savePath := h.contentPath(mirror, "blobs", digest)
+	return streamDownload(c, mirror, savePath,
+		func() (*http.Response, error) {
+			return h.fetchUpstream(mirror, http.MethodGet, upstreamPath, cacheHeaders(c.Request.Header), scope)
+		},
+		verifySha256(strings.TrimPrefix(digest, "sha256:")),
+		func(header http.Header, size int64, sum string) error {
+			now := time.Now()
+			file := &models.DockerFile{
+				MirrorID:     mirror.ID,
+				Repository:   repository,
+				Reference:    digest,
+				Digest:       digest,
+				FileType:     models.DockerFileTypeBlob,
+				MediaType:    "application/octet-stream",
+				FileSize:     size,
+				SavePath:     savePath,
+				DownloadedAt: now,
+				LastUsedTime: now,
+			}
+			if err := database.DB.Create(file).Error; err != nil {
+				return fmt.Errorf("保存文件记录失败: %v", err)
+			}
+
+			log.Debug("镜像层已缓存",
+				zap.String("repository", repository),
+				zap.String("digest", digest),
+				zap.Int64("size", size),
+			)
+			return nil
+		},
+	)
+}
+
+// findByDigest 按 digest 查找已缓存的文件
+func (h *DockerHandler) findByDigest(mirror *models.Mirror, digest string, fileType models.DockerFileType) (*models.DockerFile, bool) {
+	var file models.DockerFile
+	err := database.DB.Where("mirror_id = ? AND digest = ? AND reference = ? AND file_type = ?",
+		mirror.ID, digest, digest, fileType).First(&file).Error
+	if err != nil {
+		return nil, false
+	}
+
+	if !cachedFileExists(mirror, file.SavePath) {
+		// 文件已丢失，删除记录后重新拉取
+		logger.GetLogger().Warn("缓存文件不存在，重新拉取", zap.String("path", file.SavePath))
+		database.DB.Delete(&file)
+		return nil, false
+	}
+	return &file, true
+}
+
+// serveCachedFile 从缓存提供文件
+func (h *DockerHandler) serveCachedFile(c *gin.Context, mirror *models.Mirror, file *models.DockerFile) error {
+	log := logger.GetLogger()
+
+	log.Debug("命中缓存",
+		zap.String("repository", file.Repository),
+		zap.String("digest", file.Digest),
+		zap.String("mirror", mirror.Name),
+	)
+
+	h.touch(file)
+	if err := updateMirrorCounts(mirror, true); err != nil {
+		log.Error("更新缓存命中计数失败", zap.Error(err))
+	}
+
+	c.Header("Docker-Content-Digest", file.Digest)
+	c.Header("ETag", `"`+file.Digest+`"`)
+	return serveLocalFile(c, mirror, file.SavePath, file.MediaType)
+}
+
+// touch 更新文件的最后使用时间
+func (h *DockerHandler) touch(file *models.DockerFile) {
+	if err := database.DB.Model(file).Update("last_used_time", time.Now()).Error; err != nil {
+		logger.GetLogger().Error("更新文件使用时间失败", zap.Error(err))
+	}
+}
+
+// upstreamDigest 通过 HEAD 请求获取上游标签当前指向的 digest
+// unavailable 表示上游不可用(请求失败或返回 5xx)，调用方可以据此决定是否使用过期的缓存
+func (h *DockerHandler) upstreamDigest(mirror *models.Mirror, upstreamPath string, headers http.Header, scope string) (digest string, unavailable bool, err error) {
+	resp, err := h.fetchUpstream(mirror, http.MethodHead, upstreamPath, cacheHeaders(headers), scope)
+	if upstreamFailed(resp, err) {
+		if err == nil {
+			resp.Body.Close()
+			err = fmt.Errorf("上游返回状态码 %d", resp.StatusCode)
+		}
+		return "", true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", false, fmt.Errorf("上游返回状态码 %d", resp.StatusCode)
+	}
+	return resp.Header.Get("Docker-Content-Digest"), false, nil
+}
+
+// fetchUpstream 请求上游仓库，并在需要时完成 Bearer 令牌鉴权
+// 令牌按签发它的上游分别缓存，只发送给该上游，主上游和备用上游互不影响
+func (h *DockerHandler) fetchUpstream(mirror *models.Mirror, method, apiPath string, headers http.Header, scope string) (*http.Response, error) {
+	headers = headers.Clone()
+	headers.Del("Authorization")
+
+	// 上游按顺序依次尝试，最后一次生成请求头的上游就是返回响应的上游
+	upstreamURL := ""
+	headersFor := func(rawURL string) http.Header {
+		upstreamURL = rawURL
+		token := h.cachedToken(mirror, upstreamOrigin(rawURL), scope)
+		if token == "" {
+			return headers
+		}
+		withToken := headers.Clone()
+		withToken.Set("Authorization", "Bearer "+token)
+		return withToken
+	}
+
+	resp, err := h.proxy.ProxyRequestWithHeaders(mirror, method, "v2/"+apiPath, headersFor)
+	if err != nil {
+		return nil, err
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	if resp.StatusCode != http.StatusUnauthorized || !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	if _, err := h.requestToken(mirror, upstreamURL, challenge, scope); err != nil {
+		return nil, fmt.Errorf("获取上游访问令牌失败: %w", err)
+	}
+
+	return h.proxy.ProxyRequestWithHeaders(mirror, method, "v2/"+apiPath, headersFor)
+}
+
+// requestToken 按 WWW-Authenticate 的要求向鉴权服务申请令牌，upstreamURL 为返回该鉴权要求的上游地址
+func (h *DockerHandler) requestToken(mirror *models.Mirror, upstreamURL, challenge, scope string) (string, error) {
+	params := make(map[string]string)
+	for _, match := range dockerChallengeParam.FindAllStringSubmatch(challenge, -1) {
+		params[strings.ToLower(match[1])] = match[2]
+	}
+
+	realm := params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("无效的鉴权信息: %s", challenge)
+	}
+
+	query := url.Values{}
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	if params["scope"] != "" {
+		query.Set("scope", params["scope"])
+	} else if scope != "" {
+		query.Set("scope", scope)
+	}
+
+	tokenURL := realm
+	if len(query) > 0 {
+		tokenURL += "?" + query.Encode()
+	}
+
+	// 鉴权服务通常与仓库不在同一主机，配置了用户名密码时需要显式带上
+	// 只有主上游返回的鉴权要求才带凭据，备用上游指定的鉴权服务属于第三方，不能收到主上游的账号
+	headers := http.Header{}
+	if mirror.UpstreamAuthType == models.UpstreamAuthBasic && proxy.SameHost(mirror.UpstreamURL, upstreamURL) {
+		name, value := mirror.UpstreamAuthHeader()
+		headers.Set(name, value)
+	}
+
+	resp, err := h.proxy.Do(mirror, http.MethodGet, tokenURL, headers)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("鉴权服务返回状态码 %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("解析令牌失败: %v", err)
+	}
+
+	token := result.Token
+	if token == "" {
+		token = result.AccessToken
+	}
+	if token == "" {
+		return "", fmt.Errorf("鉴权服务未返回令牌")
+	}
+
+	// 规范规定未返回有效期时按 60 秒处理，提前 10 秒过期避免边界问题
+	expiresIn := result.ExpiresIn
+	if expiresIn < 60 {
+		expiresIn = 60
+	}
+
+	h.mu.Lock()
+	h.tokens[h.tokenKey(mirror, upstreamOrigin(upstreamURL), scope)] = dockerToken{
+		value:     token,
+		expiresAt: time.Now().Add(time.Duration(expiresIn-10) * time.Second),
+	}
+	h.mu.Unlock()
+
+	return token, nil
+}
+
+// cachedToken 获取上游未过期的令牌
+func (h *DockerHandler) cachedToken(mirror *models.Mirror, origin, scope string) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := h.tokenKey(mirror, origin, scope)
+	token, ok := h.tokens[key]
+	if !ok {
+		return ""
+	}
+	if time.Now().After(token.expiresAt) {
+		delete(h.tokens, key)
+		return ""
+	}
+	return token.value
+}
+
+func (h *DockerHandler) tokenKey(mirror *models.Mirror, origin, scope string) string {
+	return fmt.Sprintf("%d|%s|%s", mirror.ID, origin, scope)
+}
+
+// upstreamOrigin 返回上游地址的协议和主机，作为令牌缓存的键
+func upstreamOrigin(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Scheme + "://" + strings.ToLower(u.Host)
+}
+
+func (h *DockerHandler) pullScope(repository string) string {
+	return "repository:" + repository + ":pull"
+}
+
+// normalizeRepository Docker Hub 的官方镜像需要加上 library/ 前缀
+func (h *DockerHandler) normalizeRepository(mirror *models.Mirror, repository string) string {
+	if strings.Contains(repository, "/") {
+		return repository
+	}
+	upstream, err := url.Parse(mirror.UpstreamURL)
+	if err != nil {
+		return repository
+	}
+	switch upstream.Host {
+	case "registry-1.docker.io", "index.docker.io", "docker.io":
+		return "library/" + repository
+	}
+	return repository
+}
+
+// contentPath 返回按 digest 存储的文件路径
+func (h *DockerHandler) contentPath(mirror *models.Mirror, kind, digest string) string {
+	hexDigest := strings.TrimPrefix(digest, "sha256:")
+	return filepath.Join(mirror.BlobPath, kind, "sha256", hexDigest[:2], hexDigest)
+}
+
+// apiPath 去掉 v2 前缀，返回 Registry API 内的路径
+func (h *DockerHandler) apiPath(path string) (string, bool) {
+	if path == "v2" {
+		return "", true
+	}
+	if strings.HasPrefix(path, "v2/") {
+		return strings.TrimPrefix(path, "v2/"), true
+	}
+	return path, false
+}
+
+// splitPath 按分隔符拆分出仓库名和引用
+func (h *DockerHandler) splitPath(apiPath, marker string) (string, string) {
+	idx := strings.LastIndex(apiPath, marker)
+	if idx <= 0 {
+		return apiPath, ""
+	}
+	return apiPath[:idx], apiPath[idx+len(marker):]
+}
+
+// writeError 按 Registry API 的格式返回错误
+func (h *DockerHandler) writeError(c *gin.Context, status int, code, message string) {
+	c.JSON(status, gin.H{
+		"errors": []gin.H{{"code": code, "message": message}},
+	})
+}
+
+// getRequestType 判断Docker请求的类型，path 为去掉 v2 前缀后的路径
 func (h *DockerHandler) getRequestType(path string, method string) string {
 	switch {
-	case strings.Contains(path, "/v2/_catalog"):
+	case path == "" || path == "_ping":
+		return "ping"
+	case path == "_catalog":
 		return "catalog"
-	case strings.Contains(path, "/v2/") && strings.HasSuffix(path, "/tags/list"):
+	case strings.HasSuffix(path, "/tags/list"):
 		return "tags-list"
-	case strings.Contains(path, "/v2/") && strings.Contains(path, "/manifests/"):
+	case strings.Contains(path, "/manifests/"):
 		if method == "HEAD" {
 			return "manifest-check"
 		}
 		return "manifest"
-	case strings.Contains(path, "/v2/") && strings.Contains(path, "/blobs/"):
+	case strings.Contains(path, "/blobs/uploads"):
+		return "upload"
+	case strings.Contains(path, "/blobs/"):
 		if method == "HEAD" {
 			return "blob-check"
 		}
 		return "blob"
-	case strings.Contains(path, "/v2/") && strings.Contains(path, "/uploads/"):
-		return "upload"
-	case strings.Contains(path, "/v2/_ping"):
-		return "ping"
-	case strings.Contains(path, "/v2/"):
-		return "v2-api"
 	default:
-		return "other"
+		return "v2-api"
 	}
 }
@@ -0,0 +1,284 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"easyCacheMirror/internal/models"
+)
+
+// fakeRegistry 模拟需要 Bearer 令牌的 Docker 仓库，只接受 token 签发的令牌
+type fakeRegistry struct {
+	*httptest.Server
+	mu    sync.Mutex
+	auth  []string
+	token string
+	down  bool // 返回 502，让代理尝试下一个上游
+}
+
+func newFakeRegistry(t *testing.T, realm func() string, token string, down bool) *fakeRegistry {
+	t.Helper()
+	r := &fakeRegistry{token: token, down: down}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		r.auth = append(r.auth, req.Header.Get("Authorization"))
+		r.mu.Unlock()
+
+		switch {
+		case r.down:
+			w.WriteHeader(http.StatusBadGateway)
+		case req.Header.Get("Authorization") != "Bearer "+r.token:
+			w.Header().Set("WWW-Authenticate",
+				fmt.Sprintf(`Bearer realm="%s",service="registry",scope="repository:library/alpine:pull"`, realm()))
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *fakeRegistry) received() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.auth...)
+}
+
+// fakeTokenServer 模拟鉴权服务，记录收到的 Authorization 请求头
+type fakeTokenServer struct {
+	*httptest.Server
+	mu   sync.Mutex
+	auth []string
+}
+
+func newFakeTokenServer(t *testing.T, token string) *fakeTokenServer {
+	t.Helper()
+	s := &fakeTokenServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s.mu.Lock()
+		s.auth = append(s.auth, req.Header.Get("Authorization"))
+		s.mu.Unlock()
+		fmt.Fprintf(w, `{"token":%q,"expires_in":300}`, token)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fakeTokenServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.auth...)
+}
+
+func TestDockerUpstreamCredentials(t *testing.T) {
+	const basicAuth = "Basic dXNlcjpwYXNz" // user:pass
+
+	tests := []struct {
+		name        string
+		primaryDown bool
+		// 鉴权服务应收到的请求头
+		wantTokenAuth []string
+	}{
+		{"主上游的鉴权服务收到凭据", false, []string{basicAuth}},
+		{"备用上游的鉴权服务不收到凭据", true, []string{""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primaryToken := newFakeTokenServer(t, "primary-token")
+			fallbackToken := newFakeTokenServer(t, "fallback-token")
+			primary := newFakeRegistry(t, func() string { return primaryToken.URL }, "primary-token", tt.primaryDown)
+			fallback := newFakeRegistry(t, func() string { return fallbackToken.URL }, "fallback-token", false)
+
+			mirror := &models.Mirror{
+				ID:               1,
+				UpstreamURL:      primary.URL,
+				UpstreamAuthType: models.UpstreamAuthBasic,
+				UpstreamUsername: "user",
+				UpstreamSecret:   "pass",
+				Upstreams:        []models.MirrorUpstream{{URL: fallback.URL}},
+			}
+
+			h := NewDockerHandler()
+			scope := h.pullScope("library/alpine")
+			for i := 0; i < 2; i++ {
+				resp, err := h.fetchUpstream(mirror, http.MethodGet, "library/alpine/manifests/latest", http.Header{}, scope)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+				}
+			}
+
+			tokenServer := primaryToken
+			if tt.primaryDown {
+				tokenServer = fallbackToken
+			}
+			// 令牌缓存后第二次请求不再访问鉴权服务
+			if got := tokenServer.received(); !equalStrings(got, tt.wantTokenAuth) {
+				t.Errorf("鉴权服务收到 %q, want %q", got, tt.wantTokenAuth)
+			}
+
+			// 主上游可以收到配置的凭据，每个上游只收到自己签发的令牌
+			allowed := map[*fakeRegistry][]string{
+				primary:  {"", basicAuth, "Bearer primary-token"},
+				fallback: {"", "Bearer fallback-token"},
+			}
+			for registry, values := range allowed {
+				for _, auth := range registry.received() {
+					if !containsString(values, auth) {
+						t.Errorf("上游 %s 收到了不属于它的认证头 %q", registry.URL, auth)
+					}
+				}
+			}
+		})
+	}
+}
+
+func containsString(values []string, s string) bool {
+	for _, value := range values {
+		if value == s {
+			return true
+		}
+	}
+	return false
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// dockerUpstream 模拟不需要鉴权的 Docker 仓库，按 digest 提供清单和镜像层
+type dockerUpstream struct {
+	server   *httptest.Server
+	mu       sync.Mutex
+	manifest string
+	blobs    map[string]string
+	gets     atomic.Int32
+	heads    atomic.Int32
+}
+
+func newDockerUpstream(t *testing.T, manifest string, blobs ...string) *dockerUpstream {
+	t.Helper()
+	u := &dockerUpstream{manifest: manifest, blobs: make(map[string]string)}
+	for _, blob := range blobs {
+		u.blobs[sha256Digest(blob)] = blob
+	}
+	u.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			u.heads.Add(1)
+		} else {
+			u.gets.Add(1)
+		}
+		u.mu.Lock()
+		manifest := u.manifest
+		u.mu.Unlock()
+
+		var body string
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/manifests/latest"), strings.HasSuffix(r.URL.Path, "/manifests/"+sha256Digest(manifest)):
+			body = manifest
+			w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+		case strings.Contains(r.URL.Path, "/blobs/"):
+			blob, ok := u.blobs[r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			body = blob
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", sha256Digest(body))
+		if r.Method != http.MethodHead {
+			io.WriteString(w, body)
+		}
+	}))
+	t.Cleanup(u.server.Close)
+	return u
+}
+
+// setManifest 修改标签指向的清单
+func (u *dockerUpstream) setManifest(manifest string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.manifest = manifest
+}
+
+func sha256Digest(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func TestDockerCaching(t *testing.T) {
+	mirror := setupRegistryStore(t)
+	const manifestV1 = `{"schemaVersion":2,"tag":"v1"}`
+	const manifestV2 = `{"schemaVersion":2,"tag":"v2"}`
+	const layer = "layer content"
+	upstream := newDockerUpstream(t, manifestV1, layer)
+	// 缓存时间为 0，按标签引用的清单每次都需要确认 digest
+	mirror.UpstreamURL = upstream.server.URL
+	h := NewDockerHandler()
+
+	steps := []struct {
+		name      string
+		setup     func()
+		path      string
+		wantBody  string
+		wantGets  int32
+		wantHeads int32
+	}{
+		{"第一次获取标签的清单", nil, "v2/myorg/app/manifests/latest", manifestV1, 1, 0},
+		{"标签未变化时使用缓存", nil, "v2/myorg/app/manifests/latest", manifestV1, 0, 1},
+		{"按 digest 引用的清单使用缓存", nil, "v2/myorg/app/manifests/" + sha256Digest(manifestV1), manifestV1, 0, 0},
+		{"第一次获取镜像层", nil, "v2/myorg/app/blobs/" + sha256Digest(layer), layer, 1, 0},
+		{"再次获取镜像层使用缓存", nil, "v2/myorg/app/blobs/" + sha256Digest(layer), layer, 0, 0},
+		{
+			name:      "标签变化后重新拉取清单",
+			setup:     func() { upstream.setManifest(manifestV2) },
+			path:      "v2/myorg/app/manifests/latest",
+			wantBody:  manifestV2,
+			wantGets:  1,
+			wantHeads: 1,
+		},
+		{"旧的清单仍然可以按 digest 获取", nil, "v2/myorg/app/manifests/" + sha256Digest(manifestV1), manifestV1, 0, 0},
+	}
+	for _, st := range steps {
+		if st.setup != nil {
+			st.setup()
+		}
+		gets, heads := upstream.gets.Load(), upstream.heads.Load()
+		w := serveHandler(h, mirror, st.path, nil)
+		if w.Code != http.StatusOK || w.Body.String() != st.wantBody {
+			t.Fatalf("%s: status = %d, body = %q, want %q", st.name, w.Code, w.Body.String(), st.wantBody)
+		}
+		if got := w.Header().Get("Docker-Content-Digest"); got != sha256Digest(st.wantBody) {
+			t.Errorf("%s: Docker-Content-Digest = %s, want %s", st.name, got, sha256Digest(st.wantBody))
+		}
+		if got := upstream.gets.Load() - gets; got != st.wantGets {
+			t.Errorf("%s: 上游收到 %d 次 GET 请求, want %d", st.name, got, st.wantGets)
+		}
+		if got := upstream.heads.Load() - heads; got != st.wantHeads {
+			t.Errorf("%s: 上游收到 %d 次 HEAD 请求, want %d", st.name, got, st.wantHeads)
+		}
+	}
+}
@@ -0,0 +1,420 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/logger"
+	"easyCacheMirror/internal/models"
+	"easyCacheMirror/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// errNotCacheable 上游没有返回可缓存的内容，跟随的请求需要自己访问上游
+var errNotCacheable = errors.New("上游响应不可缓存")
+
+// errCached 等待期间其他实例已经把文件写入缓存，跟随的请求直接从缓存读取
+var errCached = errors.New("文件已由其他实例写入缓存")
+
+const (
+	// fetchLeaseTTL 拉取租约的有效时间，拉取期间自动续期
+	fetchLeaseTTL = 30 * time.Second
+
+	// fetchLeaseTimeout 等待其他实例拉取同一文件的最长时间，超时后直接访问上游且不写入缓存
+	fetchLeaseTimeout = time.Minute
+)
+
+// download 一次正在进行的上游拉取
+// 上游内容一边写入缓存一边发送给发起请求的客户端，同一文件的其他请求从写入中的内容跟随读取
+type download struct {
+	store *storage.Store
+	key   string
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	upload  *storage.Upload
+	header  http.Header // 上游响应头，开始写入缓存后设置
+	written int64       // 已写入缓存的字节数
+	held    int64       // 末尾等待校验的字节数，校验通过前不发送给客户端
+	moved   bool        // 写入已提交，可以直接从缓存读取
+	done    bool        // 拉取结束
+	err     error
+}
+
+// downloads 按镜像和缓存文件记录正在进行的拉取
+var (
+	downloadsMu sync.Mutex
+	downloads   = make(map[string]*download)
+)
+
+// streamDownload 从上游拉取文件，同时发送给客户端和写入缓存
+// 同一个 savePath 同时只有一个请求访问上游，其他请求等待并跟随读取已写入的内容。
+// 多个实例共用数据库和存储时，通过数据库中的租约保证只有一个实例访问上游，其他实例等待拉取完成后从缓存读取。
+// verify 在文件完整写入后、提交之前调用，返回错误时丢弃文件，可以为空。
+// 有 verify 时最后一块内容在校验通过后才发送，校验失败时断开连接，客户端不会收到看起来完整的错误内容；
+// save 在文件提交到缓存后调用，用于写入数据库记录，此时其他请求仍在等待，不会重复拉取。
+// 上游返回非 200 时原样转发，不写入缓存
+func streamDownload(
+	c *gin.Context,
+	mirror *models.Mirror,
+	savePath string,
+	fetch func() (*http.Response, error),
+	verify func(r io.Reader) error,
+	save func(header http.Header, size int64, sum string) error,
+) error {
+	downloadKey := fmt.Sprintf("%d:%s", mirror.ID, savePath)
+
+	downloadsMu.Lock()
+	if d, ok := downloads[downloadKey]; ok {
+		downloadsMu.Unlock()
+		err := d.follow(c)
+		if errors.Is(err, errCached) {
+			return serveStored(c, mirror, savePath)
+		}
+		if !errors.Is(err, errNotCacheable) {
+			return err
+		}
+
+		// 拉取失败或上游没有返回可缓存的内容，单独访问上游
+		return fetchUncached(c, fetch)
+	}
+
+	d := &download{
+		store: storage.ForMirror(mirror),
+		key:   blobKey(mirror, savePath),
+	}
+	d.cond = sync.NewCond(&d.mu)
+	downloads[downloadKey] = d
+	downloadsMu.Unlock()
+
+	defer func() {
+		downloadsMu.Lock()
+		delete(downloads, downloadKey)
+		downloadsMu.Unlock()
+	}()
+
+	started := time.Now()
+	lease, err := database.AcquireLease("fetch:"+downloadKey, fetchLeaseTTL, fetchLeaseTimeout)
+	if err != nil {
+		d.finish(errNotCacheable)
+		logger.GetLogger().Warn("等待其他实例拉取超时，直接访问上游",
+			zap.Error(err),
+			zap.String("key", d.key),
+		)
+		return fetchUncached(c, fetch)
+	}
+	defer lease.Release()
+
+	// 等待期间其他实例已经拉取完成，直接使用其写入的缓存
+	if info, err := d.store.Stat(d.key); err == nil && !info.ModTime.Before(started) {
+		d.finish(errCached)
+		return serveStored(c, mirror, savePath)
+	}
+
+	return d.lead(c, fetch, verify, save)
+}
+
+// fetchUncached 单独访问上游并原样转发，不写入缓存
+func fetchUncached(c *gin.Context, fetch func() (*http.Response, error)) error {
+	resp, err := fetch()
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return copyResponse(c, resp)
+}
+
+// serveStored 从缓存提供其他实例刚拉取的文件，内容类型使用写入时记录的值
+func serveStored(c *gin.Context, mirror *models.Mirror, savePath string) error {
+	info, err := storage.ForMirror(mirror).Stat(blobKey(mirror, savePath))
+	if err != nil {
+		return fmt.Errorf("读取缓存文件失败: %v", err)
+	}
+	return serveLocalFile(c, mirror, savePath, info.ContentType)
+}
+
+// lead 访问上游并写入缓存和客户端
+func (d *download) lead(
+	c *gin.Context,
+	fetch func() (*http.Response, error),
+	verify func(r io.Reader) error,
+	save func(header http.Header, size int64, sum string) error,
+) error {
+	log := logger.GetLogger()
+
+	resp, err := fetch()
+	if err != nil {
+		d.finish(errNotCacheable)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		d.finish(errNotCacheable)
+		return copyResponse(c, resp)
+	}
+
+	upload, err := d.store.Create(d.key)
+	if err != nil {
+		d.finish(errNotCacheable)
+		return err
+	}
+	upload.SetContentType(resp.Header.Get("Content-Type"))
+
+	d.mu.Lock()
+	d.upload = upload
+	d.header = resp.Header.Clone()
+	d.mu.Unlock()
+	d.cond.Broadcast()
+
+	writeUpstreamHeaders(c, d.header)
+	c.Status(http.StatusOK)
+
+	// 客户端断开后继续拉取，保证其他请求和后续访问可以使用缓存
+	clientGone := c.Request.Method == http.MethodHead
+	send := func(p []byte) {
+		if clientGone || len(p) == 0 {
+			return
+		}
+		if _, err := c.Writer.Write(p); err != nil {
+			clientGone = true
+			log.Debug("客户端已断开，继续拉取到缓存", zap.String("key", d.key))
+			return
+		}
+		c.Writer.Flush()
+	}
+
+	// 需要校验时保留最后一块，校验通过后再发送
+	hold := verify != nil
+	var pending []byte
+	size, err := d.copy(upload, resp.Body, hold, func(p []byte) {
+		if !hold {
+			send(p)
+			return
+		}
+		send(pending)
+		pending = append(pending[:0], p...)
+	})
+	if err == nil && verify != nil {
+		err = verifyUpload(upload, size, verify)
+	}
+	if err != nil {
+		upload.Abort()
+		d.finish(err)
+		log.Error("拉取上游文件失败", zap.Error(err), zap.String("key", d.key))
+		if !clientGone {
+			abortResponse()
+		}
+		return nil
+	}
+
+	d.mu.Lock()
+	d.held = 0
+	d.mu.Unlock()
+	d.cond.Broadcast()
+	send(pending)
+
+	// 提交时持有锁，跟随的请求按 moved 判断应该从哪里读取
+	d.mu.Lock()
+	entry, err := upload.Commit()
+	if err != nil {
+		d.mu.Unlock()
+		d.finish(err)
+		log.Error("保存缓存文件失败", zap.Error(err), zap.String("key", d.key))
+		return nil
+	}
+	d.moved = true
+	d.mu.Unlock()
+
+	if err := save(d.header, entry.Size, entry.Sha256); err != nil {
+		log.Error("保存文件记录失败", zap.Error(err), zap.String("key", d.key))
+	}
+	d.finish(nil)
+	return nil
+}
+
+// prefetched 返回第一次调用时使用已经拿到的上游响应、之后重新请求上游的 fetch 函数
+// 用于调用方需要先检查上游响应(例如判断是否使用过期的缓存)再交给 streamDownload 的情况
+func prefetched(resp *http.Response, fetch func() (*http.Response, error)) func() (*http.Response, error) {
+	used := false
+	return func() (*http.Response, error) {
+		if used {
+			return fetch()
+		}
+		used = true
+		return resp, nil
+	}
+}
+
+// abortResponse 中断已经发出响应头的响应
+// 此时无法再返回错误状态，断开连接让客户端知道响应不完整，而不是把已收到的部分当作完整的文件，
+// 需要 gin 的 Recovery 将 http.ErrAbortHandler 交给 net/http 处理，见 middleware.Recovery
+func abortResponse() {
+	panic(http.ErrAbortHandler)
+}
+
+// verifyUpload 读取已写入的完整内容进行校验
+func verifyUpload(upload *storage.Upload, size int64, verify func(r io.Reader) error) error {
+	reader, err := upload.Reader()
+	if err != nil {
+		return fmt.Errorf("读取缓存文件失败: %v", err)
+	}
+	defer reader.Close()
+	return verify(io.NewSectionReader(reader, 0, size))
+}
+
+// verifySha256 返回校验内容 sha256 的 verify 函数，expected 为十六进制摘要
+func verifySha256(expected string) func(r io.Reader) error {
+	return func(r io.Reader) error {
+		hash := sha256.New()
+		if _, err := io.Copy(hash, r); err != nil {
+			return fmt.Errorf("读取缓存文件失败: %v", err)
+		}
+		if actual := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(actual, expected) {
+			return fmt.Errorf("sha256 校验失败: 期望 %s, 实际 %s", expected, actual)
+		}
+		return nil
+	}
+}
+
+// copy 将上游内容写入缓存，每写入一块就通知跟随的请求
+// hold 为 true 时最后写入的一块记为等待校验，跟随的请求在校验通过前不会发送
+func (d *download) copy(w io.Writer, body io.Reader, hold bool, onChunk func([]byte)) (int64, error) {
+	buf := make([]byte, 32*1024)
+	var size int64
+	for {
+		n, readErr := body.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return size, fmt.Errorf("写入缓存文件失败: %v", err)
+			}
+			size += int64(n)
+
+			d.mu.Lock()
+			d.written = size
+			if hold {
+				d.held = int64(n)
+			}
+			d.mu.Unlock()
+			d.cond.Broadcast()
+
+			onChunk(buf[:n])
+		}
+		if readErr == io.EOF {
+			return size, nil
+		}
+		if readErr != nil {
+			return size, fmt.Errorf("读取上游响应失败: %v", readErr)
+		}
+	}
+}
+
+// finish 结束拉取并唤醒所有跟随的请求
+func (d *download) finish(err error) {
+	d.mu.Lock()
+	d.done = true
+	d.err = err
+	d.mu.Unlock()
+	d.cond.Broadcast()
+}
+
+// follow 跟随正在进行的拉取，读取已写入的内容发送给客户端
+// 拉取在开始写入前就失败时返回 errNotCacheable，文件已由其他实例写入缓存时返回 errCached
+func (d *download) follow(c *gin.Context) error {
+	d.mu.Lock()
+	for d.header == nil && !d.done {
+		d.cond.Wait()
+	}
+	if d.header == nil {
+		err := d.err
+		d.mu.Unlock()
+		if errors.Is(err, errCached) {
+			return errCached
+		}
+		return errNotCacheable
+	}
+	if d.done && d.err != nil {
+		d.mu.Unlock()
+		return errNotCacheable
+	}
+
+	var file storage.ReadAtCloser
+	var err error
+	if d.moved {
+		file, _, err = d.store.Get(d.key)
+	} else {
+		file, err = d.upload.Reader()
+	}
+	header := d.header
+	d.mu.Unlock()
+	if err != nil {
+		return errNotCacheable
+	}
+	defer file.Close()
+
+	logger.GetLogger().Debug("等待同一文件的上游拉取", zap.String("key", d.key))
+
+	writeUpstreamHeaders(c, header)
+	c.Status(http.StatusOK)
+	if c.Request.Method == http.MethodHead {
+		return nil
+	}
+
+	buf := make([]byte, 32*1024)
+	var offset int64
+	for {
+		d.mu.Lock()
+		for offset >= d.written-d.held && !d.done {
+			d.cond.Wait()
+		}
+		readable, done, downloadErr := d.written-d.held, d.done, d.err
+		d.mu.Unlock()
+
+		if done && downloadErr != nil {
+			logger.GetLogger().Error("跟随的上游拉取失败",
+				zap.Error(downloadErr),
+				zap.String("key", d.key),
+			)
+			abortResponse()
+		}
+
+		for offset < readable {
+			n, err := file.ReadAt(buf[:min(int64(len(buf)), readable-offset)], offset)
+			if n > 0 {
+				if _, err := c.Writer.Write(buf[:n]); err != nil {
+					// 客户端已断开
+					return nil
+				}
+				offset += int64(n)
+			}
+			if err != nil && (err != io.EOF || n == 0) {
+				logger.GetLogger().Error("读取缓存文件失败", zap.Error(err), zap.String("key", d.key))
+				abortResponse()
+			}
+		}
+		c.Writer.Flush()
+
+		if done {
+			return nil
+		}
+	}
+}
+
+// writeUpstreamHeaders 复制上游的响应头
+func writeUpstreamHeaders(c *gin.Context, header http.Header) {
+	for key, values := range header {
+		for _, value := range values {
+			c.Header(key, value)
+		}
+	}
+}
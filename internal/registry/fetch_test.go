@@ -0,0 +1,389 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"easyCacheMirror/internal/auth"
+	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/models"
+	"easyCacheMirror/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setupRegistryStore 使用临时目录中的数据库和内容寻址存储，返回一个本地存储的镜像
+func setupRegistryStore(t *testing.T) *models.Mirror {
+	t.Helper()
+	database.UseTestDB(t)
+
+	previous := storage.CASDir
+	storage.CASDir = filepath.Join(t.TempDir(), "cas")
+	t.Cleanup(func() { storage.CASDir = previous })
+
+	mirror := &models.Mirror{Name: "test", BlobPath: filepath.Join(t.TempDir(), "blobs")}
+	if err := database.DB.Create(mirror).Error; err != nil {
+		t.Fatal(err)
+	}
+	return mirror
+}
+
+// countingUpstream 返回固定内容的上游，记录请求次数
+func countingUpstream(t *testing.T, body string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+// runStreamDownload 以 GET 请求调用 streamDownload，返回响应
+func runStreamDownload(mirror *models.Mirror, savePath, upstream string, verify func(io.Reader) error) (*httptest.ResponseRecorder, error) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/file", nil)
+	err := streamDownload(c, mirror, savePath,
+		func() (*http.Response, error) { return http.Get(upstream) },
+		verify,
+		func(http.Header, int64, string) error { return nil },
+	)
+	return w, err
+}
+
+// staticUpstream 按路径返回固定内容的上游，status 不为 0 时所有请求返回该状态码
+type staticUpstream struct {
+	server   *httptest.Server
+	mu       sync.Mutex
+	files    map[string]string
+	status   atomic.Int32
+	requests atomic.Int32
+}
+
+func newStaticUpstream(t *testing.T, files map[string]string) *staticUpstream {
+	t.Helper()
+	u := &staticUpstream{files: files}
+	u.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u.requests.Add(1)
+		if status := u.status.Load(); status != 0 {
+			w.WriteHeader(int(status))
+			return
+		}
+		u.mu.Lock()
+		body, ok := u.files[strings.TrimPrefix(r.URL.Path, "/")]
+		u.mu.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(u.server.Close)
+	return u
+}
+
+// set 修改上游的文件内容
+func (u *staticUpstream) set(path, body string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.files[path] = body
+}
+
+// serveHandler 以 GET 请求调用处理器，返回响应
+func serveHandler(h Handler, mirror *models.Mirror, path string, header http.Header) *httptest.ResponseRecorder {
+	return sendRequest(h, mirror, http.MethodGet, path, nil, nil, header)
+}
+
+// sendRequest 以指定的方法、用户和请求体调用处理器，返回响应，user 为 nil 时表示未认证
+func sendRequest(h Handler, mirror *models.Mirror, method, path string, user *models.User, body io.Reader, header http.Header) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(method, "/"+path, body)
+	for key, values := range header {
+		c.Request.Header[key] = values
+	}
+	if user != nil {
+		auth.SetCurrentUser(c, user)
+	}
+	if err := h.Handle(c, mirror, path); err != nil && !c.Writer.Written() {
+		c.String(http.StatusInternalServerError, err.Error())
+	}
+	c.Writer.WriteHeaderNow()
+	return w
+}
+
+// serveAbortable 调用 serveHandler，记录是否通过 http.ErrAbortHandler 中断了响应
+func serveAbortable(h Handler, mirror *models.Mirror, path string) (w *httptest.ResponseRecorder, aborted bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if r != http.ErrAbortHandler {
+				panic(r)
+			}
+			aborted = true
+		}
+	}()
+	return serveHandler(h, mirror, path, nil), false
+}
+
+func TestStreamDownloadAcrossInstances(t *testing.T) {
+	tests := []struct {
+		name string
+		// otherCaches 持有租约的其他实例是否在释放前写入了缓存
+		otherCaches  bool
+		wantBody     string
+		wantType     string
+		wantUpstream int32
+	}{
+		{"其他实例已写入缓存", true, "from other instance", "application/java-archive", 0},
+		{"其他实例拉取失败", false, "from upstream", "application/octet-stream", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mirror := setupRegistryStore(t)
+			upstream, requests := countingUpstream(t, "from upstream")
+			savePath := filepath.Join(mirror.BlobPath, "lib.jar")
+
+			// 模拟另一个实例正在拉取同一文件
+			lease, err := database.TryAcquireLease(fmt.Sprintf("fetch:%d:%s", mirror.ID, savePath), time.Minute)
+			if err != nil || lease == nil {
+				t.Fatalf("TryAcquireLease() = %v, %v", lease, err)
+			}
+
+			type result struct {
+				w   *httptest.ResponseRecorder
+				err error
+			}
+			done := make(chan result, 1)
+			go func() {
+				w, err := runStreamDownload(mirror, savePath, upstream.URL, nil)
+				done <- result{w, err}
+			}()
+
+			time.Sleep(300 * time.Millisecond)
+			if tt.otherCaches {
+				upload, err := storage.ForMirror(mirror).Create("lib.jar")
+				if err != nil {
+					t.Fatal(err)
+				}
+				upload.SetContentType("application/java-archive")
+				io.WriteString(upload, "from other instance")
+				if _, err := upload.Commit(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			lease.Release()
+
+			r := <-done
+			if r.err != nil {
+				t.Fatalf("streamDownload() error = %v", r.err)
+			}
+			if got := r.w.Body.String(); got != tt.wantBody {
+				t.Errorf("body = %q, want %q", got, tt.wantBody)
+			}
+			if got := r.w.Header().Get("Content-Type"); !strings.HasPrefix(got, tt.wantType) {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantType)
+			}
+			if got := requests.Load(); got != tt.wantUpstream {
+				t.Errorf("上游请求次数 = %d, want %d", got, tt.wantUpstream)
+			}
+			if data, err := readCachedFile(mirror, savePath); err != nil || string(data) != tt.wantBody {
+				t.Errorf("缓存内容 = %q, %v, want %q", data, err, tt.wantBody)
+			}
+		})
+	}
+}
+
+// streamResult 一个请求 streamDownload 的结果
+type streamResult struct {
+	body    string
+	aborted bool // 响应被中断
+	err     error
+}
+
+// runAbortable 调用 runStreamDownload，记录是否通过 http.ErrAbortHandler 中断了响应
+func runAbortable(mirror *models.Mirror, savePath, upstream string, verify func(io.Reader) error) (result streamResult) {
+	var w *httptest.ResponseRecorder
+	defer func() {
+		if r := recover(); r != nil {
+			if r != http.ErrAbortHandler {
+				panic(r)
+			}
+			result.aborted = true
+		}
+		if w != nil {
+			result.body = w.Body.String()
+		}
+	}()
+	gin.SetMode(gin.TestMode)
+	w = httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/file", nil)
+	result.err = streamDownload(c, mirror, savePath,
+		func() (*http.Response, error) { return http.Get(upstream) },
+		verify,
+		func(http.Header, int64, string) error { return nil },
+	)
+	return result
+}
+
+func TestStreamDownloadVerify(t *testing.T) {
+	// 上游分两次发送，第二次在跟随的请求开始后发送
+	content := strings.Repeat("a", 64*1024) + strings.Repeat("b", 64*1024)
+
+	tests := []struct {
+		name        string
+		expected    string
+		wantAborted bool
+	}{
+		{"校验通过", digestHex(content), false},
+		{"校验失败", digestHex("other"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mirror := setupRegistryStore(t)
+			savePath := filepath.Join(mirror.BlobPath, "lib.tgz")
+
+			release := make(chan struct{})
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, content[:len(content)/2])
+				w.(http.Flusher).Flush()
+				<-release
+				io.WriteString(w, content[len(content)/2:])
+			}))
+			defer upstream.Close()
+
+			results := make(chan streamResult, 2)
+			go func() { results <- runAbortable(mirror, savePath, upstream.URL, verifySha256(tt.expected)) }()
+			time.Sleep(200 * time.Millisecond)
+			go func() { results <- runAbortable(mirror, savePath, upstream.URL, verifySha256(tt.expected)) }()
+			time.Sleep(200 * time.Millisecond)
+			close(release)
+
+			for i := 0; i < 2; i++ {
+				r := <-results
+				if r.err != nil {
+					t.Fatalf("streamDownload() error = %v", r.err)
+				}
+				if r.aborted != tt.wantAborted {
+					t.Errorf("aborted = %v, want %v", r.aborted, tt.wantAborted)
+				}
+				if tt.wantAborted {
+					// 最后一块在校验通过前不会发送
+					if len(r.body) >= len(content) || !strings.HasPrefix(content, r.body) {
+						t.Errorf("校验失败时发送了 %d 字节, 完整内容 %d 字节", len(r.body), len(content))
+					}
+				} else if r.body != content {
+					t.Errorf("body 长度 = %d, want %d", len(r.body), len(content))
+				}
+			}
+			if got := cachedFileExists(mirror, savePath); got != !tt.wantAborted {
+				t.Errorf("缓存文件存在 = %v, want %v", got, !tt.wantAborted)
+			}
+		})
+	}
+}
+
+func digestHex(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// signalRecorder 第一次写入响应体时关闭 wrote
+type signalRecorder struct {
+	*httptest.ResponseRecorder
+	once  sync.Once
+	wrote chan struct{}
+}
+
+func (r *signalRecorder) Write(p []byte) (int, error) {
+	n, err := r.ResponseRecorder.Write(p)
+	r.once.Do(func() { close(r.wrote) })
+	return n, err
+}
+
+func TestStreamDownloadCoalescing(t *testing.T) {
+	mirror := setupRegistryStore(t)
+	savePath := filepath.Join(mirror.BlobPath, "lib.jar")
+
+	// 上游先返回一部分内容，等待 release 后再返回剩余内容
+	var requests atomic.Int32
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		io.WriteString(w, "part1-")
+		w.(http.Flusher).Flush()
+		started <- struct{}{}
+		<-release
+		io.WriteString(w, "part2")
+	}))
+	t.Cleanup(upstream.Close)
+
+	const clients = 5
+	var saves atomic.Int32
+	recorders := make([]*signalRecorder, clients)
+	errs := make([]error, clients)
+	var wg sync.WaitGroup
+	run := func(i int) {
+		defer wg.Done()
+		c, _ := gin.CreateTestContext(recorders[i])
+		c.Request = httptest.NewRequest(http.MethodGet, "/file", nil)
+		errs[i] = streamDownload(c, mirror, savePath,
+			func() (*http.Response, error) { return http.Get(upstream.URL) },
+			nil,
+			func(http.Header, int64, string) error {
+				saves.Add(1)
+				return nil
+			},
+		)
+	}
+
+	gin.SetMode(gin.TestMode)
+	for i := range recorders {
+		recorders[i] = &signalRecorder{ResponseRecorder: httptest.NewRecorder(), wrote: make(chan struct{})}
+	}
+	wg.Add(clients)
+	go run(0)
+	<-started
+
+	// 拉取进行中时到达的请求跟随读取，收到已写入的部分说明已经加入
+	for i := 1; i < clients; i++ {
+		go run(i)
+	}
+	for i := 1; i < clients; i++ {
+		select {
+		case <-recorders[i].wrote:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("请求 %d 没有收到已写入的内容", i)
+		}
+	}
+	close(release)
+	wg.Wait()
+
+	if got := requests.Load(); got != 1 {
+		t.Errorf("上游收到 %d 次请求, want 1", got)
+	}
+	if got := saves.Load(); got != 1 {
+		t.Errorf("save 调用了 %d 次, want 1", got)
+	}
+	for i, r := range recorders {
+		if errs[i] != nil || r.Code != http.StatusOK || r.Body.String() != "part1-part2" {
+			t.Errorf("请求 %d: status = %d, body = %q, err = %v", i, r.Code, r.Body.String(), errs[i])
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package registry
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/logger"
+	"easyCacheMirror/internal/models"
+	"easyCacheMirror/internal/proxy"
+	"easyCacheMirror/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// copyResponse 将上游响应原样转发给客户端
+func copyResponse(c *gin.Context, resp *http.Response) error {
+	for key, values := range resp.Header {
+		for _, value := range values {
+			c.Header(key, value)
+		}
+	}
+	c.Status(resp.StatusCode)
+
+	if _, err := io.Copy(c.Writer, resp.Body); err != nil {
+		return fmt.Errorf("写入响应失败: %v", err)
+	}
+	return nil
+}
+
+// cacheHeaders 复制用于缓存拉取的请求头
+// 移除压缩、条件请求和范围请求相关的头，保证拿到的是完整的原始内容
+func cacheHeaders(headers http.Header) http.Header {
+	cloned := headers.Clone()
+	for _, key := range []string{
+		"Accept-Encoding",
+		"If-None-Match",
+		"If-Modified-Since",
+		"If-Range",
+		"Range",
+	} {
+		cloned.Del(key)
+	}
+	return cloned
+}
+
+// isCacheExpired 判断缓存是否超过镜像设置的缓存时间，离线模式下缓存永不过期
+func isCacheExpired(downloadedAt time.Time, cacheTime int) bool {
+	if proxy.IsOffline() {
+		return false
+	}
+	return time.Now().After(downloadedAt.Add(time.Duration(cacheTime) * time.Minute))
+}
+
+// upstreamFailed 判断上游是否不可用：请求失败或返回 5xx
+// resp 为空且没有错误时表示已经处理了上游响应(例如重新验证成功)，不算失败
+func upstreamFailed(resp *http.Response, err error) bool {
+	return err != nil || (resp != nil && resp.StatusCode >= http.StatusInternalServerError)
+}
+
+// canServeStale 判断上游不可用时是否可以使用已过期的缓存
+// 离线模式下总是可以，否则只在过期后的 StaleIfError 分钟内可以
+func canServeStale(mirror *models.Mirror, downloadedAt time.Time) bool {
+	if proxy.IsOffline() {
+		return true
+	}
+	if mirror.StaleIfError <= 0 {
+		return false
+	}
+	return !isCacheExpired(downloadedAt, mirror.CacheTime+mirror.StaleIfError)
+}
+
+// blobKey 返回缓存文件在镜像存储中的路径
+func blobKey(mirror *models.Mirror, savePath string) string {
+	if rel, err := filepath.Rel(mirror.BlobPath, savePath); err == nil && !strings.HasPrefix(rel, "..") {
+		return filepath.ToSlash(rel)
+	}
+	return filepath.ToSlash(savePath)
+}
+
+// saveToFile 将数据流写入镜像存储，返回文件大小和 sha256
+// 写入完成后才替换同名的旧文件，不会留下不完整的缓存文件
+func saveToFile(mirror *models.Mirror, savePath string, body io.Reader) (int64, string, error) {
+	entry, err := storage.ForMirror(mirror).Put(blobKey(mirror, savePath), body)
+	if err != nil {
+		return 0, "", err
+	}
+	return entry.Size, entry.Sha256, nil
+}
+
+// saveToHostedFile 写入本地发布的文件，本地发布的文件不会被清理
+func saveToHostedFile(mirror *models.Mirror, savePath string, body io.Reader) (int64, string, error) {
+	entry, err := storage.ForMirror(mirror).PutHosted(blobKey(mirror, savePath), body)
+	if err != nil {
+		return 0, "", err
+	}
+	return entry.Size, entry.Sha256, nil
+}
+
+// openCachedFile 打开缓存文件
+func openCachedFile(mirror *models.Mirror, savePath string) (storage.Object, error) {
+	file, _, err := storage.ForMirror(mirror).Get(blobKey(mirror, savePath))
+	return file, err
+}
+
+// readCachedFile 读取整个缓存文件
+func readCachedFile(mirror *models.Mirror, savePath string) ([]byte, error) {
+	return storage.ForMirror(mirror).ReadAll(blobKey(mirror, savePath))
+}
+
+// cachedFileExists 判断缓存文件是否存在
+func cachedFileExists(mirror *models.Mirror, savePath string) bool {
+	return storage.ForMirror(mirror).Exists(blobKey(mirror, savePath))
+}
+
+// removeCachedFile 删除缓存文件及其缓存记录，文件不存在时不返回错误
+func removeCachedFile(mirror *models.Mirror, savePath string) error {
+	return storage.ForMirror(mirror).Delete(blobKey(mirror, savePath))
+}
+
+// evictCache 按镜像的淘汰策略删除缓存文件，同时删除各类型文件表中的记录
+func evictCache(mirror *models.Mirror) error {
+	result, err := storage.Evict(mirror, func(keys []string) error {
+		savePaths := make([]string, len(keys))
+		for i, key := range keys {
+			savePaths[i] = filepath.Join(mirror.BlobPath, filepath.FromSlash(key))
+		}
+		return database.DeleteFileRecordsByPaths(mirror.ID, savePaths)
+	})
+	if result.Files > 0 {
+		logger.GetLogger().Info("已淘汰缓存文件",
+			zap.String("mirror", mirror.Name),
+			zap.String("policy", mirror.EvictionPolicy),
+			zap.Int("files", result.Files),
+			zap.Int64("bytes", result.Bytes),
+		)
+	}
+	return err
+}
+
+// hostedLeaseTTL 托管仓库租约的有效时间，持有期间自动续期
+const hostedLeaseTTL = 30 * time.Second
+
+// hostedLeaseTimeout 等待其他发布完成的最长时间
+const hostedLeaseTimeout = time.Minute
+
+// lockHosted 获取托管仓库的租约，串行化发布和删除，避免并发修改元数据互相覆盖
+// 租约保存在数据库中，多个实例共用数据库时同样有效；返回释放租约的函数
+func lockHosted(mirror *models.Mirror) (func(), error) {
+	lease, err := database.AcquireLease(fmt.Sprintf("hosted:%d", mirror.ID), hostedLeaseTTL, hostedLeaseTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("等待其他发布完成失败: %v", err)
+	}
+	return lease.Release, nil
+}
+
+// serveLocalFile 从缓存文件提供响应
+// 通过 http.ServeContent 支持范围请求和条件请求，调用方已设置 ETag 或 Last-Modified 时使用调用方的值，
+// 否则按文件大小和修改时间生成 ETag，修改时间作为 Last-Modified。
+// 镜像开启了对象存储重定向时，GET 请求重定向到对象存储的临时地址，由客户端直接下载
+func serveLocalFile(c *gin.Context, mirror *models.Mirror, savePath, contentType string) error {
+	store := storage.ForMirror(mirror)
+	if mirror.UsesS3() && mirror.S3Redirect && c.Request.Method == http.MethodGet {
+		url, ok, err := store.PresignGet(blobKey(mirror, savePath), contentType)
+		if err != nil {
+			return fmt.Errorf("生成下载地址失败: %v", err)
+		}
+		if ok {
+			c.Redirect(http.StatusTemporaryRedirect, url)
+			return nil
+		}
+	}
+
+	file, info, err := store.Get(blobKey(mirror, savePath))
+	if err != nil {
+		return fmt.Errorf("读取缓存文件失败: %v", err)
+	}
+	defer file.Close()
+
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header := c.Writer.Header()
+	header.Set("Content-Type", contentType)
+	header.Set("Accept-Ranges", "bytes")
+	if header.Get("ETag") == "" {
+		header.Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime.UnixNano(), info.Size))
+	}
+
+	modTime := info.ModTime
+	if lastModified := header.Get("Last-Modified"); lastModified != "" {
+		if t, err := http.ParseTime(lastModified); err == nil {
+			modTime = t
+		}
+	}
+
+	http.ServeContent(c.Writer, c.Request, "", modTime, file)
+	return nil
+}
+
+// mirrorBaseURL 返回镜像对外提供服务的基础地址，不带结尾的斜杠
+func mirrorBaseURL(mirror *models.Mirror) string {
+	return strings.TrimRight(mirror.ServiceURL, "/") + "/" + strings.Trim(mirror.AccessURL, "/")
+}
+
+// withUpstream 返回一个使用指定上游地址的镜像副本
+// 用于文件实际托管在其他域名上的情况（例如 PyPI 的 files.pythonhosted.org）
+func withUpstream(mirror *models.Mirror, upstreamURL string) *models.Mirror {
+	copied := *mirror
+	copied.UpstreamURL = upstreamURL
+	copied.Upstreams = nil
+	return &copied
+}
@@ -0,0 +1,108 @@
+package registry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"easyCacheMirror/internal/models"
+	"easyCacheMirror/internal/proxy"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestServeLocalFile(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mirror := setupRegistryStore(t)
+	savePath := filepath.Join(mirror.BlobPath, "lib.jar")
+	if _, _, err := saveToFile(mirror, savePath, strings.NewReader("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(header http.Header, setup func(c *gin.Context)) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/lib.jar", nil)
+		c.Request.Header = header
+		if setup != nil {
+			setup(c)
+		}
+		if err := serveLocalFile(c, mirror, savePath, "application/java-archive"); err != nil {
+			t.Fatal(err)
+		}
+		c.Writer.WriteHeaderNow()
+		return w
+	}
+	etag := serve(http.Header{}, nil).Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("响应缺少 ETag")
+	}
+
+	const lastModified = "Mon, 01 Jan 2024 00:00:00 GMT"
+	withValidators := func(c *gin.Context) {
+		c.Header("ETag", `"upstream"`)
+		c.Header("Last-Modified", lastModified)
+	}
+
+	tests := []struct {
+		name       string
+		header     http.Header
+		setup      func(c *gin.Context)
+		wantStatus int
+		// wantBody 为空时不检查响应体
+		wantBody string
+	}{
+		{"完整内容", http.Header{}, nil, http.StatusOK, "0123456789"},
+		{"范围请求", http.Header{"Range": {"bytes=2-5"}}, nil, http.StatusPartialContent, "2345"},
+		{"超出范围", http.Header{"Range": {"bytes=20-"}}, nil, http.StatusRequestedRangeNotSatisfiable, ""},
+		{"ETag 一致", http.Header{"If-None-Match": {etag}}, nil, http.StatusNotModified, ""},
+		{"ETag 不一致", http.Header{"If-None-Match": {`"other"`}}, nil, http.StatusOK, "0123456789"},
+		{"If-Range 不一致时返回完整内容", http.Header{"Range": {"bytes=2-5"}, "If-Range": {`"other"`}}, nil, http.StatusOK, "0123456789"},
+		{"使用调用方的 ETag", http.Header{"If-None-Match": {`"upstream"`}}, withValidators, http.StatusNotModified, ""},
+		{"使用调用方的修改时间", http.Header{"If-Modified-Since": {lastModified}}, withValidators, http.StatusNotModified, ""},
+		{"修改时间晚于客户端的缓存", http.Header{"If-Modified-Since": {"Sun, 31 Dec 2023 00:00:00 GMT"}}, withValidators, http.StatusOK, "0123456789"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(tt.header, tt.setup)
+			if w.Code != tt.wantStatus || (tt.wantBody != "" && w.Body.String() != tt.wantBody) {
+				t.Errorf("status = %d, body = %q, want %d %q", w.Code, w.Body.String(), tt.wantStatus, tt.wantBody)
+			}
+			if tt.wantStatus == http.StatusNotModified && w.Body.Len() != 0 {
+				t.Errorf("304 响应不应有响应体: %q", w.Body.String())
+			}
+			if tt.wantStatus == http.StatusOK && w.Header().Get("Accept-Ranges") != "bytes" {
+				t.Error("响应缺少 Accept-Ranges")
+			}
+		})
+	}
+}
+
+func TestCanServeStale(t *testing.T) {
+	t.Cleanup(func() { proxy.SetOffline(false) })
+
+	tests := []struct {
+		name         string
+		offline      bool
+		mirror       models.Mirror
+		downloadedAt time.Duration
+		want         bool
+	}{
+		{"没有配置 StaleIfError", false, models.Mirror{CacheTime: 10}, -20 * time.Minute, false},
+		{"在 StaleIfError 时间内", false, models.Mirror{CacheTime: 10, StaleIfError: 60}, -20 * time.Minute, true},
+		{"超过 StaleIfError 时间", false, models.Mirror{CacheTime: 10, StaleIfError: 60}, -80 * time.Minute, false},
+		{"CacheTime 为 0 时从获取时开始计算", false, models.Mirror{StaleIfError: 60}, -59 * time.Minute, true},
+		{"离线模式下总是可以使用", true, models.Mirror{CacheTime: 10}, -24 * time.Hour, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy.SetOffline(tt.offline)
+			if got := canServeStale(&tt.mirror, time.Now().Add(tt.downloadedAt)); got != tt.want {
+				t.Errorf("canServeStale() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
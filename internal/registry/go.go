@@ -2,15 +2,25 @@ package registry
 
 import (
 	"fmt"
-	"io"
+	"net/http"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"time"
 
+	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/logger"
 	"easyCacheMirror/internal/models"
 	"easyCacheMirror/internal/proxy"
 
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
+// goCanonicalVersion 匹配规范的语义化版本号，只有规范版本的文件才是不可变的
+var goCanonicalVersion = regexp.MustCompile(`^v\d+\.\d+\.\d+(-[0-9A-Za-z.-]+)?(\+incompatible)?$`)
+
 type GoHandler struct {
 	BaseHandler
 	proxy *proxy.Proxy
@@ -41,6 +51,8 @@ func (h *GoHandler) SupportedType() string {
 }
 
 func (h *GoHandler) Handle(c *gin.Context, mirror *models.Mirror, path string) error {
+	log := logger.GetLogger()
+
 	// 添加更详细的初始化检查
 	if h == nil {
 		return fmt.Errorf("handler 未初始化")
@@ -59,36 +71,181 @@ func (h *GoHandler) Handle(c *gin.Context, mirror *models.Mirror, path string) e
 	// 检查请求类型
 	requestType := h.getRequestType(path)
 
-	// 打印请求信息
-	fmt.Printf("[INFO] 处理Go请求: %s (类型: %s)\n", path, requestType)
+	log.Debug("处理Go请求",
+		zap.String("path", path),
+		zap.String("type", requestType),
+	)
 
-	// 直接转发请求到上游
+	// 更新总请求计数
+	if err := updateMirrorCounts(mirror, false); err != nil {
+		log.Error("更新请求计数失败", zap.Error(err))
+	}
+
+	if fileType, ok := h.cacheableFileType(path, requestType); ok {
+		return h.handleCacheable(c, mirror, path, fileType)
+	}
+
+	// 其他请求(如 sumdb)直接转发到上游
 	resp, err := h.proxy.ProxyRequest(mirror, path, c.Request.Header)
 	if err != nil {
-		fmt.Printf("[ERROR] 代理请求失败: %v\n", err)
-		return fmt.Errorf("代理请求失败: %v", err)
+		log.Error("代理请求失败", zap.Error(err), zap.String("path", path))
+		return fmt.Errorf("代理请求失败: %w", err)
 	}
 	defer resp.Body.Close()
 
-	// 复制响应头和状态码
-	for key, values := range resp.Header {
-		for _, value := range values {
-			c.Header(key, value)
+	return copyResponse(c, resp)
+}
+
+// handleCacheable 处理可缓存的模块文件请求
+func (h *GoHandler) handleCacheable(c *gin.Context, mirror *models.Mirror, path string, fileType models.GoFileType) error {
+	log := logger.GetLogger()
+
+	var goFile models.GoModuleFile
+	result := database.DB.Where(&models.GoModuleFile{
+		MirrorID:     mirror.ID,
+		RelativePath: path,
+	}).First(&goFile)
+	if result.Error == nil {
+		// 不可变文件永久有效，列表和 @latest 遵循缓存时间
+		if goFile.IsImmutable() || !isCacheExpired(goFile.DownloadedAt, mirror.CacheTime) {
+			if cachedFileExists(mirror, goFile.SavePath) {
+				return h.serveCachedFile(c, mirror, &goFile)
+			}
+			log.Warn("缓存文件不存在，重新拉取", zap.String("path", goFile.SavePath))
+		} else {
+			log.Info("缓存已过期，从上游拉取",
+				zap.String("path", path),
+				zap.Time("downloaded_at", goFile.DownloadedAt),
+			)
 		}
+	} else if result.Error != gorm.ErrRecordNotFound {
+		return fmt.Errorf("查询缓存文件失败: %v", result.Error)
 	}
-	c.Status(resp.StatusCode)
 
-	// 复制响应体
-	written, err := io.Copy(c.Writer, resp.Body)
-	if err != nil {
-		fmt.Printf("[ERROR] 复制响应失败: %v\n", err)
-		return fmt.Errorf("复制响应失败: %v", err)
+	fetch := func() (*http.Response, error) {
+		return h.proxy.ProxyRequest(mirror, path, cacheHeaders(c.Request.Header))
+	}
+
+	// 列表和 @latest 已过期时先访问上游，上游不可用时继续使用过期的缓存
+	if goFile.ID != 0 && !goFile.IsImmutable() && cachedFileExists(mirror, goFile.SavePath) {
+		resp, err := fetch()
+		if upstreamFailed(resp, err) && canServeStale(mirror, goFile.DownloadedAt) {
+			log.Warn("上游不可用，使用过期的缓存",
+				zap.Error(err),
+				zap.String("path", path),
+			)
+			if resp != nil {
+				resp.Body.Close()
+			}
+			return h.serveCachedFile(c, mirror, &goFile)
+		}
+		if err != nil {
+			return fmt.Errorf("代理请求失败: %w", err)
+		}
+		defer resp.Body.Close()
+		fetch = prefetched(resp, fetch)
 	}
 
-	fmt.Printf("[INFO] 完成请求: %s (状态码: %d, 大小: %d bytes)\n",
-		path, resp.StatusCode, written)
+	// 边下载边返回给客户端，同一文件的并发请求共用一次拉取
+	// 404/410 表示模块或版本不存在，原样转发，不缓存
+	savePath := filepath.Join(mirror.BlobPath, path)
+	return streamDownload(c, mirror, savePath, fetch,
+		nil,
+		func(header http.Header, size int64, sum string) error {
+			modulePath, version := h.parseModulePath(path)
+			now := time.Now()
+			if goFile.ID == 0 {
+				goFile = models.GoModuleFile{
+					MirrorID:     mirror.ID,
+					ModulePath:   modulePath,
+					Version:      version,
+					RelativePath: path,
+					FileType:     fileType,
+					LastUsedTime: now,
+				}
+			}
+			goFile.FileSize = size
+			goFile.SavePath = savePath
+			goFile.ContentType = header.Get("Content-Type")
+			goFile.DownloadedAt = now
+
+			if err := database.DB.Save(&goFile).Error; err != nil {
+				return fmt.Errorf("保存文件记录失败: %v", err)
+			}
+
+			log.Debug("模块文件已缓存",
+				zap.String("path", path),
+				zap.String("type", string(fileType)),
+				zap.Int64("size", size),
+			)
+			return nil
+		},
+	)
+}
+
+// serveCachedFile 从缓存提供文件
+func (h *GoHandler) serveCachedFile(c *gin.Context, mirror *models.Mirror, file *models.GoModuleFile) error {
+	log := logger.GetLogger()
 
-	return nil
+	log.Debug("命中缓存",
+		zap.String("path", file.RelativePath),
+		zap.String("mirror", mirror.Name),
+	)
+
+	if err := database.DB.Model(file).Update("last_used_time", time.Now()).Error; err != nil {
+		log.Error("更新文件使用时间失败", zap.Error(err))
+	}
+
+	if err := updateMirrorCounts(mirror, true); err != nil {
+		log.Error("更新缓存命中计数失败", zap.Error(err))
+	}
+
+	return serveLocalFile(c, mirror, file.SavePath, file.ContentType)
+}
+
+// cacheableFileType 判断请求是否可以缓存，并返回对应的文件类型
+func (h *GoHandler) cacheableFileType(path, requestType string) (models.GoFileType, bool) {
+	_, version := h.parseModulePath(path)
+	if version == "" && requestType != "version-list" && requestType != "latest-version" {
+		return "", false
+	}
+
+	switch requestType {
+	case "version-list":
+		return models.GoFileTypeList, true
+	case "latest-version":
+		return models.GoFileTypeLatest, true
+	case "version-info":
+		// go 命令会用分支名等非规范版本查询 .info，这类结果会变化
+		if !goCanonicalVersion.MatchString(version) {
+			return models.GoFileTypeQuery, true
+		}
+		return models.GoFileTypeInfo, true
+	case "go-mod":
+		if goCanonicalVersion.MatchString(version) {
+			return models.GoFileTypeMod, true
+		}
+	case "source":
+		if goCanonicalVersion.MatchString(version) {
+			return models.GoFileTypeZip, true
+		}
+	}
+	return "", false
+}
+
+// parseModulePath 从请求路径中解析模块路径和版本号
+func (h *GoHandler) parseModulePath(path string) (modulePath, version string) {
+	if idx := strings.Index(path, "/@v/"); idx != -1 {
+		modulePath = path[:idx]
+		version = path[idx+len("/@v/"):]
+		if version != "list" {
+			version = strings.TrimSuffix(version, filepath.Ext(version))
+		} else {
+			version = ""
+		}
+		return modulePath, version
+	}
+	return strings.TrimSuffix(path, "/@latest"), ""
 }
 
 // getRequestType 判断Go请求的类型
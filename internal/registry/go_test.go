@@ -0,0 +1,87 @@
+package registry
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"easyCacheMirror/internal/models"
+)
+
+func TestGoCaching(t *testing.T) {
+	mirror := setupRegistryStore(t)
+	upstream := newStaticUpstream(t, map[string]string{
+		"example.com/lib/@v/list":        "v1.0.0\n",
+		"example.com/lib/@v/v1.0.0.info": `{"Version":"v1.0.0"}`,
+		"example.com/lib/@v/v1.0.0.mod":  "module example.com/lib\n",
+		"example.com/lib/@v/v1.0.0.zip":  "zip",
+	})
+	// 缓存时间为 0，列表每次都从上游拉取，不可变文件仍然永久有效
+	mirror.UpstreamURL = upstream.server.URL
+	h := NewGoHandler()
+
+	steps := []struct {
+		name         string
+		setup        func()
+		path         string
+		wantBody     string
+		wantRequests int32
+	}{
+		{"第一次获取 info", nil, "example.com/lib/@v/v1.0.0.info", "v1.0.0", 1},
+		{"第一次获取 mod", nil, "example.com/lib/@v/v1.0.0.mod", "module example.com/lib", 1},
+		{"第一次获取 zip", nil, "example.com/lib/@v/v1.0.0.zip", "zip", 1},
+		{"第一次获取版本列表", nil, "example.com/lib/@v/list", "v1.0.0", 1},
+		{
+			name: "上游更新后 info、mod 和 zip 仍然使用缓存",
+			setup: func() {
+				upstream.set("example.com/lib/@v/v1.0.0.info", `{"Version":"changed"}`)
+				upstream.set("example.com/lib/@v/v1.0.0.mod", "changed")
+				upstream.set("example.com/lib/@v/v1.0.0.zip", "changed")
+				upstream.set("example.com/lib/@v/list", "v1.0.0\nv1.1.0\n")
+			},
+			path:     "example.com/lib/@v/v1.0.0.info",
+			wantBody: `{"Version":"v1.0.0"}`,
+		},
+		{"mod 使用缓存", nil, "example.com/lib/@v/v1.0.0.mod", "module example.com/lib", 0},
+		{"zip 使用缓存", nil, "example.com/lib/@v/v1.0.0.zip", "zip", 0},
+		{"过期的版本列表重新拉取", nil, "example.com/lib/@v/list", "v1.1.0", 1},
+	}
+	for _, st := range steps {
+		if st.setup != nil {
+			st.setup()
+		}
+		before := upstream.requests.Load()
+		w := serveHandler(h, mirror, st.path, nil)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), st.wantBody) {
+			t.Fatalf("%s: status = %d, body = %q, want %q", st.name, w.Code, w.Body.String(), st.wantBody)
+		}
+		if got := upstream.requests.Load() - before; got != st.wantRequests {
+			t.Errorf("%s: 上游收到 %d 次请求, want %d", st.name, got, st.wantRequests)
+		}
+	}
+}
+
+func TestGoCacheableFileType(t *testing.T) {
+	h := NewGoHandler()
+	tests := []struct {
+		path   string
+		want   models.GoFileType
+		wantOK bool
+	}{
+		{"example.com/lib/@v/list", models.GoFileTypeList, true},
+		{"example.com/lib/@latest", models.GoFileTypeLatest, true},
+		{"example.com/lib/@v/v1.0.0.info", models.GoFileTypeInfo, true},
+		{"example.com/lib/@v/master.info", models.GoFileTypeQuery, true},
+		{"example.com/lib/@v/v1.0.0.mod", models.GoFileTypeMod, true},
+		{"example.com/lib/@v/v1.0.0-rc.1.zip", models.GoFileTypeZip, true},
+		{"example.com/lib/@v/v2.0.0+incompatible.zip", models.GoFileTypeZip, true},
+		{"example.com/lib/@v/master.zip", "", false},
+		{"sumdb/sum.golang.org/lookup/example.com/lib@v1.0.0", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := h.cacheableFileType(tt.path, h.getRequestType(tt.path))
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("cacheableFileType(%q) = %q, %v, want %q, %v", tt.path, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
@@ -2,9 +2,7 @@ package registry
 
 import (
 	"fmt"
-	"io"
 	"net/http"
-	"os"
 	"path/filepath"
 	"strings"
 	"time"
@@ -16,7 +14,6 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
-	"gorm.io/gorm"
 )
 
 type MavenHandler struct {
@@ -64,9 +61,19 @@ func (h *MavenHandler) Handle(c *gin.Context, mirror *models.Mirror, path string
 		log.Error("更新请求计数失败", zap.Error(err))
 	}
 
-	// 对于SNAPSHOT版本或元数据文件，直接转发到上游
+	// 组合镜像由成员镜像处理
+	if mirror.IsGroup() {
+		return h.handleGroup(c, mirror, path)
+	}
+
+	// 托管镜像只从本地提供，不访问上游
+	if mirror.IsHosted() {
+		return h.handleHosted(c, mirror, path)
+	}
+
+	// 对于SNAPSHOT版本或元数据文件，每次从上游获取最新内容
 	if strings.Contains(path, "SNAPSHOT") || strings.Contains(path, "maven-metadata.xml") {
-		return h.proxyRequest(c, mirror, path)
+		return h.handleMutableFile(c, mirror, path)
 	}
 
 	// 检查缓存
@@ -88,39 +95,17 @@ func (h *MavenHandler) Handle(c *gin.Context, mirror *models.Mirror, path string
 		zap.String("path", path),
 	)
 
-	// 从上游获取
-	resp, err := h.proxy.ProxyRequest(mirror, path, c.Request.Header)
-	if err != nil {
-		log.Error("代理请求失败",
-			zap.Error(err),
-			zap.String("path", path),
-		)
-		return fmt.Errorf("代理请求失败: %v", err)
-	}
-	defer resp.Body.Close()
-
-	log.Debug("收到上游响应",
-		zap.Int("status", resp.StatusCode),
-		zap.String("content_type", resp.Header.Get("Content-Type")),
-		zap.String("content_encoding", resp.Header.Get("Content-Encoding")),
+	// 从上游获取，边下载边返回给客户端，同一文件的并发请求共用一次拉取
+	savePath := filepath.Join(mirror.BlobPath, path)
+	return streamDownload(c, mirror, savePath,
+		func() (*http.Response, error) {
+			return h.proxy.ProxyRequest(mirror, path, cacheHeaders(c.Request.Header))
+		},
+		nil,
+		func(header http.Header, size int64, sum string) error {
+			return h.saveFileRecord(mirror, path, savePath, size, header)
+		},
 	)
-
-	// 读取原始响应体
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("读取响应体失败: %v", err)
-	}
-
-	// 对于非SNAPSHOT版本，保存到缓存
-	if resp.StatusCode == 200 {
-		if err := h.processResponse(mirror, path, bodyBytes, resp); err != nil {
-			log.Error("保存缓存失败", zap.Error(err))
-			// 继续处理，不影响响应
-		}
-	}
-
-	// 写入响应
-	return h.writeResponse(c, resp, bodyBytes)
 }
 
 // serveCachedFile 从缓存提供文件
@@ -145,54 +130,19 @@ func (h *MavenHandler) serveCachedFile(c *gin.Context, mirror *models.Mirror, fi
 		log.Error("更新缓存命中计数失败", zap.Error(err))
 	}
 
-	// 读取文件
-	data, err := os.ReadFile(file.SavePath)
-	if err != nil {
-		return fmt.Errorf("读取缓存文件失败: %v", err)
-	}
-
-	// 设置响应头
-	c.Header("Content-Type", file.ContentType)
 	if file.ContentEncoding != "" {
 		c.Header("Content-Encoding", file.ContentEncoding)
 	}
-	c.Status(200)
-
-	if _, err := c.Writer.Write(data); err != nil {
-		return fmt.Errorf("写入响应失败: %v", err)
-	}
-
-	return nil
+	return serveLocalFile(c, mirror, file.SavePath, file.ContentType)
 }
 
-// processResponse 处理响应内容，保存到缓存
-func (h *MavenHandler) processResponse(mirror *models.Mirror, path string, bodyBytes []byte, resp *http.Response) error {
+// saveFileRecord 保存缓存文件的数据库记录
+func (h *MavenHandler) saveFileRecord(mirror *models.Mirror, path, savePath string, size int64, header http.Header) error {
 	log := logger.GetLogger()
 
-	log.Debug("开始保存文件到缓存",
-		zap.String("path", path),
-		zap.Int("size", len(bodyBytes)),
-	)
-
-	// 构建保存路径
-	savePath := filepath.Join(mirror.BlobPath, path)
-
-	// 确保目录存在
-	if err := os.MkdirAll(filepath.Dir(savePath), 0755); err != nil {
-		return fmt.Errorf("创建缓存目录失败: %v", err)
-	}
-
-	// 保存文件
-	if err := os.WriteFile(savePath, bodyBytes, 0644); err != nil {
-		log.Error("保存文件失败",
-			zap.Error(err),
-			zap.String("path", savePath),
-		)
-		return fmt.Errorf("保存文件失败: %v", err)
-	}
-
 	log.Debug("文件已保存到缓存",
 		zap.String("path", savePath),
+		zap.Int64("size", size),
 	)
 
 	// 确定文件类型
@@ -206,107 +156,93 @@ func (h *MavenHandler) processResponse(mirror *models.Mirror, path string, bodyB
 		MirrorID:        mirror.ID,
 		RelativePath:    path,
 		FileType:        fileType,
-		FileSize:        int64(len(bodyBytes)),
+		FileSize:        size,
 		SavePath:        savePath,
-		ContentType:     resp.Header.Get("Content-Type"),
-		ContentEncoding: resp.Header.Get("Content-Encoding"),
+		ContentType:     header.Get("Content-Type"),
+		ContentEncoding: header.Get("Content-Encoding"),
 		IsSnapshot:      strings.Contains(path, "SNAPSHOT"),
 		DownloadedAt:    time.Now(),
 		LastUsedTime:    time.Now(),
 	}
 
 	if err := database.DB.Create(&mavenFile).Error; err != nil {
-		log.Error("保存文件记录失败", zap.Error(err))
+		return fmt.Errorf("保存文件记录失败: %v", err)
 	}
-
 	return nil
 }
 
-// proxyRequest 直接代理请求到上游
-func (h *MavenHandler) proxyRequest(c *gin.Context, mirror *models.Mirror, path string) error {
-	resp, err := h.proxy.ProxyRequest(mirror, path, c.Request.Header)
-	if err != nil {
-		return fmt.Errorf("代理请求失败: %v", err)
+// handleMutableFile 处理会变化的文件(SNAPSHOT 和 maven-metadata.xml)
+// 每次从上游获取最新内容并更新缓存，缓存只在上游不可用且在 StaleIfError 时间内或离线模式下使用
+func (h *MavenHandler) handleMutableFile(c *gin.Context, mirror *models.Mirror, path string) error {
+	log := logger.GetLogger()
+
+	var mavenFile models.MavenFile
+	found := database.DB.Where(&models.MavenFile{
+		MirrorID:     mirror.ID,
+		RelativePath: path,
+	}).First(&mavenFile).Error == nil
+	cached := found && cachedFileExists(mirror, mavenFile.SavePath)
+
+	// 离线模式下直接使用缓存
+	if cached && proxy.IsOffline() {
+		return h.serveCachedFile(c, mirror, &mavenFile)
 	}
-	defer resp.Body.Close()
 
-	// 复制响应头
-	for key, values := range resp.Header {
-		for _, value := range values {
-			c.Header(key, value)
+	fetch := func() (*http.Response, error) {
+		return h.proxy.ProxyRequest(mirror, path, cacheHeaders(c.Request.Header))
+	}
+	resp, err := fetch()
+	if cached && upstreamFailed(resp, err) && canServeStale(mirror, mavenFile.DownloadedAt) {
+		log.Warn("上游不可用，使用过期的缓存",
+			zap.Error(err),
+			zap.String("path", path),
+		)
+		if resp != nil {
+			resp.Body.Close()
 		}
+		return h.serveCachedFile(c, mirror, &mavenFile)
+	}
+	if err != nil {
+		return fmt.Errorf("代理请求失败: %w", err)
 	}
+	defer resp.Body.Close()
 
-	c.Status(resp.StatusCode)
-	_, err = io.Copy(c.Writer, resp.Body)
-	return err
+	// 边下载边返回给客户端并更新缓存，上游返回非 200 时原样转发
+	// 文件丢失时更新原有的记录
+	var existing *models.MavenFile
+	if found {
+		existing = &mavenFile
+	}
+	savePath := filepath.Join(mirror.BlobPath, path)
+	return streamDownload(c, mirror, savePath, prefetched(resp, fetch),
+		nil,
+		func(header http.Header, size int64, sum string) error {
+			return h.saveMutableRecord(mirror, path, savePath, existing, size, header)
+		},
+	)
 }
 
-// writeResponse 写入响应到客户端
-func (h *MavenHandler) writeResponse(c *gin.Context, resp *http.Response, bodyBytes []byte) error {
-	// 复制响应头
-	for key, values := range resp.Header {
-		for _, value := range values {
-			c.Header(key, value)
-		}
+// saveMutableRecord 保存重新获取的会变化的文件的记录，existing 为已有的记录，没有时创建
+func (h *MavenHandler) saveMutableRecord(mirror *models.Mirror, path, savePath string, existing *models.MavenFile, size int64, header http.Header) error {
+	if existing == nil {
+		return h.saveFileRecord(mirror, path, savePath, size, header)
 	}
-
-	c.Status(resp.StatusCode)
-	if _, err := c.Writer.Write(bodyBytes); err != nil {
-		return fmt.Errorf("写入响应失败: %v", err)
+	existing.FileSize = size
+	existing.SavePath = savePath
+	existing.ContentType = header.Get("Content-Type")
+	existing.ContentEncoding = header.Get("Content-Encoding")
+	existing.DownloadedAt = time.Now()
+	existing.LastUsedTime = time.Now()
+	if err := database.DB.Save(existing).Error; err != nil {
+		return fmt.Errorf("更新文件记录失败: %v", err)
 	}
-
 	return nil
 }
 
-// CleanupCache 清理缓存
-func (h *MavenHandler) CleanupCache(c *gin.Context, mirror *models.Mirror) error {
-	log := logger.GetLogger()
-
-	// 获取当前使用空间
-	usedSpace, err := database.GetMirrorUsedSpace(mirror.ID)
-	if err != nil {
-		return fmt.Errorf("计算使用空间失败: %v", err)
-	}
-
-	// 如果使用率低于95%，不需要清理
-	usageRatio := float64(usedSpace) / float64(mirror.MaxSize)
-	if usageRatio < 0.95 {
+// CleanupCache 按镜像的淘汰策略清理缓存，组合镜像本身不保存文件
+func (h *MavenHandler) CleanupCache(mirror *models.Mirror) error {
+	if mirror.IsGroup() {
 		return nil
 	}
-
-	// 删除最老的文件直到使用率降到80%以下
-	for usageRatio > 0.8 {
-		var oldestFile models.MavenFile
-		if err := database.DB.Where("mirror_id = ?", mirror.ID).
-			Order("last_used_time asc").
-			First(&oldestFile).Error; err != nil {
-			if err == gorm.ErrRecordNotFound {
-				break
-			}
-			return fmt.Errorf("查询最老文件失败: %v", err)
-		}
-
-		// 删除文件
-		if err := os.Remove(oldestFile.SavePath); err != nil && !os.IsNotExist(err) {
-			log.Error("删除文件失败",
-				zap.Error(err),
-				zap.String("path", oldestFile.SavePath),
-			)
-		}
-
-		// 删除数据库记录
-		if err := database.DB.Delete(&oldestFile).Error; err != nil {
-			log.Error("删除文件记录失败", zap.Error(err))
-		}
-
-		// 重新计算使用空间
-		usedSpace, err = database.GetMirrorUsedSpace(mirror.ID)
-		if err != nil {
-			return fmt.Errorf("计算使用空间失败: %v", err)
-		}
-		usageRatio = float64(usedSpace) / float64(mirror.MaxSize)
-	}
-
-	return nil
+	return evictCache(mirror)
 }
@@ -0,0 +1,430 @@
+package registry
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"easyCacheMirror/internal/cache"
+	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/logger"
+	"easyCacheMirror/internal/models"
+	"easyCacheMirror/internal/proxy"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// mavenMetadataChecksums 元数据校验文件后缀对应的摘要算法
+var mavenMetadataChecksums = map[string]func() hash.Hash{
+	".md5":    md5.New,
+	".sha1":   sha1.New,
+	".sha256": sha256.New,
+	".sha512": sha512.New,
+}
+
+// mavenMetadata maven-metadata.xml 的结构
+type mavenMetadata struct {
+	XMLName      xml.Name         `xml:"metadata"`
+	ModelVersion string           `xml:"modelVersion,attr,omitempty"`
+	GroupID      string           `xml:"groupId,omitempty"`
+	ArtifactID   string           `xml:"artifactId,omitempty"`
+	Version      string           `xml:"version,omitempty"`
+	Versioning   *mavenVersioning `xml:"versioning,omitempty"`
+	Plugins      *mavenPlugins    `xml:"plugins,omitempty"`
+}
+
+type mavenVersioning struct {
+	Latest           string                 `xml:"latest,omitempty"`
+	Release          string                 `xml:"release,omitempty"`
+	Snapshot         *mavenSnapshot         `xml:"snapshot,omitempty"`
+	Versions         *mavenVersions         `xml:"versions,omitempty"`
+	LastUpdated      string                 `xml:"lastUpdated,omitempty"`
+	SnapshotVersions *mavenSnapshotVersions `xml:"snapshotVersions,omitempty"`
+}
+
+// mavenVersions 版本列表，使用指针以便没有版本时省略整个元素
+type mavenVersions struct {
+	Versions []string `xml:"version"`
+}
+
+type mavenSnapshotVersions struct {
+	SnapshotVersions []mavenSnapshotVersion `xml:"snapshotVersion"`
+}
+
+// mavenSnapshot SNAPSHOT 版本级别元数据中最新一次构建的时间戳和构建号
+type mavenSnapshot struct {
+	Timestamp   string `xml:"timestamp,omitempty"`
+	BuildNumber int    `xml:"buildNumber,omitempty"`
+	LocalCopy   bool   `xml:"localCopy,omitempty"`
+}
+
+// mavenSnapshotVersion SNAPSHOT 版本中每种构件最新的文件版本
+type mavenSnapshotVersion struct {
+	Classifier string `xml:"classifier,omitempty"`
+	Extension  string `xml:"extension"`
+	Value      string `xml:"value"`
+	Updated    string `xml:"updated"`
+}
+
+type mavenPlugins struct {
+	Plugins []mavenPlugin `xml:"plugin"`
+}
+
+type mavenPlugin struct {
+	Name       string `xml:"name,omitempty"`
+	Prefix     string `xml:"prefix"`
+	ArtifactID string `xml:"artifactId"`
+}
+
+// handleGroup 处理组合镜像的请求
+// maven-metadata.xml 合并所有成员的版本列表，其他文件按成员顺序解析。
+// 每个成员按自己的访问控制检查，跳过当前请求无权读取的成员
+func (h *MavenHandler) handleGroup(c *gin.Context, group *models.Mirror, path string) error {
+	log := logger.GetLogger()
+
+	members := h.groupMembers(group)
+	readable := make([]*models.Mirror, 0, len(members))
+	for _, member := range members {
+		if !canRead(c, member) {
+			log.Debug("跳过无权读取的组合镜像成员",
+				zap.String("group", group.Name),
+				zap.String("member", member.Name),
+			)
+			continue
+		}
+		readable = append(readable, member)
+	}
+	if len(readable) == 0 {
+		c.String(http.StatusNotFound, "组合镜像没有可用的成员")
+		return nil
+	}
+
+	metadataPath, checksumExt := h.splitMetadataChecksum(path)
+	if strings.HasSuffix(metadataPath, "maven-metadata.xml") {
+		// 合并结果只在可以读取所有成员时缓存，避免把部分用户无权读取的版本提供给其他用户
+		cacheable := len(readable) == len(members)
+		return h.serveGroupMetadata(c, group, readable, cacheable, metadataPath, checksumExt)
+	}
+
+	// 优先使用成员已缓存的文件
+	for _, member := range readable {
+		var count int64
+		database.DB.Model(&models.MavenFile{}).
+			Where("mirror_id = ? AND relative_path = ?", member.ID, path).
+			Count(&count)
+		if count > 0 {
+			log.Debug("组合镜像命中成员缓存",
+				zap.String("path", path),
+				zap.String("member", member.Name),
+			)
+			return h.Handle(c, member, path)
+		}
+	}
+
+	// 依次探测成员的上游，最后一个成员直接处理
+	for i, member := range readable {
+		if i == len(readable)-1 {
+			return h.Handle(c, member, path)
+		}
+		// 托管成员没有上游，上面已经检查过本地文件
+		if member.IsHosted() {
+			continue
+		}
+
+		resp, err := h.proxy.ProxyRequestWithMethod(member, http.MethodHead, path, cacheHeaders(c.Request.Header))
+		if err != nil {
+			log.Warn("探测成员仓库失败",
+				zap.Error(err),
+				zap.String("member", member.Name),
+			)
+			continue
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+			continue
+		}
+		return h.Handle(c, member, path)
+	}
+
+	return nil
+}
+
+// serveGroupMetadata 返回合并后的 maven-metadata.xml，checksumExt 不为空时返回合并结果的校验值
+func (h *MavenHandler) serveGroupMetadata(c *gin.Context, group *models.Mirror, members []*models.Mirror, cacheable bool, path, checksumExt string) error {
+	body, err := h.groupMetadata(c, group, members, cacheable, path)
+	if err != nil {
+		return err
+	}
+	if body == nil {
+		c.String(http.StatusNotFound, "未在任何成员仓库中找到: %s", path)
+		return nil
+	}
+
+	if checksumExt != "" {
+		digest := mavenMetadataChecksums[checksumExt]()
+		digest.Write(body)
+		c.String(http.StatusOK, hex.EncodeToString(digest.Sum(nil)))
+		return nil
+	}
+
+	c.Data(http.StatusOK, "application/xml", body)
+	return nil
+}
+
+// groupMetadata 返回组合镜像的元数据，所有成员都没有时返回 nil
+// cacheable 时合并结果按组合镜像的缓存时间缓存，缓存有效期内不再访问成员
+func (h *MavenHandler) groupMetadata(c *gin.Context, group *models.Mirror, members []*models.Mirror, cacheable bool, path string) ([]byte, error) {
+	log := logger.GetLogger()
+
+	var groupFile models.MavenFile
+	cached := cacheable && database.DB.Where("mirror_id = ? AND relative_path = ?", group.ID, path).
+		First(&groupFile).Error == nil && cachedFileExists(group, groupFile.SavePath)
+	if cached && !isCacheExpired(groupFile.DownloadedAt, group.CacheTime) {
+		body, err := readCachedFile(group, groupFile.SavePath)
+		if err == nil {
+			return body, nil
+		}
+		log.Warn("读取组合镜像元数据缓存失败", zap.Error(err), zap.String("path", path))
+	}
+
+	var found []*mavenMetadata
+	var raw [][]byte
+	for _, member := range members {
+		bodyBytes, ok := h.memberMetadata(c, member, path)
+		if !ok {
+			continue
+		}
+
+		var metadata mavenMetadata
+		if err := xml.Unmarshal(bodyBytes, &metadata); err != nil {
+			log.Warn("解析成员元数据失败",
+				zap.Error(err),
+				zap.String("member", member.Name),
+			)
+			continue
+		}
+		found = append(found, &metadata)
+		raw = append(raw, bodyBytes)
+	}
+
+	if len(found) == 0 {
+		// 成员都不可用时使用之前合并的结果
+		if cached && canServeStale(group, groupFile.DownloadedAt) {
+			if body, err := readCachedFile(group, groupFile.SavePath); err == nil {
+				log.Warn("成员元数据都不可用，使用过期的合并结果", zap.String("path", path))
+				return body, nil
+			}
+		}
+		return nil, nil
+	}
+
+	// 只有一个成员有该元数据，或是 SNAPSHOT 版本级别的元数据时，直接使用第一个结果
+	body := raw[0]
+	if len(found) > 1 && found[0].Version == "" {
+		merged, err := h.mergeMetadata(found)
+		if err != nil {
+			return nil, err
+		}
+		body = merged
+	}
+
+	if cacheable {
+		var existing *models.MavenFile
+		if cached {
+			existing = &groupFile
+		}
+		h.cacheMetadata(group, path, existing, body, http.Header{"Content-Type": {"application/xml"}})
+	}
+	return body, nil
+}
+
+// memberMetadata 获取成员的元数据
+// 托管成员读取本地文件；代理成员从上游获取并更新成员的缓存，
+// 上游不可用时在 StaleIfError 时间内或离线模式下使用成员已缓存的元数据
+func (h *MavenHandler) memberMetadata(c *gin.Context, member *models.Mirror, path string) ([]byte, bool) {
+	log := logger.GetLogger()
+
+	var mavenFile models.MavenFile
+	cached := database.DB.Where("mirror_id = ? AND relative_path = ?", member.ID, path).
+		First(&mavenFile).Error == nil && cachedFileExists(member, mavenFile.SavePath)
+	readCached := func() ([]byte, bool) {
+		bodyBytes, err := readCachedFile(member, mavenFile.SavePath)
+		if err != nil {
+			log.Warn("读取成员元数据缓存失败",
+				zap.Error(err),
+				zap.String("member", member.Name),
+			)
+			return nil, false
+		}
+		return bodyBytes, true
+	}
+
+	if member.IsHosted() {
+		if !cached {
+			return nil, false
+		}
+		return readCached()
+	}
+	if cached && proxy.IsOffline() {
+		return readCached()
+	}
+
+	resp, err := h.proxy.ProxyRequest(member, path, cacheHeaders(c.Request.Header))
+	if upstreamFailed(resp, err) {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		if cached && canServeStale(member, mavenFile.DownloadedAt) {
+			log.Warn("成员上游不可用，使用过期的元数据缓存",
+				zap.Error(err),
+				zap.String("member", member.Name),
+				zap.String("path", path),
+			)
+			return readCached()
+		}
+		log.Warn("获取成员元数据失败",
+			zap.Error(err),
+			zap.String("member", member.Name),
+		)
+		return nil, false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, false
+	}
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false
+	}
+
+	var existing *models.MavenFile
+	if cached {
+		existing = &mavenFile
+	}
+	h.cacheMetadata(member, path, existing, bodyBytes, resp.Header)
+	return bodyBytes, true
+}
+
+// cacheMetadata 将元数据写入镜像的缓存，写入失败只记录日志
+func (h *MavenHandler) cacheMetadata(mirror *models.Mirror, path string, existing *models.MavenFile, body []byte, header http.Header) {
+	log := logger.GetLogger()
+
+	savePath := filepath.Join(mirror.BlobPath, path)
+	size, _, err := saveToFile(mirror, savePath, bytes.NewReader(body))
+	if err != nil {
+		log.Error("缓存元数据失败", zap.Error(err), zap.String("mirror", mirror.Name), zap.String("path", path))
+		return
+	}
+	if err := h.saveMutableRecord(mirror, path, savePath, existing, size, header); err != nil {
+		log.Error("保存文件记录失败", zap.Error(err), zap.String("mirror", mirror.Name), zap.String("path", path))
+	}
+}
+
+// mergeMetadata 合并多个元数据
+// 版本列表取并集并保持出现顺序，latest/release 取最后更新的成员
+func (h *MavenHandler) mergeMetadata(list []*mavenMetadata) ([]byte, error) {
+	merged := &mavenMetadata{
+		ModelVersion: list[0].ModelVersion,
+		GroupID:      list[0].GroupID,
+		ArtifactID:   list[0].ArtifactID,
+	}
+
+	seenVersions := make(map[string]bool)
+	seenPlugins := make(map[string]bool)
+	for _, metadata := range list {
+		if versioning := metadata.Versioning; versioning != nil {
+			if merged.Versioning == nil {
+				merged.Versioning = &mavenVersioning{}
+			}
+			if versioning.LastUpdated >= merged.Versioning.LastUpdated {
+				merged.Versioning.LastUpdated = versioning.LastUpdated
+				if versioning.Latest != "" {
+					merged.Versioning.Latest = versioning.Latest
+				}
+				if versioning.Release != "" {
+					merged.Versioning.Release = versioning.Release
+				}
+			}
+			if versioning.Versions != nil {
+				if merged.Versioning.Versions == nil {
+					merged.Versioning.Versions = &mavenVersions{}
+				}
+				for _, version := range versioning.Versions.Versions {
+					if !seenVersions[version] {
+						seenVersions[version] = true
+						merged.Versioning.Versions.Versions = append(merged.Versioning.Versions.Versions, version)
+					}
+				}
+			}
+		}
+
+		if metadata.Plugins != nil {
+			if merged.Plugins == nil {
+				merged.Plugins = &mavenPlugins{}
+			}
+			for _, plugin := range metadata.Plugins.Plugins {
+				if !seenPlugins[plugin.Prefix] {
+					seenPlugins[plugin.Prefix] = true
+					merged.Plugins.Plugins = append(merged.Plugins.Plugins, plugin)
+				}
+			}
+		}
+	}
+
+	return encodeMavenMetadata(merged)
+}
+
+// encodeMavenMetadata 生成 maven-metadata.xml 的内容
+func encodeMavenMetadata(metadata *mavenMetadata) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	encoder := xml.NewEncoder(&buf)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(metadata); err != nil {
+		return nil, fmt.Errorf("生成元数据失败: %v", err)
+	}
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
+
+// splitMetadataChecksum 拆分校验文件路径，例如 maven-metadata.xml.sha1 -> (maven-metadata.xml, .sha1)
+func (h *MavenHandler) splitMetadataChecksum(path string) (string, string) {
+	for ext := range mavenMetadataChecksums {
+		if strings.HasSuffix(path, "maven-metadata.xml"+ext) {
+			return strings.TrimSuffix(path, ext), ext
+		}
+	}
+	return path, ""
+}
+
+// groupMembers 返回组合镜像中可用的成员，只包含 Maven 代理镜像和托管镜像
+func (h *MavenHandler) groupMembers(group *models.Mirror) []*models.Mirror {
+	log := logger.GetLogger()
+
+	members := make([]*models.Mirror, 0, len(group.Members))
+	for _, id := range group.Members {
+		member := cache.GetMirrorCache().GetByID(id)
+		if member == nil || member.Type != group.Type || member.IsGroup() {
+			log.Warn("忽略无效的组合镜像成员",
+				zap.String("group", group.Name),
+				zap.Uint("member_id", id),
+			)
+			continue
+		}
+		members = append(members, member)
+	}
+	return members
+}
@@ -0,0 +1,224 @@
+package registry
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"easyCacheMirror/internal/cache"
+	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/models"
+	"easyCacheMirror/internal/proxy"
+
+	"github.com/gin-gonic/gin"
+)
+
+// mavenUpstream 提供 maven-metadata.xml 和构件的上游，status 不为 0 时所有请求返回该状态码
+type mavenUpstream struct {
+	server   *httptest.Server
+	versions []string
+	files    map[string]string
+	status   atomic.Int32
+	requests atomic.Int32
+}
+
+func newMavenUpstream(t *testing.T, versions []string, files map[string]string) *mavenUpstream {
+	t.Helper()
+	u := &mavenUpstream{versions: versions, files: files}
+	u.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u.requests.Add(1)
+		if status := u.status.Load(); status != 0 {
+			w.WriteHeader(int(status))
+			return
+		}
+		path := strings.TrimPrefix(r.URL.Path, "/")
+		if strings.HasSuffix(path, "maven-metadata.xml") {
+			w.Header().Set("Content-Type", "application/xml")
+			fmt.Fprintf(w, "<metadata><groupId>com.example</groupId><artifactId>lib</artifactId><versioning>"+
+				"<versions><version>%s</version></versions><lastUpdated>20240101000000</lastUpdated></versioning></metadata>",
+				strings.Join(u.versions, "</version><version>"))
+			return
+		}
+		body, ok := u.files[path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/java-archive")
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(u.server.Close)
+	return u
+}
+
+// newCachedMirror 创建镜像并加入镜像缓存
+func newCachedMirror(t *testing.T, mirror *models.Mirror) *models.Mirror {
+	t.Helper()
+	mirror.Type = "Maven"
+	mirror.BlobPath = filepath.Join(t.TempDir(), mirror.Name)
+	if err := database.DB.Create(mirror).Error; err != nil {
+		t.Fatal(err)
+	}
+	cache.GetMirrorCache().Set(mirror)
+	t.Cleanup(func() { cache.GetMirrorCache().Remove(mirror) })
+	return mirror
+}
+
+// serveMaven 以指定用户请求 Maven 镜像，用户为 admin 时可以读取组合镜像的所有成员
+func serveMaven(h *MavenHandler, mirror *models.Mirror, user, path string) *httptest.ResponseRecorder {
+	return serveHandler(h, mirror, path, http.Header{"X-Test-User": {user}})
+}
+
+func TestMavenGroup(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupRegistryStore(t)
+	t.Cleanup(func() { proxy.SetOffline(false) })
+
+	// 只有 admin 可以读取 private 成员
+	OnReadAccess(func(c *gin.Context, mirror *models.Mirror) bool {
+		return mirror.Name != "private" || c.GetHeader("X-Test-User") == "admin"
+	})
+	t.Cleanup(func() { OnReadAccess(nil) })
+
+	upstreamA := newMavenUpstream(t, []string{"1.0", "1.1"}, nil)
+	upstreamB := newMavenUpstream(t, []string{"2.0"}, nil)
+	upstreamPrivate := newMavenUpstream(t, []string{"9.0"}, map[string]string{
+		"com/example/secret/1.0/secret-1.0.jar": "secret",
+	})
+	a := newCachedMirror(t, &models.Mirror{Name: "a", UpstreamURL: upstreamA.server.URL, StaleIfError: 60})
+	b := newCachedMirror(t, &models.Mirror{Name: "b", UpstreamURL: upstreamB.server.URL})
+	private := newCachedMirror(t, &models.Mirror{Name: "private", UpstreamURL: upstreamPrivate.server.URL})
+	group := newCachedMirror(t, &models.Mirror{
+		Name:      "group",
+		Kind:      models.MirrorKindGroup,
+		CacheTime: 10,
+		Members:   []uint{a.ID, b.ID, private.ID},
+	})
+
+	h := NewMavenHandler()
+	const metadataPath = "com/example/lib/maven-metadata.xml"
+	const secretPath = "com/example/secret/1.0/secret-1.0.jar"
+
+	steps := []struct {
+		name  string
+		setup func()
+		user  string
+		path  string
+		// wantVersions 为空时只检查状态码
+		wantStatus   int
+		wantVersions []string
+		// 本次请求访问 a、b、private 上游的次数
+		wantRequests [3]int32
+	}{
+		{
+			name:         "合并所有成员的版本",
+			user:         "admin",
+			path:         metadataPath,
+			wantStatus:   http.StatusOK,
+			wantVersions: []string{"1.0", "1.1", "2.0", "9.0"},
+			wantRequests: [3]int32{1, 1, 1},
+		},
+		{
+			name:         "缓存时间内使用合并结果",
+			user:         "admin",
+			path:         metadataPath,
+			wantStatus:   http.StatusOK,
+			wantVersions: []string{"1.0", "1.1", "2.0", "9.0"},
+		},
+		{
+			name:         "跳过无权读取的成员且不使用合并结果的缓存",
+			user:         "guest",
+			path:         metadataPath,
+			wantStatus:   http.StatusOK,
+			wantVersions: []string{"1.0", "1.1", "2.0"},
+			wantRequests: [3]int32{1, 1, 0},
+		},
+		{
+			name: "上游不可用时在 StaleIfError 时间内使用成员的缓存",
+			setup: func() {
+				upstreamA.status.Store(http.StatusBadGateway)
+				upstreamB.status.Store(http.StatusBadGateway)
+			},
+			user:         "guest",
+			path:         metadataPath,
+			wantStatus:   http.StatusOK,
+			wantVersions: []string{"1.0", "1.1"},
+			wantRequests: [3]int32{1, 1, 0},
+		},
+		{
+			name:         "离线模式下使用成员的缓存",
+			setup:        func() { proxy.SetOffline(true) },
+			user:         "guest",
+			path:         metadataPath,
+			wantStatus:   http.StatusOK,
+			wantVersions: []string{"1.0", "1.1", "2.0"},
+		},
+		{
+			name: "无权读取的成员中的构件",
+			setup: func() {
+				proxy.SetOffline(false)
+				upstreamA.status.Store(0)
+				upstreamB.status.Store(0)
+			},
+			user:         "guest",
+			path:         secretPath,
+			wantStatus:   http.StatusNotFound,
+			wantRequests: [3]int32{1, 1, 0},
+		},
+		{
+			name:         "有权读取的成员中的构件",
+			user:         "admin",
+			path:         secretPath,
+			wantStatus:   http.StatusOK,
+			wantRequests: [3]int32{1, 1, 1},
+		},
+	}
+
+	upstreams := []*mavenUpstream{upstreamA, upstreamB, upstreamPrivate}
+	for _, st := range steps {
+		if st.setup != nil {
+			st.setup()
+		}
+		var before [3]int32
+		for i, u := range upstreams {
+			before[i] = u.requests.Load()
+		}
+
+		w := serveMaven(h, group, st.user, st.path)
+		if w.Code != st.wantStatus {
+			t.Fatalf("%s: status = %d, want %d, body = %s", st.name, w.Code, st.wantStatus, w.Body.String())
+		}
+		for i, u := range upstreams {
+			if got := u.requests.Load() - before[i]; got != st.wantRequests[i] {
+				t.Errorf("%s: 上游 %d 收到 %d 次请求, want %d", st.name, i, got, st.wantRequests[i])
+			}
+		}
+		if st.wantVersions != nil {
+			var metadata mavenMetadata
+			if err := xml.Unmarshal(w.Body.Bytes(), &metadata); err != nil {
+				t.Fatalf("%s: 解析元数据失败: %v", st.name, err)
+			}
+			var got []string
+			if metadata.Versioning != nil && metadata.Versioning.Versions != nil {
+				got = metadata.Versioning.Versions.Versions
+			}
+			if strings.Join(got, ",") != strings.Join(st.wantVersions, ",") {
+				t.Errorf("%s: versions = %v, want %v", st.name, got, st.wantVersions)
+			}
+
+			// 校验文件与合并结果一致
+			checksum := serveMaven(h, group, st.user, st.path+".sha1")
+			sum := sha1.Sum(w.Body.Bytes())
+			if checksum.Body.String() != hex.EncodeToString(sum[:]) {
+				t.Errorf("%s: sha1 = %s, want %x", st.name, checksum.Body.String(), sum)
+			}
+		}
+	}
+}
@@ -0,0 +1,500 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/logger"
+	"easyCacheMirror/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// mavenSnapshotFile 匹配带时间戳的 SNAPSHOT 构件文件名（去掉 artifactId 和基础版本后的部分）
+// 例如 20240101.120000-1-sources.jar -> (20240101.120000, 1, sources, jar)
+var mavenSnapshotFile = regexp.MustCompile(`^(\d{8}\.\d{6})-(\d+)(?:-([^.]+))?\.(.+)$`)
+
+// mavenArtifactPath 托管仓库中构件文件路径的组成部分
+type mavenArtifactPath struct {
+	GroupPath  string // 例如: com/ourco
+	ArtifactID string
+	Version    string
+	FileName   string
+}
+
+// GroupID 返回以点分隔的 groupId
+func (p *mavenArtifactPath) GroupID() string {
+	return strings.ReplaceAll(p.GroupPath, "/", ".")
+}
+
+// ArtifactDir 返回构件所在目录，即 artifact 级别元数据所在的目录
+func (p *mavenArtifactPath) ArtifactDir() string {
+	return p.GroupPath + "/" + p.ArtifactID
+}
+
+// IsSnapshot 判断是否为 SNAPSHOT 版本
+func (p *mavenArtifactPath) IsSnapshot() bool {
+	return strings.HasSuffix(p.Version, "-SNAPSHOT")
+}
+
+// parseMavenArtifactPath 解析构件路径，格式为 <groupPath>/<artifactId>/<version>/<fileName>
+func parseMavenArtifactPath(path string) (*mavenArtifactPath, bool) {
+	parts := strings.Split(path, "/")
+	if len(parts) < 4 {
+		return nil, false
+	}
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." {
+			return nil, false
+		}
+	}
+	n := len(parts)
+	return &mavenArtifactPath{
+		GroupPath:  strings.Join(parts[:n-3], "/"),
+		ArtifactID: parts[n-3],
+		Version:    parts[n-2],
+		FileName:   parts[n-1],
+	}, true
+}
+
+// mavenChecksumExt 返回校验文件的后缀，不是校验文件时返回空字符串
+func mavenChecksumExt(path string) string {
+	for ext := range mavenMetadataChecksums {
+		if strings.HasSuffix(path, ext) {
+			return ext
+		}
+	}
+	return ""
+}
+
+// handleHosted 处理托管镜像的请求，PUT 部署构件，GET 从本地提供
+func (h *MavenHandler) handleHosted(c *gin.Context, mirror *models.Mirror, path string) error {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead:
+		return h.serveHostedFile(c, mirror, path)
+	case http.MethodPut:
+		if status, message := checkWriteAccess(c, mirror); status != 0 {
+			c.String(status, message)
+			return nil
+		}
+		return h.deployHostedFile(c, mirror, path)
+	}
+
+	c.String(http.StatusMethodNotAllowed, "不支持的请求方法: %s", c.Request.Method)
+	return nil
+}
+
+// serveHostedFile 从本地提供已部署的文件
+func (h *MavenHandler) serveHostedFile(c *gin.Context, mirror *models.Mirror, path string) error {
+	mavenFile, err := h.findHostedFile(mirror, path)
+	if err != nil {
+		return err
+	}
+	if mavenFile == nil {
+		c.String(http.StatusNotFound, "文件不存在: %s", path)
+		return nil
+	}
+	return h.serveCachedFile(c, mirror, mavenFile)
+}
+
+// deployHostedFile 处理 mvn deploy 上传的文件
+// 构件上传后重新生成元数据，客户端上传的元数据只保留包含插件前缀的 group 级别元数据
+func (h *MavenHandler) deployHostedFile(c *gin.Context, mirror *models.Mirror, path string) error {
+	log := logger.GetLogger()
+
+	metadataPath, metadataExt := h.splitMetadataChecksum(path)
+	if strings.HasSuffix(metadataPath, "maven-metadata.xml") {
+		return h.deployClientMetadata(c, mirror, metadataPath, metadataExt)
+	}
+
+	artifact, ok := parseMavenArtifactPath(path)
+	if !ok {
+		c.String(http.StatusBadRequest, "无效的构件路径: %s", path)
+		return nil
+	}
+
+	unlock, err := lockHosted(mirror)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	existing, err := h.findHostedFile(mirror, path)
+	if err != nil {
+		return err
+	}
+	if existing != nil && !artifact.IsSnapshot() {
+		log.Warn("拒绝重新部署已发布的版本", zap.String("path", path))
+		c.String(http.StatusConflict, "不允许重新部署已发布的版本: %s", path)
+		return nil
+	}
+
+	checksumExt := mavenChecksumExt(path)
+	var body io.Reader = c.Request.Body
+	if checksumExt != "" {
+		// 校验文件内容必须与已上传的构件一致
+		content, err := io.ReadAll(io.LimitReader(c.Request.Body, 1024))
+		if err != nil {
+			return fmt.Errorf("读取请求体失败: %v", err)
+		}
+		if err := h.verifyHostedChecksum(mirror, strings.TrimSuffix(path, checksumExt), checksumExt, content); err != nil {
+			c.String(http.StatusBadRequest, "%v", err)
+			return nil
+		}
+		body = bytes.NewReader(content)
+	}
+
+	mavenFile, err := h.saveHostedFile(mirror, path, body, models.MavenFileTypeNormal)
+	if err != nil {
+		return err
+	}
+
+	log.Info("部署构件",
+		zap.String("mirror", mirror.Name),
+		zap.String("path", path),
+		zap.Int64("size", mavenFile.FileSize),
+	)
+
+	if checksumExt == "" {
+		if artifact.IsSnapshot() {
+			if err := h.updateSnapshotMetadata(mirror, artifact); err != nil {
+				return err
+			}
+		}
+		if err := h.updateArtifactMetadata(mirror, artifact); err != nil {
+			return err
+		}
+	}
+
+	c.Status(http.StatusCreated)
+	return nil
+}
+
+// deployClientMetadata 处理客户端上传的元数据
+// artifact 和版本级别的元数据由服务端生成，直接忽略；包含插件前缀的 group 级别元数据原样保存
+func (h *MavenHandler) deployClientMetadata(c *gin.Context, mirror *models.Mirror, path, checksumExt string) error {
+	if checksumExt != "" {
+		c.Status(http.StatusCreated)
+		return nil
+	}
+
+	content, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return fmt.Errorf("读取请求体失败: %v", err)
+	}
+
+	var metadata mavenMetadata
+	if err := xml.Unmarshal(content, &metadata); err != nil {
+		c.String(http.StatusBadRequest, "无效的元数据: %v", err)
+		return nil
+	}
+
+	if metadata.Plugins != nil && metadata.Versioning == nil {
+		unlock, err := lockHosted(mirror)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		if err := h.saveHostedMetadata(mirror, path, content); err != nil {
+			return err
+		}
+	}
+
+	c.Status(http.StatusCreated)
+	return nil
+}
+
+// verifyHostedChecksum 检查上传的校验值是否与已部署的文件一致，文件尚未上传时不检查
+func (h *MavenHandler) verifyHostedChecksum(mirror *models.Mirror, path, checksumExt string, content []byte) error {
+	fields := strings.Fields(string(content))
+	if len(fields) == 0 {
+		return errors.New("校验文件为空")
+	}
+
+	mavenFile, err := h.findHostedFile(mirror, path)
+	if err != nil || mavenFile == nil {
+		return err
+	}
+
+	file, err := openCachedFile(mirror, mavenFile.SavePath)
+	if err != nil {
+		return fmt.Errorf("读取已部署文件失败: %v", err)
+	}
+	defer file.Close()
+
+	digest := mavenMetadataChecksums[checksumExt]()
+	if _, err := io.Copy(digest, file); err != nil {
+		return fmt.Errorf("读取已部署文件失败: %v", err)
+	}
+	if expected := hex.EncodeToString(digest.Sum(nil)); !strings.EqualFold(fields[0], expected) {
+		return fmt.Errorf("校验值不匹配: %s", path)
+	}
+	return nil
+}
+
+// updateArtifactMetadata 根据已部署的版本重新生成 artifact 级别的 maven-metadata.xml
+func (h *MavenHandler) updateArtifactMetadata(mirror *models.Mirror, artifact *mavenArtifactPath) error {
+	prefix := artifact.ArtifactDir() + "/"
+
+	var files []models.MavenFile
+	if err := database.DB.Where("mirror_id = ? AND is_hosted = ? AND file_type = ? AND relative_path LIKE ?",
+		mirror.ID, true, models.MavenFileTypeNormal, prefix+"%").Find(&files).Error; err != nil {
+		return fmt.Errorf("查询已部署的版本失败: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	var versions []string
+	for _, file := range files {
+		parts := strings.Split(strings.TrimPrefix(file.RelativePath, prefix), "/")
+		// 只统计 <version>/<fileName>，排除 groupId 更长的其他构件
+		if !strings.HasPrefix(file.RelativePath, prefix) || len(parts) != 2 || seen[parts[0]] {
+			continue
+		}
+		seen[parts[0]] = true
+		versions = append(versions, parts[0])
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return compareMavenVersions(versions[i], versions[j]) < 0
+	})
+
+	versioning := &mavenVersioning{
+		Versions:    &mavenVersions{Versions: versions},
+		LastUpdated: time.Now().UTC().Format("20060102150405"),
+	}
+	for _, version := range versions {
+		versioning.Latest = version
+		if !strings.HasSuffix(version, "-SNAPSHOT") {
+			versioning.Release = version
+		}
+	}
+
+	content, err := encodeMavenMetadata(&mavenMetadata{
+		ModelVersion: "1.1.0",
+		GroupID:      artifact.GroupID(),
+		ArtifactID:   artifact.ArtifactID,
+		Versioning:   versioning,
+	})
+	if err != nil {
+		return err
+	}
+	return h.saveHostedMetadata(mirror, prefix+"maven-metadata.xml", content)
+}
+
+// updateSnapshotMetadata 根据带时间戳的构件文件重新生成 SNAPSHOT 版本级别的 maven-metadata.xml
+func (h *MavenHandler) updateSnapshotMetadata(mirror *models.Mirror, artifact *mavenArtifactPath) error {
+	versionDir := artifact.ArtifactDir() + "/" + artifact.Version + "/"
+	filePrefix := artifact.ArtifactID + "-" + strings.TrimSuffix(artifact.Version, "SNAPSHOT")
+
+	var files []models.MavenFile
+	if err := database.DB.Where("mirror_id = ? AND is_hosted = ? AND file_type = ? AND relative_path LIKE ?",
+		mirror.ID, true, models.MavenFileTypeNormal, versionDir+"%").Find(&files).Error; err != nil {
+		return fmt.Errorf("查询已部署的构建失败: %v", err)
+	}
+
+	snapshot := &mavenSnapshot{}
+	latest := make(map[string]mavenSnapshotVersion)
+	builds := make(map[string]int)
+	for _, file := range files {
+		fileName := strings.TrimPrefix(file.RelativePath, versionDir)
+		if strings.Contains(fileName, "/") || mavenChecksumExt(fileName) != "" || !strings.HasPrefix(fileName, filePrefix) {
+			continue
+		}
+		match := mavenSnapshotFile.FindStringSubmatch(strings.TrimPrefix(fileName, filePrefix))
+		if match == nil {
+			continue
+		}
+
+		buildNumber, _ := strconv.Atoi(match[2])
+		if buildNumber > snapshot.BuildNumber {
+			snapshot.Timestamp = match[1]
+			snapshot.BuildNumber = buildNumber
+		}
+
+		key := match[3] + ":" + match[4]
+		if buildNumber >= builds[key] {
+			builds[key] = buildNumber
+			latest[key] = mavenSnapshotVersion{
+				Classifier: match[3],
+				Extension:  match[4],
+				Value:      strings.TrimSuffix(artifact.Version, "SNAPSHOT") + match[1] + "-" + match[2],
+				Updated:    file.DownloadedAt.UTC().Format("20060102150405"),
+			}
+		}
+	}
+
+	// 没有带时间戳的构件文件（非唯一 SNAPSHOT），不需要版本级别的元数据
+	if snapshot.BuildNumber == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(latest))
+	for key := range latest {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	snapshotVersions := make([]mavenSnapshotVersion, 0, len(keys))
+	for _, key := range keys {
+		snapshotVersions = append(snapshotVersions, latest[key])
+	}
+
+	content, err := encodeMavenMetadata(&mavenMetadata{
+		ModelVersion: "1.1.0",
+		GroupID:      artifact.GroupID(),
+		ArtifactID:   artifact.ArtifactID,
+		Version:      artifact.Version,
+		Versioning: &mavenVersioning{
+			Snapshot:         snapshot,
+			LastUpdated:      time.Now().UTC().Format("20060102150405"),
+			SnapshotVersions: &mavenSnapshotVersions{SnapshotVersions: snapshotVersions},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return h.saveHostedMetadata(mirror, versionDir+"maven-metadata.xml", content)
+}
+
+// saveHostedMetadata 保存元数据及其校验文件
+func (h *MavenHandler) saveHostedMetadata(mirror *models.Mirror, path string, content []byte) error {
+	if _, err := h.saveHostedFile(mirror, path, bytes.NewReader(content), models.MavenFileTypeMetadata); err != nil {
+		return err
+	}
+	for ext, newHash := range mavenMetadataChecksums {
+		digest := newHash()
+		digest.Write(content)
+		checksum := strings.NewReader(hex.EncodeToString(digest.Sum(nil)))
+		if _, err := h.saveHostedFile(mirror, path+ext, checksum, models.MavenFileTypeMetadata); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveHostedFile 保存托管文件并创建或更新记录
+func (h *MavenHandler) saveHostedFile(mirror *models.Mirror, path string, body io.Reader, fileType models.MavenFileType) (*models.MavenFile, error) {
+	savePath := filepath.Join(mirror.BlobPath, path)
+	size, _, err := saveToHostedFile(mirror, savePath, body)
+	if err != nil {
+		return nil, err
+	}
+
+	mavenFile, err := h.findHostedFile(mirror, path)
+	if err != nil {
+		return nil, err
+	}
+	if mavenFile == nil {
+		mavenFile = &models.MavenFile{
+			MirrorID:     mirror.ID,
+			RelativePath: path,
+			IsHosted:     true,
+		}
+	}
+
+	now := time.Now()
+	mavenFile.FileType = fileType
+	mavenFile.FileSize = size
+	mavenFile.SavePath = savePath
+	mavenFile.ContentType = mavenContentType(path)
+	mavenFile.IsSnapshot = strings.Contains(path, "SNAPSHOT")
+	mavenFile.DownloadedAt = now
+	mavenFile.LastUsedTime = now
+	if err := database.DB.Save(mavenFile).Error; err != nil {
+		return nil, fmt.Errorf("保存文件记录失败: %v", err)
+	}
+	return mavenFile, nil
+}
+
+// findHostedFile 查找托管文件记录，不存在时返回 nil
+func (h *MavenHandler) findHostedFile(mirror *models.Mirror, path string) (*models.MavenFile, error) {
+	var mavenFile models.MavenFile
+	result := database.DB.Where("mirror_id = ? AND relative_path = ? AND is_hosted = ?",
+		mirror.ID, path, true).First(&mavenFile)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("查询托管文件失败: %v", result.Error)
+	}
+	return &mavenFile, nil
+}
+
+// mavenContentType 根据文件后缀返回 Content-Type
+func mavenContentType(path string) string {
+	switch {
+	case mavenChecksumExt(path) != "":
+		return "text/plain"
+	case strings.HasSuffix(path, ".pom"), strings.HasSuffix(path, ".xml"):
+		return "application/xml"
+	case strings.HasSuffix(path, ".jar"), strings.HasSuffix(path, ".war"), strings.HasSuffix(path, ".ear"):
+		return "application/java-archive"
+	}
+	return "application/octet-stream"
+}
+
+// compareMavenVersions 比较两个版本号
+// 按 . 和 - 拆分后逐段比较，数字按数值比较；较长的版本后续为数字时更大，为限定符（如 SNAPSHOT、rc1）时更小
+func compareMavenVersions(a, b string) int {
+	split := func(version string) []string {
+		return strings.FieldsFunc(version, func(r rune) bool { return r == '.' || r == '-' })
+	}
+	isNumber := func(s string) bool {
+		return s != "" && strings.IndexFunc(s, func(r rune) bool { return !unicode.IsDigit(r) }) == -1
+	}
+
+	partsA, partsB := split(a), split(b)
+	for i := 0; i < len(partsA) && i < len(partsB); i++ {
+		pa, pb := partsA[i], partsB[i]
+		if pa == pb {
+			continue
+		}
+		if isNumber(pa) && isNumber(pb) {
+			na, _ := strconv.Atoi(pa)
+			nb, _ := strconv.Atoi(pb)
+			if na != nb {
+				if na < nb {
+					return -1
+				}
+				return 1
+			}
+			continue
+		}
+		// 数字段大于限定符
+		if isNumber(pa) {
+			return 1
+		}
+		if isNumber(pb) {
+			return -1
+		}
+		return strings.Compare(strings.ToLower(pa), strings.ToLower(pb))
+	}
+
+	switch {
+	case len(partsA) > len(partsB):
+		if isNumber(partsA[len(partsB)]) {
+			return 1
+		}
+		return -1
+	case len(partsA) < len(partsB):
+		if isNumber(partsB[len(partsA)]) {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,79 @@
+package registry
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+
+	"easyCacheMirror/internal/models"
+)
+
+func TestMavenHostedDeploy(t *testing.T) {
+	mirror := setupRegistryStore(t)
+	mirror.Kind = models.MirrorKindHosted
+	h := NewMavenHandler()
+
+	writer := &models.User{Username: "ci", Role: models.RoleWriter}
+	viewer := &models.User{Username: "guest", Role: models.RoleViewer}
+	const jar = "com/example/lib/1.0/lib-1.0.jar"
+	const snapshot = "com/example/lib/2.0-SNAPSHOT/lib-2.0-SNAPSHOT.jar"
+	sum := sha1.Sum([]byte("v1"))
+
+	steps := []struct {
+		name       string
+		method     string
+		path       string
+		user       *models.User
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"未认证不能部署", http.MethodPut, jar, nil, "v1", http.StatusUnauthorized, ""},
+		{"只读用户不能部署", http.MethodPut, jar, viewer, "v1", http.StatusForbidden, ""},
+		{"部署构件", http.MethodPut, jar, writer, "v1", http.StatusCreated, ""},
+		{"校验值与构件不一致", http.MethodPut, jar + ".sha1", writer, strings.Repeat("0", 40), http.StatusBadRequest, ""},
+		{"上传校验值", http.MethodPut, jar + ".sha1", writer, hex.EncodeToString(sum[:]), http.StatusCreated, ""},
+		{"不能重新部署已发布的版本", http.MethodPut, jar, writer, "changed", http.StatusConflict, ""},
+		{"下载已部署的构件", http.MethodGet, jar, nil, "", http.StatusOK, "v1"},
+		{"部署新版本", http.MethodPut, "com/example/lib/1.1/lib-1.1.jar", writer, "v2", http.StatusCreated, ""},
+		{"部署快照版本", http.MethodPut, snapshot, writer, "s1", http.StatusCreated, ""},
+		{"快照版本可以重新部署", http.MethodPut, snapshot, writer, "s2", http.StatusCreated, ""},
+		{"重新生成元数据", http.MethodGet, "com/example/lib/maven-metadata.xml", nil, "", http.StatusOK, "<release>1.1</release>"},
+		{"不存在的构件", http.MethodGet, "com/example/lib/3.0/lib-3.0.jar", nil, "", http.StatusNotFound, ""},
+	}
+	for _, st := range steps {
+		w := sendRequest(h, mirror, st.method, st.path, st.user, strings.NewReader(st.body), nil)
+		if w.Code != st.wantStatus || !strings.Contains(w.Body.String(), st.wantBody) {
+			t.Fatalf("%s: status = %d, body = %s, want %d %s", st.name, w.Code, w.Body.String(), st.wantStatus, st.wantBody)
+		}
+	}
+
+	w := serveHandler(h, mirror, "com/example/lib/maven-metadata.xml", nil)
+	for _, version := range []string{"1.0", "1.1", "2.0-SNAPSHOT"} {
+		if !strings.Contains(w.Body.String(), "<version>"+version+"</version>") {
+			t.Errorf("元数据中没有版本 %s: %s", version, w.Body.String())
+		}
+	}
+}
+
+func TestCompareMavenVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.2", "1.10", -1},
+		{"2.0", "1.9.9", 1},
+		{"1.0.1", "1.0", 1},
+		{"1.0-SNAPSHOT", "1.0", -1},
+		{"1.0-rc1", "1.0", -1},
+		{"1.0.0", "1.0-rc1", 1},
+	}
+	for _, tt := range tests {
+		if got := compareMavenVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareMavenVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
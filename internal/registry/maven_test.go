@@ -0,0 +1,59 @@
+package registry
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMavenMutableFile(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupRegistryStore(t)
+
+	upstream := newMavenUpstream(t, []string{"1.0"}, nil)
+	mirror := newCachedMirror(t, &models.Mirror{Name: "central", UpstreamURL: upstream.server.URL, StaleIfError: 60})
+	h := NewMavenHandler()
+	const path = "com/example/lib/maven-metadata.xml"
+
+	steps := []struct {
+		name       string
+		versions   []string
+		status     int32
+		wantStatus int
+		wantBody   string
+	}{
+		{"第一次获取", []string{"1.0"}, 0, http.StatusOK, "<version>1.0</version>"},
+		{"上游更新后重新获取", []string{"1.0", "2.0"}, 0, http.StatusOK, "<version>2.0</version>"},
+		{"上游不可用时使用缓存", nil, http.StatusBadGateway, http.StatusOK, "<version>2.0</version>"},
+	}
+	for _, st := range steps {
+		upstream.versions = st.versions
+		upstream.status.Store(st.status)
+
+		w := serveMaven(h, mirror, "", path)
+		if w.Code != st.wantStatus || !strings.Contains(w.Body.String(), st.wantBody) {
+			t.Fatalf("%s: status = %d, body = %s, want %d %s", st.name, w.Code, w.Body.String(), st.wantStatus, st.wantBody)
+		}
+
+		// 每次重新获取都更新同一条记录和缓存文件
+		var files []models.MavenFile
+		if err := database.DB.Where("mirror_id = ? AND relative_path = ?", mirror.ID, path).Find(&files).Error; err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != 1 {
+			t.Fatalf("%s: 文件记录数量 = %d, want 1", st.name, len(files))
+		}
+		data, err := readCachedFile(mirror, files[0].SavePath)
+		if err != nil || !strings.Contains(string(data), st.wantBody) {
+			t.Errorf("%s: 缓存内容 = %q, %v", st.name, data, err)
+		}
+		if files[0].FileSize != int64(len(data)) {
+			t.Errorf("%s: 记录的大小 = %d, want %d", st.name, files[0].FileSize, len(data))
+		}
+	}
+}
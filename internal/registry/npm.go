@@ -1,6 +1,7 @@
 package registry
 
 import (
+	"bytes"
 	"crypto/sha1"
 	"crypto/sha512"
 	"encoding/base64"
@@ -9,7 +10,6 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"os"
 	"path/filepath"
 	"strings"
 	"time"
@@ -92,7 +92,7 @@ func (h *NpmHandler) handleTarball(c *gin.Context, mirror *models.Mirror, path s
 	return fmt.Errorf("查询缓存文件失败: %v", result.Error)
 }
 
-// handleJSONMetadata 处理 JSON 元数据请求
+// handleJSONMetadata 处理 JSON 元数据请求，缓存过期后使用 ETag/Last-Modified 向上游重新验证
 func (h *NpmHandler) handleJSONMetadata(c *gin.Context, mirror *models.Mirror, path string) error {
 	log := logger.GetLogger()
 	var npmFile models.NPMFile
@@ -103,18 +103,74 @@ func (h *NpmHandler) handleJSONMetadata(c *gin.Context, mirror *models.Mirror, p
 	}).First(&npmFile)
 	if result.Error == nil {
 		// 检查是否过期
-		cacheExpireTime := npmFile.DownloadedAt.Add(time.Duration(mirror.CacheTime) * time.Minute)
-		if time.Now().Before(cacheExpireTime) {
+		if !isCacheExpired(npmFile.DownloadedAt, mirror.CacheTime) {
 			return h.serveCachedFile(c, npmFile)
 		}
-		log.Info("缓存已过期，从上游拉取",
+		log.Info("缓存已过期，向上游重新验证",
 			zap.String("package", npmFile.PackageID),
-			zap.Time("expired_at", cacheExpireTime),
+			zap.Time("expired_at", npmFile.DownloadedAt.Add(time.Duration(mirror.CacheTime)*time.Minute)),
 		)
+		return h.revalidateJSONMetadata(c, mirror, path, &npmFile)
 	}
 	return nil
 }
 
+// revalidateJSONMetadata 带上缓存的 ETag/Last-Modified 向上游发起条件请求
+// 上游返回 304 时只更新获取时间并返回缓存，上游不可用且在 StaleIfError 时间内时返回过期的缓存，
+// 其他响应按正常的上游响应处理；缓存文件已不存在时不写入响应，由调用方完整拉取
+func (h *NpmHandler) revalidateJSONMetadata(c *gin.Context, mirror *models.Mirror, path string, npmFile *models.NPMFile) error {
+	log := logger.GetLogger()
+
+	if !cachedFileExists(mirror, npmFile.SavePath) {
+		return nil
+	}
+
+	headers := cacheHeaders(c.Request.Header)
+	if npmFile.ETag != "" {
+		headers.Set("If-None-Match", npmFile.ETag)
+	}
+	if npmFile.LastModified != "" {
+		headers.Set("If-Modified-Since", npmFile.LastModified)
+	}
+
+	resp, err := h.proxy.ProxyRequest(mirror, path, headers)
+	if upstreamFailed(resp, err) && canServeStale(mirror, npmFile.DownloadedAt) {
+		log.Warn("上游不可用，使用过期的缓存",
+			zap.Error(err),
+			zap.String("package", npmFile.PackageID),
+		)
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return h.serveCachedFile(c, *npmFile)
+	}
+	if err != nil {
+		log.Error("代理请求失败",
+			zap.Error(err),
+			zap.String("path", path),
+		)
+		return fmt.Errorf("代理请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotModified {
+		return h.processResponse(c, mirror, path, resp)
+	}
+
+	log.Debug("元数据未变化，继续使用缓存", zap.String("package", npmFile.PackageID))
+	npmFile.DownloadedAt = time.Now()
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		npmFile.ETag = etag
+	}
+	if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" {
+		npmFile.LastModified = lastModified
+	}
+	if err := database.DB.Save(npmFile).Error; err != nil {
+		log.Error("更新文件记录失败", zap.Error(err))
+	}
+	return h.serveCachedFile(c, *npmFile)
+}
+
 // serveCachedFile 从缓存中提供文件
 func (h *NpmHandler) serveCachedFile(c *gin.Context, npmFile models.NPMFile) error {
 	log := logger.GetLogger()
@@ -129,13 +185,8 @@ func (h *NpmHandler) serveCachedFile(c *gin.Context, npmFile models.NPMFile) err
 		return fmt.Errorf("获取镜像信息失败: %v", err)
 	}
 
-	// 更新缓存命中计数和最后使用时间
-	now := time.Now()
-	updates := map[string]interface{}{
-		"last_used_time": now,
-		"downloaded_at":  now,
-	}
-	if err := database.DB.Model(&npmFile).Updates(updates).Error; err != nil {
+	// 更新最后使用时间，获取时间只在从上游获取或重新验证后更新，否则缓存永远不会过期
+	if err := database.DB.Model(&npmFile).Update("last_used_time", time.Now()).Error; err != nil {
 		log.Error("更新文件使用时间失败", zap.Error(err))
 		// 继续处理，不返回错误
 	}
@@ -146,37 +197,10 @@ func (h *NpmHandler) serveCachedFile(c *gin.Context, npmFile models.NPMFile) err
 		// 继续处理，不返回错误
 	}
 
-	data, err := os.ReadFile(npmFile.SavePath)
-	if err != nil {
-		log.Error("读取缓存文件失败",
-			zap.Error(err),
-			zap.String("path", npmFile.SavePath),
-		)
-		return fmt.Errorf("读取缓存文件失败: %v", err)
-	}
-
+	// 元数据在写入缓存时由解析后的内容重新序列化，一定是有效的 JSON，这里不再读取校验
 	contentType := "application/octet-stream"
 	if npmFile.FileType == models.NPMFileTypeJSON {
 		contentType = "application/json"
-		// 尝试解析 JSON 以验证其有效性
-		var jsonTest map[string]interface{}
-		if err := json.Unmarshal(data, &jsonTest); err != nil {
-			log.Error("缓存的JSON文件无效",
-				zap.Error(err),
-				zap.String("path", npmFile.SavePath))
-			return fmt.Errorf("缓存的JSON文件无效: %v", err)
-		}
-	}
-
-	c.Header("Content-Type", contentType)
-	c.Status(200)
-
-	if _, err := c.Writer.Write(data); err != nil {
-		log.Error("写入响应失败",
-			zap.Error(err),
-			zap.String("path", npmFile.SavePath),
-		)
-		return fmt.Errorf("写入响应失败: %v", err)
 	}
 
 	log.Debug("返回缓存文件",
@@ -184,7 +208,7 @@ func (h *NpmHandler) serveCachedFile(c *gin.Context, npmFile models.NPMFile) err
 		zap.String("type", string(npmFile.FileType)),
 	)
 
-	return nil
+	return serveLocalFile(c, &mirror, npmFile.SavePath, contentType)
 }
 
 // processResponse 处理上游响应
@@ -200,8 +224,8 @@ func (h *NpmHandler) processResponse(c *gin.Context, mirror *models.Mirror, path
 	}
 
 	contentType := resp.Header.Get("Content-Type")
-	if strings.Contains(contentType, "application/json") && c.Request.Method == "GET" {
-		modifiedJSON, err := h.processJSONResponse(mirror, path, bodyBytes)
+	if resp.StatusCode == http.StatusOK && strings.Contains(contentType, "application/json") && c.Request.Method == "GET" {
+		modifiedJSON, err := h.processJSONResponse(mirror, path, bodyBytes, resp.Header)
 		if err != nil {
 			log.Error("处理JSON响应失败",
 				zap.Error(err),
@@ -210,14 +234,9 @@ func (h *NpmHandler) processResponse(c *gin.Context, mirror *models.Mirror, path
 			return err
 		}
 		bodyBytes = modifiedJSON
-	} else if strings.HasSuffix(path, ".tgz") {
-		if err := h.processTarballResponse(mirror, path, bodyBytes); err != nil {
-			log.Error("处理tarball响应失败",
-				zap.Error(err),
-				zap.String("path", path),
-			)
-			return err
-		}
+		// 改写 tarball 地址后长度和内容都会变化，上游的 ETag 只用于向上游重新验证
+		resp.Header.Del("Content-Length")
+		resp.Header.Del("ETag")
 	}
 
 	// 写入响应
@@ -267,6 +286,14 @@ func (h *NpmHandler) Handle(c *gin.Context, mirror *models.Mirror, path string)
 		// 继续处理，不返回错误
 	}
 
+	// 本地托管的包只从本地提供，不访问上游
+	if name := h.hostedPackageName(mirror, path); name != "" {
+		return h.handleHosted(c, mirror, path, name)
+	}
+	if mirror.IsHosted() {
+		return h.hostedError(c, http.StatusNotFound, "not_found")
+	}
+
 	// 验证上游URL和初始化检查
 	if err := h.validateSetup(mirror); err != nil {
 		return err
@@ -289,6 +316,7 @@ func (h *NpmHandler) Handle(c *gin.Context, mirror *models.Mirror, path string)
 		log.Debug("tarball未缓存，准备从上游获取",
 			zap.String("path", path),
 		)
+		return h.streamTarball(c, mirror, path)
 	} else {
 		// 处理 JSON 元数据请求
 		if err := h.handleJSONMetadata(c, mirror, path); err != nil {
@@ -300,14 +328,14 @@ func (h *NpmHandler) Handle(c *gin.Context, mirror *models.Mirror, path string)
 		}
 	}
 
-	// 代理请求到上游
-	resp, err := h.proxy.ProxyRequest(mirror, path, c.Request.Header)
+	// 代理请求到上游，去掉客户端的条件请求头，保证拿到完整的元数据用于缓存
+	resp, err := h.proxy.ProxyRequest(mirror, path, cacheHeaders(c.Request.Header))
 	if err != nil {
 		log.Error("代理请求失败",
 			zap.Error(err),
 			zap.String("path", path),
 		)
-		return fmt.Errorf("代理请求失败: %v", err)
+		return fmt.Errorf("代理请求失败: %w", err)
 	}
 	defer resp.Body.Close()
 
@@ -397,7 +425,8 @@ func extractVersion(path string) string {
 	return info.Version
 }
 
-func verifyNpmPackage(data []byte, integrity, shasum string) error {
+// verifyNpmPackage 按 integrity 或 shasum 校验 tarball 内容
+func verifyNpmPackage(data io.Reader, integrity, shasum string) error {
 	log := logger.GetLogger()
 
 	// 如果有 integrity，优先使用 integrity 校验
@@ -413,8 +442,11 @@ func verifyNpmPackage(data []byte, integrity, shasum string) error {
 
 		switch hashType {
 		case "sha512":
-			hash := sha512.Sum512(data)
-			actualHash := base64.StdEncoding.EncodeToString(hash[:])
+			hash := sha512.New()
+			if _, err := io.Copy(hash, data); err != nil {
+				return fmt.Errorf("读取包内容失败: %v", err)
+			}
+			actualHash := base64.StdEncoding.EncodeToString(hash.Sum(nil))
 			if actualHash != expectedHash {
 				log.Error("integrity 校验失败",
 					zap.String("expected", expectedHash),
@@ -432,8 +464,11 @@ func verifyNpmPackage(data []byte, integrity, shasum string) error {
 
 	// 如果有 shasum，使用 shasum 校验
 	if shasum != "" {
-		hash := sha1.Sum(data)
-		actualHash := hex.EncodeToString(hash[:])
+		hash := sha1.New()
+		if _, err := io.Copy(hash, data); err != nil {
+			return fmt.Errorf("读取包内容失败: %v", err)
+		}
+		actualHash := hex.EncodeToString(hash.Sum(nil))
 		if actualHash != shasum {
 			log.Error("shasum 校验失败",
 				zap.String("expected", shasum),
@@ -451,7 +486,7 @@ func verifyNpmPackage(data []byte, integrity, shasum string) error {
 }
 
 // processJSONResponse 处理 JSON 元数据响应
-func (h *NpmHandler) processJSONResponse(mirror *models.Mirror, path string, bodyBytes []byte) ([]byte, error) {
+func (h *NpmHandler) processJSONResponse(mirror *models.Mirror, path string, bodyBytes []byte, header http.Header) ([]byte, error) {
 	// 解析 JSON 数据
 	var jsonData map[string]interface{}
 	if err := json.Unmarshal(bodyBytes, &jsonData); err != nil {
@@ -464,13 +499,15 @@ func (h *NpmHandler) processJSONResponse(mirror *models.Mirror, path string, bod
 			if versionInfo, ok := versionData.(map[string]interface{}); ok {
 				if dist, ok := versionInfo["dist"].(map[string]interface{}); ok {
 					if tarball, ok := dist["tarball"].(string); ok {
-						if strings.HasPrefix(tarball, mirror.UpstreamURL) {
-							accessURL := mirror.AccessURL
-							if !strings.HasSuffix(accessURL, "/") {
-								accessURL = accessURL + "/"
+						// 响应可能来自任意一个上游
+						for _, upstream := range mirror.UpstreamList() {
+							upstreamURL := strings.TrimRight(upstream.URL, "/")
+							if upstreamURL == "" || !strings.HasPrefix(tarball, upstreamURL+"/") {
+								continue
 							}
-							newURL := strings.Replace(tarball, mirror.UpstreamURL, mirror.ServiceURL+accessURL, 1)
-							dist["tarball"] = newURL
+							// 上游地址是否以 / 结尾都只保留一个分隔符
+							dist["tarball"] = mirrorBaseURL(mirror) + strings.TrimPrefix(tarball, upstreamURL)
+							break
 						}
 					}
 				}
@@ -486,12 +523,11 @@ func (h *NpmHandler) processJSONResponse(mirror *models.Mirror, path string, bod
 
 	// 保存到文件
 	savePath := filepath.Join(mirror.BlobPath, path+".json")
-	if err := os.MkdirAll(filepath.Dir(savePath), 0755); err != nil {
-		return nil, fmt.Errorf("创建缓存目录失败: %v", err)
+	prettyJSON, err := json.MarshalIndent(jsonData, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("序列化修改后的数据失败: %v", err)
 	}
-
-	prettyJSON, _ := json.MarshalIndent(jsonData, "", "  ")
-	if err := os.WriteFile(savePath, prettyJSON, 0644); err != nil {
+	if _, _, err := saveToFile(mirror, savePath, bytes.NewReader(prettyJSON)); err != nil {
 		return nil, fmt.Errorf("保存 JSON 文件失败: %v", err)
 	}
 
@@ -501,42 +537,37 @@ func (h *NpmHandler) processJSONResponse(mirror *models.Mirror, path string, bod
 		packageName = path
 	}
 
-	if err := h.updateJSONFileRecord(mirror, packageName, path, savePath, modifiedJSON); err != nil {
+	if err := h.updateJSONFileRecord(mirror, packageName, path, savePath, modifiedJSON, header); err != nil {
 		return nil, err
 	}
 	return modifiedJSON, nil
 }
 
-// processTarballResponse 处理 tarball 响应
-func (h *NpmHandler) processTarballResponse(mirror *models.Mirror, path string, bodyBytes []byte) error {
-	// 构建保存路径
+// streamTarball 从上游拉取 tarball，边下载边返回给客户端，同时写入缓存
+// 同一个 tarball 的并发请求共用一次上游拉取
+func (h *NpmHandler) streamTarball(c *gin.Context, mirror *models.Mirror, path string) error {
 	savePath := filepath.Join(mirror.BlobPath, path)
-	if err := os.MkdirAll(filepath.Dir(savePath), 0755); err != nil {
-		return fmt.Errorf("创建 tarball 目录失败: %v", err)
-	}
-
-	// 保存文件
-	if err := os.WriteFile(savePath, bodyBytes, 0644); err != nil {
-		return fmt.Errorf("保存 tarball 失败: %v", err)
-	}
-
-	// 获取包信息
 	info := parseNpmTarballPath(path)
-	integrity, shasum := h.getPackageChecksums(info.PackageName, info.Version)
-
-	// 校验文件
-	if err := verifyNpmPackage(bodyBytes, integrity, shasum); err != nil {
-		// 删除校验失败的文件
-		os.Remove(savePath)
-		return fmt.Errorf("包校验失败: %v", err)
-	}
-
-	// 更新数据库记录
-	return h.updateTarballFileRecord(mirror, info, savePath, bodyBytes, integrity, shasum)
+	integrity, shasum := h.getPackageChecksums(mirror, info.PackageName, info.Version)
+
+	return streamDownload(c, mirror, savePath,
+		func() (*http.Response, error) {
+			return h.proxy.ProxyRequest(mirror, path, cacheHeaders(c.Request.Header))
+		},
+		func(r io.Reader) error {
+			if err := verifyNpmPackage(r, integrity, shasum); err != nil {
+				return fmt.Errorf("包校验失败: %v", err)
+			}
+			return nil
+		},
+		func(header http.Header, size int64, sum string) error {
+			return h.updateTarballFileRecord(mirror, info, savePath, size, integrity, shasum)
+		},
+	)
 }
 
 // updateJSONFileRecord 更新 JSON 文件记录
-func (h *NpmHandler) updateJSONFileRecord(mirror *models.Mirror, packageName, path, savePath string, bodyBytes []byte) error {
+func (h *NpmHandler) updateJSONFileRecord(mirror *models.Mirror, packageName, path, savePath string, bodyBytes []byte, header http.Header) error {
 	fileSize := int64(len(bodyBytes))
 
 	var npmFile models.NPMFile
@@ -549,6 +580,8 @@ func (h *NpmHandler) updateJSONFileRecord(mirror *models.Mirror, packageName, pa
 
 	if result.Error == nil {
 		npmFile.FileSize = fileSize
+		npmFile.ETag = header.Get("ETag")
+		npmFile.LastModified = header.Get("Last-Modified")
 		npmFile.DownloadedAt = time.Now()
 
 		if err := database.DB.Save(&npmFile).Error; err != nil {
@@ -566,6 +599,8 @@ func (h *NpmHandler) updateJSONFileRecord(mirror *models.Mirror, packageName, pa
 		FileType:     models.NPMFileTypeJSON,
 		FileSize:     fileSize,
 		SavePath:     savePath,
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
 		DownloadedAt: time.Now(),
 		LastUsedTime: time.Now(),
 	}
@@ -578,13 +613,13 @@ func (h *NpmHandler) updateJSONFileRecord(mirror *models.Mirror, packageName, pa
 }
 
 // getPackageChecksums 获取包的校验值
-func (h *NpmHandler) getPackageChecksums(packageName, version string) (integrity, shasum string) {
+func (h *NpmHandler) getPackageChecksums(mirror *models.Mirror, packageName, version string) (integrity, shasum string) {
 	var metadataFile models.NPMFile
-	result := database.DB.Where("package_id = ? AND file_type = ?",
-		packageName, models.NPMFileTypeJSON).First(&metadataFile)
+	result := database.DB.Where("mirror_id = ? AND package_id = ? AND file_type = ?",
+		mirror.ID, packageName, models.NPMFileTypeJSON).First(&metadataFile)
 
 	if result.Error == nil {
-		if jsonData, err := os.ReadFile(metadataFile.SavePath); err == nil {
+		if jsonData, err := readCachedFile(mirror, metadataFile.SavePath); err == nil {
 			var metadata map[string]interface{}
 			if err := json.Unmarshal(jsonData, &metadata); err == nil {
 				if versions, ok := metadata["versions"].(map[string]interface{}); ok {
@@ -602,14 +637,14 @@ func (h *NpmHandler) getPackageChecksums(packageName, version string) (integrity
 }
 
 // updateTarballFileRecord 更新 tarball 文件记录
-func (h *NpmHandler) updateTarballFileRecord(mirror *models.Mirror, info ParsedNpmInfo, savePath string, bodyBytes []byte, integrity, shasum string) error {
+func (h *NpmHandler) updateTarballFileRecord(mirror *models.Mirror, info ParsedNpmInfo, savePath string, size int64, integrity, shasum string) error {
 	npmFile := &models.NPMFile{
 		MirrorID:     mirror.ID,
 		PackageID:    info.PackageName,
 		Version:      info.Version,
 		FileName:     filepath.Base(savePath),
 		FileType:     models.NPMFileTypeTarball,
-		FileSize:     int64(len(bodyBytes)),
+		FileSize:     size,
 		SavePath:     savePath,
 		DownloadedAt: time.Now(),
 		LastUsedTime: time.Now(),
@@ -637,161 +672,7 @@ func updateMirrorCounts(mirror *models.Mirror, isHit bool) error {
 	return result.Error
 }
 
-// CleanupCache 清理缓存
-func (h *NpmHandler) CleanupCache(c *gin.Context, mirror *models.Mirror) error {
-	log := logger.GetLogger()
-
-	// 获取当前使用空间
-	usedSpace, err := database.GetMirrorUsedSpace(mirror.ID)
-	if err != nil {
-		return fmt.Errorf("计算使用空间失败: %v", err)
-	}
-
-	// 计算使用率
-	usageRatio := float64(usedSpace) / float64(mirror.MaxSize)
-	if usageRatio < 0.95 {
-		return nil // 使用率低于95%，不需要清理
-	}
-
-	log.Info("开始清理缓存",
-		zap.String("mirror", mirror.Name),
-		zap.Float64("usage_ratio", usageRatio),
-		zap.Int64("used_space", usedSpace),
-	)
-
-	// 1. 删除过期的JSON文件
-	var expiredJSONFiles []models.NPMFile
-	expireTime := time.Now().Add(-time.Duration(mirror.CacheTime) * time.Minute)
-	if err := database.DB.Where("mirror_id = ? AND file_type = ? AND downloaded_at < ?",
-		mirror.ID, models.NPMFileTypeJSON, expireTime).Find(&expiredJSONFiles).Error; err != nil {
-		return fmt.Errorf("查询过期JSON文件失败: %v", err)
-	}
-
-	// 删除过期的JSON文件
-	for _, file := range expiredJSONFiles {
-		if err := os.Remove(file.SavePath); err != nil && !os.IsNotExist(err) {
-			log.Error("删除过期JSON文件失败",
-				zap.Error(err),
-				zap.String("path", file.SavePath),
-			)
-			continue
-		}
-		if err := database.DB.Delete(&file).Error; err != nil {
-			log.Error("删除JSON文件记录失败", zap.Error(err))
-			continue
-		}
-	}
-
-	// 2. 循环删除最老的tarball文件直到使用率低于80%
-	for {
-		// 重新计算使用空间
-		usedSpace, err = database.GetMirrorUsedSpace(mirror.ID)
-		if err != nil {
-			return fmt.Errorf("计算使用空间失败: %v", err)
-		}
-
-		usageRatio = float64(usedSpace) / float64(mirror.MaxSize)
-		if usageRatio <= 0.8 {
-			break // 已达到目标使用率，退出循环
-		}
-
-		var oldestTarball models.NPMFile
-		if err := database.DB.Where("mirror_id = ? AND file_type = ?",
-			mirror.ID, models.NPMFileTypeTarball).
-			Order("COALESCE(last_used_time, downloaded_at) asc").
-			First(&oldestTarball).Error; err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				log.Info("没有可删除的tarball文件，开始删除旧的JSON文件",
-					zap.String("mirror", mirror.Name),
-					zap.Uint("mirror_id", mirror.ID),
-				)
-				// 3. 如果没有 tarball 可删除且空间仍然不足，开始删除最老的 JSON 文件
-				for usageRatio > 0.8 {
-					var oldestJSON models.NPMFile
-					if err := database.DB.Where("mirror_id = ? AND file_type = ?",
-						mirror.ID, models.NPMFileTypeJSON).
-						Order("COALESCE(last_used_time, downloaded_at) asc").
-						First(&oldestJSON).Error; err != nil {
-						if errors.Is(err, gorm.ErrRecordNotFound) {
-							log.Info("没有可删除的JSON文件",
-								zap.String("mirror", mirror.Name),
-							)
-							break
-						}
-						return fmt.Errorf("查询最老的JSON文件失败: %v", err)
-					}
-
-					log.Info("准备删除JSON文件",
-						zap.String("package", oldestJSON.PackageID),
-						zap.Time("last_used", oldestJSON.LastUsedTime),
-						zap.Time("downloaded", oldestJSON.DownloadedAt),
-					)
-
-					// 删除文件
-					if err := os.Remove(oldestJSON.SavePath); err != nil && !os.IsNotExist(err) {
-						log.Error("删除JSON文件失败",
-							zap.Error(err),
-							zap.String("path", oldestJSON.SavePath),
-						)
-						continue
-					}
-
-					// 删除数据库记录
-					if err := database.DB.Delete(&oldestJSON).Error; err != nil {
-						log.Error("删除JSON记录失败", zap.Error(err))
-						continue
-					}
-
-					// 重新计算使用空间
-					usedSpace, err = database.GetMirrorUsedSpace(mirror.ID)
-					if err != nil {
-						return fmt.Errorf("计算使用空间失败: %v", err)
-					}
-					usageRatio = float64(usedSpace) / float64(mirror.MaxSize)
-				}
-				break
-			}
-			return fmt.Errorf("查询最老的tarball失败: %v", err)
-		}
-
-		log.Info("准备删除tarball文件",
-			zap.String("package", oldestTarball.PackageID),
-			zap.String("version", oldestTarball.Version),
-			zap.Time("last_used", oldestTarball.LastUsedTime),
-			zap.Time("downloaded", oldestTarball.DownloadedAt),
-		)
-
-		// 删除文件
-		if err := os.Remove(oldestTarball.SavePath); err != nil && !os.IsNotExist(err) {
-			log.Error("删除tarball文件失败",
-				zap.Error(err),
-				zap.String("path", oldestTarball.SavePath),
-			)
-			continue
-		}
-
-		// 删除数据库记录
-		if err := database.DB.Delete(&oldestTarball).Error; err != nil {
-			log.Error("删除tarball记录失败", zap.Error(err))
-			continue
-		}
-
-		log.Debug("删除旧tarball文件",
-			zap.String("package", oldestTarball.PackageID),
-			zap.String("version", oldestTarball.Version),
-		)
-
-		// 检查是否已达到目标使用率
-		if usageRatio <= 0.8 {
-			break
-		}
-	}
-
-	log.Info("缓存清理完成",
-		zap.String("mirror", mirror.Name),
-		zap.Int64("used_space", usedSpace),
-		zap.Float64("new_usage_ratio", usageRatio),
-	)
-
-	return nil
+// CleanupCache 按镜像的淘汰策略清理缓存
+func (h *NpmHandler) CleanupCache(mirror *models.Mirror) error {
+	return evictCache(mirror)
 }
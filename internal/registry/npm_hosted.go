@@ -0,0 +1,665 @@
+package registry
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/logger"
+	"easyCacheMirror/internal/models"
+	"easyCacheMirror/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// npmHostedDir 本地发布的包在镜像存储目录下的子目录
+const npmHostedDir = "hosted"
+
+// splitNpmPackagePath 从请求路径中拆分包名和剩余部分
+// 例如 @ourco/utils/-/utils-1.0.0.tgz -> (@ourco/utils, -/utils-1.0.0.tgz)
+func splitNpmPackagePath(path string) (name, rest string) {
+	parts := strings.SplitN(path, "/", 3)
+	if strings.HasPrefix(path, "@") {
+		if len(parts) < 2 {
+			return path, ""
+		}
+		name = parts[0] + "/" + parts[1]
+		if len(parts) == 3 {
+			rest = parts[2]
+		}
+		return name, rest
+	}
+	name = parts[0]
+	if len(parts) > 1 {
+		rest = strings.Join(parts[1:], "/")
+	}
+	return name, rest
+}
+
+// findNpmAttachment 按文件名查找发布内容中的附件
+// npm 客户端使用完整包名作为附件名，例如 @ourco/utils-1.0.0.tgz
+func findNpmAttachment(attachments map[string]interface{}, fileName string) map[string]interface{} {
+	for key, value := range attachments {
+		if key[strings.LastIndex(key, "/")+1:] != fileName {
+			continue
+		}
+		if attachment, ok := value.(map[string]interface{}); ok {
+			return attachment
+		}
+	}
+	return nil
+}
+
+// isHostedPackage 判断包是否由本地托管
+// 托管镜像的所有包都由本地托管，代理镜像只托管 HostedScopes 中的作用域
+func (h *NpmHandler) isHostedPackage(mirror *models.Mirror, name string) bool {
+	if mirror.IsHosted() {
+		return true
+	}
+	if !strings.HasPrefix(name, "@") {
+		return false
+	}
+	scope := strings.SplitN(name, "/", 2)[0]
+	for _, hosted := range strings.FieldsFunc(mirror.HostedScopes, func(r rune) bool {
+		return r == '\n' || r == ',' || r == ' '
+	}) {
+		if "@"+strings.TrimPrefix(hosted, "@") == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// hostedPackageName 解析请求对应的本地托管包名，不是托管包的请求返回空字符串
+func (h *NpmHandler) hostedPackageName(mirror *models.Mirror, path string) string {
+	path = strings.TrimPrefix(path, "-/package/")
+	name, _ := splitNpmPackagePath(path)
+	if name == "" || strings.HasPrefix(name, "-") || !h.isHostedPackage(mirror, name) {
+		return ""
+	}
+	return name
+}
+
+// handleHosted 处理本地托管包的请求
+func (h *NpmHandler) handleHosted(c *gin.Context, mirror *models.Mirror, path, name string) error {
+	log := logger.GetLogger()
+
+	log.Debug("处理本地托管包请求",
+		zap.String("path", path),
+		zap.String("package", name),
+		zap.String("method", c.Request.Method),
+	)
+
+	if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		if status, message := checkWriteAccess(c, mirror); status != 0 {
+			return h.hostedError(c, status, message)
+		}
+	}
+
+	// dist-tag 接口: -/package/<name>/dist-tags[/<tag>]
+	if strings.HasPrefix(path, "-/package/") {
+		rest := strings.TrimPrefix(strings.TrimPrefix(path, "-/package/"), name)
+		rest = strings.TrimPrefix(rest, "/")
+		if !strings.HasPrefix(rest, "dist-tags") {
+			return h.hostedError(c, http.StatusNotFound, "not_found")
+		}
+		return h.handleDistTags(c, mirror, name, strings.TrimPrefix(strings.TrimPrefix(rest, "dist-tags"), "/"))
+	}
+
+	_, rest := splitNpmPackagePath(path)
+
+	// 去掉 /-rev/<rev> 后缀，unpublish 和 deprecate 时携带
+	rev := ""
+	if idx := strings.Index(rest, "-rev/"); idx != -1 {
+		rev = rest[idx+len("-rev/"):]
+		rest = strings.TrimSuffix(rest[:idx], "/")
+	}
+
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead:
+		if strings.HasPrefix(rest, "-/") {
+			return h.serveHostedTarball(c, mirror, name, strings.TrimPrefix(rest, "-/"))
+		}
+		return h.serveHostedPackument(c, mirror, name, rest)
+	case http.MethodPut:
+		var body map[string]interface{}
+		if err := json.NewDecoder(c.Request.Body).Decode(&body); err != nil {
+			return h.hostedError(c, http.StatusBadRequest, "无效的请求内容")
+		}
+		// npm deprecate 直接提交完整的元数据，不带附件，修订号在 _rev 中
+		if _, ok := body["_attachments"]; !ok && rev == "" {
+			rev, _ = body["_rev"].(string)
+		}
+		if rev != "" {
+			return h.updateHostedPackage(c, mirror, name, rev, body)
+		}
+		return h.publishHostedPackage(c, mirror, name, body)
+	case http.MethodDelete:
+		if strings.HasPrefix(rest, "-/") {
+			return h.deleteHostedTarball(c, mirror, name, strings.TrimPrefix(rest, "-/"))
+		}
+		return h.deleteHostedPackage(c, mirror, name)
+	}
+
+	return h.hostedError(c, http.StatusMethodNotAllowed, "method_not_allowed")
+}
+
+// serveHostedPackument 返回本地托管包的元数据，rest 不为空时返回指定版本或标签的元数据
+func (h *NpmHandler) serveHostedPackument(c *gin.Context, mirror *models.Mirror, name, rest string) error {
+	packument, record, err := h.loadHostedPackument(mirror, name)
+	if err != nil {
+		return err
+	}
+	if packument == nil {
+		return h.hostedError(c, http.StatusNotFound, "not_found")
+	}
+
+	h.touchHostedFile(mirror, record)
+
+	if rest == "" {
+		c.JSON(http.StatusOK, packument)
+		return nil
+	}
+
+	version := rest
+	if distTags, ok := packument["dist-tags"].(map[string]interface{}); ok {
+		if tagged, ok := distTags[rest].(string); ok {
+			version = tagged
+		}
+	}
+	versions, _ := packument["versions"].(map[string]interface{})
+	if doc, ok := versions[version]; ok {
+		c.JSON(http.StatusOK, doc)
+		return nil
+	}
+	return h.hostedError(c, http.StatusNotFound, "version not found: "+rest)
+}
+
+// serveHostedTarball 返回本地托管包的 tarball
+func (h *NpmHandler) serveHostedTarball(c *gin.Context, mirror *models.Mirror, name, fileName string) error {
+	var npmFile models.NPMFile
+	result := database.DB.Where("mirror_id = ? AND package_id = ? AND file_name = ? AND file_type = ? AND is_hosted = ?",
+		mirror.ID, name, fileName, models.NPMFileTypeTarball, true).First(&npmFile)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return h.hostedError(c, http.StatusNotFound, "not_found")
+		}
+		return fmt.Errorf("查询托管文件失败: %v", result.Error)
+	}
+
+	h.touchHostedFile(mirror, &npmFile)
+	return serveLocalFile(c, mirror, npmFile.SavePath, "application/octet-stream")
+}
+
+// publishHostedPackage 处理 npm publish 提交的包
+func (h *NpmHandler) publishHostedPackage(c *gin.Context, mirror *models.Mirror, name string, body map[string]interface{}) error {
+	log := logger.GetLogger()
+
+	if bodyName, _ := body["name"].(string); bodyName != name {
+		return h.hostedError(c, http.StatusBadRequest, "包名与请求地址不一致")
+	}
+
+	attachments, _ := body["_attachments"].(map[string]interface{})
+	newVersions, _ := body["versions"].(map[string]interface{})
+	if len(attachments) == 0 || len(newVersions) == 0 {
+		return h.hostedError(c, http.StatusBadRequest, "发布内容中没有包文件")
+	}
+
+	unlock, err := lockHosted(mirror)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	packument, _, err := h.loadHostedPackument(mirror, name)
+	if err != nil {
+		return err
+	}
+	if packument == nil {
+		packument = map[string]interface{}{
+			"_id":       name,
+			"name":      name,
+			"versions":  map[string]interface{}{},
+			"dist-tags": map[string]interface{}{},
+			"time": map[string]interface{}{
+				"created": time.Now().UTC().Format(time.RFC3339),
+			},
+		}
+	}
+	versions := packument["versions"].(map[string]interface{})
+
+	for version := range newVersions {
+		if _, exists := versions[version]; exists {
+			return h.hostedError(c, http.StatusForbidden, fmt.Sprintf("不能覆盖已发布的版本: %s@%s", name, version))
+		}
+	}
+
+	now := time.Now()
+	timeInfo, _ := packument["time"].(map[string]interface{})
+	if timeInfo == nil {
+		timeInfo = map[string]interface{}{}
+	}
+
+	for version, doc := range newVersions {
+		versionDoc, ok := doc.(map[string]interface{})
+		if !ok {
+			return h.hostedError(c, http.StatusBadRequest, "无效的版本信息: "+version)
+		}
+		dist, _ := versionDoc["dist"].(map[string]interface{})
+		if dist == nil {
+			return h.hostedError(c, http.StatusBadRequest, "版本缺少 dist 信息: "+version)
+		}
+
+		// 根据 dist.tarball 的文件名找到对应的附件
+		tarball, _ := dist["tarball"].(string)
+		fileName := tarball[strings.LastIndex(tarball, "/")+1:]
+		attachment := findNpmAttachment(attachments, fileName)
+		if attachment == nil {
+			return h.hostedError(c, http.StatusBadRequest, "找不到版本对应的包文件: "+fileName)
+		}
+		encoded, _ := attachment["data"].(string)
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return h.hostedError(c, http.StatusBadRequest, "包文件编码无效: "+fileName)
+		}
+
+		integrity, _ := dist["integrity"].(string)
+		shasum, _ := dist["shasum"].(string)
+		if err := verifyNpmPackage(bytes.NewReader(data), integrity, shasum); err != nil {
+			return h.hostedError(c, http.StatusBadRequest, fmt.Sprintf("包文件校验失败: %v", err))
+		}
+		if shasum == "" {
+			sum := sha1.Sum(data)
+			dist["shasum"] = hex.EncodeToString(sum[:])
+		}
+
+		savePath := filepath.Join(mirror.BlobPath, npmHostedDir, name, "-", fileName)
+		size, _, err := saveToHostedFile(mirror, savePath, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+
+		npmFile := models.NPMFile{
+			MirrorID:     mirror.ID,
+			PackageID:    name,
+			Version:      version,
+			FileName:     fileName,
+			FileType:     models.NPMFileTypeTarball,
+			FileSize:     size,
+			SavePath:     savePath,
+			Integrity:    integrity,
+			Shasum:       dist["shasum"].(string),
+			IsHosted:     true,
+			DownloadedAt: now,
+			LastUsedTime: now,
+		}
+		if err := database.DB.Create(&npmFile).Error; err != nil {
+			return fmt.Errorf("保存文件记录失败: %v", err)
+		}
+
+		dist["tarball"] = mirrorBaseURL(mirror) + "/" + name + "/-/" + fileName
+		versions[version] = versionDoc
+		timeInfo[version] = now.UTC().Format(time.RFC3339)
+
+		log.Info("发布本地托管包",
+			zap.String("package", name),
+			zap.String("version", version),
+			zap.Int64("size", size),
+		)
+	}
+
+	// 合并 dist-tags 和包级别的描述信息
+	distTags, _ := packument["dist-tags"].(map[string]interface{})
+	if distTags == nil {
+		distTags = map[string]interface{}{}
+	}
+	if bodyTags, ok := body["dist-tags"].(map[string]interface{}); ok {
+		for tag, version := range bodyTags {
+			distTags[tag] = version
+		}
+	}
+	packument["dist-tags"] = distTags
+	timeInfo["modified"] = now.UTC().Format(time.RFC3339)
+	packument["time"] = timeInfo
+
+	for key, value := range body {
+		switch key {
+		case "_id", "_rev", "_attachments", "versions", "dist-tags", "time":
+			continue
+		}
+		packument[key] = value
+	}
+
+	rev, err := h.saveHostedPackument(mirror, name, packument)
+	if err != nil {
+		return err
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"ok": true, "id": name, "rev": rev})
+	return nil
+}
+
+// updateHostedPackage 处理 npm unpublish 单个版本和 npm deprecate 提交的完整元数据
+// 只接受已有版本的修改和删除，dist 信息以本地记录为准
+func (h *NpmHandler) updateHostedPackage(c *gin.Context, mirror *models.Mirror, name, rev string, body map[string]interface{}) error {
+	log := logger.GetLogger()
+
+	unlock, err := lockHosted(mirror)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	packument, _, err := h.loadHostedPackument(mirror, name)
+	if err != nil {
+		return err
+	}
+	if packument == nil {
+		return h.hostedError(c, http.StatusNotFound, "not_found")
+	}
+	if current, _ := packument["_rev"].(string); current != rev {
+		return h.hostedError(c, http.StatusConflict, "元数据已被修改，请重试")
+	}
+
+	versions := packument["versions"].(map[string]interface{})
+	bodyVersions, ok := body["versions"].(map[string]interface{})
+	if !ok {
+		return h.hostedError(c, http.StatusBadRequest, "元数据缺少版本信息")
+	}
+	timeInfo, _ := packument["time"].(map[string]interface{})
+
+	for version, existing := range versions {
+		doc, ok := bodyVersions[version].(map[string]interface{})
+		if !ok {
+			// 版本被移除，删除对应的包文件
+			if err := h.removeHostedVersion(mirror, name, version); err != nil {
+				return err
+			}
+			delete(versions, version)
+			delete(timeInfo, version)
+			log.Info("删除本地托管包版本",
+				zap.String("package", name),
+				zap.String("version", version),
+			)
+			continue
+		}
+		doc["dist"] = existing.(map[string]interface{})["dist"]
+		versions[version] = doc
+	}
+
+	if len(versions) == 0 {
+		if err := h.removeHostedPackage(mirror, name); err != nil {
+			return err
+		}
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+		return nil
+	}
+
+	// dist-tags 只保留仍然存在的版本
+	distTags := map[string]interface{}{}
+	if bodyTags, ok := body["dist-tags"].(map[string]interface{}); ok {
+		for tag, version := range bodyTags {
+			if v, ok := version.(string); ok && versions[v] != nil {
+				distTags[tag] = v
+			}
+		}
+	}
+	packument["dist-tags"] = distTags
+	if timeInfo != nil {
+		timeInfo["modified"] = time.Now().UTC().Format(time.RFC3339)
+	}
+
+	newRev, err := h.saveHostedPackument(mirror, name, packument)
+	if err != nil {
+		return err
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"ok": true, "id": name, "rev": newRev})
+	return nil
+}
+
+// deleteHostedTarball 删除单个 tarball，npm unpublish 在更新元数据后调用
+func (h *NpmHandler) deleteHostedTarball(c *gin.Context, mirror *models.Mirror, name, fileName string) error {
+	unlock, err := lockHosted(mirror)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	var npmFile models.NPMFile
+	result := database.DB.Where("mirror_id = ? AND package_id = ? AND file_name = ? AND file_type = ? AND is_hosted = ?",
+		mirror.ID, name, fileName, models.NPMFileTypeTarball, true).First(&npmFile)
+	if result.Error == nil {
+		if err := h.removeHostedFile(mirror, &npmFile); err != nil {
+			return err
+		}
+	} else if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("查询托管文件失败: %v", result.Error)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+	return nil
+}
+
+// deleteHostedPackage 删除整个包，对应 npm unpublish <pkg> --force
+func (h *NpmHandler) deleteHostedPackage(c *gin.Context, mirror *models.Mirror, name string) error {
+	unlock, err := lockHosted(mirror)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := h.removeHostedPackage(mirror, name); err != nil {
+		return err
+	}
+
+	logger.GetLogger().Info("删除本地托管包", zap.String("package", name))
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+	return nil
+}
+
+// handleDistTags 处理 npm dist-tag 的查询、添加和删除
+func (h *NpmHandler) handleDistTags(c *gin.Context, mirror *models.Mirror, name, tag string) error {
+	if c.Request.Method != http.MethodGet {
+		unlock, err := lockHosted(mirror)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	packument, _, err := h.loadHostedPackument(mirror, name)
+	if err != nil {
+		return err
+	}
+	if packument == nil {
+		return h.hostedError(c, http.StatusNotFound, "not_found")
+	}
+	distTags, _ := packument["dist-tags"].(map[string]interface{})
+	if distTags == nil {
+		distTags = map[string]interface{}{}
+	}
+
+	switch c.Request.Method {
+	case http.MethodGet:
+		c.JSON(http.StatusOK, distTags)
+		return nil
+	case http.MethodPut, http.MethodPost:
+		var version string
+		if err := json.NewDecoder(c.Request.Body).Decode(&version); err != nil || tag == "" {
+			return h.hostedError(c, http.StatusBadRequest, "无效的标签")
+		}
+		versions, _ := packument["versions"].(map[string]interface{})
+		if _, ok := versions[version]; !ok {
+			return h.hostedError(c, http.StatusNotFound, "版本不存在: "+version)
+		}
+		distTags[tag] = version
+	case http.MethodDelete:
+		if tag == "latest" {
+			return h.hostedError(c, http.StatusBadRequest, "不能删除 latest 标签")
+		}
+		delete(distTags, tag)
+	default:
+		return h.hostedError(c, http.StatusMethodNotAllowed, "method_not_allowed")
+	}
+
+	packument["dist-tags"] = distTags
+	if _, err := h.saveHostedPackument(mirror, name, packument); err != nil {
+		return err
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+	return nil
+}
+
+// loadHostedPackument 读取本地托管包的元数据，包不存在时返回 nil
+func (h *NpmHandler) loadHostedPackument(mirror *models.Mirror, name string) (map[string]interface{}, *models.NPMFile, error) {
+	var npmFile models.NPMFile
+	result := database.DB.Where("mirror_id = ? AND package_id = ? AND file_type = ? AND is_hosted = ?",
+		mirror.ID, name, models.NPMFileTypeJSON, true).First(&npmFile)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("查询托管包失败: %v", result.Error)
+	}
+
+	data, err := readCachedFile(mirror, npmFile.SavePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("读取托管包元数据失败: %v", err)
+	}
+
+	var packument map[string]interface{}
+	if err := json.Unmarshal(data, &packument); err != nil {
+		return nil, nil, fmt.Errorf("解析托管包元数据失败: %v", err)
+	}
+	if _, ok := packument["versions"].(map[string]interface{}); !ok {
+		packument["versions"] = map[string]interface{}{}
+	}
+	return packument, &npmFile, nil
+}
+
+// saveHostedPackument 保存本地托管包的元数据并更新修订号，返回新的修订号
+func (h *NpmHandler) saveHostedPackument(mirror *models.Mirror, name string, packument map[string]interface{}) (string, error) {
+	// 修订号格式与 CouchDB 一致: <序号>-<摘要>
+	seq := 0
+	if current, ok := packument["_rev"].(string); ok {
+		seq, _ = strconv.Atoi(strings.SplitN(current, "-", 2)[0])
+	}
+	delete(packument, "_rev")
+	content, err := json.Marshal(packument)
+	if err != nil {
+		return "", fmt.Errorf("序列化托管包元数据失败: %v", err)
+	}
+	sum := sha1.Sum(content)
+	rev := fmt.Sprintf("%d-%s", seq+1, hex.EncodeToString(sum[:8]))
+	packument["_rev"] = rev
+
+	content, err = json.MarshalIndent(packument, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("序列化托管包元数据失败: %v", err)
+	}
+
+	savePath := filepath.Join(mirror.BlobPath, npmHostedDir, name, "package.json")
+	size, _, err := saveToHostedFile(mirror, savePath, bytes.NewReader(content))
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	var npmFile models.NPMFile
+	result := database.DB.Where("mirror_id = ? AND package_id = ? AND file_type = ? AND is_hosted = ?",
+		mirror.ID, name, models.NPMFileTypeJSON, true).First(&npmFile)
+	if result.Error != nil && !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return "", fmt.Errorf("查询托管包失败: %v", result.Error)
+	}
+	if result.Error != nil {
+		npmFile = models.NPMFile{
+			MirrorID:     mirror.ID,
+			PackageID:    name,
+			FileName:     "package.json",
+			FileType:     models.NPMFileTypeJSON,
+			SavePath:     savePath,
+			IsHosted:     true,
+			LastUsedTime: now,
+		}
+	}
+	npmFile.FileSize = size
+	npmFile.DownloadedAt = now
+	if err := database.DB.Save(&npmFile).Error; err != nil {
+		return "", fmt.Errorf("保存文件记录失败: %v", err)
+	}
+
+	return rev, nil
+}
+
+// removeHostedVersion 删除本地托管包某个版本的 tarball
+func (h *NpmHandler) removeHostedVersion(mirror *models.Mirror, name, version string) error {
+	var files []models.NPMFile
+	if err := database.DB.Where("mirror_id = ? AND package_id = ? AND version = ? AND file_type = ? AND is_hosted = ?",
+		mirror.ID, name, version, models.NPMFileTypeTarball, true).Find(&files).Error; err != nil {
+		return fmt.Errorf("查询托管文件失败: %v", err)
+	}
+	for i := range files {
+		if err := h.removeHostedFile(mirror, &files[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeHostedPackage 删除本地托管包的所有文件和记录
+func (h *NpmHandler) removeHostedPackage(mirror *models.Mirror, name string) error {
+	var files []models.NPMFile
+	if err := database.DB.Where("mirror_id = ? AND package_id = ? AND is_hosted = ?",
+		mirror.ID, name, true).Find(&files).Error; err != nil {
+		return fmt.Errorf("查询托管文件失败: %v", err)
+	}
+	for i := range files {
+		if err := h.removeHostedFile(mirror, &files[i]); err != nil {
+			return err
+		}
+	}
+	return storage.ForMirror(mirror).DeletePrefix(npmHostedDir + "/" + name)
+}
+
+// removeHostedFile 删除托管文件及其记录
+func (h *NpmHandler) removeHostedFile(mirror *models.Mirror, npmFile *models.NPMFile) error {
+	if err := removeCachedFile(mirror, npmFile.SavePath); err != nil {
+		return fmt.Errorf("删除托管文件失败: %v", err)
+	}
+	if err := database.DB.Delete(npmFile).Error; err != nil {
+		return fmt.Errorf("删除托管文件记录失败: %v", err)
+	}
+	return nil
+}
+
+// touchHostedFile 更新托管文件的使用时间和镜像的命中计数
+func (h *NpmHandler) touchHostedFile(mirror *models.Mirror, npmFile *models.NPMFile) {
+	log := logger.GetLogger()
+
+	if err := database.DB.Model(npmFile).Update("last_used_time", time.Now()).Error; err != nil {
+		log.Error("更新文件使用时间失败", zap.Error(err))
+	}
+	if err := updateMirrorCounts(mirror, true); err != nil {
+		log.Error("更新缓存命中计数失败", zap.Error(err))
+	}
+}
+
+// hostedError 以 npm 客户端能识别的格式返回错误
+func (h *NpmHandler) hostedError(c *gin.Context, status int, message string) error {
+	c.JSON(status, gin.H{"error": message})
+	return nil
+}
@@ -0,0 +1,92 @@
+package registry
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"easyCacheMirror/internal/models"
+)
+
+// npmPublishBody 生成 npm publish 提交的内容，shasum 为空时使用 tarball 的实际值
+func npmPublishBody(name, version, tarball, shasum string) string {
+	if shasum == "" {
+		sum := sha1.Sum([]byte(tarball))
+		shasum = hex.EncodeToString(sum[:])
+	}
+	fileName := name[strings.LastIndex(name, "/")+1:] + "-" + version + ".tgz"
+	body, _ := json.Marshal(map[string]interface{}{
+		"name":      name,
+		"dist-tags": map[string]string{"latest": version},
+		"versions": map[string]interface{}{
+			version: map[string]interface{}{
+				"name":    name,
+				"version": version,
+				"dist": map[string]string{
+					"tarball": "http://localhost/" + name + "/-/" + fileName,
+					"shasum":  shasum,
+				},
+			},
+		},
+		"_attachments": map[string]interface{}{
+			name + "-" + version + ".tgz": map[string]string{
+				"data": base64.StdEncoding.EncodeToString([]byte(tarball)),
+			},
+		},
+	})
+	return string(body)
+}
+
+func TestNpmHostedPublish(t *testing.T) {
+	mirror := setupRegistryStore(t)
+	mirror.Kind = models.MirrorKindHosted
+	mirror.ServiceURL = "http://mirror.local"
+	mirror.AccessURL = "npm"
+	h := NewNpmHandler()
+
+	writer := &models.User{Username: "ci", Role: models.RoleWriter}
+	viewer := &models.User{Username: "guest", Role: models.RoleViewer}
+	const name = "@ourco/lib"
+
+	steps := []struct {
+		name       string
+		method     string
+		path       string
+		user       *models.User
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"未认证不能发布", http.MethodPut, name, nil, npmPublishBody(name, "1.0.0", "v1", ""), http.StatusUnauthorized, ""},
+		{"只读用户不能发布", http.MethodPut, name, viewer, npmPublishBody(name, "1.0.0", "v1", ""), http.StatusForbidden, ""},
+		{"校验失败的包", http.MethodPut, name, writer, npmPublishBody(name, "1.0.0", "v1", strings.Repeat("0", 40)), http.StatusBadRequest, ""},
+		{"发布第一个版本", http.MethodPut, name, writer, npmPublishBody(name, "1.0.0", "v1", ""), http.StatusCreated, ""},
+		{"tarball 地址指向镜像", http.MethodGet, name, nil, "", http.StatusOK, `"tarball":"http://mirror.local/npm/@ourco/lib/-/lib-1.0.0.tgz"`},
+		{"下载已发布的 tarball", http.MethodGet, name + "/-/lib-1.0.0.tgz", nil, "", http.StatusOK, "v1"},
+		{"不能覆盖已发布的版本", http.MethodPut, name, writer, npmPublishBody(name, "1.0.0", "changed", ""), http.StatusForbidden, ""},
+		{"覆盖失败后内容不变", http.MethodGet, name + "/-/lib-1.0.0.tgz", nil, "", http.StatusOK, "v1"},
+		{"发布新版本", http.MethodPut, name, writer, npmPublishBody(name, "1.1.0", "v2", ""), http.StatusCreated, ""},
+		{"按标签获取版本", http.MethodGet, name + "/latest", nil, "", http.StatusOK, `"version":"1.1.0"`},
+		{"旧版本仍然保留", http.MethodGet, name + "/1.0.0", nil, "", http.StatusOK, `"version":"1.0.0"`},
+		{"不存在的包", http.MethodGet, "@ourco/missing", nil, "", http.StatusNotFound, ""},
+	}
+	for _, st := range steps {
+		w := sendRequest(h, mirror, st.method, st.path, st.user, strings.NewReader(st.body), nil)
+		if w.Code != st.wantStatus || !strings.Contains(w.Body.String(), st.wantBody) {
+			t.Fatalf("%s: status = %d, body = %s, want %d %s", st.name, w.Code, w.Body.String(), st.wantStatus, st.wantBody)
+		}
+	}
+
+	var packument map[string]interface{}
+	w := serveHandler(h, mirror, name, nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &packument); err != nil {
+		t.Fatal(err)
+	}
+	if versions, _ := packument["versions"].(map[string]interface{}); len(versions) != 2 {
+		t.Errorf("版本数量 = %d, want 2", len(versions))
+	}
+}
@@ -0,0 +1,176 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"easyCacheMirror/internal/cache"
+	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// npmMetadataUpstream 返回带 ETag 的包元数据，If-None-Match 与当前版本一致时返回 304
+type npmMetadataUpstream struct {
+	server      *httptest.Server
+	version     atomic.Value
+	status      atomic.Int32
+	requests    atomic.Int32
+	ifNoneMatch atomic.Value
+}
+
+func newNpmMetadataUpstream(t *testing.T, version string) *npmMetadataUpstream {
+	t.Helper()
+	u := &npmMetadataUpstream{}
+	u.version.Store(version)
+	u.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u.requests.Add(1)
+		u.ifNoneMatch.Store(r.Header.Get("If-None-Match"))
+		if status := u.status.Load(); status != 0 {
+			w.WriteHeader(int(status))
+			return
+		}
+		version := u.version.Load().(string)
+		etag := `"` + version + `"`
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"name":"demo","versions":{%q:{"dist":{"tarball":"%s/demo/-/demo-%s.tgz"}}}}`,
+			version, u.server.URL, version)
+	}))
+	t.Cleanup(u.server.Close)
+	return u
+}
+
+// newNpmMirror 创建指向上游的 npm 镜像并加入镜像缓存，CacheTime 为 0 时每次请求都向上游重新验证
+func newNpmMirror(t *testing.T, name, upstreamURL string, staleIfError int) *models.Mirror {
+	t.Helper()
+	mirror := &models.Mirror{
+		Name:         name,
+		Type:         "NPM",
+		UpstreamURL:  upstreamURL,
+		ServiceURL:   "http://mirror.example.com/",
+		AccessURL:    "npm",
+		StaleIfError: staleIfError,
+		BlobPath:     t.TempDir(),
+	}
+	if err := database.DB.Create(mirror).Error; err != nil {
+		t.Fatal(err)
+	}
+	cache.GetMirrorCache().Set(mirror)
+	t.Cleanup(func() { cache.GetMirrorCache().Remove(mirror) })
+	return mirror
+}
+
+func TestNpmMetadataRevalidation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupRegistryStore(t)
+
+	upstream := newNpmMetadataUpstream(t, "1.0.0")
+	mirror := newNpmMirror(t, "npm", upstream.server.URL, 0)
+
+	h := NewNpmHandler()
+	const tarballURL = "http://mirror.example.com/npm/demo/-/demo-%s.tgz"
+
+	steps := []struct {
+		name    string
+		version string
+		// header 为客户端请求头
+		header          http.Header
+		wantBody        string
+		wantIfNoneMatch string
+	}{
+		{
+			name:            "第一次获取不转发客户端的条件请求头",
+			version:         "1.0.0",
+			header:          http.Header{"If-None-Match": {`"client"`}},
+			wantBody:        fmt.Sprintf(tarballURL, "1.0.0"),
+			wantIfNoneMatch: "",
+		},
+		{
+			name:            "上游返回 304 时使用缓存",
+			version:         "1.0.0",
+			wantBody:        fmt.Sprintf(tarballURL, "1.0.0"),
+			wantIfNoneMatch: `"1.0.0"`,
+		},
+		{
+			name:            "上游更新后返回新的元数据",
+			version:         "2.0.0",
+			wantBody:        fmt.Sprintf(tarballURL, "2.0.0"),
+			wantIfNoneMatch: `"1.0.0"`,
+		},
+		{
+			name:            "使用更新后的 ETag 重新验证",
+			version:         "2.0.0",
+			wantBody:        fmt.Sprintf(tarballURL, "2.0.0"),
+			wantIfNoneMatch: `"2.0.0"`,
+		},
+	}
+	for _, st := range steps {
+		upstream.version.Store(st.version)
+		before := upstream.requests.Load()
+
+		w := serveHandler(h, mirror, "demo", st.header)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), st.wantBody) {
+			t.Fatalf("%s: status = %d, body = %s, want %s", st.name, w.Code, w.Body.String(), st.wantBody)
+		}
+		// 每次请求都只向上游发起一次请求
+		if got := upstream.requests.Load() - before; got != 1 {
+			t.Errorf("%s: 上游收到 %d 次请求, want 1", st.name, got)
+		}
+		if got := upstream.ifNoneMatch.Load(); got != st.wantIfNoneMatch {
+			t.Errorf("%s: If-None-Match = %q, want %q", st.name, got, st.wantIfNoneMatch)
+		}
+		if etag := w.Header().Get("ETag"); etag == `"`+st.version+`"` {
+			t.Errorf("%s: 改写后的元数据不应返回上游的 ETag %s", st.name, etag)
+		}
+
+		// 重新验证和重新获取都只更新同一条记录
+		var count int64
+		if err := database.DB.Model(&models.NPMFile{}).Where("mirror_id = ? AND file_type = ?", mirror.ID, models.NPMFileTypeJSON).Count(&count).Error; err != nil {
+			t.Fatal(err)
+		}
+		if count != 1 {
+			t.Errorf("%s: 元数据记录数量 = %d, want 1", st.name, count)
+		}
+	}
+}
+
+func TestNpmMetadataStaleIfError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupRegistryStore(t)
+
+	upstream := newNpmMetadataUpstream(t, "1.0.0")
+	stale := newNpmMirror(t, "stale", upstream.server.URL, 60)
+	strict := newNpmMirror(t, "strict", upstream.server.URL, 0)
+	h := NewNpmHandler()
+
+	steps := []struct {
+		name       string
+		mirror     *models.Mirror
+		status     int32
+		wantStatus int
+		wantBody   string
+	}{
+		{"缓存元数据", stale, 0, http.StatusOK, "demo-1.0.0.tgz"},
+		{"缓存另一个镜像的元数据", strict, 0, http.StatusOK, "demo-1.0.0.tgz"},
+		{"上游不可用时在 StaleIfError 时间内使用过期的缓存", stale, http.StatusBadGateway, http.StatusOK, "demo-1.0.0.tgz"},
+		{"没有配置 StaleIfError 时返回上游的错误", strict, http.StatusBadGateway, http.StatusBadGateway, ""},
+	}
+	for _, st := range steps {
+		upstream.status.Store(st.status)
+
+		w := serveHandler(h, st.mirror, "demo", nil)
+		if w.Code != st.wantStatus || !strings.Contains(w.Body.String(), st.wantBody) {
+			t.Fatalf("%s: status = %d, body = %s, want %d %s", st.name, w.Code, w.Body.String(), st.wantStatus, st.wantBody)
+		}
+	}
+}
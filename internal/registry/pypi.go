@@ -1,16 +1,28 @@
 package registry
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
 	"strings"
+	"time"
 
+	"easyCacheMirror/internal/database"
 	"easyCacheMirror/internal/logger"
 	"easyCacheMirror/internal/models"
 	"easyCacheMirror/internal/proxy"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	pypiSimpleJSONType = "application/vnd.pypi.simple.v1+json"
+	pypiSimpleHTMLType = "text/html; charset=utf-8"
 )
 
 type PyPiHandler struct {
@@ -53,29 +65,313 @@ func (h *PyPiHandler) Handle(c *gin.Context, mirror *models.Mirror, path string)
 		zap.String("type", requestType),
 	)
 
-	// 代理请求到上游
+	// 更新总请求计数
+	if err := updateMirrorCounts(mirror, false); err != nil {
+		log.Error("更新请求计数失败", zap.Error(err))
+	}
+
+	// 本地上传的分发包和项目
+	if h.isUploadRequest(c, path) {
+		return h.handleUpload(c, mirror)
+	}
+	if strings.HasPrefix(path, pypiHostedDir+"/") {
+		return h.serveHostedFile(c, mirror, path)
+	}
+	if requestType == "simple" {
+		format := h.simpleFormat(c.Request.Header.Get("Accept"))
+		project := normalizeProject(h.projectName(path))
+		if project != "" && (mirror.IsHosted() || h.hasHostedProject(mirror, project)) {
+			return h.serveHostedIndex(c, mirror, project, format)
+		}
+		if project == "" && mirror.IsHosted() {
+			contentType := pypiSimpleHTMLType
+			if format == "json" {
+				contentType = pypiSimpleJSONType
+			}
+			c.Data(http.StatusOK, contentType, h.mergeHostedProjects(mirror, format, nil))
+			return nil
+		}
+	}
+	// 托管镜像不访问上游
+	if mirror.IsHosted() {
+		c.String(http.StatusNotFound, "文件不存在: %s", path)
+		return nil
+	}
+
+	switch requestType {
+	case "wheel", "sdist", "egg", "zip":
+		return h.handlePackage(c, mirror, path)
+	case "simple":
+		return h.handleSimple(c, mirror, path)
+	}
+
+	// 其他请求直接转发到上游
 	resp, err := h.proxy.ProxyRequest(mirror, path, c.Request.Header)
 	if err != nil {
-		return fmt.Errorf("代理请求失败: %v", err)
+		return fmt.Errorf("代理请求失败: %w", err)
 	}
 	defer resp.Body.Close()
 
-	// 复制响应头
-	for key, values := range resp.Header {
-		for _, value := range values {
-			c.Header(key, value)
+	return copyResponse(c, resp)
+}
+
+// handleSimple 处理 simple 索引页面请求(PEP 503/691)
+func (h *PyPiHandler) handleSimple(c *gin.Context, mirror *models.Mirror, path string) error {
+	log := logger.GetLogger()
+
+	// 同一个页面可能以 HTML 或 JSON 两种格式返回，分别缓存
+	format := h.simpleFormat(c.Request.Header.Get("Accept"))
+	cacheKey := path + "." + format
+
+	var pypiFile models.PyPIFile
+	result := database.DB.Where(&models.PyPIFile{
+		MirrorID:     mirror.ID,
+		RelativePath: cacheKey,
+		FileType:     models.PyPIFileTypeIndex,
+	}).First(&pypiFile)
+	// 缓存文件被清理后记录仍然保留，重新拉取时更新这条记录
+	cached := result.Error == nil && cachedFileExists(mirror, pypiFile.SavePath)
+	if cached {
+		if !isCacheExpired(pypiFile.DownloadedAt, mirror.CacheTime) {
+			return h.serveCachedFile(c, mirror, &pypiFile)
+		}
+		log.Info("索引缓存已过期，从上游拉取",
+			zap.String("path", path),
+			zap.Time("downloaded_at", pypiFile.DownloadedAt),
+		)
+	}
+
+	headers := cacheHeaders(c.Request.Header)
+	if format == "json" {
+		headers.Set("Accept", pypiSimpleJSONType)
+	} else {
+		headers.Set("Accept", "text/html")
+	}
+
+	resp, err := h.proxy.ProxyRequest(mirror, path, headers)
+	if cached && upstreamFailed(resp, err) && canServeStale(mirror, pypiFile.DownloadedAt) {
+		log.Warn("上游不可用，使用过期的索引",
+			zap.Error(err),
+			zap.String("path", path),
+		)
+		if resp != nil {
+			resp.Body.Close()
 		}
+		return h.serveCachedFile(c, mirror, &pypiFile)
+	}
+	if err != nil {
+		return fmt.Errorf("代理请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return copyResponse(c, resp)
+	}
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("读取响应体失败: %v", err)
+	}
+
+	// 将页面中指向上游的文件链接改写为镜像地址
+	bodyBytes = h.rewriteLinks(mirror, bodyBytes)
+
+	// 根索引中加入本地上传的项目
+	if h.projectName(path) == "" {
+		bodyBytes = h.mergeHostedProjects(mirror, format, bodyBytes)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = pypiSimpleHTMLType
+	}
+
+	savePath := filepath.Join(mirror.BlobPath, path, "index."+format)
+	if err := h.saveIndexFile(mirror, cacheKey, savePath, contentType, bodyBytes, &pypiFile); err != nil {
+		log.Error("保存索引缓存失败", zap.Error(err), zap.String("path", path))
 	}
-	c.Status(resp.StatusCode)
 
-	// 复制响应体
-	if _, err := io.Copy(c.Writer, resp.Body); err != nil {
+	c.Header("Content-Type", contentType)
+	c.Status(http.StatusOK)
+	if _, err := c.Writer.Write(bodyBytes); err != nil {
 		return fmt.Errorf("写入响应失败: %v", err)
 	}
+	return nil
+}
+
+// saveIndexFile 保存索引页面并更新数据库记录
+func (h *PyPiHandler) saveIndexFile(mirror *models.Mirror, cacheKey, savePath, contentType string, bodyBytes []byte, existing *models.PyPIFile) error {
+	size, sum, err := saveToFile(mirror, savePath, bytes.NewReader(bodyBytes))
+	if err != nil {
+		return err
+	}
 
+	now := time.Now()
+	if existing.ID != 0 {
+		existing.FileSize = size
+		existing.SavePath = savePath
+		existing.ContentType = contentType
+		existing.Sha256 = sum
+		existing.DownloadedAt = now
+		if err := database.DB.Save(existing).Error; err != nil {
+			return fmt.Errorf("更新文件记录失败: %v", err)
+		}
+		return nil
+	}
+
+	pypiFile := models.PyPIFile{
+		MirrorID:     mirror.ID,
+		RelativePath: cacheKey,
+		PackageName:  h.projectName(cacheKey),
+		FileName:     filepath.Base(savePath),
+		FileType:     models.PyPIFileTypeIndex,
+		FileSize:     size,
+		SavePath:     savePath,
+		ContentType:  contentType,
+		Sha256:       sum,
+		DownloadedAt: now,
+		LastUsedTime: now,
+	}
+	if err := database.DB.Create(&pypiFile).Error; err != nil {
+		return fmt.Errorf("创建文件记录失败: %v", err)
+	}
 	return nil
 }
 
+// handlePackage 处理分发包请求，分发包内容不可变，缓存后永久有效
+func (h *PyPiHandler) handlePackage(c *gin.Context, mirror *models.Mirror, path string) error {
+	log := logger.GetLogger()
+
+	var pypiFile models.PyPIFile
+	result := database.DB.Where(&models.PyPIFile{
+		MirrorID:     mirror.ID,
+		RelativePath: path,
+		FileType:     models.PyPIFileTypePackage,
+	}).First(&pypiFile)
+	if result.Error == nil {
+		if cachedFileExists(mirror, pypiFile.SavePath) {
+			return h.serveCachedFile(c, mirror, &pypiFile)
+		}
+		// 文件已丢失，删除记录后重新拉取
+		log.Warn("缓存文件不存在，重新拉取", zap.String("path", pypiFile.SavePath))
+		database.DB.Delete(&pypiFile)
+	} else if result.Error != gorm.ErrRecordNotFound {
+		return fmt.Errorf("查询缓存文件失败: %v", result.Error)
+	}
+
+	// 边下载边返回给客户端，同一文件的并发请求共用一次拉取
+	savePath := filepath.Join(mirror.BlobPath, path)
+	return streamDownload(c, mirror, savePath,
+		func() (*http.Response, error) {
+			return h.proxy.ProxyRequest(h.packageUpstream(mirror), path, cacheHeaders(c.Request.Header))
+		},
+		nil,
+		func(header http.Header, size int64, sum string) error {
+			fileName := filepath.Base(path)
+			pypiFile := models.PyPIFile{
+				MirrorID:     mirror.ID,
+				RelativePath: path,
+				PackageName:  h.packageNameFromFile(fileName),
+				FileName:     fileName,
+				FileType:     models.PyPIFileTypePackage,
+				FileSize:     size,
+				SavePath:     savePath,
+				ContentType:  header.Get("Content-Type"),
+				Sha256:       sum,
+				DownloadedAt: time.Now(),
+				LastUsedTime: time.Now(),
+			}
+			if err := database.DB.Create(&pypiFile).Error; err != nil {
+				return fmt.Errorf("保存文件记录失败: %v", err)
+			}
+
+			log.Debug("分发包已缓存",
+				zap.String("path", path),
+				zap.Int64("size", size),
+				zap.String("sha256", sum),
+			)
+			return nil
+		},
+	)
+}
+
+// serveCachedFile 从缓存提供文件
+func (h *PyPiHandler) serveCachedFile(c *gin.Context, mirror *models.Mirror, file *models.PyPIFile) error {
+	log := logger.GetLogger()
+
+	log.Debug("命中缓存",
+		zap.String("path", file.RelativePath),
+		zap.String("mirror", mirror.Name),
+	)
+
+	if err := database.DB.Model(file).Update("last_used_time", time.Now()).Error; err != nil {
+		log.Error("更新文件使用时间失败", zap.Error(err))
+	}
+
+	if err := updateMirrorCounts(mirror, true); err != nil {
+		log.Error("更新缓存命中计数失败", zap.Error(err))
+	}
+
+	return serveLocalFile(c, mirror, file.SavePath, file.ContentType)
+}
+
+// rewriteLinks 将索引页面中的上游文件地址改写为镜像地址
+func (h *PyPiHandler) rewriteLinks(mirror *models.Mirror, bodyBytes []byte) []byte {
+	baseURL := []byte(mirrorBaseURL(mirror) + "/")
+	upstreams := []string{models.DefaultPyPIFilesHost}
+	for _, upstream := range mirror.UpstreamList() {
+		upstreams = append(upstreams, strings.TrimRight(upstream.URL, "/"))
+	}
+	for _, upstream := range upstreams {
+		bodyBytes = bytes.ReplaceAll(bodyBytes, []byte(upstream+"/"), baseURL)
+	}
+	return bodyBytes
+}
+
+// packageUpstream 返回分发包所在的上游
+// pypi.org 的分发包托管在 files.pythonhosted.org 上，其他镜像站一般与索引同源
+func (h *PyPiHandler) packageUpstream(mirror *models.Mirror) *models.Mirror {
+	upstream, err := url.Parse(mirror.UpstreamURL)
+	if err == nil && upstream.Host == strings.TrimPrefix(models.DefaultPyPIIndex, "https://") {
+		return withUpstream(mirror, models.DefaultPyPIFilesHost)
+	}
+	return mirror
+}
+
+// simpleFormat 根据 Accept 头判断客户端需要的索引格式
+func (h *PyPiHandler) simpleFormat(accept string) string {
+	if strings.Contains(accept, pypiSimpleJSONType) {
+		return "json"
+	}
+	return "html"
+}
+
+// projectName 从 simple 索引路径中提取项目名
+func (h *PyPiHandler) projectName(cacheKey string) string {
+	path := strings.TrimSuffix(strings.TrimSuffix(cacheKey, ".json"), ".html")
+	path = strings.Trim(strings.TrimPrefix(path, "simple"), "/")
+	return path
+}
+
+// packageNameFromFile 从分发包文件名中提取包名
+func (h *PyPiHandler) packageNameFromFile(fileName string) string {
+	// wheel 文件名格式: {name}-{version}-{python}-{abi}-{platform}.whl
+	if strings.HasSuffix(fileName, ".whl") {
+		if idx := strings.Index(fileName, "-"); idx > 0 {
+			return fileName[:idx]
+		}
+	}
+
+	// sdist 文件名格式: {name}-{version}.tar.gz
+	for _, ext := range []string{".tar.gz", ".zip", ".egg"} {
+		fileName = strings.TrimSuffix(fileName, ext)
+	}
+	if idx := strings.LastIndex(fileName, "-"); idx > 0 {
+		return fileName[:idx]
+	}
+	return fileName
+}
+
 // getRequestType 判断PyPI请求的类型
 func (h *PyPiHandler) getRequestType(path string) string {
 	switch {
@@ -89,7 +385,7 @@ func (h *PyPiHandler) getRequestType(path string) string {
 		return "egg"
 	case strings.HasSuffix(path, ".zip"):
 		return "zip"
-	case strings.Contains(path, "simple/"):
+	case strings.Contains(path, "simple/"), path == "simple":
 		return "simple"
 	default:
 		return "other"
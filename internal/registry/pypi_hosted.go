@@ -0,0 +1,352 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/logger"
+	"easyCacheMirror/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// pypiHostedDir 本地上传的分发包在镜像中的路径前缀
+const pypiHostedDir = "hosted"
+
+// pypiNameSeparators PEP 503 规范化项目名时合并的分隔符
+var pypiNameSeparators = regexp.MustCompile(`[-_.]+`)
+
+// normalizeProject 按 PEP 503 规范化项目名
+func normalizeProject(name string) string {
+	return strings.ToLower(pypiNameSeparators.ReplaceAllString(name, "-"))
+}
+
+// isUploadRequest 判断是否为 twine 使用的 legacy 上传接口
+func (h *PyPiHandler) isUploadRequest(c *gin.Context, path string) bool {
+	return c.Request.Method == http.MethodPost && (path == "" || path == "legacy")
+}
+
+// handleUpload 处理 twine upload 提交的分发包
+func (h *PyPiHandler) handleUpload(c *gin.Context, mirror *models.Mirror) error {
+	log := logger.GetLogger()
+
+	if status, message := checkWriteAccess(c, mirror); status != 0 {
+		c.String(status, message)
+		return nil
+	}
+
+	if action := c.PostForm(":action"); action != "file_upload" {
+		c.String(http.StatusBadRequest, "不支持的操作: %s", action)
+		return nil
+	}
+
+	name := c.PostForm("name")
+	version := c.PostForm("version")
+	fileHeader, err := c.FormFile("content")
+	if name == "" || version == "" || err != nil {
+		c.String(http.StatusBadRequest, "缺少包名、版本号或分发包文件")
+		return nil
+	}
+
+	fileName := fileHeader.Filename
+	project := normalizeProject(name)
+	if strings.ContainsAny(fileName, `/\`) || normalizeProject(h.packageNameFromFile(fileName)) != project {
+		c.String(http.StatusBadRequest, "文件名与包名不一致: %s", fileName)
+		return nil
+	}
+	if h.getRequestType(fileName) == "other" {
+		c.String(http.StatusBadRequest, "不支持的分发包格式: %s", fileName)
+		return nil
+	}
+
+	unlock, err := lockHosted(mirror)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	relativePath := pypiHostedDir + "/" + project + "/" + fileName
+	existing, err := h.findHostedFile(mirror, relativePath)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		c.String(http.StatusBadRequest, "文件已存在: %s", fileName)
+		return nil
+	}
+	newProject := !h.hasHostedProject(mirror, project)
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return fmt.Errorf("读取上传文件失败: %v", err)
+	}
+	defer file.Close()
+
+	savePath := filepath.Join(mirror.BlobPath, pypiHostedDir, project, fileName)
+	size, sum, err := saveToHostedFile(mirror, savePath, file)
+	if err != nil {
+		return err
+	}
+	if digest := c.PostForm("sha256_digest"); digest != "" && !strings.EqualFold(digest, sum) {
+		removeCachedFile(mirror, savePath)
+		c.String(http.StatusBadRequest, "sha256 校验失败: %s", fileName)
+		return nil
+	}
+
+	now := time.Now()
+	pypiFile := models.PyPIFile{
+		MirrorID:       mirror.ID,
+		RelativePath:   relativePath,
+		PackageName:    project,
+		FileName:       fileName,
+		FileType:       models.PyPIFileTypePackage,
+		FileSize:       size,
+		SavePath:       savePath,
+		ContentType:    "application/octet-stream",
+		Sha256:         sum,
+		Version:        version,
+		RequiresPython: c.PostForm("requires_python"),
+		IsHosted:       true,
+		DownloadedAt:   now,
+		LastUsedTime:   now,
+	}
+	if err := database.DB.Create(&pypiFile).Error; err != nil {
+		removeCachedFile(mirror, savePath)
+		return fmt.Errorf("保存文件记录失败: %v", err)
+	}
+
+	// 新项目需要出现在根索引中，丢弃缓存的根索引
+	if newProject {
+		h.invalidateRootIndex(mirror)
+	}
+
+	log.Info("上传分发包",
+		zap.String("mirror", mirror.Name),
+		zap.String("project", project),
+		zap.String("version", version),
+		zap.String("file", fileName),
+		zap.Int64("size", size),
+	)
+
+	c.String(http.StatusOK, "OK")
+	return nil
+}
+
+// serveHostedFile 从本地提供上传的分发包
+func (h *PyPiHandler) serveHostedFile(c *gin.Context, mirror *models.Mirror, path string) error {
+	pypiFile, err := h.findHostedFile(mirror, path)
+	if err != nil {
+		return err
+	}
+	if pypiFile == nil {
+		c.String(http.StatusNotFound, "文件不存在: %s", path)
+		return nil
+	}
+	return h.serveCachedFile(c, mirror, pypiFile)
+}
+
+// serveHostedIndex 生成本地上传项目的 simple 页面(PEP 503/691)
+func (h *PyPiHandler) serveHostedIndex(c *gin.Context, mirror *models.Mirror, project, format string) error {
+	var files []models.PyPIFile
+	if err := database.DB.Where("mirror_id = ? AND package_name = ? AND file_type = ? AND is_hosted = ?",
+		mirror.ID, project, models.PyPIFileTypePackage, true).
+		Order("file_name asc").Find(&files).Error; err != nil {
+		return fmt.Errorf("查询本地上传的分发包失败: %v", err)
+	}
+	if len(files) == 0 {
+		c.String(http.StatusNotFound, "项目不存在: %s", project)
+		return nil
+	}
+
+	if err := updateMirrorCounts(mirror, true); err != nil {
+		logger.GetLogger().Error("更新缓存命中计数失败", zap.Error(err))
+	}
+
+	baseURL := mirrorBaseURL(mirror) + "/"
+	if format == "json" {
+		type projectFile struct {
+			FileName       string            `json:"filename"`
+			URL            string            `json:"url"`
+			Hashes         map[string]string `json:"hashes"`
+			RequiresPython string            `json:"requires-python,omitempty"`
+			Size           int64             `json:"size"`
+			UploadTime     string            `json:"upload-time"`
+		}
+
+		page := struct {
+			Meta     map[string]string `json:"meta"`
+			Name     string            `json:"name"`
+			Files    []projectFile     `json:"files"`
+			Versions []string          `json:"versions"`
+		}{
+			Meta: map[string]string{"api-version": "1.1"},
+			Name: project,
+		}
+		seen := make(map[string]bool)
+		for _, file := range files {
+			page.Files = append(page.Files, projectFile{
+				FileName:       file.FileName,
+				URL:            baseURL + file.RelativePath,
+				Hashes:         map[string]string{"sha256": file.Sha256},
+				RequiresPython: file.RequiresPython,
+				Size:           file.FileSize,
+				UploadTime:     file.DownloadedAt.UTC().Format("2006-01-02T15:04:05.000000Z"),
+			})
+			if !seen[file.Version] {
+				seen[file.Version] = true
+				page.Versions = append(page.Versions, file.Version)
+			}
+		}
+
+		body, err := json.Marshal(page)
+		if err != nil {
+			return fmt.Errorf("生成索引失败: %v", err)
+		}
+		c.Data(http.StatusOK, pypiSimpleJSONType, body)
+		return nil
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "<!DOCTYPE html>\n<html>\n<head>\n<meta name=\"pypi:repository-version\" content=\"1.1\">\n<title>Links for %s</title>\n</head>\n<body>\n<h1>Links for %s</h1>\n", project, project)
+	for _, file := range files {
+		requiresPython := ""
+		if file.RequiresPython != "" {
+			requiresPython = fmt.Sprintf(` data-requires-python="%s"`, html.EscapeString(file.RequiresPython))
+		}
+		fmt.Fprintf(&buf, "<a href=\"%s#sha256=%s\"%s>%s</a><br/>\n",
+			html.EscapeString(baseURL+file.RelativePath), file.Sha256, requiresPython, html.EscapeString(file.FileName))
+	}
+	buf.WriteString("</body>\n</html>\n")
+
+	c.Data(http.StatusOK, pypiSimpleHTMLType, buf.Bytes())
+	return nil
+}
+
+// mergeHostedProjects 将本地上传的项目加入根索引，body 为空时生成只包含本地项目的索引
+func (h *PyPiHandler) mergeHostedProjects(mirror *models.Mirror, format string, body []byte) []byte {
+	log := logger.GetLogger()
+
+	projects, err := h.hostedProjects(mirror)
+	if err != nil {
+		log.Error("查询本地上传的项目失败", zap.Error(err))
+		return body
+	}
+	if len(projects) == 0 && body != nil {
+		return body
+	}
+
+	if format == "json" {
+		index := map[string]interface{}{
+			"meta":     map[string]string{"api-version": "1.0"},
+			"projects": []interface{}{},
+		}
+		if body != nil {
+			if err := json.Unmarshal(body, &index); err != nil {
+				log.Warn("解析上游根索引失败", zap.Error(err))
+				return body
+			}
+		}
+
+		list, _ := index["projects"].([]interface{})
+		seen := make(map[string]bool, len(list))
+		for _, item := range list {
+			if entry, ok := item.(map[string]interface{}); ok {
+				if name, ok := entry["name"].(string); ok {
+					seen[normalizeProject(name)] = true
+				}
+			}
+		}
+		for _, project := range projects {
+			if !seen[project] {
+				list = append(list, map[string]string{"name": project})
+			}
+		}
+		index["projects"] = list
+
+		merged, err := json.Marshal(index)
+		if err != nil {
+			log.Error("生成根索引失败", zap.Error(err))
+			return body
+		}
+		return merged
+	}
+
+	if body == nil {
+		body = []byte("<!DOCTYPE html>\n<html>\n<head>\n<meta name=\"pypi:repository-version\" content=\"1.0\">\n<title>Simple index</title>\n</head>\n<body>\n</body>\n</html>\n")
+	}
+
+	var links bytes.Buffer
+	baseURL := mirrorBaseURL(mirror) + "/simple/"
+	for _, project := range projects {
+		fmt.Fprintf(&links, "<a href=\"%s%s/\">%s</a>\n", baseURL, project, project)
+	}
+
+	idx := bytes.LastIndex(body, []byte("</body>"))
+	if idx == -1 {
+		return append(body, links.Bytes()...)
+	}
+	merged := make([]byte, 0, len(body)+links.Len())
+	merged = append(merged, body[:idx]...)
+	merged = append(merged, links.Bytes()...)
+	return append(merged, body[idx:]...)
+}
+
+// hostedProjects 返回本地上传过分发包的项目名
+func (h *PyPiHandler) hostedProjects(mirror *models.Mirror) ([]string, error) {
+	var projects []string
+	if err := database.DB.Model(&models.PyPIFile{}).
+		Where("mirror_id = ? AND file_type = ? AND is_hosted = ?", mirror.ID, models.PyPIFileTypePackage, true).
+		Distinct().Pluck("package_name", &projects).Error; err != nil {
+		return nil, err
+	}
+	sort.Strings(projects)
+	return projects, nil
+}
+
+// hasHostedProject 判断项目是否有本地上传的分发包
+// 本地上传的项目只使用本地的分发包，避免被上游同名项目覆盖
+func (h *PyPiHandler) hasHostedProject(mirror *models.Mirror, project string) bool {
+	var count int64
+	database.DB.Model(&models.PyPIFile{}).
+		Where("mirror_id = ? AND package_name = ? AND file_type = ? AND is_hosted = ?",
+			mirror.ID, project, models.PyPIFileTypePackage, true).
+		Count(&count)
+	return count > 0
+}
+
+// invalidateRootIndex 删除缓存的根索引，下次请求时重新拉取并合并本地项目
+func (h *PyPiHandler) invalidateRootIndex(mirror *models.Mirror) {
+	var files []models.PyPIFile
+	database.DB.Where("mirror_id = ? AND file_type = ? AND relative_path IN ?",
+		mirror.ID, models.PyPIFileTypeIndex, []string{"simple.html", "simple.json"}).Find(&files)
+	for i := range files {
+		removeCachedFile(mirror, files[i].SavePath)
+		database.DB.Delete(&files[i])
+	}
+}
+
+// findHostedFile 查找本地上传的分发包记录，不存在时返回 nil
+func (h *PyPiHandler) findHostedFile(mirror *models.Mirror, path string) (*models.PyPIFile, error) {
+	var pypiFile models.PyPIFile
+	result := database.DB.Where("mirror_id = ? AND relative_path = ? AND is_hosted = ?",
+		mirror.ID, path, true).First(&pypiFile)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("查询本地上传的分发包失败: %v", result.Error)
+	}
+	return &pypiFile, nil
+}
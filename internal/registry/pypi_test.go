@@ -0,0 +1,221 @@
+package registry
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+
+	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/models"
+)
+
+func TestPyPISimpleStale(t *testing.T) {
+	mirror := setupRegistryStore(t)
+	mirror.StaleIfError = 60
+	upstream := newStaticUpstream(t, map[string]string{"simple/demo/": "<a href=\"demo-1.0.tar.gz\">v1</a>"})
+	mirror.UpstreamURL = upstream.server.URL
+	h := NewPyPiHandler()
+	const path = "simple/demo/"
+
+	steps := []struct {
+		name       string
+		setup      func()
+		wantStatus int
+		wantBody   string
+	}{
+		{"第一次获取", nil, http.StatusOK, "v1"},
+		{
+			name:       "上游不可用时使用过期的索引",
+			setup:      func() { upstream.status.Store(http.StatusBadGateway) },
+			wantStatus: http.StatusOK,
+			wantBody:   "v1",
+		},
+		{
+			name: "缓存文件已被清理时不使用过期的索引",
+			setup: func() {
+				var file models.PyPIFile
+				if err := database.DB.Where("mirror_id = ?", mirror.ID).First(&file).Error; err != nil {
+					t.Fatal(err)
+				}
+				if err := removeCachedFile(mirror, file.SavePath); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantStatus: http.StatusBadGateway,
+		},
+		{
+			name: "上游恢复后重新缓存",
+			setup: func() {
+				upstream.status.Store(0)
+				upstream.set(path, "<a href=\"demo-2.0.tar.gz\">v2</a>")
+			},
+			wantStatus: http.StatusOK,
+			wantBody:   "v2",
+		},
+	}
+	for _, st := range steps {
+		if st.setup != nil {
+			st.setup()
+		}
+		w := serveHandler(h, mirror, path, http.Header{"Accept": {"text/html"}})
+		if w.Code != st.wantStatus || !strings.Contains(w.Body.String(), st.wantBody) {
+			t.Fatalf("%s: status = %d, body = %q, want %d %q", st.name, w.Code, w.Body.String(), st.wantStatus, st.wantBody)
+		}
+	}
+
+	var count int64
+	database.DB.Model(&models.PyPIFile{}).Where("mirror_id = ?", mirror.ID).Count(&count)
+	if count != 1 {
+		t.Errorf("索引记录数量 = %d, want 1", count)
+	}
+}
+
+func TestPyPICaching(t *testing.T) {
+	mirror := setupRegistryStore(t)
+	upstream := newStaticUpstream(t, map[string]string{
+		"packages/demo-1.0-py3-none-any.whl": "wheel",
+		"packages/demo-1.0.tar.gz":           "sdist",
+	})
+	upstream.set("simple/demo/", "<a href=\""+upstream.server.URL+"/packages/demo-1.0.tar.gz\">demo-1.0.tar.gz</a>")
+	mirror.UpstreamURL = upstream.server.URL
+	mirror.ServiceURL = "http://mirror.local"
+	mirror.AccessURL = "pypi"
+	mirror.CacheTime = 10
+	h := NewPyPiHandler()
+
+	steps := []struct {
+		name   string
+		path   string
+		accept string
+		// wantBody 为响应中应包含的内容
+		wantBody     string
+		wantRequests int32
+	}{
+		{"第一次获取索引并改写链接", "simple/demo/", "text/html", "http://mirror.local/pypi/packages/demo-1.0.tar.gz", 1},
+		{"缓存时间内使用缓存的索引", "simple/demo/", "text/html", "http://mirror.local/pypi/packages/demo-1.0.tar.gz", 0},
+		{"JSON 格式的索引单独缓存", "simple/demo/", pypiSimpleJSONType, "http://mirror.local/pypi/packages/demo-1.0.tar.gz", 1},
+		{"第一次下载 wheel", "packages/demo-1.0-py3-none-any.whl", "", "wheel", 1},
+		{"再次下载 wheel 使用缓存", "packages/demo-1.0-py3-none-any.whl", "", "wheel", 0},
+		{"第一次下载 sdist", "packages/demo-1.0.tar.gz", "", "sdist", 1},
+		{"再次下载 sdist 使用缓存", "packages/demo-1.0.tar.gz", "", "sdist", 0},
+	}
+	for _, st := range steps {
+		before := upstream.requests.Load()
+		w := serveHandler(h, mirror, st.path, http.Header{"Accept": {st.accept}})
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), st.wantBody) {
+			t.Fatalf("%s: status = %d, body = %q, want %q", st.name, w.Code, w.Body.String(), st.wantBody)
+		}
+		if got := upstream.requests.Load() - before; got != st.wantRequests {
+			t.Errorf("%s: 上游收到 %d 次请求, want %d", st.name, got, st.wantRequests)
+		}
+	}
+
+	var file models.PyPIFile
+	if err := database.DB.Where("mirror_id = ? AND relative_path = ?", mirror.ID, "packages/demo-1.0-py3-none-any.whl").First(&file).Error; err != nil {
+		t.Fatal(err)
+	}
+	if file.PackageName != "demo" || file.FileType != models.PyPIFileTypePackage {
+		t.Errorf("文件记录 = %+v", file)
+	}
+}
+
+func TestPyPIPackageNameFromFile(t *testing.T) {
+	h := NewPyPiHandler()
+	tests := []struct {
+		fileName string
+		want     string
+	}{
+		{"requests-2.31.0-py3-none-any.whl", "requests"},
+		{"requests-2.31.0.tar.gz", "requests"},
+		{"python-dateutil-2.8.2.tar.gz", "python-dateutil"},
+		{"demo-1.0.zip", "demo"},
+	}
+	for _, tt := range tests {
+		if got := h.packageNameFromFile(tt.fileName); got != tt.want {
+			t.Errorf("packageNameFromFile(%q) = %q, want %q", tt.fileName, got, tt.want)
+		}
+	}
+}
+
+// twineUpload 生成 twine upload 提交的表单，digest 为空时不携带 sha256_digest
+func twineUpload(name, version, fileName, content, digest string) (*bytes.Buffer, http.Header) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField(":action", "file_upload")
+	form.WriteField("name", name)
+	form.WriteField("version", version)
+	if digest != "" {
+		form.WriteField("sha256_digest", digest)
+	}
+	part, _ := form.CreateFormFile("content", fileName)
+	part.Write([]byte(content))
+	form.Close()
+	return &body, http.Header{"Content-Type": {form.FormDataContentType()}}
+}
+
+func TestPyPIUpload(t *testing.T) {
+	mirror := setupRegistryStore(t)
+	upstream := newStaticUpstream(t, map[string]string{
+		"simple/": "<html><body><a href=\"/simple/requests/\">requests</a></body></html>",
+	})
+	mirror.UpstreamURL = upstream.server.URL
+	mirror.ServiceURL = "http://mirror.local"
+	mirror.AccessURL = "pypi"
+	mirror.CacheTime = 10
+	h := NewPyPiHandler()
+
+	writer := &models.User{Username: "ci", Role: models.RoleWriter}
+	viewer := &models.User{Username: "guest", Role: models.RoleViewer}
+	const fileName = "our_lib-1.0.tar.gz"
+
+	uploads := []struct {
+		name       string
+		user       *models.User
+		project    string
+		fileName   string
+		digest     string
+		wantStatus int
+	}{
+		{"未认证不能上传", nil, "Our_Lib", fileName, "", http.StatusUnauthorized},
+		{"只读用户不能上传", viewer, "Our_Lib", fileName, "", http.StatusForbidden},
+		{"上传分发包", writer, "Our_Lib", fileName, strings.TrimPrefix(sha256Digest("sdist"), "sha256:"), http.StatusOK},
+		{"不能覆盖已上传的文件", writer, "Our_Lib", fileName, "", http.StatusBadRequest},
+		{"sha256 校验失败", writer, "Our_Lib", "our_lib-1.1.tar.gz", strings.Repeat("0", 64), http.StatusBadRequest},
+		{"文件名与包名不一致", writer, "Our_Lib", "other-1.0.tar.gz", "", http.StatusBadRequest},
+	}
+	for _, up := range uploads {
+		body, header := twineUpload(up.project, "1.0", up.fileName, "sdist", up.digest)
+		w := sendRequest(h, mirror, http.MethodPost, "legacy", up.user, body, header)
+		if w.Code != up.wantStatus {
+			t.Fatalf("%s: status = %d, body = %s, want %d", up.name, w.Code, w.Body.String(), up.wantStatus)
+		}
+	}
+
+	steps := []struct {
+		name         string
+		path         string
+		wantBody     []string
+		wantRequests int32
+	}{
+		{"本地项目的索引不访问上游", "simple/our-lib/", []string{"http://mirror.local/pypi/hosted/our-lib/our_lib-1.0.tar.gz#sha256="}, 0},
+		{"根索引合并本地项目", "simple/", []string{"/simple/requests/", "http://mirror.local/pypi/simple/our-lib/"}, 1},
+		{"下载上传的分发包", "hosted/our-lib/" + fileName, []string{"sdist"}, 0},
+	}
+	for _, st := range steps {
+		before := upstream.requests.Load()
+		w := serveHandler(h, mirror, st.path, http.Header{"Accept": {"text/html"}})
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, body = %s", st.name, w.Code, w.Body.String())
+		}
+		for _, want := range st.wantBody {
+			if !strings.Contains(w.Body.String(), want) {
+				t.Errorf("%s: body = %s, want %s", st.name, w.Body.String(), want)
+			}
+		}
+		if got := upstream.requests.Load() - before; got != st.wantRequests {
+			t.Errorf("%s: 上游收到 %d 次请求, want %d", st.name, got, st.wantRequests)
+		}
+	}
+}
@@ -2,15 +2,26 @@ package registry
 
 import (
 	"fmt"
-	"io"
+	"net/http"
+	"path"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"time"
 
+	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/logger"
 	"easyCacheMirror/internal/models"
 	"easyCacheMirror/internal/proxy"
 
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
+// rPackageFile 匹配包文件名，例如 ggplot2_3.5.1.tar.gz、Rcpp_1.0.12.zip
+var rPackageFile = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9.]*)_([0-9][0-9.\-]*)\.(tar\.gz|tgz|zip)$`)
+
 type RHandler struct {
 	BaseHandler
 	proxy *proxy.Proxy
@@ -41,6 +52,8 @@ func (h *RHandler) SupportedType() string {
 }
 
 func (h *RHandler) Handle(c *gin.Context, mirror *models.Mirror, path string) error {
+	log := logger.GetLogger()
+
 	// 添加更详细的初始化检查
 	if h == nil {
 		return fmt.Errorf("handler 未初始化")
@@ -59,36 +72,293 @@ func (h *RHandler) Handle(c *gin.Context, mirror *models.Mirror, path string) er
 	// 检查请求类型
 	requestType := h.getRequestType(path)
 
-	// 打印请求信息
-	fmt.Printf("[INFO] 处理CRAN请求: %s (类型: %s)\n", path, requestType)
+	log.Debug("处理CRAN请求",
+		zap.String("path", path),
+		zap.String("type", requestType),
+	)
+
+	// 更新总请求计数
+	if err := updateMirrorCounts(mirror, false); err != nil {
+		log.Error("更新请求计数失败", zap.Error(err))
+	}
+
+	switch requestType {
+	case "packages", "packages-gz", "packages-rds":
+		return h.handleIndex(c, mirror, path)
+	case "source", "mac-binary", "win-binary":
+		if _, _, ok := h.parsePackageFile(path); ok {
+			return h.handlePackage(c, mirror, path)
+		}
+	}
 
-	// 直接转发请求到上游
+	// 其他请求直接转发到上游
 	resp, err := h.proxy.ProxyRequest(mirror, path, c.Request.Header)
 	if err != nil {
-		fmt.Printf("[ERROR] 代理请求失败: %v\n", err)
-		return fmt.Errorf("代理请求失败: %v", err)
+		log.Error("代理请求失败", zap.Error(err), zap.String("path", path))
+		return fmt.Errorf("代理请求失败: %w", err)
 	}
 	defer resp.Body.Close()
 
-	// 复制响应头和状态码
-	for key, values := range resp.Header {
-		for _, value := range values {
-			c.Header(key, value)
+	return copyResponse(c, resp)
+}
+
+// handleIndex 处理 PACKAGES 索引请求，在缓存时间内有效，过期后向上游重新验证
+func (h *RHandler) handleIndex(c *gin.Context, mirror *models.Mirror, path string) error {
+	log := logger.GetLogger()
+
+	file, err := h.findFile(mirror, path)
+	if err != nil {
+		return err
+	}
+
+	if file != nil && !isCacheExpired(file.DownloadedAt, mirror.CacheTime) {
+		return h.serveCachedFile(c, mirror, file)
+	}
+
+	updated, resp, err := h.refreshIndex(mirror, path, file)
+	if file != nil && upstreamFailed(resp, err) && canServeStale(mirror, file.DownloadedAt) {
+		// 上游不可用时继续使用过期的索引，保证已缓存的包仍可安装
+		log.Warn("上游不可用，使用过期的索引",
+			zap.Error(err),
+			zap.String("path", path),
+		)
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return h.serveCachedFile(c, mirror, file)
+	}
+	if err != nil {
+		return err
+	}
+	if resp != nil {
+		defer resp.Body.Close()
+		return copyResponse(c, resp)
+	}
+	return serveLocalFile(c, mirror, updated.SavePath, updated.ContentType)
+}
+
+// refreshIndex 从上游拉取或重新验证索引，返回最新的缓存记录
+// 上游返回 200/304 以外的状态时，返回上游响应由调用方转发
+func (h *RHandler) refreshIndex(mirror *models.Mirror, path string, file *models.RFile) (*models.RFile, *http.Response, error) {
+	log := logger.GetLogger()
+
+	headers := http.Header{}
+	if file != nil {
+		if file.ETag != "" {
+			headers.Set("If-None-Match", file.ETag)
+		}
+		if file.LastModified != "" {
+			headers.Set("If-Modified-Since", file.LastModified)
+		}
+	}
+
+	resp, err := h.proxy.ProxyRequest(mirror, path, headers)
+	if err != nil {
+		log.Error("代理请求失败", zap.Error(err), zap.String("path", path))
+		return nil, nil, fmt.Errorf("代理请求失败: %w", err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		resp.Body.Close()
+		if file == nil {
+			return nil, nil, fmt.Errorf("上游返回 304 但本地没有缓存: %s", path)
+		}
+		log.Debug("索引未变化，继续使用缓存", zap.String("path", path))
+		file.DownloadedAt = time.Now()
+		if err := database.DB.Model(file).Update("downloaded_at", file.DownloadedAt).Error; err != nil {
+			log.Error("更新文件记录失败", zap.Error(err))
+		}
+		return file, nil, nil
+	case http.StatusOK:
+		defer resp.Body.Close()
+	default:
+		if resp.StatusCode >= http.StatusInternalServerError && file != nil {
+			resp.Body.Close()
+			return nil, nil, fmt.Errorf("上游返回错误状态: %d", resp.StatusCode)
+		}
+		return nil, resp, nil
+	}
+
+	savePath := filepath.Join(mirror.BlobPath, path)
+	size, _, err := saveToFile(mirror, savePath, resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	if file == nil {
+		file = &models.RFile{
+			MirrorID:     mirror.ID,
+			RelativePath: path,
+			UpstreamPath: path,
+			FileType:     models.RFileTypeIndex,
+			LastUsedTime: now,
 		}
 	}
-	c.Status(resp.StatusCode)
+	file.FileSize = size
+	file.SavePath = savePath
+	file.ContentType = resp.Header.Get("Content-Type")
+	file.ETag = resp.Header.Get("ETag")
+	file.LastModified = resp.Header.Get("Last-Modified")
+	file.DownloadedAt = now
+
+	if err := database.DB.Save(file).Error; err != nil {
+		log.Error("保存文件记录失败", zap.Error(err))
+	}
+	return file, nil, nil
+}
+
+// handlePackage 处理包文件请求，包文件缓存后永久有效
+// 源码包在上游返回 404 时，尝试从 Archive 目录获取旧版本
+func (h *RHandler) handlePackage(c *gin.Context, mirror *models.Mirror, path string) error {
+	log := logger.GetLogger()
 
-	// 复制响应体
-	written, err := io.Copy(c.Writer, resp.Body)
+	file, err := h.findFile(mirror, path)
 	if err != nil {
-		fmt.Printf("[ERROR] 复制响应失败: %v\n", err)
-		return fmt.Errorf("复制响应失败: %v", err)
+		return err
+	}
+	if file != nil {
+		return h.serveCachedFile(c, mirror, file)
+	}
+
+	// 边下载边返回给客户端，同一文件的并发请求共用一次拉取
+	upstreamPath := path
+	savePath := filepath.Join(mirror.BlobPath, path)
+	return streamDownload(c, mirror, savePath,
+		func() (*http.Response, error) {
+			return h.fetchPackage(c, mirror, path, &upstreamPath)
+		},
+		nil,
+		func(header http.Header, size int64, sum string) error {
+			packageName, version, _ := h.parsePackageFile(path)
+			now := time.Now()
+			file := &models.RFile{
+				MirrorID:     mirror.ID,
+				PackageName:  packageName,
+				Version:      version,
+				RelativePath: path,
+				UpstreamPath: upstreamPath,
+				FileType:     models.RFileTypePackage,
+				FileSize:     size,
+				SavePath:     savePath,
+				ContentType:  header.Get("Content-Type"),
+				DownloadedAt: now,
+				LastUsedTime: now,
+			}
+			if err := database.DB.Create(file).Error; err != nil {
+				return fmt.Errorf("保存文件记录失败: %v", err)
+			}
+
+			log.Debug("包文件已缓存",
+				zap.String("path", path),
+				zap.String("upstream", upstreamPath),
+				zap.Int64("size", size),
+			)
+			return nil
+		},
+	)
+}
+
+// fetchPackage 从上游拉取包文件，当前目录中不存在时尝试 Archive 目录
+// upstreamPath 返回实际拉取的上游路径
+func (h *RHandler) fetchPackage(c *gin.Context, mirror *models.Mirror, path string, upstreamPath *string) (*http.Response, error) {
+	log := logger.GetLogger()
+
+	resp, err := h.proxy.ProxyRequest(mirror, path, cacheHeaders(c.Request.Header))
+	if err != nil {
+		log.Error("代理请求失败", zap.Error(err), zap.String("path", path))
+		return nil, fmt.Errorf("代理请求失败: %w", err)
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		return resp, nil
+	}
+
+	archivePath := h.archivePath(path)
+	if archivePath == "" {
+		return resp, nil
+	}
+	log.Debug("包已移至 Archive，尝试旧版本路径",
+		zap.String("path", path),
+		zap.String("archive", archivePath),
+	)
+	archiveResp, err := h.proxy.ProxyRequest(mirror, archivePath, cacheHeaders(c.Request.Header))
+	if err != nil {
+		log.Error("代理请求失败", zap.Error(err), zap.String("path", archivePath))
+		return resp, nil
+	}
+	if archiveResp.StatusCode != http.StatusOK {
+		archiveResp.Body.Close()
+		return resp, nil
 	}
+	resp.Body.Close()
+	*upstreamPath = archivePath
+	return archiveResp, nil
+}
+
+// archivePath 返回源码包在 Archive 目录中的路径，例如
+// src/contrib/ggplot2_3.4.0.tar.gz -> src/contrib/Archive/ggplot2/ggplot2_3.4.0.tar.gz
+func (h *RHandler) archivePath(filePath string) string {
+	dir, name := path.Split(filePath)
+	if !strings.HasSuffix(dir, "src/contrib/") {
+		return ""
+	}
+	packageName, _, ok := h.parsePackageFile(filePath)
+	if !ok {
+		return ""
+	}
+	return dir + "Archive/" + packageName + "/" + name
+}
 
-	fmt.Printf("[INFO] 完成请求: %s (状态码: %d, 大小: %d bytes)\n",
-		path, resp.StatusCode, written)
+// parsePackageFile 从路径中解析包名和版本号
+func (h *RHandler) parsePackageFile(filePath string) (packageName, version string, ok bool) {
+	matches := rPackageFile.FindStringSubmatch(path.Base(filePath))
+	if matches == nil {
+		return "", "", false
+	}
+	return matches[1], matches[2], true
+}
+
+// findFile 查找缓存记录，记录存在但文件丢失时删除记录
+func (h *RHandler) findFile(mirror *models.Mirror, path string) (*models.RFile, error) {
+	var file models.RFile
+	result := database.DB.Where(&models.RFile{
+		MirrorID:     mirror.ID,
+		RelativePath: path,
+	}).First(&file)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("查询缓存文件失败: %v", result.Error)
+	}
+
+	if !cachedFileExists(mirror, file.SavePath) {
+		logger.GetLogger().Warn("缓存文件不存在，重新拉取", zap.String("path", file.SavePath))
+		database.DB.Delete(&file)
+		return nil, nil
+	}
+	return &file, nil
+}
+
+// serveCachedFile 从缓存提供文件
+func (h *RHandler) serveCachedFile(c *gin.Context, mirror *models.Mirror, file *models.RFile) error {
+	log := logger.GetLogger()
+
+	log.Debug("命中缓存",
+		zap.String("path", file.RelativePath),
+		zap.String("mirror", mirror.Name),
+	)
+
+	if err := database.DB.Model(file).Update("last_used_time", time.Now()).Error; err != nil {
+		log.Error("更新文件使用时间失败", zap.Error(err))
+	}
+
+	if err := updateMirrorCounts(mirror, true); err != nil {
+		log.Error("更新缓存命中计数失败", zap.Error(err))
+	}
 
-	return nil
+	return serveLocalFile(c, mirror, file.SavePath, file.ContentType)
 }
 
 // getRequestType 判断CRAN请求的类型
@@ -0,0 +1,76 @@
+package registry
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/models"
+)
+
+func TestCRANCaching(t *testing.T) {
+	mirror := setupRegistryStore(t)
+	upstream := newStaticUpstream(t, map[string]string{
+		"src/contrib/PACKAGES":                             "Package: ggplot2\nVersion: 3.5.0\n",
+		"src/contrib/ggplot2_3.5.0.tar.gz":                 "current",
+		"src/contrib/Archive/ggplot2/ggplot2_3.4.0.tar.gz": "archived",
+	})
+	mirror.UpstreamURL = upstream.server.URL
+	mirror.CacheTime = 10
+	h := NewRHandler()
+
+	steps := []struct {
+		name       string
+		path       string
+		wantStatus int
+		wantBody   string
+		// wantUpstream 为缓存记录中的上游路径，为空时不检查
+		wantUpstream string
+		wantRequests int32
+	}{
+		{"第一次获取 PACKAGES", "src/contrib/PACKAGES", http.StatusOK, "Version: 3.5.0", "", 1},
+		{"缓存时间内使用缓存的 PACKAGES", "src/contrib/PACKAGES", http.StatusOK, "Version: 3.5.0", "", 0},
+		{"当前版本的源码包", "src/contrib/ggplot2_3.5.0.tar.gz", http.StatusOK, "current", "src/contrib/ggplot2_3.5.0.tar.gz", 1},
+		{"旧版本从 Archive 目录拉取", "src/contrib/ggplot2_3.4.0.tar.gz", http.StatusOK, "archived", "src/contrib/Archive/ggplot2/ggplot2_3.4.0.tar.gz", 2},
+		{"再次下载旧版本使用缓存", "src/contrib/ggplot2_3.4.0.tar.gz", http.StatusOK, "archived", "", 0},
+		{"Archive 中也不存在", "src/contrib/ggplot2_1.0.0.tar.gz", http.StatusNotFound, "", "", 2},
+	}
+	for _, st := range steps {
+		before := upstream.requests.Load()
+		w := serveHandler(h, mirror, st.path, nil)
+		if w.Code != st.wantStatus || !strings.Contains(w.Body.String(), st.wantBody) {
+			t.Fatalf("%s: status = %d, body = %q, want %d %q", st.name, w.Code, w.Body.String(), st.wantStatus, st.wantBody)
+		}
+		if got := upstream.requests.Load() - before; got != st.wantRequests {
+			t.Errorf("%s: 上游收到 %d 次请求, want %d", st.name, got, st.wantRequests)
+		}
+		if st.wantUpstream != "" {
+			var file models.RFile
+			if err := database.DB.Where("mirror_id = ? AND relative_path = ?", mirror.ID, st.path).First(&file).Error; err != nil {
+				t.Fatalf("%s: %v", st.name, err)
+			}
+			if file.UpstreamPath != st.wantUpstream {
+				t.Errorf("%s: UpstreamPath = %q, want %q", st.name, file.UpstreamPath, st.wantUpstream)
+			}
+		}
+	}
+}
+
+func TestCRANArchivePath(t *testing.T) {
+	h := NewRHandler()
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"src/contrib/ggplot2_3.4.0.tar.gz", "src/contrib/Archive/ggplot2/ggplot2_3.4.0.tar.gz"},
+		{"src/contrib/data.table_1.14.8.tar.gz", "src/contrib/Archive/data.table/data.table_1.14.8.tar.gz"},
+		{"bin/windows/contrib/4.3/ggplot2_3.4.0.zip", ""},
+		{"src/contrib/PACKAGES", ""},
+	}
+	for _, tt := range tests {
+		if got := h.archivePath(tt.path); got != tt.want {
+			t.Errorf("archivePath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,464 @@
+package registry
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/logger"
+	"easyCacheMirror/internal/models"
+	"easyCacheMirror/internal/proxy"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// rawIndexFile 目录页面在本地保存的文件名
+const rawIndexFile = "__index__"
+
+// RawHandler 缓存上游地址下的任意文件，例如 https://nodejs.org/dist/
+type RawHandler struct {
+	BaseHandler
+	proxy *proxy.Proxy
+}
+
+func NewRawHandler() *RawHandler {
+	p := proxy.NewProxy()
+	if p == nil {
+		fmt.Println("警告: proxy.NewProxy() 返回 nil")
+	}
+
+	handler := &RawHandler{
+		proxy: p,
+	}
+
+	// 验证初始化
+	if handler.proxy == nil {
+		fmt.Println("错误: RawHandler 初始化后 proxy 为 nil")
+	} else {
+		fmt.Println("RawHandler 初始化成功")
+	}
+
+	return handler
+}
+
+func (h *RawHandler) SupportedType() string {
+	return "Raw"
+}
+
+func (h *RawHandler) Handle(c *gin.Context, mirror *models.Mirror, path string) error {
+	log := logger.GetLogger()
+
+	// 添加更详细的初始化检查
+	if h == nil {
+		return fmt.Errorf("handler 未初始化")
+	}
+
+	if h.proxy == nil {
+		return fmt.Errorf("proxy 未初始化 (handler: %v)", h)
+	}
+
+	path = strings.TrimPrefix(path, "/")
+
+	// 路径以 / 结尾或为空时请求的是目录页面
+	isDir := path == "" || strings.HasSuffix(c.Request.URL.Path, "/")
+
+	log.Debug("处理Raw请求",
+		zap.String("path", path),
+		zap.Bool("dir", isDir),
+	)
+
+	// 更新总请求计数
+	if err := updateMirrorCounts(mirror, false); err != nil {
+		log.Error("更新请求计数失败", zap.Error(err))
+	}
+
+	// 只缓存不带查询参数的 GET/HEAD 请求
+	method := c.Request.Method
+	if (method != http.MethodGet && method != http.MethodHead) || c.Request.URL.RawQuery != "" {
+		upstreamPath := path
+		if isDir && path != "" {
+			upstreamPath += "/"
+		}
+		if c.Request.URL.RawQuery != "" {
+			upstreamPath += "?" + c.Request.URL.RawQuery
+		}
+		resp, err := h.proxy.ProxyRequestWithMethod(mirror, method, upstreamPath, c.Request.Header)
+		if err != nil {
+			log.Error("代理请求失败", zap.Error(err), zap.String("path", path))
+			return fmt.Errorf("代理请求失败: %w", err)
+		}
+		defer resp.Body.Close()
+		return copyResponse(c, resp)
+	}
+
+	// 未缓存的文件边下载边返回给客户端，同一文件的并发请求共用一次拉取
+	if !isDir {
+		file, err := h.findFile(mirror, path)
+		if err != nil {
+			return err
+		}
+		if file == nil {
+			return h.streamFile(c, mirror, path)
+		}
+	}
+
+	file, hit, resp, err := h.ensureFile(c, mirror, path, isDir)
+	if err != nil {
+		return err
+	}
+	if resp != nil {
+		defer resp.Body.Close()
+		return copyResponse(c, resp)
+	}
+	if file == nil {
+		// 上游把文件地址重定向到了目录，让客户端使用带 / 的地址，保证页面中的相对链接正确
+		c.Redirect(http.StatusMovedPermanently, c.Request.URL.Path+"/")
+		return nil
+	}
+	if hit {
+		return h.serveCachedFile(c, mirror, file)
+	}
+	return serveLocalFile(c, mirror, file.SavePath, file.ContentType)
+}
+
+// ensureFile 返回可用的缓存记录，需要时从上游拉取或重新验证
+// hit 表示直接使用了缓存；上游返回无法缓存的响应时返回该响应由调用方转发；
+// 请求的文件实际是目录时 file 和 resp 均为 nil
+func (h *RawHandler) ensureFile(c *gin.Context, mirror *models.Mirror, filePath string, isDir bool) (file *models.RawFile, hit bool, resp *http.Response, err error) {
+	log := logger.GetLogger()
+
+	cachePath := filePath
+	if isDir {
+		cachePath = strings.TrimPrefix(filePath+"/"+rawIndexFile, "/")
+	}
+
+	file, err = h.findFile(mirror, cachePath)
+	if err != nil {
+		return nil, false, nil, err
+	}
+	if file != nil && (file.Immutable || !isCacheExpired(file.DownloadedAt, mirror.CacheTime)) {
+		return file, true, nil, nil
+	}
+
+	updated, resp, err := h.refreshFile(c, mirror, filePath, cachePath, isDir, file)
+	if file != nil && upstreamFailed(resp, err) && canServeStale(mirror, file.DownloadedAt) {
+		// 上游不可用时继续使用过期的缓存
+		log.Warn("上游不可用，使用过期的缓存",
+			zap.Error(err),
+			zap.String("path", filePath),
+		)
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return file, true, nil, nil
+	}
+	if err != nil {
+		return nil, false, nil, err
+	}
+	return updated, false, resp, nil
+}
+
+// refreshFile 从上游拉取或重新验证文件，返回最新的缓存记录
+func (h *RawHandler) refreshFile(c *gin.Context, mirror *models.Mirror, filePath, cachePath string, isDir bool, file *models.RawFile) (*models.RawFile, *http.Response, error) {
+	log := logger.GetLogger()
+
+	headers := cacheHeaders(c.Request.Header)
+	if file != nil {
+		if file.ETag != "" {
+			headers.Set("If-None-Match", file.ETag)
+		}
+		if file.LastModified != "" {
+			headers.Set("If-Modified-Since", file.LastModified)
+		}
+	}
+
+	upstreamPath := filePath
+	if isDir && filePath != "" {
+		upstreamPath += "/"
+	}
+
+	resp, err := h.proxy.ProxyRequest(mirror, upstreamPath, headers)
+	if err != nil {
+		log.Error("代理请求失败", zap.Error(err), zap.String("path", filePath))
+		return nil, nil, fmt.Errorf("代理请求失败: %w", err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		resp.Body.Close()
+		if file == nil {
+			return nil, nil, fmt.Errorf("上游返回 304 但本地没有缓存: %s", filePath)
+		}
+		log.Debug("文件未变化，继续使用缓存", zap.String("path", filePath))
+		file.DownloadedAt = time.Now()
+		if err := database.DB.Model(file).Update("downloaded_at", file.DownloadedAt).Error; err != nil {
+			log.Error("更新文件记录失败", zap.Error(err))
+		}
+		return file, nil, nil
+	case http.StatusOK:
+		defer resp.Body.Close()
+	default:
+		return nil, resp, nil
+	}
+
+	if !isDir && strings.HasSuffix(resp.Request.URL.Path, "/") {
+		return nil, nil, nil
+	}
+
+	// 保存前查找校验值，校验文件本身不参与校验
+	expected := ""
+	if !isDir && mirror.ChecksumFile != "" && path.Base(cachePath) != mirror.ChecksumFile {
+		expected = h.lookupChecksum(c, mirror, cachePath)
+	}
+
+	savePath := filepath.Join(mirror.BlobPath, cachePath)
+	size, sum, err := saveToFile(mirror, savePath, resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if expected != "" && !strings.EqualFold(sum, expected) {
+		removeCachedFile(mirror, savePath)
+		if file != nil {
+			database.DB.Delete(file)
+		}
+		log.Error("文件校验失败",
+			zap.String("path", filePath),
+			zap.String("expected", expected),
+			zap.String("actual", sum),
+		)
+		return nil, nil, fmt.Errorf("文件校验失败: %s", filePath)
+	}
+
+	now := time.Now()
+	if file == nil {
+		fileType := models.RawFileTypeFile
+		if isDir {
+			fileType = models.RawFileTypeIndex
+		}
+		file = &models.RawFile{
+			MirrorID:     mirror.ID,
+			RelativePath: cachePath,
+			FileType:     fileType,
+			LastUsedTime: now,
+		}
+	}
+	file.Immutable = !isDir && h.isImmutable(mirror, cachePath)
+	file.FileSize = size
+	file.SavePath = savePath
+	file.ContentType = resp.Header.Get("Content-Type")
+	file.Sha256 = sum
+	file.ETag = resp.Header.Get("ETag")
+	file.LastModified = resp.Header.Get("Last-Modified")
+	file.DownloadedAt = now
+
+	if err := database.DB.Save(file).Error; err != nil {
+		log.Error("保存文件记录失败", zap.Error(err))
+	}
+
+	log.Debug("文件已缓存",
+		zap.String("path", cachePath),
+		zap.Bool("immutable", file.Immutable),
+		zap.Int64("size", size),
+	)
+	return file, nil, nil
+}
+
+// streamFile 拉取未缓存的文件，同时发送给客户端和写入缓存
+// 镜像配置了校验文件时，写入完成后按校验文件中的 sha256 校验
+func (h *RawHandler) streamFile(c *gin.Context, mirror *models.Mirror, filePath string) error {
+	log := logger.GetLogger()
+
+	// 校验文件本身不参与校验
+	var verify func(r io.Reader) error
+	if mirror.ChecksumFile != "" && path.Base(filePath) != mirror.ChecksumFile {
+		if expected := h.lookupChecksum(c, mirror, filePath); expected != "" {
+			verify = verifySha256(expected)
+		}
+	}
+
+	savePath := filepath.Join(mirror.BlobPath, filePath)
+	return streamDownload(c, mirror, savePath,
+		func() (*http.Response, error) {
+			resp, err := h.proxy.ProxyRequest(mirror, filePath, cacheHeaders(c.Request.Header))
+			if err != nil {
+				log.Error("代理请求失败", zap.Error(err), zap.String("path", filePath))
+				return nil, fmt.Errorf("代理请求失败: %w", err)
+			}
+			// 上游把文件地址重定向到了目录，让客户端使用带 / 的地址，保证页面中的相对链接正确
+			if resp.StatusCode == http.StatusOK && strings.HasSuffix(resp.Request.URL.Path, "/") {
+				resp.Body.Close()
+				return &http.Response{
+					StatusCode: http.StatusMovedPermanently,
+					Header:     http.Header{"Location": {c.Request.URL.Path + "/"}},
+					Body:       http.NoBody,
+				}, nil
+			}
+			return resp, nil
+		},
+		verify,
+		func(header http.Header, size int64, sum string) error {
+			now := time.Now()
+			file := &models.RawFile{
+				MirrorID:     mirror.ID,
+				RelativePath: filePath,
+				FileType:     models.RawFileTypeFile,
+				Immutable:    h.isImmutable(mirror, filePath),
+				FileSize:     size,
+				SavePath:     savePath,
+				ContentType:  header.Get("Content-Type"),
+				Sha256:       sum,
+				ETag:         header.Get("ETag"),
+				LastModified: header.Get("Last-Modified"),
+				DownloadedAt: now,
+				LastUsedTime: now,
+			}
+			if err := database.DB.Create(file).Error; err != nil {
+				return fmt.Errorf("保存文件记录失败: %v", err)
+			}
+
+			log.Debug("文件已缓存",
+				zap.String("path", filePath),
+				zap.Bool("immutable", file.Immutable),
+				zap.Int64("size", size),
+			)
+			return nil
+		},
+	)
+}
+
+// lookupChecksum 在同目录的校验文件中查找文件的 sha256
+// 校验文件格式与 sha256sum 输出一致: <sha256>  <文件名>，文件名前可能带 *
+func (h *RawHandler) lookupChecksum(c *gin.Context, mirror *models.Mirror, filePath string) string {
+	log := logger.GetLogger()
+
+	dir := path.Dir(filePath)
+	checksumPath := mirror.ChecksumFile
+	if dir != "." {
+		checksumPath = dir + "/" + mirror.ChecksumFile
+	}
+
+	file, _, resp, err := h.ensureFile(c, mirror, checksumPath, false)
+	if err != nil {
+		log.Warn("获取校验文件失败", zap.Error(err), zap.String("path", checksumPath))
+		return ""
+	}
+	if resp != nil {
+		resp.Body.Close()
+		return ""
+	}
+	if file == nil {
+		return ""
+	}
+
+	f, err := openCachedFile(mirror, file.SavePath)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	name := path.Base(filePath)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || len(fields[0]) != 64 {
+			continue
+		}
+		if strings.TrimPrefix(fields[1], "*") == name {
+			return fields[0]
+		}
+	}
+	return ""
+}
+
+// isImmutable 判断路径是否匹配镜像设置的不可变路径模式
+// 模式每行一个，不含 / 的模式匹配文件名，* 不跨目录，** 可跨目录
+func (h *RawHandler) isImmutable(mirror *models.Mirror, filePath string) bool {
+	for _, pattern := range strings.Split(mirror.ImmutablePatterns, "\n") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		target := filePath
+		if !strings.Contains(pattern, "/") {
+			target = path.Base(filePath)
+		}
+		if globToRegexp(strings.TrimPrefix(pattern, "/")).MatchString(target) {
+			return true
+		}
+	}
+	return false
+}
+
+// globToRegexp 将路径模式转换为正则表达式
+func globToRegexp(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch ch := pattern[i]; ch {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+// findFile 查找缓存记录，记录存在但文件丢失时删除记录
+func (h *RawHandler) findFile(mirror *models.Mirror, path string) (*models.RawFile, error) {
+	var file models.RawFile
+	result := database.DB.Where(&models.RawFile{
+		MirrorID:     mirror.ID,
+		RelativePath: path,
+	}).First(&file)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("查询缓存文件失败: %v", result.Error)
+	}
+
+	if !cachedFileExists(mirror, file.SavePath) {
+		logger.GetLogger().Warn("缓存文件不存在，重新拉取", zap.String("path", file.SavePath))
+		database.DB.Delete(&file)
+		return nil, nil
+	}
+	return &file, nil
+}
+
+// serveCachedFile 从缓存提供文件
+func (h *RawHandler) serveCachedFile(c *gin.Context, mirror *models.Mirror, file *models.RawFile) error {
+	log := logger.GetLogger()
+
+	log.Debug("命中缓存",
+		zap.String("path", file.RelativePath),
+		zap.String("mirror", mirror.Name),
+	)
+
+	if err := database.DB.Model(file).Update("last_used_time", time.Now()).Error; err != nil {
+		log.Error("更新文件使用时间失败", zap.Error(err))
+	}
+
+	if err := updateMirrorCounts(mirror, true); err != nil {
+		log.Error("更新缓存命中计数失败", zap.Error(err))
+	}
+
+	return serveLocalFile(c, mirror, file.SavePath, file.ContentType)
+}
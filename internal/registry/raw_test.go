@@ -0,0 +1,92 @@
+package registry
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"easyCacheMirror/internal/models"
+)
+
+func TestRawCaching(t *testing.T) {
+	mirror := setupRegistryStore(t)
+	const archive = "archive v1"
+	upstream := newStaticUpstream(t, map[string]string{
+		"latest.txt":             "v1",
+		"releases/v1/app.tar.gz": archive,
+		"releases/v1/bad.bin":    "tampered",
+		"releases/v1/SHA256SUMS": strings.TrimPrefix(sha256Digest(archive), "sha256:") + "  app.tar.gz\n" +
+			strings.TrimPrefix(sha256Digest("original"), "sha256:") + " *bad.bin\n",
+	})
+	// 缓存时间为 0，不可变路径以外的文件每次都从上游拉取
+	mirror.UpstreamURL = upstream.server.URL
+	mirror.ImmutablePatterns = "*.tar.gz\nreleases/**"
+	mirror.ChecksumFile = "SHA256SUMS"
+	h := NewRawHandler()
+
+	steps := []struct {
+		name         string
+		setup        func()
+		path         string
+		wantBody     string
+		wantAborted  bool
+		wantRequests int32
+	}{
+		// 根目录没有校验文件，每次拉取前都会先请求一次校验文件
+		{"第一次获取可变文件", nil, "latest.txt", "v1", false, 2},
+		{
+			name:         "可变文件过期后重新拉取",
+			setup:        func() { upstream.set("latest.txt", "v2") },
+			path:         "latest.txt",
+			wantBody:     "v2",
+			wantRequests: 2,
+		},
+		{"先获取校验文件再校验", nil, "releases/v1/app.tar.gz", archive, false, 2},
+		{
+			name:     "不可变文件永久有效",
+			setup:    func() { upstream.set("releases/v1/app.tar.gz", "archive v2") },
+			path:     "releases/v1/app.tar.gz",
+			wantBody: archive,
+		},
+		{"校验失败时中断响应", nil, "releases/v1/bad.bin", "", true, 1},
+	}
+	for _, st := range steps {
+		if st.setup != nil {
+			st.setup()
+		}
+		before := upstream.requests.Load()
+		w, aborted := serveAbortable(h, mirror, st.path)
+		if aborted != st.wantAborted {
+			t.Fatalf("%s: aborted = %v, want %v", st.name, aborted, st.wantAborted)
+		}
+		if !aborted && (w.Code != http.StatusOK || w.Body.String() != st.wantBody) {
+			t.Fatalf("%s: status = %d, body = %q, want %q", st.name, w.Code, w.Body.String(), st.wantBody)
+		}
+		if got := upstream.requests.Load() - before; got != st.wantRequests {
+			t.Errorf("%s: 上游收到 %d 次请求, want %d", st.name, got, st.wantRequests)
+		}
+	}
+}
+
+func TestRawIsImmutable(t *testing.T) {
+	h := NewRawHandler()
+	mirror := &models.Mirror{ImmutablePatterns: "*.tar.gz\n/releases/*/bin/**\nv?.txt"}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"app.tar.gz", true},
+		{"dist/app.tar.gz", true},
+		{"releases/v1/bin/app", true},
+		{"releases/v1/bin/linux/app", true},
+		{"releases/v1/v2/bin/app", false},
+		{"docs/v1.txt", true},
+		{"docs/v10.txt", false},
+		{"latest.txt", false},
+	}
+	for _, tt := range tests {
+		if got := h.isImmutable(mirror, tt.path); got != tt.want {
+			t.Errorf("isImmutable(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
@@ -2,8 +2,10 @@ package registry
 
 import (
 	"fmt"
+	"net/http"
 	"sync"
 
+	"easyCacheMirror/internal/auth"
 	"easyCacheMirror/internal/logger"
 	"easyCacheMirror/internal/models"
 
@@ -118,6 +120,15 @@ func (r *Registry) registerHandlers() {
 	}
 	log.Info("注册处理器", zap.String("type", "Docker"))
 	r.Register(dockerHandler)
+
+	// Raw 处理器
+	rawHandler := NewRawHandler()
+	if rawHandler == nil {
+		log.Error("无法创建Raw处理器")
+		return
+	}
+	log.Info("注册处理器", zap.String("type", "Raw"))
+	r.Register(rawHandler)
 }
 
 // Register 注册一个新的处理器
@@ -153,11 +164,42 @@ func (r *Registry) GetHandler(mirrorType string) Handler {
 	return handler
 }
 
+// checkWriteAccess 检查当前客户端能否向托管仓库发布或删除包
+// 允许时返回 0，否则返回应答的状态码和提示，由各处理器按客户端能识别的格式返回
+func checkWriteAccess(c *gin.Context, mirror *models.Mirror) (int, string) {
+	user := auth.CurrentUser(c)
+	if user == nil {
+		c.Header("WWW-Authenticate", fmt.Sprintf(`Basic realm=%q`, mirror.Name))
+		return http.StatusUnauthorized, "发布需要认证"
+	}
+	if !user.CanWrite() {
+		logger.GetLogger().Warn("用户没有发布权限",
+			zap.String("mirror", mirror.Name),
+			zap.String("username", user.Username),
+		)
+		return http.StatusForbidden, "当前用户没有发布权限"
+	}
+	return 0, ""
+}
+
+// readAccess 判断已通过入口镜像检查的请求能否读取另一个镜像，由 handlers 注册
+var readAccess func(c *gin.Context, mirror *models.Mirror) bool
+
+// OnReadAccess 注册判断请求能否读取镜像的函数，组合镜像按成员各自的访问控制过滤成员
+func OnReadAccess(check func(c *gin.Context, mirror *models.Mirror) bool) {
+	readAccess = check
+}
+
+// canRead 判断当前请求能否读取镜像，没有注册检查函数时不限制
+func canRead(c *gin.Context, mirror *models.Mirror) bool {
+	return readAccess == nil || readAccess(c, mirror)
+}
+
 // Handler 定义了处理器接口
 type Handler interface {
 	SupportedType() string
 	Handle(c *gin.Context, mirror *models.Mirror, path string) error
-	CleanupCache(c *gin.Context, mirror *models.Mirror) error
+	CleanupCache(mirror *models.Mirror) error
 }
 
 // BaseHandler 提供基本的处理器实现
@@ -173,8 +215,7 @@ func (h *BaseHandler) Handle(c *gin.Context, mirror *models.Mirror, path string)
 	return fmt.Errorf("未实现的处理方法")
 }
 
-// CleanupCache 基本的清理缓存实现
-func (h *BaseHandler) CleanupCache(c *gin.Context, mirror *models.Mirror) error {
-	// 默认实现，暂时返回 nil
-	return nil
+// CleanupCache 按镜像的淘汰策略清理缓存
+func (h *BaseHandler) CleanupCache(mirror *models.Mirror) error {
+	return evictCache(mirror)
 }
@@ -1,14 +1,28 @@
 package registry
 
 import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"net/http"
+	"path"
+	"path/filepath"
 	"strings"
+	"time"
 
+	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/logger"
 	"easyCacheMirror/internal/models"
 	"easyCacheMirror/internal/proxy"
+	"easyCacheMirror/internal/storage"
 
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
 type RubyGemsHandler struct {
@@ -41,6 +55,8 @@ func (h *RubyGemsHandler) SupportedType() string {
 }
 
 func (h *RubyGemsHandler) Handle(c *gin.Context, mirror *models.Mirror, path string) error {
+	log := logger.GetLogger()
+
 	// 添加更详细的初始化检查
 	if h == nil {
 		return fmt.Errorf("handler 未初始化")
@@ -59,59 +75,477 @@ func (h *RubyGemsHandler) Handle(c *gin.Context, mirror *models.Mirror, path str
 	// 检查请求类型
 	requestType := h.getRequestType(path)
 
-	// 打印请求信息
-	fmt.Printf("[INFO] 处理RubyGems请求: %s (类型: %s)\n", path, requestType)
+	log.Debug("处理RubyGems请求",
+		zap.String("path", path),
+		zap.String("type", requestType),
+	)
+
+	// 更新总请求计数
+	if err := updateMirrorCounts(mirror, false); err != nil {
+		log.Error("更新请求计数失败", zap.Error(err))
+	}
+
+	switch requestType {
+	case "versions", "names", "info":
+		return h.handleIndex(c, mirror, path, models.RubyGemsFileTypeCompactIndex)
+	case "specs", "latest-specs", "prerelease-specs":
+		return h.handleIndex(c, mirror, path, models.RubyGemsFileTypeSpecs)
+	case "gemspec":
+		return h.handleGemspec(c, mirror, path)
+	case "gem":
+		return h.handleGem(c, mirror, path)
+	}
 
-	// 直接转发请求到上游
+	// 其他请求(如 api/v1/dependencies)直接转发到上游
 	resp, err := h.proxy.ProxyRequest(mirror, path, c.Request.Header)
 	if err != nil {
-		fmt.Printf("[ERROR] 代理请求失败: %v\n", err)
-		return fmt.Errorf("代理请求失败: %v", err)
+		log.Error("代理请求失败", zap.Error(err), zap.String("path", path))
+		return fmt.Errorf("代理请求失败: %w", err)
 	}
 	defer resp.Body.Close()
 
-	// 复制响应头和状态码
-	for key, values := range resp.Header {
-		for _, value := range values {
-			c.Header(key, value)
+	return copyResponse(c, resp)
+}
+
+// handleIndex 处理索引请求，在缓存时间内有效，过期后向上游重新验证
+// 兼容索引只追加内容，过期后通过范围请求增量更新
+func (h *RubyGemsHandler) handleIndex(c *gin.Context, mirror *models.Mirror, path string, fileType models.RubyGemsFileType) error {
+	log := logger.GetLogger()
+
+	file, err := h.findFile(mirror, path)
+	if err != nil {
+		return err
+	}
+
+	if file != nil && !isCacheExpired(file.DownloadedAt, mirror.CacheTime) {
+		return h.serveCachedFile(c, mirror, file)
+	}
+
+	incremental := fileType == models.RubyGemsFileTypeCompactIndex
+	updated, resp, err := h.refreshIndex(mirror, path, file, fileType, incremental)
+	if file != nil && upstreamFailed(resp, err) && canServeStale(mirror, file.DownloadedAt) {
+		// 上游不可用时继续使用过期的索引
+		log.Warn("上游不可用，使用过期的索引",
+			zap.Error(err),
+			zap.String("path", path),
+		)
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return h.serveCachedFile(c, mirror, file)
+	}
+	if err != nil {
+		return err
+	}
+	if resp != nil {
+		defer resp.Body.Close()
+		return copyResponse(c, resp)
+	}
+	return h.serveFile(c, mirror, updated)
+}
+
+// refreshIndex 从上游拉取或重新验证索引，返回最新的缓存记录
+// incremental 为 true 且本地已有缓存时，只请求本地文件最后一个字节之后的内容
+// 上游返回 200/206/304 以外的状态时，返回上游响应由调用方转发
+func (h *RubyGemsHandler) refreshIndex(mirror *models.Mirror, path string, file *models.RubyGemsFile, fileType models.RubyGemsFileType, incremental bool) (*models.RubyGemsFile, *http.Response, error) {
+	log := logger.GetLogger()
+
+	incremental = incremental && file != nil && file.FileSize > 0
+
+	headers := http.Header{}
+	if file != nil {
+		if file.ETag != "" {
+			headers.Set("If-None-Match", file.ETag)
+		}
+		if file.LastModified != "" {
+			headers.Set("If-Modified-Since", file.LastModified)
+		}
+	}
+	if incremental {
+		// 多请求一个字节，用于确认上游文件与本地文件的内容是连续的
+		headers.Set("Range", fmt.Sprintf("bytes=%d-", file.FileSize-1))
+	}
+
+	resp, err := h.proxy.ProxyRequest(mirror, path, headers)
+	if err != nil {
+		log.Error("代理请求失败", zap.Error(err), zap.String("path", path))
+		return nil, nil, fmt.Errorf("代理请求失败: %w", err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		resp.Body.Close()
+		if file == nil {
+			return nil, nil, fmt.Errorf("上游返回 304 但本地没有缓存: %s", path)
+		}
+		log.Debug("索引未变化，继续使用缓存", zap.String("path", path))
+		file.DownloadedAt = time.Now()
+		if err := database.DB.Model(file).Update("downloaded_at", file.DownloadedAt).Error; err != nil {
+			log.Error("更新文件记录失败", zap.Error(err))
+		}
+		return file, nil, nil
+	case resp.StatusCode == http.StatusPartialContent && incremental:
+		defer resp.Body.Close()
+		if err := h.appendToFile(mirror, file.SavePath, resp.Body); err != nil {
+			log.Warn("增量更新索引失败，重新完整拉取",
+				zap.Error(err),
+				zap.String("path", path),
+			)
+			return h.refreshIndex(mirror, path, file, fileType, false)
+		}
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && incremental:
+		// 上游文件比本地短，说明索引被重建，需要完整拉取
+		resp.Body.Close()
+		return h.refreshIndex(mirror, path, file, fileType, false)
+	case resp.StatusCode == http.StatusOK:
+		defer resp.Body.Close()
+		if _, _, err := saveToFile(mirror, filepath.Join(mirror.BlobPath, path), resp.Body); err != nil {
+			return nil, nil, err
+		}
+	default:
+		if resp.StatusCode >= http.StatusInternalServerError && file != nil {
+			resp.Body.Close()
+			return nil, nil, fmt.Errorf("上游返回错误状态: %d", resp.StatusCode)
+		}
+		return nil, resp, nil
+	}
+
+	savePath := filepath.Join(mirror.BlobPath, path)
+	size, md5Sum, sha256Sum, err := h.fileDigests(mirror, savePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	if file == nil {
+		file = &models.RubyGemsFile{
+			MirrorID:     mirror.ID,
+			RelativePath: path,
+			FileType:     fileType,
+			LastUsedTime: now,
 		}
+		if strings.HasPrefix(path, "info/") {
+			file.GemName = strings.TrimPrefix(path, "info/")
+		}
+	}
+	file.FileSize = size
+	file.SavePath = savePath
+	file.MD5 = md5Sum
+	file.Sha256 = sha256Sum
+	file.ETag = resp.Header.Get("ETag")
+	file.LastModified = resp.Header.Get("Last-Modified")
+	file.DownloadedAt = now
+	if resp.StatusCode == http.StatusOK {
+		file.ContentType = resp.Header.Get("Content-Type")
 	}
-	c.Status(resp.StatusCode)
 
-	// 复制响应体
-	written, err := io.Copy(c.Writer, resp.Body)
+	if err := database.DB.Save(file).Error; err != nil {
+		log.Error("保存文件记录失败", zap.Error(err))
+	}
+	return file, nil, nil
+}
+
+// appendToFile 将范围请求返回的内容追加到本地文件
+// 响应的第一个字节必须与本地文件的最后一个字节相同，否则认为上游文件已变化
+func (h *RubyGemsHandler) appendToFile(mirror *models.Mirror, savePath string, body io.Reader) error {
+	src, info, err := storage.ForMirror(mirror).Get(blobKey(mirror, savePath))
 	if err != nil {
-		fmt.Printf("[ERROR] 复制响应失败: %v\n", err)
-		return fmt.Errorf("复制响应失败: %v", err)
+		return fmt.Errorf("读取缓存文件失败: %v", err)
+	}
+	defer src.Close()
+
+	if info.Size == 0 {
+		return fmt.Errorf("读取缓存文件失败: 文件为空")
+	}
+
+	last := make([]byte, 1)
+	if _, err := src.ReadAt(last, info.Size-1); err != nil {
+		return fmt.Errorf("读取缓存文件失败: %v", err)
+	}
+	first := make([]byte, 1)
+	if _, err := io.ReadFull(body, first); err != nil {
+		return fmt.Errorf("读取上游响应失败: %v", err)
 	}
+	if first[0] != last[0] {
+		return fmt.Errorf("上游内容与本地缓存不连续")
+	}
+
+	_, _, err = saveToFile(mirror, savePath, io.MultiReader(src, body))
+	return err
+}
 
-	fmt.Printf("[INFO] 完成请求: %s (状态码: %d, 大小: %d bytes)\n",
-		path, resp.StatusCode, written)
+// handleGemspec 处理 quick/Marshal.4.8 下的 gemspec 请求，gemspec 缓存后永久有效
+func (h *RubyGemsHandler) handleGemspec(c *gin.Context, mirror *models.Mirror, path string) error {
+	file, err := h.findFile(mirror, path)
+	if err != nil {
+		return err
+	}
+	if file != nil {
+		return h.serveCachedFile(c, mirror, file)
+	}
 
-	return nil
+	// 边下载边返回给客户端，同一文件的并发请求共用一次拉取
+	savePath := filepath.Join(mirror.BlobPath, path)
+	return streamDownload(c, mirror, savePath,
+		func() (*http.Response, error) {
+			return h.proxy.ProxyRequest(mirror, path, cacheHeaders(c.Request.Header))
+		},
+		nil,
+		func(header http.Header, size int64, sum string) error {
+			now := time.Now()
+			file := &models.RubyGemsFile{
+				MirrorID:     mirror.ID,
+				RelativePath: path,
+				FileType:     models.RubyGemsFileTypeGemspec,
+				FileSize:     size,
+				SavePath:     savePath,
+				ContentType:  header.Get("Content-Type"),
+				Sha256:       sum,
+				DownloadedAt: now,
+				LastUsedTime: now,
+			}
+			if err := database.DB.Create(file).Error; err != nil {
+				return fmt.Errorf("保存文件记录失败: %v", err)
+			}
+			return nil
+		},
+	)
+}
+
+// handleGem 处理 .gem 包文件请求，保存前校验 info 文件中的 sha256，缓存后永久有效
+func (h *RubyGemsHandler) handleGem(c *gin.Context, mirror *models.Mirror, path string) error {
+	log := logger.GetLogger()
+
+	file, err := h.findFile(mirror, path)
+	if err != nil {
+		return err
+	}
+	if file != nil {
+		return h.serveCachedFile(c, mirror, file)
+	}
+
+	gemName, version, expected := h.gemChecksum(mirror, path)
+
+	fetch := func() (*http.Response, error) {
+		resp, err := h.proxy.ProxyRequest(mirror, path, cacheHeaders(c.Request.Header))
+		if err != nil {
+			log.Error("代理请求失败", zap.Error(err), zap.String("path", path))
+			return nil, fmt.Errorf("代理请求失败: %w", err)
+		}
+		return resp, nil
+	}
+
+	// 无法获取校验值的包不进入缓存，直接转发
+	if expected == "" {
+		log.Warn("info 文件中没有 gem 的 sha256，跳过缓存", zap.String("path", path))
+		resp, err := fetch()
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		return copyResponse(c, resp)
+	}
+
+	// 边下载边返回给客户端，同一文件的并发请求共用一次拉取，写入完成后校验 sha256
+	savePath := filepath.Join(mirror.BlobPath, path)
+	return streamDownload(c, mirror, savePath, fetch, verifySha256(expected),
+		func(header http.Header, size int64, sum string) error {
+			now := time.Now()
+			file := &models.RubyGemsFile{
+				MirrorID:     mirror.ID,
+				GemName:      gemName,
+				Version:      version,
+				RelativePath: path,
+				FileType:     models.RubyGemsFileTypeGem,
+				FileSize:     size,
+				SavePath:     savePath,
+				ContentType:  header.Get("Content-Type"),
+				Sha256:       sum,
+				DownloadedAt: now,
+				LastUsedTime: now,
+			}
+			if err := database.DB.Create(file).Error; err != nil {
+				return fmt.Errorf("保存文件记录失败: %v", err)
+			}
+
+			log.Debug("gem 文件已缓存",
+				zap.String("path", path),
+				zap.Int64("size", size),
+			)
+			return nil
+		},
+	)
+}
+
+// gemChecksum 从 gem 文件名中解析名称和版本，并从 info 文件中查找 sha256
+// gem 名称中可能包含 "-"，因此依次尝试每个以数字开头的片段作为版本号的起点
+func (h *RubyGemsHandler) gemChecksum(mirror *models.Mirror, gemPath string) (gemName, version, sum string) {
+	parts := strings.Split(strings.TrimSuffix(path.Base(gemPath), ".gem"), "-")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] == "" || parts[i][0] < '0' || parts[i][0] > '9' {
+			continue
+		}
+		name := strings.Join(parts[:i], "-")
+		key := strings.Join(parts[i:], "-")
+		if gemName == "" {
+			gemName, version = name, key
+		}
+		if found := h.lookupGemChecksum(mirror, name, key); found != "" {
+			return name, key, found
+		}
+	}
+	return gemName, version, ""
+}
+
+// lookupGemChecksum 在 info/<gem> 中查找指定版本的 sha256，本地缓存中没有时刷新 info 文件
+func (h *RubyGemsHandler) lookupGemChecksum(mirror *models.Mirror, gemName, version string) string {
+	infoPath := "info/" + gemName
+
+	file, err := h.findFile(mirror, infoPath)
+	if err != nil {
+		return ""
+	}
+	if file != nil {
+		if sum := h.parseInfoChecksum(mirror, file.SavePath, version); sum != "" {
+			return sum
+		}
+	}
+
+	updated, resp, err := h.refreshIndex(mirror, infoPath, file, models.RubyGemsFileTypeCompactIndex, true)
+	if err != nil {
+		logger.GetLogger().Warn("拉取 info 文件失败", zap.Error(err), zap.String("path", infoPath))
+		return ""
+	}
+	if resp != nil {
+		resp.Body.Close()
+		return ""
+	}
+	return h.parseInfoChecksum(mirror, updated.SavePath, version)
+}
+
+// parseInfoChecksum 解析 info 文件，返回指定版本的 sha256
+// 每行格式为: <版本>[-<平台>] <依赖>|checksum:<sha256>,ruby:...,rubygems:...
+func (h *RubyGemsHandler) parseInfoChecksum(mirror *models.Mirror, savePath, version string) string {
+	f, err := openCachedFile(mirror, savePath)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, version+" ") {
+			continue
+		}
+		idx := strings.LastIndex(line, "|")
+		if idx == -1 {
+			continue
+		}
+		for _, field := range strings.Split(line[idx+1:], ",") {
+			if strings.HasPrefix(field, "checksum:") {
+				return strings.TrimPrefix(field, "checksum:")
+			}
+		}
+	}
+	return ""
+}
+
+// fileDigests 计算文件的大小、md5 和 sha256
+func (h *RubyGemsHandler) fileDigests(mirror *models.Mirror, savePath string) (int64, string, string, error) {
+	f, err := openCachedFile(mirror, savePath)
+	if err != nil {
+		return 0, "", "", fmt.Errorf("读取缓存文件失败: %v", err)
+	}
+	defer f.Close()
+
+	md5Hash := md5.New()
+	sha256Hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(md5Hash, sha256Hash), f)
+	if err != nil {
+		return 0, "", "", fmt.Errorf("读取缓存文件失败: %v", err)
+	}
+	return size, hex.EncodeToString(md5Hash.Sum(nil)), hex.EncodeToString(sha256Hash.Sum(nil)), nil
+}
+
+// findFile 查找缓存记录，记录存在但文件丢失时删除记录
+func (h *RubyGemsHandler) findFile(mirror *models.Mirror, path string) (*models.RubyGemsFile, error) {
+	var file models.RubyGemsFile
+	result := database.DB.Where(&models.RubyGemsFile{
+		MirrorID:     mirror.ID,
+		RelativePath: path,
+	}).First(&file)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("查询缓存文件失败: %v", result.Error)
+	}
+
+	if !cachedFileExists(mirror, file.SavePath) {
+		logger.GetLogger().Warn("缓存文件不存在，重新拉取", zap.String("path", file.SavePath))
+		database.DB.Delete(&file)
+		return nil, nil
+	}
+	return &file, nil
+}
+
+// serveCachedFile 从缓存提供文件
+func (h *RubyGemsHandler) serveCachedFile(c *gin.Context, mirror *models.Mirror, file *models.RubyGemsFile) error {
+	log := logger.GetLogger()
+
+	log.Debug("命中缓存",
+		zap.String("path", file.RelativePath),
+		zap.String("mirror", mirror.Name),
+	)
+
+	if err := database.DB.Model(file).Update("last_used_time", time.Now()).Error; err != nil {
+		log.Error("更新文件使用时间失败", zap.Error(err))
+	}
+
+	if err := updateMirrorCounts(mirror, true); err != nil {
+		log.Error("更新缓存命中计数失败", zap.Error(err))
+	}
+
+	return h.serveFile(c, mirror, file)
+}
+
+// serveFile 提供本地文件，兼容索引支持客户端的 ETag 和范围请求
+func (h *RubyGemsHandler) serveFile(c *gin.Context, mirror *models.Mirror, file *models.RubyGemsFile) error {
+	if file.FileType != models.RubyGemsFileTypeCompactIndex {
+		return serveLocalFile(c, mirror, file.SavePath, file.ContentType)
+	}
+
+	// bundler 使用 md5 形式的 ETag 和 Repr-Digest 校验下载的索引
+	c.Header("ETag", `"`+file.MD5+`"`)
+	if sum, err := hex.DecodeString(file.Sha256); err == nil {
+		c.Header("Repr-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(sum)+":")
+	}
+	return serveLocalFile(c, mirror, file.SavePath, "text/plain; charset=utf-8")
 }
 
 // getRequestType 判断RubyGems请求的类型
 func (h *RubyGemsHandler) getRequestType(path string) string {
 	switch {
-	case strings.HasSuffix(path, ".gem"):
+	case strings.HasPrefix(path, "gems/") && strings.HasSuffix(path, ".gem"):
 		return "gem"
-	case strings.Contains(path, "/quick/Marshal.4.8/"):
-		return "quick-marshal"
-	case strings.Contains(path, "/specs."):
-		return "specs"
-	case strings.HasSuffix(path, ".gemspec.rz"):
+	case strings.HasPrefix(path, "quick/Marshal.4.8/") && strings.HasSuffix(path, ".gemspec.rz"):
 		return "gemspec"
-	case strings.Contains(path, "/latest_specs."):
+	case strings.HasPrefix(path, "specs."):
+		return "specs"
+	case strings.HasPrefix(path, "latest_specs."):
 		return "latest-specs"
-	case strings.Contains(path, "/prerelease_specs."):
+	case strings.HasPrefix(path, "prerelease_specs."):
 		return "prerelease-specs"
 	case strings.HasSuffix(path, "/dependencies"):
 		return "dependencies"
-	case strings.HasSuffix(path, "/info"):
+	case strings.HasPrefix(path, "info/"):
 		return "info"
-	case strings.HasSuffix(path, "/versions"):
+	case path == "versions":
 		return "versions"
+	case path == "names":
+		return "names"
 	default:
 		return "other"
 	}
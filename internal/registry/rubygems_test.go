@@ -0,0 +1,106 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// rangeUpstream 支持范围请求的上游，记录每次请求的 Range 头
+type rangeUpstream struct {
+	server *httptest.Server
+	mu     sync.Mutex
+	files  map[string]string
+	ranges []string
+}
+
+func newRangeUpstream(t *testing.T, files map[string]string) *rangeUpstream {
+	t.Helper()
+	u := &rangeUpstream{files: files}
+	u.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u.mu.Lock()
+		u.ranges = append(u.ranges, r.Header.Get("Range"))
+		body, ok := u.files[strings.TrimPrefix(r.URL.Path, "/")]
+		u.mu.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(body))
+	}))
+	t.Cleanup(u.server.Close)
+	return u
+}
+
+func (u *rangeUpstream) set(path, body string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.files[path] = body
+}
+
+// takeRanges 返回并清空已记录的 Range 头
+func (u *rangeUpstream) takeRanges() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	ranges := u.ranges
+	u.ranges = nil
+	return ranges
+}
+
+func TestRubyGemsCaching(t *testing.T) {
+	mirror := setupRegistryStore(t)
+	const gem = "gem content"
+	const versionsV1 = "created_at: 2024-01-01\n---\nrails 7.0.0 abc\n"
+	const versionsV2 = versionsV1 + "rails 7.1.0 def\n"
+	const rebuilt = "created_at: 2024-06-01\n---\nrails 7.0.0,7.1.0 ghi\nnet-http 0.4.1 jkl\n"
+	upstream := newRangeUpstream(t, map[string]string{
+		"versions":                versionsV1,
+		"info/net-http":           "---\n0.4.1 uri:>= 0|checksum:" + strings.TrimPrefix(sha256Digest(gem), "sha256:") + ",ruby:>= 2.6.0\n",
+		"gems/net-http-0.4.1.gem": gem,
+	})
+	// 缓存时间为 0，兼容索引每次都向上游增量更新
+	mirror.UpstreamURL = upstream.server.URL
+	h := NewRubyGemsHandler()
+
+	steps := []struct {
+		name       string
+		setup      func()
+		path       string
+		wantBody   string
+		wantRanges []string
+	}{
+		{"第一次获取 versions", nil, "versions", versionsV1, []string{""}},
+		{
+			name:       "上游追加内容后增量更新",
+			setup:      func() { upstream.set("versions", versionsV2) },
+			path:       "versions",
+			wantBody:   versionsV2,
+			wantRanges: []string{fmt.Sprintf("bytes=%d-", len(versionsV1)-1)},
+		},
+		{
+			name:       "上游重建索引后完整拉取",
+			setup:      func() { upstream.set("versions", rebuilt) },
+			path:       "versions",
+			wantBody:   rebuilt,
+			wantRanges: []string{fmt.Sprintf("bytes=%d-", len(versionsV2)-1), ""},
+		},
+		{"按 info 文件中的 sha256 校验 gem", nil, "gems/net-http-0.4.1.gem", gem, []string{"", ""}},
+		{"再次下载 gem 使用缓存", nil, "gems/net-http-0.4.1.gem", gem, nil},
+	}
+	for _, st := range steps {
+		if st.setup != nil {
+			st.setup()
+		}
+		w := serveHandler(h, mirror, st.path, nil)
+		if w.Code != http.StatusOK || w.Body.String() != st.wantBody {
+			t.Fatalf("%s: status = %d, body = %q, want %q", st.name, w.Code, w.Body.String(), st.wantBody)
+		}
+		if got := upstream.takeRanges(); strings.Join(got, ",") != strings.Join(st.wantRanges, ",") || len(got) != len(st.wantRanges) {
+			t.Errorf("%s: 上游收到的 Range = %q, want %q", st.name, got, st.wantRanges)
+		}
+	}
+}
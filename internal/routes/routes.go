@@ -1,15 +1,18 @@
 package routes
 
 import (
+	"easyCacheMirror/internal/auth"
 	"easyCacheMirror/internal/cache"
 	"easyCacheMirror/internal/database"
 	"easyCacheMirror/internal/handlers"
 	"easyCacheMirror/internal/logger"
+	"easyCacheMirror/internal/middleware"
 	"easyCacheMirror/internal/models"
 
 	"go.uber.org/zap"
 
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -20,6 +23,7 @@ func SetupRoutes(r *gin.Engine) {
 		log := logger.GetLogger()
 		log.Error("初始化镜像缓存失败", zap.Error(err))
 	}
+	go refreshMirrorCache()
 
 	// 静态文件服务
 	r.Static("/assets", "./dist/assets")               // 服务前端资源文件
@@ -29,22 +33,53 @@ func SetupRoutes(r *gin.Engine) {
 	controller := handlers.NewController()
 	handler := &handlers.Handler{}
 
-	// API 路由
-	api := r.Group("/api")
+	// 没有用户时创建默认管理员
+	if err := auth.EnsureDefaultAdmin(); err != nil {
+		log := logger.GetLogger()
+		log.Error("创建默认管理员失败", zap.Error(err))
+	}
+
+	// 登录接口不需要鉴权
+	r.POST("/api/auth/login", handlers.Login)
+
+	// API 路由，登录后可以查看
+	api := r.Group("/api", middleware.AuthMiddleware())
 	{
-		api.GET("/mirrors", handlers.ListMirrors)
-		api.POST("/mirrors", handlers.CreateMirror)
-		api.PUT("/mirrors/:id", handlers.UpdateMirror)
-		api.DELETE("/mirrors/:id", handlers.DeleteMirror)
+		api.GET("/auth/me", handlers.GetCurrentUser)
+		api.POST("/auth/logout", handlers.Logout)
+		api.PUT("/auth/password", handlers.ChangePassword)
+		api.GET("/auth/tokens", handlers.ListTokens)
+		api.POST("/auth/tokens", handlers.CreateToken)
+		api.DELETE("/auth/tokens/:id", handlers.DeleteToken)
 
+		api.GET("/mirrors", handlers.ListMirrors)
+		api.GET("/mirrors/:id/cleanups", handlers.ListCleanupRuns)
 		api.GET("/storage", handler.HandleStorage)
-		api.DELETE("/storage", handler.HandleStorage)
-
-		// 添加清理缓存的路由
-		api.POST("/mirrors/:id/cleanup", handlers.CleanupMirrorCache)
 
 		// 添加简化的镜像列表接口
 		api.GET("/mirrors/simple", handlers.GetSimpleMirrors)
+
+		api.GET("/system/offline", handlers.GetOfflineMode)
+	}
+
+	// 修改镜像、清理缓存和管理用户需要管理员权限
+	admin := api.Group("", middleware.RequireAdmin())
+	{
+		admin.POST("/mirrors", handlers.CreateMirror)
+		admin.PUT("/mirrors/:id", handlers.UpdateMirror)
+		admin.DELETE("/mirrors/:id", handlers.DeleteMirror)
+
+		admin.DELETE("/storage", handler.HandleStorage)
+
+		// 添加清理缓存的路由
+		admin.POST("/mirrors/:id/cleanup", handlers.CleanupMirrorCache)
+
+		admin.PUT("/system/offline", handlers.SetOfflineMode)
+
+		admin.GET("/users", handlers.ListUsers)
+		admin.POST("/users", handlers.CreateUser)
+		admin.PUT("/users/:id", handlers.UpdateUser)
+		admin.DELETE("/users/:id", handlers.DeleteUser)
 	}
 
 	// 所有其他请求交给控制器处理，但要排除前端路由
@@ -108,7 +143,7 @@ func isAPIRequest(path string) bool {
 // 初始化镜像缓存
 func initMirrorCache() error {
 	var mirrors []models.Mirror
-	if err := database.DB.Find(&mirrors).Error; err != nil {
+	if err := database.DB.Preload("Upstreams").Find(&mirrors).Error; err != nil {
 		return err
 	}
 
@@ -125,3 +160,21 @@ func initMirrorCache() error {
 
 	return nil
 }
+
+// mirrorRefreshInterval 从数据库重新加载镜像配置的间隔
+const mirrorRefreshInterval = 30 * time.Second
+
+// refreshMirrorCache 定期从数据库重新加载镜像配置
+// 多个实例共用数据库时，其他实例修改或删除的镜像在一个间隔内生效
+func refreshMirrorCache() {
+	ticker := time.NewTicker(mirrorRefreshInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		var mirrors []*models.Mirror
+		if err := database.DB.Preload("Upstreams").Find(&mirrors).Error; err != nil {
+			logger.GetLogger().Error("重新加载镜像配置失败", zap.Error(err))
+			continue
+		}
+		cache.GetMirrorCache().Replace(mirrors)
+	}
+}
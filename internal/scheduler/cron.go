@@ -0,0 +1,152 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultCleanupSchedule 镜像没有设置清理计划时使用的计划：每天凌晨 3 点
+const DefaultCleanupSchedule = "0 3 * * *"
+
+// cronDescriptors 常用计划的简写
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Schedule 解析后的 cron 计划，每个字段记录允许的取值
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+// cronField 字段的取值范围
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = []cronField{
+	{"分钟", 0, 59},
+	{"小时", 0, 23},
+	{"日期", 1, 31},
+	{"月份", 1, 12},
+	{"星期", 0, 7},
+}
+
+// ParseSchedule 解析标准的 5 段 cron 表达式(分 时 日 月 周)，也支持 @daily、@hourly 等简写
+// 每段支持 *、数字、范围(1-5)、步长(*/15、0-30/10)以及逗号分隔的列表，星期的 0 和 7 都表示周日
+func ParseSchedule(expr string) (*Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		expr = DefaultCleanupSchedule
+	}
+	if strings.HasPrefix(expr, "@") {
+		spec, ok := cronDescriptors[expr]
+		if !ok {
+			return nil, fmt.Errorf("不支持的计划: %s", expr)
+		}
+		expr = spec
+	}
+
+	parts := strings.Fields(expr)
+	if len(parts) != len(cronFields) {
+		return nil, fmt.Errorf("cron 表达式需要 5 段(分 时 日 月 周): %s", expr)
+	}
+
+	var bits [5]uint64
+	for i, part := range parts {
+		value, err := parseCronField(part, cronFields[i])
+		if err != nil {
+			return nil, err
+		}
+		bits[i] = value
+	}
+
+	// 星期的 7 和 0 都表示周日
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+
+	// 与标准 cron 一致，以 * 开头的字段(包括 */2 这样的步长)都视为没有限制
+	return &Schedule{
+		minute:  bits[0],
+		hour:    bits[1],
+		dom:     bits[2],
+		month:   bits[3],
+		dow:     bits[4],
+		domStar: strings.HasPrefix(parts[2], "*"),
+		dowStar: strings.HasPrefix(parts[4], "*"),
+	}, nil
+}
+
+// parseCronField 解析 cron 表达式中的一段，返回允许取值的位图
+func parseCronField(part string, field cronField) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(part, ",") {
+		rangePart, step := item, 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			rangePart = item[:i]
+			n, err := strconv.Atoi(item[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("%s字段的步长无效: %s", field.name, item)
+			}
+			step = n
+		}
+
+		start, end := field.min, field.max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err1, err2 error
+			start, err1 = strconv.Atoi(bounds[0])
+			end, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return 0, fmt.Errorf("%s字段的范围无效: %s", field.name, item)
+			}
+		default:
+			n, err := strconv.Atoi(rangePart)
+			if err != nil {
+				return 0, fmt.Errorf("%s字段的取值无效: %s", field.name, item)
+			}
+			start, end = n, n
+			// 单个数字带步长时表示从该值开始到最大值
+			if step > 1 {
+				end = field.max
+			}
+		}
+
+		if start < field.min || end > field.max || start > end {
+			return 0, fmt.Errorf("%s字段超出范围 %d-%d: %s", field.name, field.min, field.max, item)
+		}
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// Matches 判断计划是否在指定的分钟触发
+// 与标准 cron 一致，日期和星期都有限制时满足其中一个即可
+func (s *Schedule) Matches(t time.Time) bool {
+	if s.minute&(1<<uint(t.Minute())) == 0 ||
+		s.hour&(1<<uint(t.Hour())) == 0 ||
+		s.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
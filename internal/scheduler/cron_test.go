@@ -0,0 +1,75 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		wantErr bool
+	}{
+		{"空表达式使用默认计划", "", false},
+		{"简写", "@daily", false},
+		{"不支持的简写", "@reboot", true},
+		{"范围、步长和列表", "0-30/10 1,3 1-15 */2 1-5", false},
+		{"星期的 7 表示周日", "0 0 * * 7", false},
+		{"段数不足", "0 3 * *", true},
+		{"超出范围", "60 3 * * *", true},
+		{"范围反向", "0 5-3 * * *", true},
+		{"步长无效", "*/0 * * * *", true},
+		{"取值无效", "a * * * *", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseSchedule(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseSchedule(%q) error = %v, wantErr %v", tt.expr, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestScheduleMatches(t *testing.T) {
+	// 2024-06-02 是周日
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2024, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		time time.Time
+		want bool
+	}{
+		{"默认计划", "", at(6, 2, 3, 0), true},
+		{"默认计划的其他时间", "", at(6, 2, 3, 1), false},
+		{"分钟步长", "*/15 * * * *", at(6, 2, 10, 45), true},
+		{"不在分钟步长上", "*/15 * * * *", at(6, 2, 10, 46), false},
+		{"带起点的步长", "5/20 * * * *", at(6, 2, 10, 25), true},
+		{"星期的 7 表示周日", "0 0 * * 7", at(6, 2, 0, 0), true},
+		{"工作日", "0 0 * * 1-5", at(6, 2, 0, 0), false},
+		{"日期和星期都有限制时满足日期即可", "0 0 1 * 1", at(6, 1, 0, 0), true},
+		{"日期和星期都有限制时满足星期即可", "0 0 1 * 0", at(6, 2, 0, 0), true},
+		{"星期不限制时只看日期", "0 0 1 * *", at(6, 2, 0, 0), false},
+		{"星期为步长时只看日期", "0 0 1 * */2", at(6, 2, 0, 0), false},
+		{"日期为步长时需要同时满足日期和星期", "0 0 */2 * 1", at(6, 3, 0, 0), true},
+		{"日期为步长时只满足星期", "0 0 */2 * 1", at(6, 10, 0, 0), false},
+		{"日期为步长时只满足日期", "0 0 */2 * 1", at(6, 5, 0, 0), false},
+		{"月份", "0 0 1 1 *", at(6, 1, 0, 0), false},
+		{"每年", "@yearly", at(1, 1, 0, 0), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := ParseSchedule(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := schedule.Matches(tt.time); got != tt.want {
+				t.Errorf("Matches(%s) = %v, want %v", tt.time.Format(time.RFC3339), got, tt.want)
+			}
+		})
+	}
+}
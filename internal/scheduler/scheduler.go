@@ -0,0 +1,265 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"easyCacheMirror/internal/cache"
+	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/logger"
+	"easyCacheMirror/internal/models"
+	"easyCacheMirror/internal/registry"
+	"easyCacheMirror/internal/storage"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// usageCheckInterval 写入缓存后检查使用率的间隔，同一镜像在间隔内的多次写入只检查一次
+	usageCheckInterval = 10 * time.Second
+
+	// maxMissedMinutes 系统休眠等原因错过调度时最多补执行的分钟数
+	maxMissedMinutes = 60
+
+	// maxRunsPerMirror 每个镜像保留的清理记录数量
+	maxRunsPerMirror = 100
+
+	// cleanupLeaseTTL 清理租约的有效时间，清理期间自动续期
+	cleanupLeaseTTL = time.Minute
+)
+
+// ErrCleanupRunning 镜像正在清理
+var ErrCleanupRunning = errors.New("该镜像正在清理缓存")
+
+// errAlreadyCleaned 其他实例已经执行了这一次计划清理
+var errAlreadyCleaned = errors.New("计划清理已由其他实例执行")
+
+var (
+	startOnce sync.Once
+	writes    = make(chan uint, 1024)
+)
+
+// Start 启动后台调度：按镜像的清理计划定时清理，并在写入缓存后检查使用率
+// 需要在数据库和镜像缓存初始化之后调用
+func Start() {
+	startOnce.Do(func() {
+		storage.OnWrite(notifyWrite)
+		go runSchedules()
+		go watchUsage()
+		logger.GetLogger().Info("缓存清理调度已启动")
+	})
+}
+
+// notifyWrite 记录写入了缓存的镜像，队列已满时丢弃，下一次写入会再次通知
+func notifyWrite(mirrorID uint) {
+	select {
+	case writes <- mirrorID:
+	default:
+	}
+}
+
+// cleanable 判断镜像是否需要清理：组合镜像不保存文件，没有设置最大容量的镜像不限制空间，
+// 但 ttl 策略的镜像不论容量都需要定时删除过期的文件
+func cleanable(mirror *models.Mirror) bool {
+	if mirror.IsGroup() {
+		return false
+	}
+	return mirror.MaxSize > 0 || (mirror.EvictionPolicy == models.EvictionTTL && mirror.EvictionTTL > 0)
+}
+
+// runSchedules 每分钟检查一次所有镜像的清理计划
+func runSchedules() {
+	last := time.Now().Truncate(time.Minute)
+	for {
+		next := last.Add(time.Minute)
+		time.Sleep(time.Until(next))
+
+		now := time.Now().Truncate(time.Minute)
+		if now.Sub(next) > maxMissedMinutes*time.Minute {
+			next = now.Add(-maxMissedMinutes * time.Minute)
+		}
+		for t := next; !t.After(now); t = t.Add(time.Minute) {
+			checkSchedules(t)
+		}
+		last = now
+	}
+}
+
+// checkSchedules 清理计划在指定分钟触发的镜像
+func checkSchedules(t time.Time) {
+	log := logger.GetLogger()
+
+	for _, mirror := range cache.GetMirrorCache().GetAll() {
+		if !cleanable(mirror) {
+			continue
+		}
+		schedule, err := ParseSchedule(mirror.CleanupSchedule)
+		if err != nil {
+			log.Error("解析清理计划失败",
+				zap.Error(err),
+				zap.String("mirror", mirror.Name),
+			)
+			continue
+		}
+		if schedule.Matches(t) {
+			go runInBackground(mirror.ID, models.CleanupTriggerSchedule, t)
+		}
+	}
+}
+
+// watchUsage 定期检查最近写入了缓存的镜像，使用空间达到高水位时立即清理
+func watchUsage() {
+	log := logger.GetLogger()
+
+	pending := make(map[uint]bool)
+	ticker := time.NewTicker(usageCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case mirrorID := <-writes:
+			pending[mirrorID] = true
+		case <-ticker.C:
+			for mirrorID := range pending {
+				delete(pending, mirrorID)
+
+				mirror := cache.GetMirrorCache().GetByID(mirrorID)
+				if mirror == nil || !cleanable(mirror) || mirror.MaxSize <= 0 ||
+					mirror.EvictionPolicy == models.EvictionTTL {
+					continue
+				}
+				usage, err := storage.UsedSpace(mirror)
+				if err != nil {
+					log.Error("计算使用空间失败", zap.Error(err), zap.Uint("mirror_id", mirrorID))
+					continue
+				}
+				if high, _ := mirror.Watermarks(); usage.Logical >= high {
+					log.Info("缓存使用空间达到高水位，开始清理",
+						zap.String("mirror", mirror.Name),
+						zap.Int64("used_space", usage.Logical),
+						zap.Int64("max_size", mirror.MaxSize),
+					)
+					go runInBackground(mirrorID, models.CleanupTriggerThreshold, time.Time{})
+				}
+			}
+		}
+	}
+}
+
+// runInBackground 在后台执行清理，结果已经记录在清理记录中，这里只记录日志
+func runInBackground(mirrorID uint, trigger string, scheduled time.Time) {
+	if _, err := runCleanup(mirrorID, trigger, scheduled); err != nil &&
+		!errors.Is(err, ErrCleanupRunning) && !errors.Is(err, errAlreadyCleaned) {
+		logger.GetLogger().Error("清理缓存失败",
+			zap.Error(err),
+			zap.Uint("mirror_id", mirrorID),
+			zap.String("trigger", trigger),
+		)
+	}
+}
+
+// Run 执行一次镜像缓存清理，记录释放的空间并更新镜像的最后清理时间
+// 同一镜像同时只执行一次清理(包括共用数据库的其他实例)，已经在清理时返回 ErrCleanupRunning
+func Run(mirrorID uint, trigger string) (*models.CleanupRun, error) {
+	return runCleanup(mirrorID, trigger, time.Time{})
+}
+
+// runCleanup 执行清理，scheduled 不为空时为该分钟触发的计划清理，
+// 每个实例都会按计划触发，镜像在这之后已经清理过时说明其他实例已经执行，返回 errAlreadyCleaned
+func runCleanup(mirrorID uint, trigger string, scheduled time.Time) (*models.CleanupRun, error) {
+	log := logger.GetLogger()
+
+	lease, err := database.TryAcquireLease(fmt.Sprintf("cleanup:%d", mirrorID), cleanupLeaseTTL)
+	if err != nil {
+		return nil, err
+	}
+	if lease == nil {
+		return nil, ErrCleanupRunning
+	}
+	defer lease.Release()
+
+	var mirror models.Mirror
+	if err := database.DB.First(&mirror, mirrorID).Error; err != nil {
+		return nil, fmt.Errorf("查询镜像失败: %v", err)
+	}
+	if !scheduled.IsZero() && !mirror.LastCleanup.Before(scheduled) {
+		return nil, errAlreadyCleaned
+	}
+	handler := registry.GetRegistry().GetHandler(mirror.Type)
+	if handler == nil {
+		return nil, fmt.Errorf("不支持的镜像类型: %s", mirror.Type)
+	}
+
+	run := &models.CleanupRun{
+		MirrorID:  mirror.ID,
+		Trigger:   trigger,
+		StartedAt: time.Now(),
+	}
+
+	before, err := storage.UsedSpace(&mirror)
+	if err != nil {
+		err = fmt.Errorf("计算使用空间失败: %v", err)
+	} else if cleanable(&mirror) {
+		err = handler.CleanupCache(&mirror)
+	}
+	after, afterErr := storage.UsedSpace(&mirror)
+	if afterErr != nil {
+		after = before
+	}
+	usedBefore, usedAfter := before.Logical, after.Logical
+
+	run.UsedBefore = usedBefore
+	run.UsedAfter = usedAfter
+	if usedBefore > usedAfter {
+		run.Reclaimed = usedBefore - usedAfter
+	}
+	if err != nil {
+		run.Error = err.Error()
+	}
+	run.FinishedAt = time.Now()
+
+	saveRun(&mirror, run)
+
+	log.Info("缓存清理完成",
+		zap.String("mirror", mirror.Name),
+		zap.String("trigger", trigger),
+		zap.Int64("used_before", usedBefore),
+		zap.Int64("used_after", usedAfter),
+		zap.Int64("reclaimed", run.Reclaimed),
+		zap.Duration("duration", run.FinishedAt.Sub(run.StartedAt)),
+	)
+	return run, err
+}
+
+// saveRun 保存清理记录，只保留每个镜像最近的记录，并更新镜像的最后清理时间
+func saveRun(mirror *models.Mirror, run *models.CleanupRun) {
+	log := logger.GetLogger()
+
+	if err := database.DB.Create(run).Error; err != nil {
+		log.Error("保存清理记录失败", zap.Error(err))
+	}
+
+	recent := database.DB.Model(&models.CleanupRun{}).
+		Select("id").
+		Where("mirror_id = ?", mirror.ID).
+		Order("id desc").
+		Limit(maxRunsPerMirror)
+	if err := database.DB.Where("mirror_id = ? AND id NOT IN (?)", mirror.ID, recent).
+		Delete(&models.CleanupRun{}).Error; err != nil {
+		log.Error("删除旧的清理记录失败", zap.Error(err))
+	}
+
+	if err := database.DB.Model(mirror).UpdateColumn("last_cleanup", run.FinishedAt).Error; err != nil {
+		log.Error("更新最后清理时间失败", zap.Error(err))
+	}
+
+	// 镜像缓存中的对象可能正在被请求使用，替换为副本而不是直接修改
+	mirrorCache := cache.GetMirrorCache()
+	if cached := mirrorCache.GetByID(mirror.ID); cached != nil {
+		updated := *cached
+		updated.LastCleanup = run.FinishedAt
+		mirrorCache.Set(&updated)
+	}
+}
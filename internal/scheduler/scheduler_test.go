@@ -0,0 +1,35 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/models"
+)
+
+func TestRunAcrossInstances(t *testing.T) {
+	database.UseTestDB(t)
+	scheduled := time.Now().Truncate(time.Minute)
+	mirror := &models.Mirror{Name: "test", LastCleanup: scheduled.Add(time.Second)}
+	if err := database.DB.Create(mirror).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟另一个实例正在清理
+	lease, err := database.TryAcquireLease(fmt.Sprintf("cleanup:%d", mirror.ID), time.Minute)
+	if err != nil || lease == nil {
+		t.Fatalf("TryAcquireLease() = %v, %v", lease, err)
+	}
+	if _, err := Run(mirror.ID, models.CleanupTriggerManual); !errors.Is(err, ErrCleanupRunning) {
+		t.Errorf("其他实例清理时 Run() error = %v, want %v", err, ErrCleanupRunning)
+	}
+	lease.Release()
+
+	// 其他实例已经执行了这一分钟的计划清理
+	if _, err := runCleanup(mirror.ID, models.CleanupTriggerSchedule, scheduled); !errors.Is(err, errAlreadyCleaned) {
+		t.Errorf("计划清理已执行时 runCleanup() error = %v, want %v", err, errAlreadyCleaned)
+	}
+}
@@ -0,0 +1,116 @@
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	// KeyFile 加密密钥的保存位置，与数据库放在同一目录，备份时需要一起保留
+	KeyFile = "data/secret.key"
+
+	// prefix 密文的前缀，用于区分加密前保存的明文
+	prefix = "enc:v1:"
+)
+
+var (
+	keyOnce sync.Once
+	key     []byte
+	keyErr  error
+)
+
+// loadKey 读取加密密钥，不存在时生成新的 256 位密钥
+func loadKey() ([]byte, error) {
+	keyOnce.Do(func() {
+		data, err := os.ReadFile(KeyFile)
+		if err == nil {
+			key, keyErr = base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+			if keyErr == nil && len(key) != 32 {
+				keyErr = errors.New("密钥长度必须是 32 字节")
+			}
+			if keyErr != nil {
+				keyErr = fmt.Errorf("读取密钥文件 %s 失败: %v", KeyFile, keyErr)
+			}
+			return
+		}
+		if !os.IsNotExist(err) {
+			keyErr = fmt.Errorf("读取密钥文件 %s 失败: %v", KeyFile, err)
+			return
+		}
+
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			keyErr = fmt.Errorf("生成密钥失败: %v", err)
+			return
+		}
+		if err := os.MkdirAll(filepath.Dir(KeyFile), 0755); err != nil {
+			keyErr = fmt.Errorf("创建密钥目录失败: %v", err)
+			return
+		}
+		if err := os.WriteFile(KeyFile, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600); err != nil {
+			keyErr = fmt.Errorf("保存密钥文件失败: %v", err)
+		}
+	})
+	return key, keyErr
+}
+
+// newGCM 使用加密密钥创建 AES-GCM
+func newGCM() (cipher.AEAD, error) {
+	key, err := loadKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("创建加密器失败: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypt 使用 AES-GCM 加密字符串
+func Encrypt(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	gcm, err := newGCM()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("生成随机数失败: %v", err)
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return prefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt 解密 Encrypt 生成的密文，没有密文前缀的值按明文原样返回
+func Decrypt(value string) (string, error) {
+	if !strings.HasPrefix(value, prefix) {
+		return value, nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, prefix))
+	if err != nil {
+		return "", fmt.Errorf("解析密文失败: %v", err)
+	}
+	gcm, err := newGCM()
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("密文长度无效")
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("解密失败，密钥可能已更换: %v", err)
+	}
+	return string(plaintext), nil
+}
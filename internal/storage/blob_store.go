@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"time"
+)
+
+// ErrNotExist 对象不存在
+var ErrNotExist = fs.ErrNotExist
+
+// ErrInvalidKey 对象路径无效
+var ErrInvalidKey = errors.New("无效的对象路径")
+
+// Info 对象的信息
+type Info struct {
+	Key         string
+	Size        int64
+	ModTime     time.Time
+	ContentType string // 写入时记录的内容类型，存储后端返回的信息中为空
+}
+
+// Object 用于读取的对象，支持随机读取，可以直接交给 http.ServeContent
+type Object interface {
+	io.ReadSeekCloser
+	io.ReaderAt
+}
+
+// ReadAtCloser 可随机读取的读取器
+type ReadAtCloser interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// Writer 流式写入的对象，Commit 之前对 Get 不可见
+// 写入时还不知道对象的 key(内容寻址存储按写入内容的 sha256 寻址)，Commit 时再指定
+type Writer interface {
+	io.Writer
+
+	// Reader 打开一个读取已写入内容的读取器，用于同一文件的其他请求跟随读取
+	// 读取器在 Commit 或 Abort 之后仍然可以继续读取已写入的内容，使用完需要关闭
+	Reader() (ReadAtCloser, error)
+
+	// Commit 完成写入并保存为 key，对象替换同名的旧对象
+	Commit(key string) (Info, error)
+
+	// Abort 放弃写入，删除已写入的内容
+	Abort() error
+}
+
+// BlobStore 缓存文件的存储后端
+// 对象按镜像存储目录内的相对路径(key)寻址，key 使用 / 分隔
+type BlobStore interface {
+	// Put 写入对象，写入完成后才替换同名的旧对象
+	Put(key string, r io.Reader) (Info, error)
+
+	// Create 创建流式写入的对象，边写入边供其他请求读取
+	Create() (Writer, error)
+
+	// Get 打开对象，不存在时返回 ErrNotExist
+	Get(key string) (Object, Info, error)
+
+	// Stat 获取对象信息，不存在时返回 ErrNotExist
+	Stat(key string) (Info, error)
+
+	// Delete 删除对象，对象不存在时不返回错误
+	Delete(key string) error
+
+	// DeletePrefix 删除目录下的所有对象，prefix 为空时删除所有对象
+	DeletePrefix(prefix string) error
+}
+
+// Presigner 可以生成临时下载地址的存储后端，客户端可以不经过服务直接下载对象
+type Presigner interface {
+	// PresignGet 生成在 expires 内有效的下载地址，contentType 不为空时指定下载响应的 Content-Type
+	PresignGet(key string, expires time.Duration, contentType string) (string, error)
+}
@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/logger"
+	"easyCacheMirror/internal/models"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CASDir 本地镜像共用的内容寻址存储目录，可以通过 CAS_DIR 环境变量修改
+var CASDir = "data/cas"
+
+// s3CASDir 对象存储中内容寻址存储所在的目录，位于镜像的 Key 前缀下，前缀相同的镜像共用
+const s3CASDir = "cas"
+
+// locationLocal 本地内容寻址存储的位置名称
+const locationLocal = "local"
+
+// casFor 返回镜像使用的内容寻址存储及其位置名称，位置相同的镜像共用对象和引用计数
+// 对象存储配置无效时所有操作都返回该错误
+func casFor(mirror *models.Mirror) (BlobStore, string) {
+	if !mirror.UsesS3() {
+		return NewFileStore(CASDir), locationLocal
+	}
+
+	config := S3ConfigFor(mirror)
+	config.Prefix = path.Join(strings.Trim(mirror.S3Prefix, "/"), s3CASDir)
+	location := "s3:" + mirror.S3Endpoint + "/" + mirror.S3Bucket + "/" + config.Prefix
+	store, err := NewS3Store(config)
+	if err != nil {
+		return errorStore{err: err}, location
+	}
+	return store, location
+}
+
+// casKey 返回内容对应的对象 key，按前两位分目录避免单个目录下文件过多
+func casKey(sum string) string {
+	if len(sum) < 2 {
+		return sum
+	}
+	return sum[:2] + "/" + sum
+}
+
+// blobLeaseTTL 对象租约的有效时间，持有期间自动续期
+const blobLeaseTTL = 30 * time.Second
+
+// blobLeaseTimeout 等待对象租约的最长时间，租约只在保存或删除同一个对象时持有
+const blobLeaseTimeout = 10 * time.Minute
+
+// blobLease 获取对象的租约，保存没有引用的对象和删除对象都需要持有
+// 多个实例共用数据库时，租约保证对象不会在被重新引用的同时被删除
+func (s *Store) blobLease(sum string) (*database.Lease, error) {
+	return database.AcquireLease("blob:"+s.location+":"+sum, blobLeaseTTL, blobLeaseTimeout)
+}
+
+// link 为写入的内容增加一个引用
+// 已有相同内容的对象时丢弃写入的内容，否则将写入的内容保存为对象。
+// 引用计数只通过条件更新修改，对象的保存和删除都在数据库事务之外进行，不会在上传期间占用数据库的写锁
+func (s *Store) link(sum string, size int64, writer Writer) error {
+	// 对象仍有引用时不会被删除，直接增加引用
+	result := database.DB.Model(&models.Blob{}).
+		Where("location = ? AND sha256 = ? AND ref_count > 0", s.location, sum).
+		UpdateColumn("ref_count", gorm.Expr("ref_count + ?", 1))
+	if result.Error != nil {
+		writer.Abort()
+		return fmt.Errorf("增加对象引用失败: %v", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		// 对象被手动删除时重新保存
+		if _, err := s.blobs.Stat(casKey(sum)); err != nil {
+			if _, err := writer.Commit(casKey(sum)); err != nil {
+				s.release(sum)
+				return err
+			}
+			return nil
+		}
+		if err := writer.Abort(); err != nil {
+			logger.GetLogger().Error("删除临时文件失败", zap.Error(err), zap.String("sha256", sum))
+		}
+		return nil
+	}
+
+	// 没有对象，或对象的引用已经降为 0 等待删除。持有租约期间对象不会被删除，先保存对象再创建或恢复记录
+	lease, err := s.blobLease(sum)
+	if err != nil {
+		writer.Abort()
+		return err
+	}
+	defer lease.Release()
+
+	if _, err := writer.Commit(casKey(sum)); err != nil {
+		return err
+	}
+	if err := database.DB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "location"}, {Name: "sha256"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"size":      size,
+			"ref_count": gorm.Expr("ref_count + ?", 1),
+		}),
+	}).Create(&models.Blob{
+		Location: s.location,
+		Sha256:   sum,
+		Size:     size,
+		RefCount: 1,
+	}).Error; err != nil {
+		return fmt.Errorf("保存对象记录失败: %v", err)
+	}
+	return nil
+}
+
+// release 减少对象的一个引用，没有引用时删除对象
+// 对象删除失败时保留引用计数为 0 的记录，下次启动时重新删除
+func (s *Store) release(sum string) {
+	if sum == "" {
+		return
+	}
+
+	err := database.DB.Model(&models.Blob{}).
+		Where("location = ? AND sha256 = ?", s.location, sum).
+		UpdateColumn("ref_count", gorm.Expr("ref_count - ?", 1)).Error
+	if err == nil {
+		err = s.removeUnreferenced(sum)
+	}
+	if err != nil {
+		logger.GetLogger().Error("释放对象失败", zap.Error(err), zap.String("sha256", sum))
+	}
+}
+
+// unreferenced 判断对象的记录是否存在且没有引用
+func (s *Store) unreferenced(sum string) (bool, error) {
+	var count int64
+	if err := database.DB.Model(&models.Blob{}).
+		Where("location = ? AND sha256 = ? AND ref_count <= 0", s.location, sum).
+		Count(&count).Error; err != nil {
+		return false, fmt.Errorf("查询对象引用失败: %v", err)
+	}
+	return count > 0, nil
+}
+
+// removeUnreferenced 删除没有引用的对象及其记录，对象仍有引用时不做任何操作
+// 引用计数为 0 时只能在持有租约的情况下重新增加，获取租约后再次确认没有引用才删除对象
+func (s *Store) removeUnreferenced(sum string) error {
+	if ok, err := s.unreferenced(sum); err != nil || !ok {
+		return err
+	}
+
+	lease, err := s.blobLease(sum)
+	if err != nil {
+		return err
+	}
+	defer lease.Release()
+
+	if ok, err := s.unreferenced(sum); err != nil || !ok {
+		return err
+	}
+	if err := s.blobs.Delete(casKey(sum)); err != nil && !errors.Is(err, ErrNotExist) {
+		return fmt.Errorf("删除对象失败: %v", err)
+	}
+	if err := database.DB.Where("location = ? AND sha256 = ? AND ref_count <= 0", s.location, sum).
+		Delete(&models.Blob{}).Error; err != nil {
+		return fmt.Errorf("删除对象记录失败: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,327 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// setupStorage 使用临时目录中的数据库和内容寻址存储
+func setupStorage(t *testing.T) {
+	t.Helper()
+	database.UseTestDB(t)
+
+	previous := CASDir
+	CASDir = filepath.Join(t.TempDir(), "cas")
+	t.Cleanup(func() { CASDir = previous })
+}
+
+// newTestMirror 创建使用本地存储的镜像
+func newTestMirror(t *testing.T, name string) *models.Mirror {
+	t.Helper()
+	mirror := &models.Mirror{
+		Name:     name,
+		BlobPath: filepath.Join(t.TempDir(), name),
+	}
+	if err := database.DB.Create(mirror).Error; err != nil {
+		t.Fatalf("创建镜像失败: %v", err)
+	}
+	return mirror
+}
+
+func digest(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// refCount 返回对象的引用计数，没有记录时返回 -1
+func refCount(t *testing.T, content string) int64 {
+	t.Helper()
+	var blob models.Blob
+	err := database.DB.Where("location = ? AND sha256 = ?", locationLocal, digest(content)).Take(&blob).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return -1
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blob.RefCount
+}
+
+// objectExists 判断内容寻址存储中是否有该内容的对象
+func objectExists(content string) bool {
+	_, err := NewFileStore(CASDir).Stat(casKey(digest(content)))
+	return err == nil
+}
+
+func TestRefCount(t *testing.T) {
+	setupStorage(t)
+	a := ForMirror(newTestMirror(t, "a"))
+	b := ForMirror(newTestMirror(t, "b"))
+
+	type step struct {
+		name string
+		do   func() error
+		// 每个内容期望的引用计数，-1 表示记录和对象都已删除
+		want map[string]int64
+	}
+	put := func(s *Store, key, content string) func() error {
+		return func() error {
+			_, err := s.Put(key, strings.NewReader(content))
+			return err
+		}
+	}
+	steps := []step{
+		{"写入新内容", put(a, "x.jar", "X"), map[string]int64{"X": 1}},
+		{"其他镜像写入相同内容", put(b, "y.jar", "X"), map[string]int64{"X": 2}},
+		{"同一镜像的另一路径写入相同内容", put(a, "copy/x.jar", "X"), map[string]int64{"X": 3}},
+		{"重复写入同一文件不增加引用", put(a, "x.jar", "X"), map[string]int64{"X": 3}},
+		{"删除一条引用", func() error { return a.Delete("copy/x.jar") }, map[string]int64{"X": 2}},
+		{"覆盖为新内容", put(b, "y.jar", "Y"), map[string]int64{"X": 1, "Y": 1}},
+		{"删除最后一条引用", func() error { return a.Delete("x.jar") }, map[string]int64{"X": -1, "Y": 1}},
+		{"删除后重新写入", put(a, "x.jar", "X"), map[string]int64{"X": 1, "Y": 1}},
+		{"清空镜像", b.Clear, map[string]int64{"X": 1, "Y": -1}},
+		{"删除不存在的文件", func() error { return b.Delete("missing") }, map[string]int64{"X": 1, "Y": -1}},
+	}
+	for _, st := range steps {
+		if err := st.do(); err != nil {
+			t.Fatalf("%s: %v", st.name, err)
+		}
+		for content, want := range st.want {
+			if got := refCount(t, content); got != want {
+				t.Errorf("%s: %s 的引用计数 = %d, want %d", st.name, content, got, want)
+			}
+			if exists := objectExists(content); exists != (want > 0) {
+				t.Errorf("%s: %s 的对象存在 = %v, want %v", st.name, content, exists, want > 0)
+			}
+		}
+	}
+
+	data, err := a.ReadAll("x.jar")
+	if err != nil || string(data) != "X" {
+		t.Errorf("ReadAll() = %q, %v", data, err)
+	}
+}
+
+func TestRefCountConcurrent(t *testing.T) {
+	setupStorage(t)
+	s := ForMirror(newTestMirror(t, "concurrent"))
+
+	const n = 16
+	run := func(do func(i int) error) {
+		var wg sync.WaitGroup
+		errs := make(chan error, n)
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if err := do(i); err != nil {
+					errs <- err
+				}
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Fatal(err)
+		}
+	}
+
+	run(func(i int) error {
+		_, err := s.Put(fmt.Sprintf("file-%d", i), strings.NewReader("same"))
+		return err
+	})
+	if got := refCount(t, "same"); got != n {
+		t.Fatalf("并发写入后引用计数 = %d, want %d", got, n)
+	}
+
+	// 删除和重新写入交替进行，最终只剩重新写入的引用
+	run(func(i int) error {
+		if i%2 == 0 {
+			return s.Delete(fmt.Sprintf("file-%d", i))
+		}
+		_, err := s.Put(fmt.Sprintf("again-%d", i), strings.NewReader("same"))
+		return err
+	})
+	if got, want := refCount(t, "same"), int64(n); got != want {
+		t.Errorf("交替删除和写入后引用计数 = %d, want %d", got, want)
+	}
+
+	run(func(i int) error {
+		if err := s.Delete(fmt.Sprintf("file-%d", i)); err != nil {
+			return err
+		}
+		return s.Delete(fmt.Sprintf("again-%d", i))
+	})
+	if got := refCount(t, "same"); got != -1 {
+		t.Errorf("全部删除后引用计数 = %d, want -1", got)
+	}
+	if objectExists("same") {
+		t.Error("全部删除后对象仍然存在")
+	}
+}
+
+func TestDeleteEntriesBatches(t *testing.T) {
+	setupStorage(t)
+	a := ForMirror(newTestMirror(t, "a"))
+	b := ForMirror(newTestMirror(t, "b"))
+
+	// 跨越多个批次，每个批次中同一内容有多条引用
+	contents := []string{"A", "B", "C"}
+	n := deleteBatchSize*2 + 3
+	for i := 0; i < n; i++ {
+		if _, err := a.Put(fmt.Sprintf("batch/%d", i), strings.NewReader(contents[i%len(contents)])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := b.Put("a.jar", strings.NewReader("A")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Put("keep.jar", strings.NewReader("C")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 查询之后被重新写入的记录不会被删除
+	var stale []models.CacheEntry
+	if err := database.DB.Where("mirror_id = ? AND object_key = ?", a.mirrorID, "keep.jar").Find(&stale).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Put("keep.jar", strings.NewReader("D")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		delete      func() ([]models.CacheEntry, error)
+		wantDeleted int
+		want        map[string]int64
+	}{
+		{
+			name: "内容已变化的记录",
+			delete: func() ([]models.CacheEntry, error) {
+				return a.deleteEntries(stale)
+			},
+			wantDeleted: 0,
+			want:        map[string]int64{"C": int64(n / len(contents)), "D": 1},
+		},
+		{
+			name: "多个批次",
+			delete: func() ([]models.CacheEntry, error) {
+				var entries []models.CacheEntry
+				if err := database.DB.Where("mirror_id = ? AND object_key LIKE ?", a.mirrorID, "batch/%").
+					Find(&entries).Error; err != nil {
+					return nil, err
+				}
+				return a.deleteEntries(entries)
+			},
+			wantDeleted: n,
+			want:        map[string]int64{"A": 1, "B": -1, "C": -1, "D": 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deleted, err := tt.delete()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(deleted) != tt.wantDeleted {
+				t.Errorf("删除了 %d 条记录, want %d", len(deleted), tt.wantDeleted)
+			}
+			for content, want := range tt.want {
+				if got := refCount(t, content); got != want {
+					t.Errorf("%s 的引用计数 = %d, want %d", content, got, want)
+				}
+				if exists := objectExists(content); exists != (want > 0) {
+					t.Errorf("%s 的对象存在 = %v, want %v", content, exists, want > 0)
+				}
+			}
+		})
+	}
+}
+
+func TestReleaseWaitsForLease(t *testing.T) {
+	setupStorage(t)
+	s := ForMirror(newTestMirror(t, "lease"))
+	if _, err := s.Put("x.jar", strings.NewReader("X")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟另一个实例正在保存同一个对象
+	lease, err := s.blobLease(digest("X"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted := make(chan error, 1)
+	go func() { deleted <- s.Delete("x.jar") }()
+
+	time.Sleep(300 * time.Millisecond)
+	if got := refCount(t, "X"); got != 0 {
+		t.Errorf("等待租约时引用计数 = %d, want 0", got)
+	}
+	if !objectExists("X") {
+		t.Error("持有租约时对象被删除")
+	}
+
+	lease.Release()
+	if err := <-deleted; err != nil {
+		t.Fatal(err)
+	}
+	if got := refCount(t, "X"); got != -1 || objectExists("X") {
+		t.Errorf("释放租约后引用计数 = %d, 对象存在 = %v", got, objectExists("X"))
+	}
+}
+
+func TestUsedSpace(t *testing.T) {
+	setupStorage(t)
+	mirrorA, mirrorB := newTestMirror(t, "a"), newTestMirror(t, "b")
+	a, b := ForMirror(mirrorA), ForMirror(mirrorB)
+
+	puts := []struct {
+		store   *Store
+		key     string
+		content string
+	}{
+		{a, "x.jar", "XXXXXX"},
+		{a, "copy/x.jar", "XXXXXX"},
+		{b, "x.jar", "XXXXXX"},
+		{a, "y.jar", "YYY"},
+	}
+	for _, p := range puts {
+		if _, err := p.store.Put(p.key, strings.NewReader(p.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name         string
+		mirror       *models.Mirror
+		wantLogical  int64
+		wantPhysical int64
+	}{
+		// X 被引用 3 次，a 引用 2 次分摊 4 字节，b 分摊 2 字节
+		{"a", mirrorA, 15, 7},
+		{"b", mirrorB, 6, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usage, err := UsedSpace(tt.mirror)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if usage.Logical != tt.wantLogical || usage.Physical != tt.wantPhysical {
+				t.Errorf("UsedSpace() = %+v, want logical %d physical %d", usage, tt.wantLogical, tt.wantPhysical)
+			}
+		})
+	}
+}
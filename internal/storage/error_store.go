@@ -0,0 +1,15 @@
+package storage
+
+import "io"
+
+// errorStore 配置无效的存储后端，所有操作都返回创建时的错误
+type errorStore struct {
+	err error
+}
+
+func (s errorStore) Put(key string, r io.Reader) (Info, error) { return Info{}, s.err }
+func (s errorStore) Create() (Writer, error)                   { return nil, s.err }
+func (s errorStore) Get(key string) (Object, Info, error)      { return nil, Info{}, s.err }
+func (s errorStore) Stat(key string) (Info, error)             { return Info{}, s.err }
+func (s errorStore) Delete(key string) error                   { return s.err }
+func (s errorStore) DeletePrefix(prefix string) error          { return s.err }
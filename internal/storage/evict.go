@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/logger"
+	"easyCacheMirror/internal/models"
+
+	"go.uber.org/zap"
+)
+
+// evictBatchSize 每批查询和删除的文件数量
+const evictBatchSize = 500
+
+// evictionOrders 各淘汰策略选择文件的顺序，排在前面的先删除
+var evictionOrders = map[string]string{
+	models.EvictionLRU:     "last_used_time asc, id asc",
+	models.EvictionLFU:     "hits asc, last_used_time asc, id asc",
+	models.EvictionLargest: "size desc, last_used_time asc, id asc",
+}
+
+// ValidEvictionPolicy 判断淘汰策略是否有效，为空时使用 LRU
+func ValidEvictionPolicy(policy string) bool {
+	if policy == "" || policy == models.EvictionTTL {
+		return true
+	}
+	_, ok := evictionOrders[policy]
+	return ok
+}
+
+// EvictResult 一次淘汰删除的文件
+type EvictResult struct {
+	Files int
+	Bytes int64
+}
+
+// Evict 按镜像的淘汰策略删除缓存文件，本地发布的文件不会被删除
+// ttl 策略删除缓存时间超过 EvictionTTL 的文件；其他策略在使用空间达到高水位时按顺序删除，直到低于低水位。
+// 每批删除后调用 onEvict 传入删除的文件 key，用于删除各类型文件表中的记录
+func Evict(mirror *models.Mirror, onEvict func(keys []string) error) (EvictResult, error) {
+	store := ForMirror(mirror)
+	if mirror.EvictionPolicy == models.EvictionTTL {
+		return store.evictExpired(mirror, onEvict)
+	}
+	return store.evictToWatermark(mirror, onEvict)
+}
+
+// evictExpired 删除缓存时间超过 EvictionTTL 的文件
+func (s *Store) evictExpired(mirror *models.Mirror, onEvict func(keys []string) error) (EvictResult, error) {
+	var result EvictResult
+	if mirror.EvictionTTL <= 0 {
+		return result, nil
+	}
+
+	cutoff := time.Now().Add(-time.Duration(mirror.EvictionTTL) * time.Minute)
+	for {
+		var entries []models.CacheEntry
+		if err := database.DB.Where("mirror_id = ? AND hosted = ? AND stored_at < ?", s.mirrorID, false, cutoff).
+			Order("stored_at asc, id asc").
+			Limit(evictBatchSize).
+			Find(&entries).Error; err != nil {
+			return result, fmt.Errorf("查询过期文件失败: %v", err)
+		}
+		if len(entries) == 0 {
+			return result, nil
+		}
+
+		batch, err := s.deleteBatch(entries, onEvict)
+		result.Files += batch.Files
+		result.Bytes += batch.Bytes
+		if err != nil {
+			return result, err
+		}
+		if len(entries) < evictBatchSize {
+			return result, nil
+		}
+	}
+}
+
+// evictToWatermark 使用空间达到高水位时按策略删除文件，直到低于低水位
+func (s *Store) evictToWatermark(mirror *models.Mirror, onEvict func(keys []string) error) (EvictResult, error) {
+	var result EvictResult
+	if mirror.MaxSize <= 0 {
+		return result, nil
+	}
+
+	usage, err := database.GetMirrorUsedSpace(s.mirrorID, s.location)
+	if err != nil {
+		return result, fmt.Errorf("计算使用空间失败: %v", err)
+	}
+	used := usage.Logical
+	high, low := mirror.Watermarks()
+	if used < high {
+		return result, nil
+	}
+
+	order, ok := evictionOrders[mirror.EvictionPolicy]
+	if !ok {
+		order = evictionOrders[models.EvictionLRU]
+	}
+
+	for used > low {
+		var entries []models.CacheEntry
+		if err := database.DB.Where("mirror_id = ? AND hosted = ?", s.mirrorID, false).
+			Order(order).
+			Limit(evictBatchSize).
+			Find(&entries).Error; err != nil {
+			return result, fmt.Errorf("查询待删除文件失败: %v", err)
+		}
+		if len(entries) == 0 {
+			break
+		}
+
+		// 只删除降到低水位所需的文件
+		var need int64
+		n := 0
+		for n < len(entries) && used-need > low {
+			need += entries[n].Size
+			n++
+		}
+
+		batch, err := s.deleteBatch(entries[:n], onEvict)
+		result.Files += batch.Files
+		result.Bytes += batch.Bytes
+		used -= batch.Bytes
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// deleteBatch 删除一批文件的记录，没有其他记录引用的对象随之删除
+func (s *Store) deleteBatch(entries []models.CacheEntry, onEvict func(keys []string) error) (EvictResult, error) {
+	var result EvictResult
+	deleted, err := s.deleteEntries(entries)
+	keys := make([]string, 0, len(deleted))
+	for _, entry := range deleted {
+		keys = append(keys, entry.Key)
+		result.Files++
+		result.Bytes += entry.Size
+	}
+	if len(keys) > 0 && onEvict != nil {
+		if err := onEvict(keys); err != nil {
+			logger.GetLogger().Error("删除文件记录失败", zap.Error(err), zap.Int("files", len(keys)))
+		}
+	}
+	if err != nil {
+		return result, err
+	}
+	if len(keys) == 0 {
+		return result, fmt.Errorf("删除缓存文件失败")
+	}
+	return result, nil
+}
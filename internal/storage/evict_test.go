@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/models"
+)
+
+// evictFile 淘汰测试中的缓存文件
+type evictFile struct {
+	key    string
+	size   int
+	hosted bool
+	// age 为最后使用和写入存储的时间距今的时长
+	age  time.Duration
+	hits int64
+}
+
+// 合计 100 字节，默认水位下达到高水位 95，需要降到低水位 80
+var evictFiles = []evictFile{
+	{key: "old.jar", size: 20, age: 3 * time.Hour, hits: 5},
+	{key: "new.jar", size: 16, age: 2 * time.Hour, hits: 3},
+	{key: "rare.jar", size: 20, age: time.Hour, hits: 0},
+	{key: "big.jar", size: 40, hits: 10},
+	{key: "hosted.jar", size: 4, hosted: true, age: 4 * time.Hour},
+}
+
+func TestEvict(t *testing.T) {
+	setupStorage(t)
+
+	tests := []struct {
+		name   string
+		mirror models.Mirror
+		want   []string
+	}{
+		{"lru 删除最久未使用的文件", models.Mirror{MaxSize: 100, EvictionPolicy: models.EvictionLRU}, []string{"old.jar"}},
+		{"lfu 删除命中次数最少的文件", models.Mirror{MaxSize: 100, EvictionPolicy: models.EvictionLFU}, []string{"rare.jar"}},
+		{"largest 删除最大的文件", models.Mirror{MaxSize: 100, EvictionPolicy: models.EvictionLargest}, []string{"big.jar"}},
+		{"未设置策略时使用 lru", models.Mirror{MaxSize: 100}, []string{"old.jar"}},
+		{"未达到高水位", models.Mirror{MaxSize: 200, EvictionPolicy: models.EvictionLRU}, nil},
+		{"未限制容量", models.Mirror{EvictionPolicy: models.EvictionLRU}, nil},
+		{
+			"自定义水位",
+			models.Mirror{MaxSize: 100, EvictionPolicy: models.EvictionLRU, HighWatermark: 50, LowWatermark: 50},
+			[]string{"new.jar", "old.jar", "rare.jar"},
+		},
+		{"ttl 删除缓存时间超过 EvictionTTL 的文件", models.Mirror{EvictionPolicy: models.EvictionTTL, EvictionTTL: 90}, []string{"new.jar", "old.jar"}},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mirror := newTestMirror(t, fmt.Sprintf("evict-%d", i))
+			mirror.MaxSize = tt.mirror.MaxSize
+			mirror.EvictionPolicy = tt.mirror.EvictionPolicy
+			mirror.EvictionTTL = tt.mirror.EvictionTTL
+			mirror.HighWatermark = tt.mirror.HighWatermark
+			mirror.LowWatermark = tt.mirror.LowWatermark
+
+			store := ForMirror(mirror)
+			now := time.Now()
+			for _, f := range evictFiles {
+				put := store.Put
+				if f.hosted {
+					put = store.PutHosted
+				}
+				entry, err := put(f.key, strings.NewReader(strings.Repeat(f.key[:1], f.size)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := database.DB.Model(entry).Updates(map[string]interface{}{
+					"last_used_time": now.Add(-f.age),
+					"stored_at":      now.Add(-f.age),
+					"hits":           f.hits,
+				}).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var evicted []string
+			result, err := Evict(mirror, func(keys []string) error {
+				evicted = append(evicted, keys...)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(evicted)
+			if strings.Join(evicted, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("删除的文件 = %v, want %v", evicted, tt.want)
+			}
+			if result.Files != len(tt.want) {
+				t.Errorf("EvictResult.Files = %d, want %d", result.Files, len(tt.want))
+			}
+			for _, key := range tt.want {
+				if store.Exists(key) {
+					t.Errorf("%s 仍然存在", key)
+				}
+			}
+			if !store.Exists("hosted.jar") {
+				t.Error("本地发布的文件不应被删除")
+			}
+		})
+	}
+}
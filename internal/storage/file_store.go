@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// FileStore 将对象保存在本地目录中
+type FileStore struct {
+	root string
+}
+
+// NewFileStore 创建以 root 为根目录的本地存储
+func NewFileStore(root string) *FileStore {
+	return &FileStore{root: root}
+}
+
+// path 返回对象在本地的路径，key 不能超出根目录
+func (s *FileStore) path(key string) (string, error) {
+	cleaned := path.Clean("/" + strings.ReplaceAll(key, "\\", "/"))
+	if cleaned == "/" {
+		return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(cleaned)), nil
+}
+
+// createTemp 在对象所在目录创建临时文件，完成后重命名为对象，避免留下不完整的文件
+func (s *FileStore) createTemp(key string) (*os.File, string, error) {
+	target, err := s.path(key)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return nil, "", fmt.Errorf("创建缓存目录失败: %v", err)
+	}
+	file, err := os.CreateTemp(filepath.Dir(target), filepath.Base(target)+".*.tmp")
+	if err != nil {
+		return nil, "", fmt.Errorf("创建临时文件失败: %v", err)
+	}
+	return file, target, nil
+}
+
+// Put 写入对象
+func (s *FileStore) Put(key string, r io.Reader) (Info, error) {
+	file, target, err := s.createTemp(key)
+	if err != nil {
+		return Info{}, err
+	}
+	tmpPath := file.Name()
+
+	_, err = io.Copy(file, r)
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return Info{}, fmt.Errorf("写入缓存文件失败: %v", err)
+	}
+	if err := os.Chmod(tmpPath, 0644); err != nil {
+		os.Remove(tmpPath)
+		return Info{}, fmt.Errorf("保存缓存文件失败: %v", err)
+	}
+	if err := os.Rename(tmpPath, target); err != nil {
+		os.Remove(tmpPath)
+		return Info{}, fmt.Errorf("保存缓存文件失败: %v", err)
+	}
+	return s.Stat(key)
+}
+
+// Create 创建流式写入的对象，临时文件放在根目录，Commit 时重命名到对象的路径
+func (s *FileStore) Create() (Writer, error) {
+	if err := os.MkdirAll(s.root, 0755); err != nil {
+		return nil, fmt.Errorf("创建缓存目录失败: %v", err)
+	}
+	file, err := os.CreateTemp(s.root, ".upload-*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("创建临时文件失败: %v", err)
+	}
+	return &fileWriter{store: s, file: file}, nil
+}
+
+// Get 打开对象
+func (s *FileStore) Get(key string) (Object, Info, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	file, err := os.Open(p)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, Info{}, err
+	}
+	if stat.IsDir() {
+		file.Close()
+		return nil, Info{}, ErrNotExist
+	}
+	return file, Info{Key: key, Size: stat.Size(), ModTime: stat.ModTime()}, nil
+}
+
+// Stat 获取对象信息
+func (s *FileStore) Stat(key string) (Info, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return Info{}, err
+	}
+	stat, err := os.Stat(p)
+	if err != nil {
+		return Info{}, err
+	}
+	if stat.IsDir() {
+		return Info{}, ErrNotExist
+	}
+	return Info{Key: key, Size: stat.Size(), ModTime: stat.ModTime()}, nil
+}
+
+// Delete 删除对象
+func (s *FileStore) Delete(key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("删除缓存文件失败: %v", err)
+	}
+	return nil
+}
+
+// DeletePrefix 删除目录下的所有对象，prefix 为空时删除存储目录
+func (s *FileStore) DeletePrefix(prefix string) error {
+	p := s.root
+	if strings.Trim(prefix, "/") != "" {
+		var err error
+		if p, err = s.path(prefix); err != nil {
+			return err
+		}
+	}
+	if err := os.RemoveAll(p); err != nil {
+		return fmt.Errorf("删除缓存目录失败: %v", err)
+	}
+	return nil
+}
+
+// fileWriter 写入临时文件，Commit 时重命名为对象
+type fileWriter struct {
+	store *FileStore
+	file  *os.File
+}
+
+func (w *fileWriter) Write(p []byte) (int, error) {
+	return w.file.Write(p)
+}
+
+// Reader 单独打开临时文件，重命名或删除后已打开的文件仍然可以读取
+func (w *fileWriter) Reader() (ReadAtCloser, error) {
+	return os.Open(w.file.Name())
+}
+
+func (w *fileWriter) Commit(key string) (Info, error) {
+	tmpPath := w.file.Name()
+	target, err := w.store.path(key)
+	if err != nil {
+		w.Abort()
+		return Info{}, err
+	}
+	if err := w.file.Close(); err != nil {
+		os.Remove(tmpPath)
+		return Info{}, fmt.Errorf("写入缓存文件失败: %v", err)
+	}
+	if err := os.Chmod(tmpPath, 0644); err != nil {
+		os.Remove(tmpPath)
+		return Info{}, fmt.Errorf("保存缓存文件失败: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		os.Remove(tmpPath)
+		return Info{}, fmt.Errorf("创建缓存目录失败: %v", err)
+	}
+	if err := os.Rename(tmpPath, target); err != nil {
+		os.Remove(tmpPath)
+		return Info{}, fmt.Errorf("保存缓存文件失败: %v", err)
+	}
+	return w.store.Stat(key)
+}
+
+func (w *fileWriter) Abort() error {
+	w.file.Close()
+	if err := os.Remove(w.file.Name()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/logger"
+	"easyCacheMirror/internal/models"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// initLeaseTTL 初始化租约的有效时间，迁移期间自动续期
+	initLeaseTTL = time.Minute
+
+	// initLeaseTimeout 等待其他实例完成初始化的最长时间
+	initLeaseTimeout = time.Hour
+)
+
+// Init 初始化内容寻址存储，需要在处理请求之前调用
+// 将旧版本按路径保存在镜像存储目录中的文件移入内容寻址存储，并根据缓存记录校正引用计数
+func Init() error {
+	log := logger.GetLogger()
+
+	// 多个实例同时启动时依次迁移，后启动的实例不会重复迁移已完成的文件
+	lease, err := database.AcquireLease("storage:init", initLeaseTTL, initLeaseTimeout)
+	if err != nil {
+		return err
+	}
+	defer lease.Release()
+
+	var mirrors []models.Mirror
+	if err := database.DB.Find(&mirrors).Error; err != nil {
+		return fmt.Errorf("获取镜像列表失败: %v", err)
+	}
+
+	// 对象记录为空说明是升级后第一次启动，所有缓存记录都需要迁移；
+	// 否则只迁移上次没有完成的记录，即内容不在内容寻址存储中的记录
+	var blobCount int64
+	if err := database.DB.Model(&models.Blob{}).Count(&blobCount).Error; err != nil {
+		return fmt.Errorf("查询对象记录失败: %v", err)
+	}
+	for i := range mirrors {
+		migrated, err := migrateMirror(&mirrors[i], blobCount == 0)
+		if migrated > 0 {
+			log.Info("已将缓存文件迁移到内容寻址存储",
+				zap.String("mirror", mirrors[i].Name),
+				zap.Int("files", migrated),
+			)
+		}
+		if err != nil {
+			log.Error("迁移缓存文件失败", zap.Error(err), zap.String("mirror", mirrors[i].Name))
+		}
+	}
+
+	// 其他实例正在写入时，已增加引用但还没有保存缓存记录的对象会被误判为没有引用，只在单独运行时校正
+	others, err := database.OtherInstancesRunning()
+	if err != nil {
+		return err
+	}
+	if others {
+		log.Info("有其他实例正在运行，跳过引用计数校正")
+		return nil
+	}
+	return reconcile(mirrors)
+}
+
+// legacyStore 返回旧版本按路径保存文件的存储
+func legacyStore(mirror *models.Mirror) BlobStore {
+	if !mirror.UsesS3() {
+		return NewFileStore(mirror.BlobPath)
+	}
+	store, err := NewS3Store(S3ConfigFor(mirror))
+	if err != nil {
+		return errorStore{err: err}
+	}
+	return store
+}
+
+// migrateMirror 将镜像按路径保存的文件移入内容寻址存储，返回迁移的文件数
+func migrateMirror(mirror *models.Mirror, all bool) (int, error) {
+	s := ForMirror(mirror)
+	query := database.DB.Where("mirror_id = ?", mirror.ID)
+	if !all {
+		query = query.Where("NOT EXISTS (SELECT 1 FROM blobs WHERE blobs.location = ? AND blobs.sha256 = cache_entries.sha256)", s.location)
+	}
+	var entries []models.CacheEntry
+	if err := query.Find(&entries).Error; err != nil {
+		return 0, fmt.Errorf("查询缓存记录失败: %v", err)
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+
+	legacy := legacyStore(mirror)
+	migrated := 0
+	for _, entry := range entries {
+		object, _, err := legacy.Get(entry.Key)
+		if errors.Is(err, ErrNotExist) {
+			// 文件已经不存在，删除缓存记录和各类型文件表中的记录后由处理器重新拉取，
+			// 只删除缓存记录时处理器会按类型记录认为文件已缓存
+			savePath := filepath.Join(mirror.BlobPath, filepath.FromSlash(entry.Key))
+			if err := database.DeleteFileRecords(mirror.ID, savePath, false); err != nil {
+				return migrated, fmt.Errorf("删除文件记录失败: %v", err)
+			}
+			if err := database.DB.Delete(&models.CacheEntry{}, entry.ID).Error; err != nil {
+				return migrated, fmt.Errorf("删除缓存记录失败: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			return migrated, err
+		}
+
+		writer, err := s.blobs.Create()
+		if err != nil {
+			object.Close()
+			return migrated, err
+		}
+		sum := sha256.New()
+		size, err := io.Copy(io.MultiWriter(writer, sum), object)
+		object.Close()
+		if err != nil {
+			writer.Abort()
+			return migrated, fmt.Errorf("复制缓存文件 %s 失败: %v", entry.Key, err)
+		}
+
+		digest := hex.EncodeToString(sum.Sum(nil))
+		if err := s.link(digest, size, writer); err != nil {
+			return migrated, err
+		}
+		if err := database.DB.Model(&models.CacheEntry{}).
+			Where("id = ?", entry.ID).
+			UpdateColumns(map[string]interface{}{"sha256": digest, "size": size}).Error; err != nil {
+			s.release(digest)
+			return migrated, fmt.Errorf("更新缓存记录失败: %v", err)
+		}
+		if err := legacy.Delete(entry.Key); err != nil {
+			logger.GetLogger().Error("删除旧缓存文件失败", zap.Error(err), zap.String("key", entry.Key))
+		}
+		migrated++
+	}
+	return migrated, nil
+}
+
+// reconcile 根据缓存记录重新计算对象的引用计数，删除没有引用的对象
+// 用于修复异常退出时没有完成的引用更新，以及重新删除上次删除失败的对象
+func reconcile(mirrors []models.Mirror) error {
+	log := logger.GetLogger()
+
+	// 本地目录不需要凭据，即使没有镜像使用也可以清理
+	stores := map[string]*Store{
+		locationLocal: {blobs: NewFileStore(CASDir), location: locationLocal},
+	}
+	mirrorIDs := make(map[string][]uint)
+	for i := range mirrors {
+		s := ForMirror(&mirrors[i])
+		if _, ok := stores[s.location]; !ok {
+			stores[s.location] = s
+		}
+		mirrorIDs[s.location] = append(mirrorIDs[s.location], mirrors[i].ID)
+	}
+
+	for location, s := range stores {
+		type refCount struct {
+			Sha256 string
+			Count  int64
+		}
+		var counts []refCount
+		if ids := mirrorIDs[location]; len(ids) > 0 {
+			if err := database.DB.Model(&models.CacheEntry{}).
+				Select("sha256, COUNT(*) AS count").
+				Where("mirror_id IN ? AND sha256 <> ''", ids).
+				Group("sha256").
+				Scan(&counts).Error; err != nil {
+				return fmt.Errorf("统计对象引用失败: %v", err)
+			}
+		}
+		refs := make(map[string]int64, len(counts))
+		for _, count := range counts {
+			refs[count.Sha256] = count.Count
+		}
+
+		var blobs []models.Blob
+		if err := database.DB.Where("location = ?", location).Find(&blobs).Error; err != nil {
+			return fmt.Errorf("查询对象记录失败: %v", err)
+		}
+		fixed, removed := 0, 0
+		for _, blob := range blobs {
+			count := refs[blob.Sha256]
+			if count != blob.RefCount {
+				if err := database.DB.Model(&models.Blob{}).
+					Where("id = ?", blob.ID).
+					UpdateColumn("ref_count", count).Error; err != nil {
+					return fmt.Errorf("更新对象引用失败: %v", err)
+				}
+				blob.RefCount = count
+				fixed++
+			}
+			if count == 0 {
+				if err := s.removeUnreferenced(blob.Sha256); err != nil {
+					log.Error("删除对象失败", zap.Error(err), zap.String("sha256", blob.Sha256))
+					continue
+				}
+				removed++
+			}
+		}
+		if fixed > 0 || removed > 0 {
+			log.Info("已校正对象引用计数",
+				zap.String("location", location),
+				zap.Int("fixed", fixed),
+				zap.Int("removed", removed),
+			)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/models"
+)
+
+func TestMigrateMirror(t *testing.T) {
+	setupStorage(t)
+	mirror := newTestMirror(t, "legacy")
+
+	// 旧版本按路径保存的文件，其中 missing.txt 的文件已经不存在
+	legacy := NewFileStore(mirror.BlobPath)
+	for key, content := range map[string]string{"a/one.txt": "one", "b/two.txt": "two", "b/dup.txt": "one"} {
+		if _, err := legacy.Put(key, strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range []string{"a/one.txt", "b/two.txt", "b/dup.txt", "missing.txt"} {
+		database.DB.Create(&models.CacheEntry{MirrorID: mirror.ID, Key: key})
+		database.DB.Create(&models.RawFile{
+			MirrorID: mirror.ID,
+			SavePath: filepath.Join(mirror.BlobPath, filepath.FromSlash(key)),
+		})
+	}
+
+	migrated, err := migrateMirror(mirror, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated != 3 {
+		t.Errorf("migrated = %d, want 3", migrated)
+	}
+
+	s := ForMirror(mirror)
+	tests := []struct {
+		key     string
+		content string
+		exists  bool
+	}{
+		{"a/one.txt", "one", true},
+		{"b/two.txt", "two", true},
+		{"b/dup.txt", "one", true},
+		{"missing.txt", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			data, err := s.ReadAll(tt.key)
+			if tt.exists != (err == nil) {
+				t.Fatalf("ReadAll() error = %v, want exists %v", err, tt.exists)
+			}
+			if tt.exists && string(data) != tt.content {
+				t.Errorf("ReadAll() = %q, want %q", data, tt.content)
+			}
+
+			// 旧文件迁移后删除
+			if _, err := os.Stat(filepath.Join(mirror.BlobPath, filepath.FromSlash(tt.key))); !os.IsNotExist(err) {
+				t.Errorf("旧文件仍然存在: %v", err)
+			}
+
+			// 文件不存在时类型记录和缓存记录一起删除，处理器会重新拉取
+			var files int64
+			database.DB.Model(&models.RawFile{}).
+				Where("save_path = ?", filepath.Join(mirror.BlobPath, filepath.FromSlash(tt.key))).
+				Count(&files)
+			if want := map[bool]int64{true: 1, false: 0}[tt.exists]; files != want {
+				t.Errorf("类型记录数 = %d, want %d", files, want)
+			}
+		})
+	}
+
+	if got := refCount(t, "one"); got != 2 {
+		t.Errorf("one 的引用计数 = %d, want 2", got)
+	}
+	if got := refCount(t, "two"); got != 1 {
+		t.Errorf("two 的引用计数 = %d, want 1", got)
+	}
+
+	// 再次迁移时没有需要迁移的记录
+	if migrated, err := migrateMirror(mirror, false); err != nil || migrated != 0 {
+		t.Errorf("再次迁移 migrated = %d, err = %v", migrated, err)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	setupStorage(t)
+	mirror := newTestMirror(t, "reconcile")
+	s := ForMirror(mirror)
+	for key, content := range map[string]string{"kept": "kept", "also-kept": "kept", "orphan": "orphan"} {
+		if _, err := s.Put(key, strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 模拟异常退出：缓存记录已删除但引用没有减少，另一个对象的引用计数偏大
+	database.DB.Where("mirror_id = ? AND object_key = ?", mirror.ID, "orphan").Delete(&models.CacheEntry{})
+	database.DB.Model(&models.Blob{}).Where("sha256 = ?", digest("kept")).Update("ref_count", 5)
+
+	if err := reconcile([]models.Mirror{*mirror}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		content string
+		want    int64
+	}{
+		{"kept", 2},
+		{"orphan", -1},
+	}
+	for _, tt := range tests {
+		if got := refCount(t, tt.content); got != tt.want {
+			t.Errorf("%s 的引用计数 = %d, want %d", tt.content, got, tt.want)
+		}
+		if exists := objectExists(tt.content); exists != (tt.want > 0) {
+			t.Errorf("%s 的对象存在 = %v, want %v", tt.content, exists, tt.want > 0)
+		}
+	}
+}
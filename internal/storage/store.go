@@ -0,0 +1,456 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+	"time"
+
+	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/logger"
+	"easyCacheMirror/internal/models"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// writeHook 对象写入后的回调
+var writeHook func(mirrorID uint)
+
+// OnWrite 注册对象写入后的回调，用于在使用空间超过阈值时触发清理
+// 回调在写入的 goroutine 中同步调用，不能执行耗时的操作
+func OnWrite(hook func(mirrorID uint)) {
+	writeHook = hook
+}
+
+// Store 镜像的缓存存储
+// 所有处理器都通过它读写缓存文件，写入和删除时同步维护 CacheEntry 记录。
+// 文件内容按 sha256 保存在共用的内容寻址存储中，CacheEntry 记录镜像内的路径到内容的映射
+type Store struct {
+	mirrorID uint
+	blobs    BlobStore
+	location string // 内容寻址存储的位置，同一位置的镜像共用对象
+}
+
+// presignExpires 临时下载地址的有效时间
+const presignExpires = 15 * time.Minute
+
+// ForMirror 返回镜像的缓存存储
+func ForMirror(mirror *models.Mirror) *Store {
+	blobs, location := casFor(mirror)
+	return &Store{
+		mirrorID: mirror.ID,
+		blobs:    blobs,
+		location: location,
+	}
+}
+
+// UsedSpace 计算镜像已用的逻辑空间和实际占用的空间
+func UsedSpace(mirror *models.Mirror) (database.MirrorUsage, error) {
+	_, location := casFor(mirror)
+	return database.GetMirrorUsedSpace(mirror.ID, location)
+}
+
+// S3ConfigFor 返回镜像的对象存储配置
+func S3ConfigFor(mirror *models.Mirror) S3Config {
+	return S3Config{
+		Endpoint:    mirror.S3Endpoint,
+		Region:      mirror.S3Region,
+		Bucket:      mirror.S3Bucket,
+		Prefix:      mirror.S3Prefix,
+		AccessKey:   mirror.S3AccessKey,
+		SecretKey:   string(mirror.S3SecretKey),
+		VirtualHost: mirror.S3VirtualHost,
+	}
+}
+
+// normalizeKey 统一使用 / 分隔且不带开头的 /
+func normalizeKey(key string) string {
+	return strings.TrimPrefix(strings.ReplaceAll(key, "\\", "/"), "/")
+}
+
+// Put 写入从上游获取的文件
+func (s *Store) Put(key string, r io.Reader) (*models.CacheEntry, error) {
+	return s.put(key, r, false)
+}
+
+// PutHosted 写入本地发布的文件，本地发布的文件不会被清理
+func (s *Store) PutHosted(key string, r io.Reader) (*models.CacheEntry, error) {
+	return s.put(key, r, true)
+}
+
+func (s *Store) put(key string, r io.Reader, hosted bool) (*models.CacheEntry, error) {
+	upload, err := s.Create(key)
+	if err != nil {
+		return nil, err
+	}
+	upload.hosted = hosted
+	if _, err := io.Copy(upload, r); err != nil {
+		upload.Abort()
+		return nil, fmt.Errorf("写入缓存文件失败: %v", err)
+	}
+	return upload.Commit()
+}
+
+// Create 创建流式写入的文件，Commit 后才对 Get 可见
+func (s *Store) Create(key string) (*Upload, error) {
+	key = normalizeKey(key)
+	if key == "" {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	writer, err := s.blobs.Create()
+	if err != nil {
+		return nil, err
+	}
+	return &Upload{store: s, key: key, writer: writer, hash: sha256.New()}, nil
+}
+
+// entry 查询文件的缓存记录，不存在时返回 ErrNotExist
+func (s *Store) entry(key string) (*models.CacheEntry, error) {
+	var entry models.CacheEntry
+	err := database.DB.Where("mirror_id = ? AND object_key = ?", s.mirrorID, normalizeKey(key)).
+		Take(&entry).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotExist
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询缓存记录失败: %v", err)
+	}
+	if entry.Sha256 == "" {
+		return nil, ErrNotExist
+	}
+	return &entry, nil
+}
+
+// entryInfo 按缓存记录返回文件信息，修改时间为写入存储的时间
+func entryInfo(entry *models.CacheEntry) Info {
+	return Info{Key: entry.Key, Size: entry.Size, ModTime: entry.StoredAt, ContentType: entry.ContentType}
+}
+
+// Get 打开文件并更新最后使用时间和读取次数
+func (s *Store) Get(key string) (Object, Info, error) {
+	entry, err := s.entry(key)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	object, _, err := s.blobs.Get(casKey(entry.Sha256))
+	if err != nil {
+		return nil, Info{}, err
+	}
+	s.touch(entry.ID)
+	return object, entryInfo(entry), nil
+}
+
+// PresignGet 生成文件的临时下载地址并更新最后使用时间和读取次数
+// 存储后端不支持时返回 ok 为 false
+func (s *Store) PresignGet(key, contentType string) (url string, ok bool, err error) {
+	presigner, ok := s.blobs.(Presigner)
+	if !ok {
+		return "", false, nil
+	}
+	entry, err := s.entry(key)
+	if err != nil {
+		return "", true, err
+	}
+	url, err = presigner.PresignGet(casKey(entry.Sha256), presignExpires, contentType)
+	if err != nil {
+		return "", true, err
+	}
+	s.touch(entry.ID)
+	return url, true, nil
+}
+
+// ReadAll 读取整个文件
+func (s *Store) ReadAll(key string) ([]byte, error) {
+	object, _, err := s.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+	return io.ReadAll(object)
+}
+
+// Stat 获取文件信息
+func (s *Store) Stat(key string) (Info, error) {
+	entry, err := s.entry(key)
+	if err != nil {
+		return Info{}, err
+	}
+	return entryInfo(entry), nil
+}
+
+// Exists 判断文件是否存在
+func (s *Store) Exists(key string) bool {
+	_, err := s.Stat(key)
+	return err == nil
+}
+
+// Delete 删除文件及其记录，没有其他记录引用文件内容时删除对象
+func (s *Store) Delete(key string) error {
+	var entries []models.CacheEntry
+	if err := database.DB.Where("mirror_id = ? AND object_key = ?", s.mirrorID, normalizeKey(key)).
+		Find(&entries).Error; err != nil {
+		return fmt.Errorf("查询缓存记录失败: %v", err)
+	}
+	_, err := s.deleteEntries(entries)
+	return err
+}
+
+// DeletePrefix 删除目录下的所有文件及其记录
+func (s *Store) DeletePrefix(prefix string) error {
+	prefix = strings.TrimSuffix(normalizeKey(prefix), "/")
+	var entries []models.CacheEntry
+	if err := database.DB.Where("mirror_id = ? AND (object_key = ? OR object_key LIKE ? ESCAPE '\\')",
+		s.mirrorID, prefix, escapeLike(prefix)+"/%").
+		Find(&entries).Error; err != nil {
+		return fmt.Errorf("查询缓存记录失败: %v", err)
+	}
+	_, err := s.deleteEntries(entries)
+	return err
+}
+
+// Clear 删除镜像的所有文件及其记录
+func (s *Store) Clear() error {
+	var entries []models.CacheEntry
+	if err := database.DB.Where("mirror_id = ?", s.mirrorID).Find(&entries).Error; err != nil {
+		return fmt.Errorf("查询缓存记录失败: %v", err)
+	}
+	_, err := s.deleteEntries(entries)
+	return err
+}
+
+// deleteBatchSize 每条语句删除的记录数量，避免超过 SQLite 的参数数量限制
+const deleteBatchSize = 500
+
+// deleteEntries 删除缓存记录并释放引用的对象，返回实际删除的记录
+// 只删除内容没有变化的记录，删除期间被重新写入的文件保留新的内容
+func (s *Store) deleteEntries(entries []models.CacheEntry) ([]models.CacheEntry, error) {
+	deleted := make([]models.CacheEntry, 0, len(entries))
+	for start := 0; start < len(entries); start += deleteBatchSize {
+		batch, err := s.deleteBatchEntries(entries[start:min(start+deleteBatchSize, len(entries))])
+		deleted = append(deleted, batch...)
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// deleteBatchEntries 在一个事务中删除一批记录并减少对象的引用计数，
+// 事务提交后再删除没有引用的对象，对象的删除不占用数据库的写锁
+func (s *Store) deleteBatchEntries(entries []models.CacheEntry) ([]models.CacheEntry, error) {
+	byID := make(map[uint]models.CacheEntry, len(entries))
+	ids := make([]uint, 0, len(entries))
+	sumByID := make([]interface{}, 0, len(entries)*2)
+	for _, entry := range entries {
+		byID[entry.ID] = entry
+		ids = append(ids, entry.ID)
+		sumByID = append(sumByID, entry.ID, entry.Sha256)
+	}
+
+	var deleted []models.CacheEntry
+	refs := make(map[string]int)
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var deletedIDs []uint
+		if err := tx.Raw("DELETE FROM cache_entries WHERE id IN ? AND sha256 = "+caseExpr("id", len(entries))+" RETURNING id",
+			append([]interface{}{ids}, sumByID...)...).
+			Scan(&deletedIDs).Error; err != nil {
+			return fmt.Errorf("删除缓存记录失败: %v", err)
+		}
+
+		for _, id := range deletedIDs {
+			entry := byID[id]
+			deleted = append(deleted, entry)
+			if entry.Sha256 != "" {
+				refs[entry.Sha256]++
+			}
+		}
+		if len(refs) == 0 {
+			return nil
+		}
+
+		sums := make([]string, 0, len(refs))
+		countBySum := make([]interface{}, 0, len(refs)*2)
+		for sum, count := range refs {
+			sums = append(sums, sum)
+			countBySum = append(countBySum, sum, count)
+		}
+		if err := tx.Model(&models.Blob{}).
+			Where("location = ? AND sha256 IN ?", s.location, sums).
+			UpdateColumn("ref_count", gorm.Expr("ref_count - "+caseExpr("sha256", len(refs)), countBySum...)).
+			Error; err != nil {
+			return fmt.Errorf("减少对象引用失败: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(refs) == 0 {
+		return deleted, nil
+	}
+
+	sums := make([]string, 0, len(refs))
+	for sum := range refs {
+		sums = append(sums, sum)
+	}
+	var unreferenced []string
+	if err := database.DB.Model(&models.Blob{}).
+		Where("location = ? AND sha256 IN ? AND ref_count <= 0", s.location, sums).
+		Pluck("sha256", &unreferenced).Error; err != nil {
+		// 引用计数已经更新，没有删除的对象在下次启动时清理
+		logger.GetLogger().Error("查询没有引用的对象失败", zap.Error(err))
+		return deleted, nil
+	}
+	for _, sum := range unreferenced {
+		if err := s.removeUnreferenced(sum); err != nil {
+			logger.GetLogger().Error("释放对象失败", zap.Error(err), zap.String("sha256", sum))
+		}
+	}
+	return deleted, nil
+}
+
+// caseExpr 返回按 column 的值取对应结果的 CASE 表达式，参数依次为 n 组值和结果
+func caseExpr(column string, n int) string {
+	var b strings.Builder
+	b.WriteString("CASE ")
+	b.WriteString(column)
+	for i := 0; i < n; i++ {
+		b.WriteString(" WHEN ? THEN ?")
+	}
+	b.WriteString(" END")
+	return b.String()
+}
+
+// escapeLike 转义 LIKE 中的通配符
+func escapeLike(value string) string {
+	return strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(value)
+}
+
+// touch 更新文件的最后使用时间和读取次数
+func (s *Store) touch(entryID uint) {
+	if err := database.DB.Model(&models.CacheEntry{}).
+		Where("id = ?", entryID).
+		UpdateColumns(map[string]interface{}{
+			"last_used_time": time.Now(),
+			"hits":           gorm.Expr("hits + ?", 1),
+		}).Error; err != nil {
+		logger.GetLogger().Error("更新缓存使用时间失败", zap.Error(err), zap.Uint("entry_id", entryID))
+	}
+}
+
+// saveEntry 写入或更新文件记录，替换了其他内容时释放原来的对象，并通知写入回调
+// 调用前已经为新内容增加了引用计数
+func (s *Store) saveEntry(key string, size int64, sum, contentType string, hosted bool) (*models.CacheEntry, error) {
+	now := time.Now()
+	entry := &models.CacheEntry{
+		MirrorID:     s.mirrorID,
+		Key:          key,
+		Size:         size,
+		Sha256:       sum,
+		Hosted:       hosted,
+		ContentType:  contentType,
+		StoredAt:     now,
+		LastUsedTime: now,
+	}
+
+	// 按原记录的内容条件更新，并发写入或删除同一文件时重试，保证每条记录只释放一次原来的对象
+	for {
+		var old models.CacheEntry
+		err := database.DB.Where("mirror_id = ? AND object_key = ?", s.mirrorID, key).Take(&old).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			result := database.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(entry)
+			if result.Error != nil {
+				return nil, fmt.Errorf("保存缓存记录失败: %v", result.Error)
+			}
+			if result.RowsAffected == 0 {
+				continue
+			}
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("保存缓存记录失败: %v", err)
+		}
+
+		result := database.DB.Model(&models.CacheEntry{}).
+			Where("id = ? AND sha256 = ?", old.ID, old.Sha256).
+			UpdateColumns(map[string]interface{}{
+				"size":           size,
+				"sha256":         sum,
+				"hosted":         hosted,
+				"content_type":   contentType,
+				"stored_at":      now,
+				"last_used_time": now,
+			})
+		if result.Error != nil {
+			return nil, fmt.Errorf("保存缓存记录失败: %v", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+		entry.ID = old.ID
+		entry.Hits = old.Hits
+		s.release(old.Sha256)
+		break
+	}
+
+	if writeHook != nil {
+		writeHook(s.mirrorID)
+	}
+	return entry, nil
+}
+
+// Upload 流式写入中的文件
+type Upload struct {
+	store       *Store
+	key         string
+	writer      Writer
+	hash        hash.Hash
+	size        int64
+	hosted      bool
+	contentType string
+}
+
+// SetContentType 记录文件的内容类型，其他实例从缓存读取同一文件时使用
+func (u *Upload) SetContentType(contentType string) {
+	u.contentType = contentType
+}
+
+// Write 写入文件内容
+func (u *Upload) Write(p []byte) (int, error) {
+	n, err := u.writer.Write(p)
+	u.hash.Write(p[:n])
+	u.size += int64(n)
+	return n, err
+}
+
+// Reader 打开读取已写入内容的读取器
+func (u *Upload) Reader() (ReadAtCloser, error) {
+	return u.writer.Reader()
+}
+
+// Commit 完成写入并保存文件记录，已有相同内容的对象时不再重复保存
+func (u *Upload) Commit() (*models.CacheEntry, error) {
+	sum := hex.EncodeToString(u.hash.Sum(nil))
+	if err := u.store.link(sum, u.size, u.writer); err != nil {
+		return nil, err
+	}
+	entry, err := u.store.saveEntry(u.key, u.size, sum, u.contentType, u.hosted)
+	if err != nil {
+		u.store.release(sum)
+		return nil, err
+	}
+	return entry, nil
+}
+
+// Abort 放弃写入
+func (u *Upload) Abort() {
+	if err := u.writer.Abort(); err != nil && !errors.Is(err, ErrNotExist) {
+		logger.GetLogger().Error("删除临时文件失败", zap.Error(err), zap.String("key", u.key))
+	}
+}
@@ -2,9 +2,16 @@ package main
 
 import (
 	"log"
+	"os"
+	"strconv"
+	"strings"
 
 	"easyCacheMirror/internal/database"
+	"easyCacheMirror/internal/middleware"
+	"easyCacheMirror/internal/proxy"
 	"easyCacheMirror/internal/routes"
+	"easyCacheMirror/internal/scheduler"
+	"easyCacheMirror/internal/storage"
 
 	"github.com/gin-gonic/gin"
 )
@@ -13,11 +20,38 @@ func main() {
 	// 初始化数据库
 	database.InitDB()
 
-	r := gin.Default()
+	// 本地镜像的缓存文件按内容保存在 CAS_DIR 中，默认为 data/cas
+	if dir := os.Getenv("CAS_DIR"); dir != "" {
+		storage.CASDir = dir
+	}
+	if err := storage.Init(); err != nil {
+		log.Println("初始化缓存存储失败:", err)
+	}
+
+	r := gin.New()
+	r.Use(gin.Logger(), middleware.Recovery())
+
+	// 只信任 TRUSTED_PROXIES 中反向代理传入的 X-Forwarded-For，否则客户端可以伪造地址绕过镜像的地址白名单
+	var trustedProxies []string
+	if value := os.Getenv("TRUSTED_PROXIES"); value != "" {
+		trustedProxies = strings.Fields(strings.ReplaceAll(value, ",", " "))
+	}
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatal("TRUSTED_PROXIES 配置无效:", err)
+	}
+
+	// OFFLINE_MODE=true 时启动即进入离线模式，只使用缓存，适用于隔离网络或上游长时间不可用
+	if offline, _ := strconv.ParseBool(os.Getenv("OFFLINE_MODE")); offline {
+		proxy.SetOffline(true)
+		log.Println("离线模式已开启，不会访问任何上游")
+	}
 
 	// 设置路由
 	routes.SetupRoutes(r)
 
+	// 启动缓存清理调度，依赖路由初始化时加载的镜像缓存
+	scheduler.Start()
+
 	// 启动服务器
 	if err := r.Run(":8080"); err != nil {
 		log.Fatal("服务器启动失败:", err)
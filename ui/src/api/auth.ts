@@ -0,0 +1,88 @@
+import { ref, computed } from 'vue'
+import { api } from './client'
+
+export interface User {
+  id: number
+  username: string
+  role: 'admin' | 'writer' | 'viewer'
+  lastLoginAt: string
+  createdAt: string
+  updatedAt: string
+}
+
+export interface UserForm {
+  username: string
+  password: string
+  role: string
+}
+
+export interface APIToken {
+  id: number
+  name: string
+  prefix: string
+  expiresAt: string | null
+  lastUsedAt: string | null
+  createdAt: string
+}
+
+// 当前登录的用户，未登录时为 null
+export const currentUser = ref<User | null>(null)
+
+export const isAdmin = computed(() => currentUser.value?.role === 'admin')
+
+export const authApi = {
+  // 登录
+  login: (username: string, password: string) => {
+    return api.post<{ user: User }>('/auth/login', { username, password })
+  },
+
+  // 退出登录
+  logout: () => {
+    return api.post('/auth/logout')
+  },
+
+  // 获取当前用户
+  me: () => {
+    return api.get<User>('/auth/me')
+  },
+
+  // 修改密码
+  changePassword: (oldPassword: string, newPassword: string) => {
+    return api.put('/auth/password', { oldPassword, newPassword })
+  },
+
+  // 获取 API 令牌列表
+  listTokens: () => {
+    return api.get<APIToken[]>('/auth/tokens')
+  },
+
+  // 创建 API 令牌，明文令牌只返回一次
+  createToken: (name: string, expiresIn: number) => {
+    return api.post<{ token: string, apiToken: APIToken }>('/auth/tokens', { name, expiresIn })
+  },
+
+  // 删除 API 令牌
+  deleteToken: (id: number) => {
+    return api.delete(`/auth/tokens/${id}`)
+  },
+
+  // 获取用户列表
+  listUsers: () => {
+    return api.get<User[]>('/users')
+  },
+
+  // 创建用户
+  createUser: (data: UserForm) => {
+    return api.post<User>('/users', data)
+  },
+
+  // 修改用户角色或重置密码
+  updateUser: (id: number, data: Partial<UserForm>) => {
+    return api.put<User>(`/users/${id}`, data)
+  },
+
+  // 删除用户
+  deleteUser: (id: number) => {
+    return api.delete(`/users/${id}`)
+  }
+}
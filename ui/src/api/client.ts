@@ -0,0 +1,26 @@
+import axios from 'axios'
+
+const baseURL = '/api'
+
+export const api = axios.create({
+  baseURL,
+  timeout: 5000,
+  withCredentials: true
+})
+
+// 登录失效时的回调，由 App 注册
+let unauthorizedHandler: (() => void) | null = null
+
+export const onUnauthorized = (handler: () => void) => {
+  unauthorizedHandler = handler
+}
+
+api.interceptors.response.use(
+  response => response,
+  error => {
+    if (error.response?.status === 401 && unauthorizedHandler) {
+      unauthorizedHandler()
+    }
+    return Promise.reject(error)
+  }
+)
@@ -1,11 +1,12 @@
-import axios from 'axios'
+import { api } from './client'
 
-const baseURL = '/api'
-
-const api = axios.create({
-  baseURL,
-  timeout: 5000
-})
+export interface MirrorUpstream {
+  id?: number
+  url: string
+  useProxy: boolean
+  proxyUrl?: string
+  priority: number
+}
 
 export interface MirrorForm {
   name: string
@@ -19,6 +20,35 @@ export interface MirrorForm {
   accessUrl: string
   cacheTime: number
   serviceUrl: string
+  immutablePatterns?: string
+  checksumFile?: string
+  upstreams?: MirrorUpstream[]
+  fallbackOn404?: boolean
+  staleIfError?: number
+  cleanupSchedule?: string
+  evictionPolicy?: string
+  highWatermark?: number
+  lowWatermark?: number
+  evictionTtl?: number
+  kind?: string
+  members?: number[]
+  hostedScopes?: string
+  requireAuth?: boolean
+  allowedUsers?: string
+  allowedCidrs?: string
+  upstreamAuthType?: string
+  upstreamUsername?: string
+  upstreamHeaderName?: string
+  upstreamSecret?: string
+  storageType?: string
+  s3Endpoint?: string
+  s3Region?: string
+  s3Bucket?: string
+  s3Prefix?: string
+  s3AccessKey?: string
+  s3SecretKey?: string
+  s3VirtualHost?: boolean
+  s3Redirect?: boolean
 }
 
 export interface Mirror extends MirrorForm {
@@ -30,6 +60,20 @@ export interface Mirror extends MirrorForm {
   hit_count: number
   request_count: number
   usedSpace: number
+  physicalSpace: number
+}
+
+// 缓存清理记录
+export interface CleanupRun {
+  id: number
+  mirrorId: number
+  trigger: 'schedule' | 'threshold' | 'manual'
+  startedAt: string
+  finishedAt: string
+  usedBefore: number
+  usedAfter: number
+  reclaimed: number
+  error: string
 }
 
 // 添加文件节点接口定义
@@ -91,11 +135,28 @@ export const mirrorApi = {
 
   // 清理镜像缓存
   cleanupMirrorCache(id: number) {
-    return api.post(`/mirrors/${id}/cleanup`)
+    return api.post<{ message: string; run: CleanupRun }>(`/mirrors/${id}/cleanup`)
+  },
+
+  // 获取镜像的缓存清理记录
+  getCleanupRuns(id: number) {
+    return api.get<CleanupRun[]>(`/mirrors/${id}/cleanups`)
   },
 
   // 获取简化的镜像列表
   getSimpleMirrors: () => {
     return api.get<SimpleMirror[]>('/mirrors/simple')
   }
-} 
\ No newline at end of file
+} 
+
+export const systemApi = {
+  // 获取离线模式状态
+  getOffline: () => {
+    return api.get<{ offline: boolean }>('/system/offline')
+  },
+
+  // 开启或关闭离线模式
+  setOffline: (offline: boolean) => {
+    return api.put<{ offline: boolean }>('/system/offline', { offline })
+  }
+}